@@ -0,0 +1,73 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/tetratelabs/run"
+	"github.com/tetratelabs/run/pkg/signal"
+
+	"github.com/basvanbeek/topology-tester/internal/controller"
+	pkghttp "github.com/basvanbeek/topology-tester/pkg/http"
+	"github.com/basvanbeek/topology-tester/pkg/leader"
+	"github.com/basvanbeek/topology-tester/pkg/lifecycle"
+)
+
+const (
+	defaultHTTPListenAddress = ":9100"
+	defaultLease             = "topology-controller"
+)
+
+func main() {
+	g := run.Group{
+		Name:     "controller",
+		HelpText: "Controller to orchestrate a fleet of topology testers",
+	}
+
+	svcController := &controller.Controller{}
+	svcLeader := &leader.Elector{
+		Lease: defaultLease,
+	}
+	svcScenarios := &controller.ScenarioWatcher{
+		Controller: svcController,
+		Leader:     svcLeader,
+	}
+	svcHTTP := &pkghttp.Service{
+		ListenAddress: defaultHTTPListenAddress,
+	}
+	lifecycles := &lifecycle.Recorder{}
+	g.Register(lifecycles.Wrap(
+		new(signal.Handler),
+		svcController,
+		svcScenarios,
+		svcLeader,
+		svcHTTP,
+		run.NewPreRunner("controller", func() error {
+			svcHTTP.Handler = svcController.Handler()
+			return nil
+		}),
+	)...)
+
+	if err := g.Run(); err != nil {
+		fmt.Printf("%s exit: %v\n", g.Name, err)
+		if !errors.Is(err, run.ErrRequestedShutdown) {
+			// We had an actual fatal error.
+			os.Exit(-1)
+		}
+	}
+}
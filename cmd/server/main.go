@@ -18,13 +18,19 @@ package main
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 
 	"github.com/tetratelabs/run"
 	"github.com/tetratelabs/run/pkg/signal"
 
 	"github.com/basvanbeek/topology-tester/internal/service"
+	"github.com/basvanbeek/topology-tester/pkg/admin"
 	pkghttp "github.com/basvanbeek/topology-tester/pkg/http"
+	"github.com/basvanbeek/topology-tester/pkg/leader"
+	"github.com/basvanbeek/topology-tester/pkg/lifecycle"
+	"github.com/basvanbeek/topology-tester/pkg/oauth"
+	"github.com/basvanbeek/topology-tester/pkg/registration"
 	pkgzipkin "github.com/basvanbeek/topology-tester/pkg/zipkin"
 )
 
@@ -57,23 +63,54 @@ func main() {
 		SampleRate:      defaultSampleRate,
 		SingleHostSpans: defaultSingleHostSpans,
 	}
+	svcOAuth := &oauth.Service{}
+	svcHTTP := &pkghttp.Service{
+		ListenAddress: defaultHTTPListenAddress,
+	}
+	svcLeader := &leader.Elector{
+		Lease: serviceName,
+	}
 	svcEndpoints := &service.Endpoints{
 		ServiceName: serviceName,
 		SvcTracer:   svcZipkin,
+		SvcOAuth:    svcOAuth,
+		SvcHTTP:     svcHTTP,
+		Leader:      svcLeader,
 	}
-	svcHTTP := &pkghttp.Service{
-		ListenAddress: defaultHTTPListenAddress,
+	svcAdmin := &admin.Service{}
+	svcRegistration := &registration.Service{
+		ServiceName: serviceName,
 	}
-	g.Register(
+	// the http service is stopped before the tracer, so the spans of the
+	// requests drained on shutdown are still reported, as are the lifecycle
+	// events of the units stopped before the tracer
+	lifecycles := &lifecycle.Recorder{}
+	g.Register(lifecycles.Wrap(
 		new(signal.Handler),
+		svcHTTP,
 		svcZipkin,
+		svcOAuth,
 		svcEndpoints,
-		svcHTTP,
+		svcAdmin,
+		svcRegistration,
+		svcLeader,
 		run.NewPreRunner(serviceName, func() error {
 			svcHTTP.Handler = svcEndpoints.Handler()
+			svcHTTP.OnDrain = svcEndpoints.Drain
+			svcHTTP.OnDrained = svcEndpoints.Drained
+			svcEndpoints.Units = map[string]bool{
+				"oauth":        svcOAuth.Enabled(),
+				"admin":        svcAdmin.ListenAddress != "",
+				"registration": svcRegistration.Controller != "",
+				"leader":       svcLeader.Enabled,
+			}
+			svcAdmin.Handle("/metrics", svcEndpoints.Metrics())
+			svcHTTP.Handlers = map[string]http.Handler{
+				"admin": svcAdmin.Handler(),
+			}
 			return nil
 		}),
-	)
+	)...)
 
 	if err := g.Run(); err != nil {
 		fmt.Printf("%s exit: %v\n", g.Name, err)
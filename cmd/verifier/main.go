@@ -0,0 +1,38 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tetratelabs/run"
+
+	"github.com/basvanbeek/topology-tester/pkg/verify"
+)
+
+func main() {
+	g := run.Group{
+		Name:     "verifier",
+		HelpText: "Verify traces and scenarios against a deployed topology and its tracing backend",
+	}
+
+	g.Register(&verify.Service{})
+
+	if err := g.Run(); err != nil {
+		fmt.Printf("%s exit: %v\n", g.Name, err)
+		os.Exit(1)
+	}
+}
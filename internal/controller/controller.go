@@ -0,0 +1,360 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package controller implements a central controller which keeps a registry of
+// topology tester instances and pushes fault, latency and scenario settings to
+// all or a subset of them.
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tetratelabs/multierror"
+	"github.com/tetratelabs/run"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+)
+
+const (
+	flagInstanceTTL = "ctl-instance-ttl"
+	flagInstances   = "ctl-instances"
+
+	defaultInstanceTTL = 30 * time.Second
+
+	errTTL      pkg.Error = "expected a positive duration"
+	errInstance pkg.Error = "expected static instance as service=host:port"
+	errNoMatch  pkg.Error = "no instances match the selector"
+	errScenario pkg.Error = "invalid scenario"
+)
+
+var (
+	_ run.Config    = (*Controller)(nil)
+	_ run.PreRunner = (*Controller)(nil)
+)
+
+// instance is a registered topology tester instance.
+type instance struct {
+	Service  string    `json:"service"`
+	Address  string    `json:"address"`
+	Instance string    `json:"instance,omitempty"`
+	Static   bool      `json:"static"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// matches returns true if the instance is selected by the provided selector,
+// which is either "all", a service name or an instance address.
+func (i *instance) matches(selector string) bool {
+	return selector == "all" || selector == i.Service || selector == i.Address ||
+		selector == i.Instance
+}
+
+// result holds the outcome of pushing a setting to an instance.
+type result struct {
+	Service    string          `json:"service,omitempty"`
+	Address    string          `json:"address,omitempty"`
+	Path       string          `json:"path"`
+	StatusCode int             `json:"statusCode,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Response   json.RawMessage `json:"response,omitempty"`
+}
+
+// step is a single step of a scenario, pushing a path to selected instances.
+type step struct {
+	Selector string `json:"selector"`
+	Path     string `json:"path"`
+	// Wait is the time to wait after applying this step.
+	Wait string `json:"wait,omitempty"`
+}
+
+// Controller implements a run.Config compatible registry of topology tester
+// instances, exposing an API to push settings to them.
+type Controller struct {
+	TTL time.Duration
+
+	staticFlags []string
+	handler     http.Handler
+	client      *http.Client
+
+	mtx       sync.Mutex
+	instances map[string]*instance
+}
+
+// Name implements run.Unit.
+func (c *Controller) Name() string {
+	return "controller"
+}
+
+// FlagSet implements run.Config.
+func (c *Controller) FlagSet() *run.FlagSet {
+	if c.TTL == 0 {
+		c.TTL = defaultInstanceTTL
+	}
+	flags := run.NewFlagSet("Controller options")
+
+	flags.DurationVar(&c.TTL, flagInstanceTTL, c.TTL,
+		`Time after which instances which stopped sending registration pings are removed`)
+	flags.StringSliceVar(&c.staticFlags, flagInstances, c.staticFlags,
+		`Static instances which do not register themselves, e.g. "svcb=svcb:8000"`)
+
+	return flags
+}
+
+// Validate implements run.Config.
+func (c *Controller) Validate() error {
+	var mErr error
+
+	if c.TTL <= 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagInstanceTTL, errTTL))
+	}
+	for _, s := range c.staticFlags {
+		if _, err := parseInstance(s); err != nil {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, flagInstances, err))
+		}
+	}
+
+	return mErr
+}
+
+// PreRun implements run.PreRunner.
+func (c *Controller) PreRun() error {
+	c.client = &http.Client{Timeout: 10 * time.Second}
+	c.instances = make(map[string]*instance)
+	for _, s := range c.staticFlags {
+		i, _ := parseInstance(s) // validated in Validate
+		c.instances[i.Address] = i
+	}
+
+	router := mux.NewRouter()
+	router.Methods("GET").Path("/register/{service}/{address}").HandlerFunc(c.register)
+	router.Methods("GET").Path("/instances").HandlerFunc(c.list)
+	router.Methods("GET").Path("/push/{selector}/{path:.*}").HandlerFunc(c.push)
+	router.Methods("POST").Path("/scenario").HandlerFunc(c.scenario)
+	c.handler = router
+
+	return nil
+}
+
+// Handler returns the HTTP handler of the controller API.
+func (c *Controller) Handler() http.Handler {
+	return c.handler
+}
+
+func parseInstance(s string) (*instance, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, errInstance
+	}
+	if _, _, err := net.SplitHostPort(parts[1]); err != nil {
+		return nil, errInstance
+	}
+	return &instance{Service: parts[0], Address: parts[1], Static: true}, nil
+}
+
+// selected returns the live instances matching the selector.
+func (c *Controller) selected(selector string) []instance {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	cutoff := time.Now().Add(-c.TTL)
+	var res []instance
+	for addr, i := range c.instances {
+		if !i.Static && i.LastSeen.Before(cutoff) {
+			delete(c.instances, addr)
+			continue
+		}
+		if i.matches(selector) {
+			res = append(res, *i)
+		}
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].Address < res[b].Address })
+	return res
+}
+
+// register handles registration pings of tester instances.
+func (c *Controller) register(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	c.mtx.Lock()
+	i, ok := c.instances[vars["address"]]
+	if !ok {
+		i = &instance{Address: vars["address"]}
+		c.instances[i.Address] = i
+		log.Printf("registered %s instance at %s", vars["service"], i.Address)
+	}
+	i.Service = vars["service"]
+	i.Instance = r.URL.Query().Get("instance")
+	i.LastSeen = time.Now()
+	c.mtx.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "registered"})
+}
+
+// list returns the registered instances.
+func (c *Controller) list(w http.ResponseWriter, _ *http.Request) {
+	instances := c.selected("all")
+	if instances == nil {
+		instances = []instance{}
+	}
+	writeJSON(w, http.StatusOK, instances)
+}
+
+// push sends the provided path to all instances matching the selector.
+//
+// Example paths:
+//
+//	/push/all/errors/10           10% errors on all instances
+//	/push/svcb/latency/200ms      200ms latency on all svcb instances
+//	/push/10.0.0.12:8000/crash/x  crash a single instance
+func (c *Controller) push(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	results, err := c.apply(r.Context(), vars["selector"], "/"+vars["path"])
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// scenario applies a list of steps in order, e.g.:
+//
+//	[
+//	  {"selector": "svcb", "path": "/latency/500ms", "wait": "30s"},
+//	  {"selector": "svcb", "path": "/latency/0"}
+//	]
+//
+// The response is returned after all steps have been applied.
+func (c *Controller) scenario(w http.ResponseWriter, r *http.Request) {
+	var steps []step
+	if err := json.NewDecoder(r.Body).Decode(&steps); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("%s: %v", errScenario, err),
+		})
+		return
+	}
+	waits, err := parseWaits(steps)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("%s: %v", errScenario, err),
+		})
+		return
+	}
+	results, err := c.runSteps(r.Context(), steps, waits)
+	if err != nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// parseWaits returns the wait durations of the scenario steps.
+func parseWaits(steps []step) ([]time.Duration, error) {
+	waits := make([]time.Duration, len(steps))
+	for idx, s := range steps {
+		if s.Wait == "" {
+			continue
+		}
+		d, err := time.ParseDuration(s.Wait)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("step %d: invalid wait %q", idx, s.Wait)
+		}
+		waits[idx] = d
+	}
+	return waits, nil
+}
+
+// runSteps applies the scenario steps in order, waiting after each step as
+// requested. It returns early if the context is cancelled.
+func (c *Controller) runSteps(ctx context.Context, steps []step, waits []time.Duration) ([]result, error) {
+	var results []result
+	for idx, s := range steps {
+		res, err := c.apply(ctx, s.Selector, s.Path)
+		if err != nil {
+			results = append(results, result{Path: s.Path, Error: err.Error()})
+		}
+		results = append(results, res...)
+		select {
+		case <-time.After(waits[idx]):
+		case <-ctx.Done():
+			return results, ctx.Err()
+		}
+	}
+	return results, nil
+}
+
+// apply sends a GET request for path to all instances matching the selector.
+func (c *Controller) apply(ctx context.Context, selector, path string) ([]result, error) {
+	instances := c.selected(selector)
+	if len(instances) == 0 {
+		return nil, errNoMatch
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	var (
+		wg      sync.WaitGroup
+		results = make([]result, len(instances))
+	)
+	for idx := range instances {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			i := instances[idx]
+			results[idx] = c.send(ctx, i, path)
+		}(idx)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func (c *Controller) send(ctx context.Context, i instance, path string) result {
+	res := result{Service: i.Service, Address: i.Address, Path: path}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+i.Address+path, nil)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	r, err := c.client.Do(req)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer func() { _ = r.Body.Close() }()
+	res.StatusCode = r.StatusCode
+	if raw, err := ioutil.ReadAll(r.Body); err == nil && json.Valid(raw) {
+		res.Response = raw
+	}
+	return res
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("error while writing http response: %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/multierror"
+	"github.com/tetratelabs/run"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+	"github.com/basvanbeek/topology-tester/pkg/kubernetes"
+	"github.com/basvanbeek/topology-tester/pkg/leader"
+)
+
+const (
+	flagScenarios    = "ctl-k8s-scenarios"
+	flagNamespace    = "ctl-k8s-namespace"
+	flagPollInterval = "ctl-k8s-poll-interval"
+
+	defaultPollInterval = 10 * time.Second
+
+	// TopologyScenario custom resource location
+	scenarioGroup   = "topology.basvanbeek.github.io"
+	scenarioVersion = "v1alpha1"
+	scenarioPlural  = "topologyscenarios"
+
+	errInterval pkg.Error = "expected a positive interval"
+)
+
+var (
+	_ run.Config    = (*ScenarioWatcher)(nil)
+	_ run.PreRunner = (*ScenarioWatcher)(nil)
+	_ run.Service   = (*ScenarioWatcher)(nil)
+)
+
+// topologyScenario is the TopologyScenario custom resource. Its spec holds the
+// steps to apply to the tester instances, in the same format as accepted by
+// the /scenario endpoint.
+type topologyScenario struct {
+	Metadata struct {
+		Name       string `json:"name"`
+		Namespace  string `json:"namespace"`
+		UID        string `json:"uid"`
+		Generation int64  `json:"generation"`
+	} `json:"metadata"`
+	Spec struct {
+		Steps []step `json:"steps"`
+	} `json:"spec"`
+}
+
+// ScenarioWatcher implements a run.Group compatible watcher of TopologyScenario
+// custom resources. Each time a scenario is created or its spec changes, its
+// steps are applied to the registered tester instances through the
+// controller, which makes scenarios GitOps-able. With multiple controller
+// replicas, only the elected leader applies scenarios. A new leader applies the
+// scenarios found again.
+type ScenarioWatcher struct {
+	// dependencies
+	Controller *Controller
+	Leader     *leader.Elector
+
+	Enabled      bool
+	Namespace    string
+	PollInterval time.Duration
+
+	client *kubernetes.Client
+	closer chan struct{}
+
+	mtx     sync.Mutex
+	applied map[string]int64
+	cancel  map[string]context.CancelFunc
+}
+
+// Name implements run.Unit.
+func (s *ScenarioWatcher) Name() string {
+	return "scenario-watcher"
+}
+
+// FlagSet implements run.Config.
+func (s *ScenarioWatcher) FlagSet() *run.FlagSet {
+	if s.PollInterval == 0 {
+		s.PollInterval = defaultPollInterval
+	}
+	flags := run.NewFlagSet("Kubernetes scenario options")
+
+	flags.BoolVar(&s.Enabled, flagScenarios, s.Enabled,
+		`Apply TopologyScenario custom resources found in the cluster`)
+	flags.StringVar(&s.Namespace, flagNamespace, s.Namespace,
+		`Namespace to watch for scenarios, defaults to all namespaces`)
+	flags.DurationVar(&s.PollInterval, flagPollInterval, s.PollInterval,
+		`Interval between scenario list requests`)
+
+	return flags
+}
+
+// Validate implements run.Config.
+func (s *ScenarioWatcher) Validate() error {
+	if !s.Enabled {
+		return nil
+	}
+
+	var mErr error
+
+	if s.PollInterval <= 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagPollInterval, errInterval))
+	}
+	if !kubernetes.InCluster() {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagScenarios, kubernetes.ErrNotInCluster))
+	}
+
+	return mErr
+}
+
+// PreRun implements run.PreRunner.
+func (s *ScenarioWatcher) PreRun() error {
+	s.closer = make(chan struct{})
+	s.applied = make(map[string]int64)
+	s.cancel = make(map[string]context.CancelFunc)
+	if !s.Enabled {
+		return nil
+	}
+
+	var err error
+	s.client, err = kubernetes.NewInClusterClient()
+	return err
+}
+
+// Serve implements run.Service.
+func (s *ScenarioWatcher) Serve() error {
+	if !s.Enabled {
+		<-s.closer
+		return nil
+	}
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.sync(); err != nil {
+			log.Printf("error while syncing scenarios: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-s.closer:
+			return nil
+		}
+	}
+}
+
+// GracefulStop implements run.Service.
+func (s *ScenarioWatcher) GracefulStop() {
+	close(s.closer)
+	s.stopAll()
+}
+
+// stopAll stops the running scenarios and forgets the applied ones.
+func (s *ScenarioWatcher) stopAll() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for uid, cancel := range s.cancel {
+		cancel()
+		delete(s.cancel, uid)
+	}
+	s.applied = make(map[string]int64)
+}
+
+// sync lists the scenarios and applies the ones which are new or changed.
+func (s *ScenarioWatcher) sync() error {
+	if !s.Leader.IsLeader() {
+		s.stopAll()
+		return nil
+	}
+	scenarios, err := s.list()
+	if err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	seen := make(map[string]bool, len(scenarios))
+	for _, sc := range scenarios {
+		uid := sc.Metadata.UID
+		seen[uid] = true
+		if s.applied[uid] == sc.Metadata.Generation {
+			continue
+		}
+		waits, err := parseWaits(sc.Spec.Steps)
+		if err != nil {
+			log.Printf("invalid scenario %s/%s: %v",
+				sc.Metadata.Namespace, sc.Metadata.Name, err)
+			s.applied[uid] = sc.Metadata.Generation
+			continue
+		}
+		if cancel, ok := s.cancel[uid]; ok {
+			// spec changed while the previous version was still running
+			cancel()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		s.applied[uid], s.cancel[uid] = sc.Metadata.Generation, cancel
+		go func(sc topologyScenario) {
+			log.Printf("applying scenario %s/%s generation %d",
+				sc.Metadata.Namespace, sc.Metadata.Name, sc.Metadata.Generation)
+			results, err := s.Controller.runSteps(ctx, sc.Spec.Steps, waits)
+			for _, res := range results {
+				if res.Error != "" {
+					log.Printf("scenario %s/%s: %s %s: %s", sc.Metadata.Namespace,
+						sc.Metadata.Name, res.Address, res.Path, res.Error)
+				}
+			}
+			if err == nil {
+				log.Printf("scenario %s/%s applied", sc.Metadata.Namespace, sc.Metadata.Name)
+			}
+		}(sc)
+	}
+	// stop scenarios which have been deleted
+	for uid := range s.applied {
+		if seen[uid] {
+			continue
+		}
+		if cancel, ok := s.cancel[uid]; ok {
+			cancel()
+		}
+		delete(s.applied, uid)
+		delete(s.cancel, uid)
+	}
+	return nil
+}
+
+// list retrieves the TopologyScenario resources from the Kubernetes API.
+func (s *ScenarioWatcher) list() ([]topologyScenario, error) {
+	path := fmt.Sprintf("/apis/%s/%s/%s", scenarioGroup, scenarioVersion, scenarioPlural)
+	if s.Namespace != "" {
+		path = fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s",
+			scenarioGroup, scenarioVersion, s.Namespace, scenarioPlural)
+	}
+	var list struct {
+		Items []topologyScenario `json:"items"`
+	}
+	if err := s.client.Get(context.Background(), path, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
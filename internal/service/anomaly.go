@@ -0,0 +1,161 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"expvar"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+// span anomalies
+const (
+	anomalyOrphan    = "orphan"
+	anomalyLate      = "late"
+	anomalyDuplicate = "duplicate"
+
+	defaultLateDelay = time.Minute
+	maxLateDelay     = time.Hour
+	maxAnomalies     = 1000
+)
+
+// anomalyMetrics holds the counters of the emitted anomalous spans, exposed
+// through expvar.
+var anomalyMetrics = expvar.NewMap("anomalies")
+
+// randomID returns a random non-zero span id.
+func randomID() model.ID {
+	for {
+		if id := model.ID(rand.Uint64()); id != 0 {
+			return id
+		}
+	}
+}
+
+// spanAnomaly deliberately emits malformed span data as part of the current
+// trace, so backend robustness can be validated from the tester. The count
+// query parameter sets the amount of anomalous spans (1 by default, at most
+// 1000).
+//
+//	orphan     spans referencing a parent span which is never reported
+//	late       spans finished and reported delay (1m by default, at most 1h)
+//	           after the request completed
+//	duplicate  a span reported count additional times with the same span id
+//
+// Late spans still pending when the service stops are lost.
+//
+// Example paths:
+//
+//	/anomaly/orphan?count=5
+//	/anomaly/late?delay=5m
+//	/anomaly/duplicate?count=2
+func (ep *Endpoints) spanAnomaly(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	kind := mux.Vars(r)["kind"]
+
+	count := 1
+	if v := query.Get("count"); v != "" {
+		var err error
+		if count, err = strconv.Atoi(v); err != nil || count < 1 || count > maxAnomalies {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errAnomalyCount,
+			})
+			return
+		}
+	}
+	delay := defaultLateDelay
+	if v := query.Get("delay"); v != "" {
+		var err error
+		if delay, err = parseDuration(v); err != nil || delay < 0 || delay > maxLateDelay {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errLateDelay,
+			})
+			return
+		}
+	}
+
+	parent := zipkin.SpanOrNoopFromContext(ctx).Context()
+	var ids []string
+	switch kind {
+	case anomalyOrphan:
+		for i := 0; i < count; i++ {
+			// a child of a span which never existed
+			missing := parent
+			missing.ID = randomID()
+			span := ep.tracer.StartSpan(fmt.Sprintf("orphan-%d", i), zipkin.Parent(missing))
+			span.Tag("anomaly", anomalyOrphan)
+			span.Tag("anomaly.missing.parent", missing.ID.String())
+			span.Finish()
+			ids = append(ids, span.Context().ID.String())
+		}
+	case anomalyLate:
+		for i := 0; i < count; i++ {
+			span := ep.tracer.StartSpan(fmt.Sprintf("late-%d", i), zipkin.Parent(parent))
+			span.Tag("anomaly", anomalyLate)
+			span.Tag("anomaly.delay", delay.String())
+			anomalyMetrics.Add("latePending", 1)
+			time.AfterFunc(delay, func() {
+				span.Finish()
+				anomalyMetrics.Add("latePending", -1)
+			})
+			ids = append(ids, span.Context().ID.String())
+		}
+	case anomalyDuplicate:
+		sc := model.SpanContext{
+			TraceID:  parent.TraceID,
+			ID:       randomID(),
+			ParentID: &parent.ID,
+			Sampled:  parent.Sampled,
+		}
+		if sc.Sampled != nil && *sc.Sampled {
+			start := time.Now()
+			for i := 0; i <= count; i++ {
+				ep.SvcTracer.Reporter.Send(model.SpanModel{
+					SpanContext:   sc,
+					Name:          "duplicate",
+					Timestamp:     start,
+					Duration:      time.Millisecond,
+					LocalEndpoint: ep.tracer.LocalEndpoint(),
+					Tags: map[string]string{
+						"anomaly":        anomalyDuplicate,
+						"anomaly.copy":   strconv.Itoa(i),
+						"anomaly.copies": strconv.Itoa(count + 1),
+					},
+				})
+			}
+		}
+		ids = append(ids, sc.ID.String())
+	}
+	anomalyMetrics.Add(kind, int64(count))
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: fmt.Sprintf("emitted %d %s span anomalies", count, kind),
+		Data: map[string]interface{}{
+			"kind":    kind,
+			"spanIDs": ids,
+		},
+	})
+}
@@ -0,0 +1,100 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// The knob types below hold the fault settings read on every request, so the
+// request path doesn't contend on the service mutex. They implement
+// pflag.Value so the knobs can be bound to flags directly.
+
+// atomicInt32 is an int32 knob safe for concurrent use.
+type atomicInt32 struct{ v int32 }
+
+func (a *atomicInt32) Load() int32   { return atomic.LoadInt32(&a.v) }
+func (a *atomicInt32) Store(v int32) { atomic.StoreInt32(&a.v, v) }
+
+func (a *atomicInt32) String() string { return strconv.FormatInt(int64(a.Load()), 10) }
+func (a *atomicInt32) Type() string   { return "int32" }
+func (a *atomicInt32) Set(s string) error {
+	v, err := strconv.ParseInt(s, 0, 32)
+	if err != nil {
+		return err
+	}
+	a.Store(int32(v))
+	return nil
+}
+
+// atomicInt is an int knob safe for concurrent use.
+type atomicInt struct{ v int64 }
+
+func (a *atomicInt) Load() int   { return int(atomic.LoadInt64(&a.v)) }
+func (a *atomicInt) Store(v int) { atomic.StoreInt64(&a.v, int64(v)) }
+
+func (a *atomicInt) String() string { return strconv.Itoa(a.Load()) }
+func (a *atomicInt) Type() string   { return "int" }
+func (a *atomicInt) Set(s string) error {
+	v, err := strconv.ParseInt(s, 0, 0)
+	if err != nil {
+		return err
+	}
+	a.Store(int(v))
+	return nil
+}
+
+// atomicBool is a bool knob safe for concurrent use. When bound to a flag, set
+// the flag's NoOptDefVal to "true" so it can be passed without a value.
+type atomicBool struct{ v int32 }
+
+func (a *atomicBool) Load() bool { return atomic.LoadInt32(&a.v) == 1 }
+func (a *atomicBool) Store(v bool) {
+	var i int32
+	if v {
+		i = 1
+	}
+	atomic.StoreInt32(&a.v, i)
+}
+
+func (a *atomicBool) String() string { return strconv.FormatBool(a.Load()) }
+func (a *atomicBool) Type() string   { return "bool" }
+func (a *atomicBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	a.Store(v)
+	return nil
+}
+
+// atomicDuration is a duration knob safe for concurrent use.
+type atomicDuration struct{ v int64 }
+
+func (a *atomicDuration) Load() time.Duration   { return time.Duration(atomic.LoadInt64(&a.v)) }
+func (a *atomicDuration) Store(v time.Duration) { atomic.StoreInt64(&a.v, int64(v)) }
+
+func (a *atomicDuration) String() string { return a.Load().String() }
+func (a *atomicDuration) Type() string   { return "duration" }
+func (a *atomicDuration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	a.Store(v)
+	return nil
+}
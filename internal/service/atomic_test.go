@@ -0,0 +1,51 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAtomicKnobFlags(t *testing.T) {
+	ep := &Endpoints{}
+	fs := ep.FlagSet()
+	if err := fs.Parse([]string{
+		"--" + flagErrors, "20",
+		"--" + flagDuration, "50ms",
+		"--" + flagCoalesce,
+		"--" + flagSlowReadRate, "1024",
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e := ep.errors.Load(); e != 20 {
+		t.Errorf("errors: want 20, have %d", e)
+	}
+	if d := ep.duration.Load(); d != 50*time.Millisecond {
+		t.Errorf("duration: want 50ms, have %s", d)
+	}
+	if !ep.coalescing.Load() {
+		t.Errorf("expected coalescing to be enabled without a flag value")
+	}
+	if ep.handleFailures.Load() {
+		t.Errorf("expected handle failures to be disabled by default")
+	}
+	if r := ep.slowReadRate.Load(); r != 1024 {
+		t.Errorf("slow read rate: want 1024, have %d", r)
+	}
+	if err := fs.Parse([]string{"--" + flagHeaders, "x"}); err == nil {
+		t.Errorf("expected error on invalid percentage")
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+const (
+	defaultBenchTimeout = 30 * time.Second
+
+	// benchPollInterval is the interval at which the reporter is polled for
+	// the benchmark spans to be handled.
+	benchPollInterval = 10 * time.Millisecond
+)
+
+// benchGeneration holds the span generation results of a benchmark.
+type benchGeneration struct {
+	Duration       string  `json:"duration"`
+	SpansPerSecond float64 `json:"spansPerSecond"`
+	AllocsPerSpan  float64 `json:"allocsPerSpan"`
+	BytesPerSpan   float64 `json:"bytesPerSpan"`
+}
+
+// benchReport holds the span reporting results of a benchmark.
+type benchReport struct {
+	Duration       string  `json:"duration"`
+	SpansPerSecond float64 `json:"spansPerSecond"`
+	Handled        int64   `json:"handled"`
+	Complete       bool    `json:"complete"`
+}
+
+// benchSpans generates the provided amount of local spans as fast as possible
+// and reports the generation throughput and allocations, and the throughput up
+// to the reporter having handled all spans, so the overhead of each reporter
+// transport can be compared on the same hardware. Reporting is only measured
+// for sampled requests and batching transports, waiting at most the timeout
+// query parameter (30s by default). Spans dropped by reporter faults count as
+// handled. Allocations are counted process wide, so the benchmark is best run
+// on an otherwise idle instance. Benchmarks are limited by
+// --ep-longtrace-max-spans and run one at a time.
+//
+// Example paths:
+//
+//	/bench/spans/10000
+//	/bench/spans/100000?timeout=1m
+func (ep *Endpoints) benchSpans(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	count, err := strconv.Atoi(mux.Vars(r)["count"])
+	if err != nil || count <= 0 {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errSpans,
+		})
+		return
+	}
+	if int64(count) > ep.longTraceMax {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errBenchSize,
+		})
+		return
+	}
+	timeout := defaultBenchTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		if timeout, err = parseDuration(v); err != nil || timeout < 0 {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errDuration,
+			})
+			return
+		}
+	}
+	if !atomic.CompareAndSwapInt32(&ep.benchRunning, 0, 1) {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusConflict,
+			Error: errBenchRunning,
+		})
+		return
+	}
+	defer atomic.StoreInt32(&ep.benchRunning, 0)
+
+	parent := zipkin.SpanOrNoopFromContext(ctx).Context()
+	sampled := parent.Debug || (parent.Sampled != nil && *parent.Sampled)
+	baseline, tracked := ep.SvcTracer.GetReporterCounts()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	generated := 0
+	for ; generated < count && ctx.Err() == nil; generated++ {
+		ep.tracer.StartSpan("bench", zipkin.Parent(parent)).Finish()
+	}
+	took := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	gen := benchGeneration{Duration: took.String()}
+	if generated > 0 {
+		// the request may have been cancelled before generating any span
+		gen.SpansPerSecond = float64(generated) / took.Seconds()
+		gen.AllocsPerSpan = float64(after.Mallocs-before.Mallocs) / float64(generated)
+		gen.BytesPerSpan = float64(after.TotalAlloc-before.TotalAlloc) / float64(generated)
+	}
+	data := map[string]interface{}{
+		"spans":      generated,
+		"sampled":    sampled,
+		"transport":  ep.SvcTracer.GetReporterStatus().Transport,
+		"generation": gen,
+	}
+
+	if sampled && tracked && generated > 0 {
+		deadline := time.Now().Add(timeout)
+		report := benchReport{}
+		for {
+			handled, _ := ep.SvcTracer.GetReporterCounts()
+			report.Handled = handled - baseline
+			if report.Complete = report.Handled >= int64(generated); report.Complete ||
+				time.Now().After(deadline) || ctx.Err() != nil {
+				break
+			}
+			time.Sleep(benchPollInterval)
+		}
+		took = time.Since(start)
+		report.Duration = took.String()
+		report.SpansPerSecond = float64(report.Handled) / took.Seconds()
+		data["report"] = report
+	}
+
+	ep.writeResponse(ctx, w, response{
+		Code: http.StatusOK,
+		Data: data,
+	})
+}
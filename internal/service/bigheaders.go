@@ -0,0 +1,181 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// supported header size faults
+const (
+	headerFaultLarge  = "large"
+	headerFaultMany   = "many"
+	headerFaultCookie = "cookie"
+)
+
+// header size fault targets
+const (
+	headerTargetResponse = "response"
+	headerTargetRequest  = "request"
+	headerTargetBoth     = "both"
+)
+
+const maxHeaderFaultSize = 1 << 20
+
+// defaultHeaderFaultSizes holds the default size of each header fault, in
+// bytes for large headers and cookies and in headers for many headers. They
+// exceed the default limits of Envoy (60KiB and 100 headers).
+var defaultHeaderFaultSizes = map[string]int{
+	headerFaultLarge:  64 << 10,
+	headerFaultMany:   2000,
+	headerFaultCookie: 64 << 10,
+}
+
+// headerFault holds the header size fault settings.
+type headerFault struct {
+	kind       string
+	percentage int32
+	size       int
+	response   bool
+	request    bool
+}
+
+// apply adds the oversized header(s) to the provided headers. Cookies are sent
+// as Set-Cookie in responses and Cookie in requests.
+func (f headerFault) apply(h http.Header, response bool) {
+	switch f.kind {
+	case headerFaultLarge:
+		h.Set("X-Topo-Large-Header", strings.Repeat("x", f.size))
+	case headerFaultMany:
+		for i := 0; i < f.size; i++ {
+			h.Set("X-Topo-Header-"+strconv.Itoa(i), "x")
+		}
+	case headerFaultCookie:
+		if response {
+			h.Add("Set-Cookie", "topo="+strings.Repeat("x", f.size))
+		} else {
+			h.Add("Cookie", "topo="+strings.Repeat("x", f.size))
+		}
+	}
+}
+
+// pickHeaderFault returns the header size fault to inject in responses or
+// outbound requests, if any.
+func (ep *Endpoints) pickHeaderFault(response bool) (headerFault, bool) {
+	ep.mtx.RLock()
+	f := ep.headerFault
+	ep.mtx.RUnlock()
+
+	if (response && !f.response) || (!response && !f.request) {
+		return f, false
+	}
+	return f, ep.rand.Int31n(100) < f.percentage
+}
+
+// tagHeaderFault tags the span with the injected header size fault.
+func tagHeaderFault(span zipkin.Span, f headerFault) {
+	span.Tag("fault", "header-size")
+	span.Tag("fault.header.kind", f.kind)
+	span.Tag("fault.header.size", strconv.Itoa(f.size))
+}
+
+// bigHeaders adds oversized headers to a percentage of responses.
+func (ep *Endpoints) bigHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f, ok := ep.pickHeaderFault(true); ok {
+			tagHeaderFault(zipkin.SpanOrNoopFromContext(r.Context()), f)
+			f.apply(w.Header(), true)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bigRequestHeaders adds oversized headers to a percentage of outbound
+// requests.
+func (ep *Endpoints) bigRequestHeaders(r *http.Request) {
+	if f, ok := ep.pickHeaderFault(false); ok {
+		tagHeaderFault(zipkin.SpanOrNoopFromContext(r.Context()), f)
+		f.apply(r.Header, false)
+	}
+}
+
+// setHeaderFault allows one to set the percentage of responses, and optionally
+// proxied requests, carrying a very large header, thousands of small headers
+// or an oversized cookie, to test max header limits and the resulting 431 and
+// 502 responses across hops. The size query parameter sets the size in bytes
+// or the amount of headers, the target query parameter one of response (the
+// default), request or both. Setting a header fault replaces the previous one
+// and a percentage of 0 disables it.
+//
+// Example paths:
+//
+//	/bigheaders/large/100                    64KiB header in all responses
+//	/bigheaders/many/50?size=500             500 headers in half of the responses
+//	/bigheaders/cookie/10?target=request     64KiB cookie in 10% of proxied requests
+func (ep *Endpoints) setHeaderFault(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	f := headerFault{kind: mux.Vars(r)["kind"]}
+
+	p, err := strconv.Atoi(mux.Vars(r)["percentage"])
+	if err != nil || p < 0 || p > 100 {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errPercentage,
+		})
+		return
+	}
+	f.percentage = int32(p)
+	f.size = defaultHeaderFaultSizes[f.kind]
+	if v := query.Get("size"); v != "" {
+		if f.size, err = strconv.Atoi(v); err != nil || f.size < 1 || f.size > maxHeaderFaultSize {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errHeaderSize,
+			})
+			return
+		}
+	}
+	switch query.Get("target") {
+	case "", headerTargetResponse:
+		f.response = true
+	case headerTargetRequest:
+		f.request = true
+	case headerTargetBoth:
+		f.response, f.request = true, true
+	default:
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errHeaderTarget,
+		})
+		return
+	}
+
+	ep.mtx.Lock()
+	ep.headerFault = f
+	ep.mtx.Unlock()
+
+	ep.writeResponse(ctx, w, response{
+		Code: http.StatusOK,
+		Message: fmt.Sprintf("%s header fault set to: %d%% with size %d",
+			f.kind, f.percentage, f.size),
+	})
+}
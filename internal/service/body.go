@@ -0,0 +1,76 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// readBody reads the request body, enforcing the configured maximum body size.
+// The number of bytes read is tagged on the server span, as chunked requests
+// have no content length for the tracing middleware to tag.
+func (ep *Endpoints) readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	var body io.Reader = r.Body
+	if ep.maxBodySize > 0 {
+		// read one byte more than allowed to detect oversized bodies
+		body = io.LimitReader(r.Body, ep.maxBodySize+1)
+	}
+	raw, err := ioutil.ReadAll(body)
+	_ = r.Body.Close()
+	if err != nil {
+		return nil, errReadBody
+	}
+	zipkin.SpanOrNoopFromContext(r.Context()).
+		Tag(string(zipkin.TagHTTPRequestSize), strconv.Itoa(len(raw)))
+	if ep.maxBodySize > 0 && int64(len(raw)) > ep.maxBodySize {
+		return nil, errBodyTooLarge
+	}
+	return raw, nil
+}
+
+// readFailedBody reads the body of a failed downstream response for the
+// graceful failure message, keeping at most the configured maximum in memory.
+// The remainder is drained when configured so the connection can be reused,
+// otherwise it is dropped by closing the body, which closes the connection.
+// It returns the bytes kept and whether the body was truncated.
+func (ep *Endpoints) readFailedBody(res *http.Response) ([]byte, bool) {
+	defer func() { _ = res.Body.Close() }()
+
+	if ep.gracefulBodyMax <= 0 {
+		raw, _ := ioutil.ReadAll(res.Body)
+		return raw, false
+	}
+	raw, _ := ioutil.ReadAll(io.LimitReader(res.Body, ep.gracefulBodyMax))
+	if int64(len(raw)) < ep.gracefulBodyMax {
+		return raw, false
+	}
+	// probe for a remainder before deciding the body was truncated
+	var probe [1]byte
+	if n, _ := res.Body.Read(probe[:]); n == 0 {
+		return raw, false
+	}
+	if ep.gracefulDrain {
+		_, _ = io.Copy(ioutil.Discard, res.Body)
+	}
+	return raw, true
+}
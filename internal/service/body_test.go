@@ -0,0 +1,65 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type closeRecorder struct {
+	*strings.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestReadFailedBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		max       int64
+		discard   bool
+		body      string
+		raw       string
+		truncated bool
+		remaining int
+	}{
+		{"no limit", 0, false, "0123456789", "0123456789", false, 0},
+		{"within limit", 10, false, "0123456789", "0123456789", false, 0},
+		{"truncated", 4, false, "0123456789", "0123", true, 5},
+		{"truncated and discarded", 4, true, "0123456789", "0123", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ep := &Endpoints{gracefulBodyMax: tt.max, gracefulDrain: tt.discard}
+			body := &closeRecorder{Reader: strings.NewReader(tt.body)}
+			raw, truncated := ep.readFailedBody(&http.Response{Body: body})
+			if string(raw) != tt.raw || truncated != tt.truncated {
+				t.Errorf("want %q (truncated %t), have %q (truncated %t)", tt.raw, tt.truncated, raw, truncated)
+			}
+			if rest, _ := ioutil.ReadAll(body); len(rest) != tt.remaining {
+				t.Errorf("remaining: want %d bytes, have %d", tt.remaining, len(rest))
+			}
+			if !body.closed {
+				t.Errorf("expected the body to be closed")
+			}
+		})
+	}
+}
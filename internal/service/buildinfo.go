@@ -0,0 +1,71 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/tetratelabs/run/pkg/version"
+
+	"github.com/basvanbeek/topology-tester/pkg/buildinfo"
+)
+
+// features returns the optional features of the endpoints which are enabled.
+func (ep *Endpoints) features() []string {
+	enabled := map[string]bool{
+		"graphql":   len(ep.resolvers) > 0,
+		"discovery": ep.discovery != nil,
+		"ratelimit": ep.rateLimitRPS > 0,
+		"cache":     ep.respCache.enabled(),
+		"queue":     ep.queue.stats().Workers > 0,
+		"warmup":    ep.warm.stats().Progress < 1,
+		"schema":    ep.schema != nil,
+		"compress":  len(ep.compressions) > 0,
+	}
+	enabled["coalesce"] = ep.coalescing.Load()
+	ep.mtx.RLock()
+	enabled["template"] = ep.template != nil
+	ep.mtx.RUnlock()
+
+	features := []string{}
+	for feature, ok := range enabled {
+		if ok {
+			features = append(features, feature)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+// versionInfo returns the version and build details of this service, the run
+// units and endpoint features which are enabled and the active instrumentation,
+// so operators can verify which feature set an instance runs.
+//
+// Example paths:
+//
+//	/version
+func (ep *Endpoints) versionInfo(w http.ResponseWriter, r *http.Request) {
+	ep.writeResponse(r.Context(), w, response{
+		Code: http.StatusOK,
+		Data: map[string]interface{}{
+			"version":         version.Parse(),
+			"build":           buildinfo.Get(),
+			"units":           ep.Units,
+			"features":        ep.features(),
+			"instrumentation": ep.SvcTracer.GetInstrumentation(),
+		},
+	})
+}
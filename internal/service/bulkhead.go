@@ -0,0 +1,216 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// bulkheadDefault is the route key holding the limit of routes without a
+// limit of their own.
+const bulkheadDefault = "*"
+
+// bulkheadMetrics holds the in flight gauges and rejection counters of the
+// bulkhead per route, exposed through expvar.
+var bulkheadMetrics = expvar.NewMap("bulkhead")
+
+// parseBulkhead parses bulkhead configuration in the form of "route=limit",
+// with route a path template or * for the default limit of each route.
+func parseBulkhead(s string) (route string, limit int, err error) {
+	idx := strings.LastIndex(s, "=")
+	if idx < 1 {
+		return "", 0, errBulkhead
+	}
+	route = strings.TrimSpace(s[:idx])
+	if limit, err = strconv.Atoi(strings.TrimSpace(s[idx+1:])); err != nil || limit < 0 {
+		return "", 0, errBulkhead
+	}
+	return route, limit, nil
+}
+
+// bulkhead limits the amount of concurrent requests per route. A limit of 0
+// means unlimited.
+type bulkhead struct {
+	mtx      sync.Mutex
+	limits   map[string]int
+	inflight map[string]int
+}
+
+func newBulkhead() *bulkhead {
+	return &bulkhead{
+		limits:   make(map[string]int),
+		inflight: make(map[string]int),
+	}
+}
+
+// limit returns the concurrency limit of route.
+func (b *bulkhead) limit(route string) int {
+	if limit, ok := b.limits[route]; ok {
+		return limit
+	}
+	return b.limits[bulkheadDefault]
+}
+
+// setLimit sets the concurrency limit of route, removing a route specific
+// limit of 0 so the default limit applies again.
+func (b *bulkhead) setLimit(route string, limit int) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if limit == 0 && route != bulkheadDefault {
+		delete(b.limits, route)
+		return
+	}
+	b.limits[route] = limit
+}
+
+// acquire takes a slot for a request to route, returning false if the share
+// percentage of the route limit is saturated.
+func (b *bulkhead) acquire(route string, share int) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if limit := b.limit(route); limit > 0 && b.inflight[route] >= limit*share/100 {
+		bulkheadMetrics.Add("rejected "+route, 1)
+		return false
+	}
+	b.inflight[route]++
+	bulkheadMetrics.Add("inflight "+route, 1)
+	return true
+}
+
+// release returns the slot taken by acquire.
+func (b *bulkhead) release(route string) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.inflight[route]--; b.inflight[route] <= 0 {
+		delete(b.inflight, route)
+	}
+	bulkheadMetrics.Add("inflight "+route, -1)
+}
+
+// bulkheadRoute holds the settings and current concurrency of a route.
+type bulkheadRoute struct {
+	Route    string `json:"route"`
+	Limit    int    `json:"limit"`
+	InFlight int    `json:"inFlight"`
+}
+
+func (b *bulkhead) stats() []bulkheadRoute {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	routes := make(map[string]struct{}, len(b.limits)+len(b.inflight))
+	for route := range b.limits {
+		routes[route] = struct{}{}
+	}
+	for route := range b.inflight {
+		routes[route] = struct{}{}
+	}
+	stats := make([]bulkheadRoute, 0, len(routes))
+	for route := range routes {
+		stats = append(stats, bulkheadRoute{
+			Route:    route,
+			Limit:    b.limit(route),
+			InFlight: b.inflight[route],
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Route < stats[j].Route })
+	return stats
+}
+
+// bulkheadLimiter rejects requests with a 503 once the amount of concurrent
+// requests of their route reaches the configured limit, mimicking an
+// application level bulkhead. High priority requests bypass the bulkhead and
+// low priority requests are rejected once their share of the limit is in use.
+// The admin endpoints are exempt so the service can always be inspected.
+func (ep *Endpoints) bulkheadLimiter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil || strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p := ep.priority(r)
+		if p == priorityHigh {
+			bulkheadMetrics.Add("bypassed "+tpl, 1)
+			tagDecision(r, p, "bulkhead", decisionBypass)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !ep.bulkheads.acquire(tpl, ep.share(p)) {
+			tagDecision(r, p, "bulkhead", decisionThrottled)
+			zipkin.SpanOrNoopFromContext(r.Context()).Tag("fault", "bulkhead-rejected")
+			w.Header().Set("X-Bulkhead-Rejected", tpl)
+			ep.writeResponse(r.Context(), w, response{
+				Code:  http.StatusServiceUnavailable,
+				Error: errBulkheadFull,
+			})
+			return
+		}
+		tagDecision(r, p, "bulkhead", decisionAdmitted)
+		defer ep.bulkheads.release(tpl)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bulkhead allows one to inspect and change the concurrency limits per route.
+// The route is provided as path template with the route query parameter, * or
+// no route sets the default limit of each route. A limit of 0 removes the
+// limit.
+//
+// Example paths:
+//
+//	/admin/bulkhead                                 show limits and concurrency
+//	/admin/bulkhead/limit/20                        limit each route to 20
+//	/admin/bulkhead/limit/5?route=/delay/{duration} limit a single route to 5
+func (ep *Endpoints) bulkhead(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var msg string
+	if value, ok := mux.Vars(r)["limit"]; ok {
+		route := r.URL.Query().Get("route")
+		if route == "" {
+			route = bulkheadDefault
+		}
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errCount,
+			})
+			return
+		}
+		ep.bulkheads.setLimit(route, limit)
+		msg = fmt.Sprintf("bulkhead limit of %s set to: %d", route, limit)
+	}
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+		Data:    ep.bulkheads.stats(),
+	})
+}
@@ -0,0 +1,71 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import "testing"
+
+func TestParseBulkhead(t *testing.T) {
+	tests := []struct {
+		in    string
+		route string
+		limit int
+		ok    bool
+	}{
+		{"/proxy/{service}=10", "/proxy/{service}", 10, true},
+		{"*=50", "*", 50, true},
+		{" /delay/{duration} = 0", "/delay/{duration}", 0, true},
+		{"/delay/{duration}", "", 0, false},
+		{"=10", "", 0, false},
+		{"*=-1", "", 0, false},
+		{"*=many", "", 0, false},
+	}
+	for _, tt := range tests {
+		route, limit, err := parseBulkhead(tt.in)
+		if route != tt.route || limit != tt.limit || (err == nil) != tt.ok {
+			t.Errorf("%q: expected %q=%d (%t), got %q=%d (%v)",
+				tt.in, tt.route, tt.limit, tt.ok, route, limit, err)
+		}
+	}
+}
+
+func TestBulkhead(t *testing.T) {
+	b := newBulkhead()
+	b.setLimit(bulkheadDefault, 2)
+	b.setLimit("/delay/{duration}", 1)
+
+	if !b.acquire("/delay/{duration}", 100) || b.acquire("/delay/{duration}", 100) {
+		t.Error("expected route limit of 1 to apply")
+	}
+	if !b.acquire("/status/{code}", 100) || !b.acquire("/status/{code}", 100) || b.acquire("/status/{code}", 100) {
+		t.Error("expected default limit of 2 to apply")
+	}
+	b.release("/delay/{duration}")
+	if !b.acquire("/delay/{duration}", 100) {
+		t.Error("expected released slot to be available")
+	}
+	b.setLimit("/delay/{duration}", 0)
+	if !b.acquire("/delay/{duration}", 100) || b.acquire("/delay/{duration}", 100) {
+		t.Error("expected default limit to apply after removing the route limit")
+	}
+	b.release("/delay/{duration}")
+	b.setLimit("/delay/{duration}", 4)
+	if !b.acquire("/delay/{duration}", 50) || b.acquire("/delay/{duration}", 50) || !b.acquire("/delay/{duration}", 100) {
+		t.Error("expected a share of 50% to limit to 2 of 4 slots")
+	}
+	b.setLimit(bulkheadDefault, 0)
+	if !b.acquire("/status/{code}", 0) {
+		t.Error("expected a limit of 0 to be unlimited")
+	}
+}
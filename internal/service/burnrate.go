@@ -0,0 +1,215 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+const defaultBurnSLO = 99.9
+
+// burnMetrics holds the request and error counters of the burn rate schedule,
+// exposed through expvar.
+var burnMetrics = expvar.NewMap("burnrate")
+
+// burnPhase fails requests at rate times the error budget of the SLO for the
+// duration of the phase. A phase without duration lasts forever.
+type burnPhase struct {
+	rate     float64
+	duration time.Duration
+}
+
+func (p burnPhase) String() string {
+	s := strconv.FormatFloat(p.rate, 'f', -1, 64) + "x"
+	if p.duration > 0 {
+		s += ":" + p.duration.String()
+	}
+	return s
+}
+
+// parseBurnSchedule parses burn rate phases as rate[x][:duration], e.g.
+// "14x:5m" followed by "6x:1h". Only the last phase may omit its duration.
+func parseBurnSchedule(phases []string) ([]burnPhase, error) {
+	schedule := make([]burnPhase, 0, len(phases))
+	for i, s := range phases {
+		var (
+			p   burnPhase
+			err error
+		)
+		rate, duration := s, ""
+		if idx := strings.IndexByte(s, ':'); idx >= 0 {
+			rate, duration = s[:idx], s[idx+1:]
+		}
+		if p.rate, err = strconv.ParseFloat(strings.TrimSuffix(rate, "x"), 64); err != nil || p.rate < 0 {
+			return nil, errBurnRate
+		}
+		if duration != "" {
+			if p.duration, err = parseDuration(duration); err != nil || p.duration <= 0 {
+				return nil, errBurnRate
+			}
+		} else if i < len(phases)-1 {
+			return nil, errBurnRate
+		}
+		schedule = append(schedule, p)
+	}
+	return schedule, nil
+}
+
+// burnRate modulates the error rate over time to burn the error budget of an
+// SLO at the rates of the schedule, allowing multi-window burn rate alerts to
+// be validated. The schedule starts with the service and ends after its last
+// phase, failing no more requests.
+type burnRate struct {
+	mtx      sync.Mutex
+	slo      float64
+	schedule []burnPhase
+	start    time.Time
+}
+
+// burnStats holds the settings and progress of the burn rate schedule.
+type burnStats struct {
+	SLO      float64  `json:"slo"`
+	Schedule []string `json:"schedule"`
+	Phase    int      `json:"phase"`
+	Rate     float64  `json:"rate"`
+	Elapsed  string   `json:"elapsed"`
+	Done     bool     `json:"done"`
+}
+
+// phase returns the index of the current phase, or -1 once the schedule
+// ended. The caller must hold the mutex.
+func (b *burnRate) phase(now time.Time) int {
+	elapsed := now.Sub(b.start)
+	for i, p := range b.schedule {
+		if p.duration <= 0 || elapsed < p.duration {
+			return i
+		}
+		elapsed -= p.duration
+	}
+	return -1
+}
+
+// errorRatio returns the burn rate and the resulting fraction of requests to
+// fail. Without an active phase ok is false.
+func (b *burnRate) errorRatio(now time.Time) (rate, ratio float64, ok bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	i := b.phase(now)
+	if i < 0 {
+		return 0, 0, false
+	}
+	rate = b.schedule[i].rate
+	return rate, rate * (100 - b.slo) / 100, true
+}
+
+// restart starts the schedule again, optionally replacing it.
+func (b *burnRate) restart(schedule []burnPhase) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if schedule != nil {
+		b.schedule = schedule
+	}
+	b.start = time.Now()
+}
+
+func (b *burnRate) stats() burnStats {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	now := time.Now()
+	s := burnStats{
+		SLO:      b.slo,
+		Schedule: make([]string, 0, len(b.schedule)),
+		Phase:    b.phase(now),
+		Elapsed:  now.Sub(b.start).Round(time.Second).String(),
+	}
+	for _, p := range b.schedule {
+		s.Schedule = append(s.Schedule, p.String())
+	}
+	if s.Done = s.Phase < 0; !s.Done {
+		s.Rate = b.schedule[s.Phase].rate
+	}
+	return s
+}
+
+// burning reports whether the request is to fail according to the current
+// burn rate phase, tagging the server span with the burn rate.
+func (ep *Endpoints) burning(ctx context.Context) bool {
+	rate, ratio, ok := ep.burn.errorRatio(time.Now())
+	if !ok {
+		return false
+	}
+	zipkin.SpanOrNoopFromContext(ctx).Tag("burnrate", strconv.FormatFloat(rate, 'f', -1, 64))
+	burnMetrics.Add("requests", 1)
+	if ep.rand.Float64() >= ratio {
+		return false
+	}
+	burnMetrics.Add("errors", 1)
+	return true
+}
+
+// burnRateState allows one to inspect the burn rate schedule, restart it or
+// start a new schedule of comma separated rate[x][:duration] phases.
+//
+// Example paths:
+//
+//	/admin/burnrate                          show the schedule and progress
+//	/admin/burnrate/restart                  start the schedule again
+//	/admin/burnrate/schedule/14x:5m,6x:1h    start a new schedule
+func (ep *Endpoints) burnRateState(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var msg string
+	if v, ok := mux.Vars(r)["schedule"]; ok {
+		schedule, err := parseBurnSchedule(strings.Split(v, ","))
+		if err != nil || !validBurnSchedule(ep.burn.slo, schedule) {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errBurnRate,
+			})
+			return
+		}
+		ep.burn.restart(schedule)
+		msg = fmt.Sprintf("burn rate schedule set to: %s", v)
+	} else if _, ok := mux.Vars(r)["action"]; ok {
+		ep.burn.restart(nil)
+		msg = "burn rate schedule restarted"
+	}
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+		Data:    ep.burn.stats(),
+	})
+}
+
+// validBurnSchedule reports whether the error budget of the SLO allows each
+// burn rate of the schedule, e.g. at most 1000x for a 99.9% SLO.
+func validBurnSchedule(slo float64, schedule []burnPhase) bool {
+	for _, p := range schedule {
+		if p.rate*(100-slo) > 100 {
+			return false
+		}
+	}
+	return true
+}
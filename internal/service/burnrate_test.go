@@ -0,0 +1,62 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBurnRate(t *testing.T) {
+	schedule, err := parseBurnSchedule([]string{"14x:5m", "6:1h", "1x"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	start := time.Now()
+	b := &burnRate{slo: 99.9, schedule: schedule, start: start}
+	tests := []struct {
+		at    time.Duration
+		rate  float64
+		ratio float64
+		ok    bool
+	}{
+		{0, 14, 0.014, true},
+		{5 * time.Minute, 6, 0.006, true},
+		{65 * time.Minute, 1, 0.001, true},
+		{48 * time.Hour, 1, 0.001, true},
+	}
+	for _, tt := range tests {
+		rate, ratio, ok := b.errorRatio(start.Add(tt.at))
+		if rate != tt.rate || math.Abs(ratio-tt.ratio) > 1e-9 || ok != tt.ok {
+			t.Errorf("at %s: expected %gx, %g (%t), got %gx, %g (%t)",
+				tt.at, tt.rate, tt.ratio, tt.ok, rate, ratio, ok)
+		}
+	}
+
+	b.schedule = schedule[:2]
+	if _, _, ok := b.errorRatio(start.Add(65 * time.Minute)); ok {
+		t.Error("expected schedule to end after its last phase")
+	}
+
+	for _, s := range [][]string{{"-1x:5m"}, {"14x:0s"}, {"1x", "2x:5m"}, {"fast:5m"}} {
+		if _, err := parseBurnSchedule(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+	if validBurnSchedule(99.9, []burnPhase{{rate: 1001}}) {
+		t.Error("expected burn rate exceeding the error budget to be invalid")
+	}
+}
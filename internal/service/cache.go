@@ -0,0 +1,208 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"container/list"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// cacheMetrics holds the proxy cache counters, exposed through expvar.
+var cacheMetrics = expvar.NewMap("proxy_cache")
+
+// cacheEntry holds a cached downstream response.
+type cacheEntry struct {
+	key     string
+	code    int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// cacheStats holds the proxy cache statistics as reported by /admin/cache.
+type cacheStats struct {
+	TTL       string `json:"ttl"`
+	Size      int    `json:"size"`
+	Entries   int    `json:"entries"`
+	Hits      int64  `json:"hits"`
+	Misses    int64  `json:"misses"`
+	Evictions int64  `json:"evictions"`
+}
+
+// responseCache is a size bounded LRU cache with TTL expiry for successful
+// downstream responses of the proxy handler.
+type responseCache struct {
+	mtx       sync.Mutex
+	ttl       time.Duration
+	size      int
+	ll        *list.List
+	items     map[string]*list.Element
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+func newResponseCache(ttl time.Duration, size int) *responseCache {
+	return &responseCache{
+		ttl:   ttl,
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// enabled returns true if responses are to be cached.
+func (c *responseCache) enabled() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.ttl > 0 && c.size > 0
+}
+
+// get returns the cached response for key if it exists and has not expired.
+func (c *responseCache) get(key string) (*cacheEntry, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*cacheEntry)
+		if time.Now().Before(e.expires) {
+			c.ll.MoveToFront(el)
+			c.hits++
+			cacheMetrics.Add("hits", 1)
+			return e, true
+		}
+		c.remove(el)
+	}
+	c.misses++
+	cacheMetrics.Add("misses", 1)
+	return nil, false
+}
+
+// set stores the response for key, evicting the least recently used entries if
+// the cache is full.
+func (c *responseCache) set(key string, code int, header http.Header, body []byte) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.ttl <= 0 || c.size <= 0 {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{
+		key:     key,
+		code:    code,
+		header:  header.Clone(),
+		body:    body,
+		expires: time.Now().Add(c.ttl),
+	})
+	for c.ll.Len() > c.size {
+		c.remove(c.ll.Back())
+		c.evictions++
+		cacheMetrics.Add("evictions", 1)
+	}
+}
+
+// purge removes all entries from the cache.
+func (c *responseCache) purge() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+// setTTL updates the cache TTL. A TTL of 0 disables the cache and purges it.
+func (c *responseCache) setTTL(ttl time.Duration) {
+	c.mtx.Lock()
+	c.ttl = ttl
+	c.mtx.Unlock()
+	if ttl <= 0 {
+		c.purge()
+	}
+}
+
+func (c *responseCache) stats() cacheStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return cacheStats{
+		TTL:       c.ttl.String(),
+		Size:      c.size,
+		Entries:   c.ll.Len(),
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// remove must be called while holding the cache mutex.
+func (c *responseCache) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry).key)
+}
+
+// write replays the cached response.
+func (e *cacheEntry) write(w http.ResponseWriter) {
+	for k, v := range e.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Cache", "HIT")
+	w.Header().Set("Content-Length", strconv.Itoa(len(e.body)))
+	w.WriteHeader(e.code)
+	_, _ = w.Write(e.body)
+}
+
+// cache allows one to inspect and control the proxy response cache.
+//
+// Example paths:
+//
+//	/admin/cache             show cache statistics
+//	/admin/cache/purge       remove all cached responses
+//	/admin/cache/ttl/30s     set the cache TTL, 0 disables the cache
+func (ep *Endpoints) cache(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	var msg string
+	switch vars["action"] {
+	case "purge":
+		ep.respCache.purge()
+		msg = "cache purged"
+	case "ttl":
+		d, err := time.ParseDuration(vars["duration"])
+		if err != nil || d < 0 {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errDuration,
+			})
+			return
+		}
+		ep.respCache.setTTL(d)
+		msg = fmt.Sprintf("cache ttl set to: %s", d)
+	}
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+		Data:    ep.respCache.stats(),
+	})
+}
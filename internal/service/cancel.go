@@ -0,0 +1,59 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// cancelMetrics counts the requests abandoned by their client, exposed through
+// expvar.
+var cancelMetrics = expvar.NewMap("cancellations")
+
+// sleep waits for the duration unless the context is done first. It returns
+// false if the wait was cut short.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// cancellation tags the server span of requests whose client disconnected
+// before a response was completed with cancelled=true and counts them, so
+// abandoned requests don't show up as successful slow requests. Handlers abort
+// their injected latency and downstream calls as soon as the client is gone.
+func (ep *Endpoints) cancellation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if r.Context().Err() != context.Canceled {
+			return
+		}
+		zipkin.SpanOrNoopFromContext(r.Context()).Tag("cancelled", "true")
+		cancelMetrics.Add("requests", 1)
+	})
+}
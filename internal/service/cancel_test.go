@@ -0,0 +1,78 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	zmw "github.com/openzipkin/zipkin-go/middleware/http"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+)
+
+func TestSleep(t *testing.T) {
+	if !sleep(context.Background(), time.Millisecond) {
+		t.Errorf("expected sleep to complete")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if sleep(ctx, time.Minute) {
+		t.Errorf("expected sleep to be cut short")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("expected sleep to return on cancellation")
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	rec := recorder.NewReporter()
+	tracer, err := zipkin.NewTracer(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := &Endpoints{}
+	handler := zmw.NewServerMiddleware(tracer)(
+		ep.cancellation(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sleep(r.Context(), time.Minute)
+		})),
+	)
+	serve := func(ctx context.Context) string {
+		_ = rec.Flush()
+		req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		for _, s := range rec.Flush() {
+			if s.Kind == "SERVER" {
+				return s.Tags["cancelled"]
+			}
+		}
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if tag := serve(ctx); tag != "" {
+		t.Errorf("expected timed out request not to be tagged as cancelled, got %q", tag)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if tag := serve(ctx); tag != "true" {
+		t.Errorf("expected cancelled request to be tagged, got %q", tag)
+	}
+}
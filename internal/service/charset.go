@@ -0,0 +1,215 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// supported charset faults
+const (
+	charsetFaultMismatch = "mismatch"
+	charsetFaultBOM      = "bom"
+	charsetFaultInvalid  = "invalid"
+)
+
+// defaultMismatchCharset is declared by the mismatch fault unless another
+// charset is requested. Decoding the UTF-8 responses as UTF-16 garbles them.
+const defaultMismatchCharset = "utf-16"
+
+var (
+	utf8BOM     = []byte{0xef, 0xbb, 0xbf}
+	invalidUTF8 = []byte{0xc3, 0x28} // truncated two byte sequence
+)
+
+// charsetFault holds the charset fault settings.
+type charsetFault struct {
+	kind       string
+	percentage int32
+	charset    string
+}
+
+// charsetWriter corrupts the response as set by the charset fault. The
+// mismatch fault declares a charset other than the one used, the bom fault
+// prefixes the body with a UTF-8 byte order mark, which JSON forbids, and the
+// invalid fault injects an invalid UTF-8 sequence in the first JSON string
+// value, or at the start of the body if none is found in the first write.
+type charsetWriter struct {
+	http.ResponseWriter
+	fault       charsetFault
+	wroteHeader bool
+	wroteBody   bool
+}
+
+func (c *charsetWriter) WriteHeader(code int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+	h := c.Header()
+	if c.fault.kind == charsetFaultMismatch {
+		mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+		if err != nil {
+			mediaType, params = "text/plain", map[string]string{}
+		}
+		params["charset"] = c.fault.charset
+		h.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+	} else {
+		// the body changes size
+		h.Del("Content-Length")
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *charsetWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.wroteBody || len(b) == 0 {
+		return c.ResponseWriter.Write(b)
+	}
+	c.wroteBody = true
+	var out []byte
+	switch c.fault.kind {
+	case charsetFaultBOM:
+		out = append(append(out, utf8BOM...), b...)
+	case charsetFaultInvalid:
+		i := bytes.Index(b, []byte(`": "`)) + len(`": "`)
+		if i < len(`": "`) {
+			i = 0
+		}
+		out = append(append(append(out, b[:i]...), invalidUTF8...), b[i:]...)
+	default:
+		return c.ResponseWriter.Write(b)
+	}
+	if _, err := c.ResponseWriter.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Flush implements http.Flusher.
+func (c *charsetWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (c *charsetWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := c.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// validCharset returns true if s is a valid charset name as registered with
+// IANA, e.g. utf-16 or iso-8859-1.
+func validCharset(s string) bool {
+	if s == "" || len(s) > 40 {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("-_.:+()", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// charsetFaults corrupts the charset of a percentage of responses. It runs
+// inside the compression middleware, so compressed responses are corrupted
+// before being compressed.
+func (ep *Endpoints) charsetFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ep.mtx.RLock()
+		f := ep.charsetFault
+		ep.mtx.RUnlock()
+
+		if f.percentage == 0 || ep.rand.Int31n(100) >= f.percentage {
+			next.ServeHTTP(w, r)
+			return
+		}
+		span := zipkin.SpanOrNoopFromContext(r.Context())
+		span.Tag("fault", "charset")
+		span.Tag("fault.charset.kind", f.kind)
+		next.ServeHTTP(&charsetWriter{ResponseWriter: w, fault: f}, r)
+	})
+}
+
+// setCharsetFault allows one to set the percentage of responses declaring a
+// charset other than UTF-8 (mismatch), starting with a byte order mark (bom)
+// or holding invalid UTF-8 (invalid), to test strict clients and proxies
+// validating content. The charset query parameter sets the charset declared
+// by the mismatch fault, utf-16 by default. Setting a charset fault replaces
+// the previous one and a percentage of 0 disables it.
+//
+// Example paths:
+//
+//	/charset/mismatch/100                     declare utf-16 in all responses
+//	/charset/mismatch/50?charset=iso-8859-1   declare latin-1 in half of them
+//	/charset/bom/10                           BOM prefixed bodies in 10%
+//	/charset/invalid/100                      invalid UTF-8 in all JSON bodies
+func (ep *Endpoints) setCharsetFault(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	f := charsetFault{kind: mux.Vars(r)["kind"], charset: defaultMismatchCharset}
+
+	p, err := strconv.Atoi(mux.Vars(r)["percentage"])
+	if err != nil || p < 0 || p > 100 {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errPercentage,
+		})
+		return
+	}
+	f.percentage = int32(p)
+	if v := r.URL.Query().Get("charset"); v != "" {
+		if !validCharset(v) {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errCharset,
+			})
+			return
+		}
+		f.charset = v
+	}
+
+	ep.mtx.Lock()
+	ep.charsetFault = f
+	ep.mtx.Unlock()
+
+	msg := fmt.Sprintf("%s charset fault set to: %d%%", f.kind, f.percentage)
+	if f.kind == charsetFaultMismatch {
+		msg += " declaring " + f.charset
+	}
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+	})
+}
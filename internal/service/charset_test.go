@@ -0,0 +1,64 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCharsetFaults(t *testing.T) {
+	body := []byte("{\n  \"service\": \"svca\"\n}\n")
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Length", "25")
+		_, _ = w.Write(body)
+	}
+	serve := func(f charsetFault) *httptest.ResponseRecorder {
+		ep := &Endpoints{charsetFault: f, rand: newFaultRand(1)}
+		rec := httptest.NewRecorder()
+		ep.charsetFaults(http.HandlerFunc(handler)).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec
+	}
+
+	rec := serve(charsetFault{kind: charsetFaultMismatch, percentage: 100, charset: "iso-8859-1"})
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json; charset=iso-8859-1" {
+		t.Errorf("expected mismatching charset, got %q", ct)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), body) {
+		t.Errorf("expected body to be unchanged, got %q", rec.Body.String())
+	}
+
+	rec = serve(charsetFault{kind: charsetFaultBOM, percentage: 100})
+	if !bytes.HasPrefix(rec.Body.Bytes(), utf8BOM) || rec.Body.Len() != len(body)+len(utf8BOM) {
+		t.Errorf("expected BOM prefixed body, got %q", rec.Body.String())
+	}
+	if rec.Header().Get("Content-Length") != "" {
+		t.Error("expected Content-Length to be removed")
+	}
+
+	rec = serve(charsetFault{kind: charsetFaultInvalid, percentage: 100})
+	if utf8.Valid(rec.Body.Bytes()) || !bytes.Contains(rec.Body.Bytes(), []byte("\": \"\xc3(svca\"")) {
+		t.Errorf("expected invalid UTF-8 in the first string value, got %q", rec.Body.String())
+	}
+
+	rec = serve(charsetFault{kind: charsetFaultInvalid})
+	if !bytes.Equal(rec.Body.Bytes(), body) {
+		t.Errorf("expected disabled fault to leave the body unchanged, got %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// maxChunkSize limits the size of a single chunk sent by the chunked handler.
+const maxChunkSize = 1 << 20
+
+// chunked forces a chunked transfer encoded response of count chunks of the
+// provided size, flushing each chunk and optionally pausing between them. The
+// response ends with HTTP trailers holding the chunk count and a checksum of
+// the body, and gRPC style grpc-status and grpc-message trailers if a
+// grpc-status is requested.
+//
+// Example paths:
+//
+//	/chunked/10/1024                       10 chunks of 1KiB
+//	/chunked/5/64?interval=500ms           5 chunks, 500ms apart
+//	/chunked/1/16?grpc-status=14           gRPC UNAVAILABLE in trailers
+func (ep *Endpoints) chunked(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	count, err := strconv.Atoi(vars["count"])
+	if err != nil || count < 0 {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errCount,
+		})
+		return
+	}
+	size, err := strconv.Atoi(vars["size"])
+	if err != nil || size < 1 || size > maxChunkSize {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errChunkSize,
+		})
+		return
+	}
+	q := r.URL.Query()
+	var interval time.Duration
+	if i := q.Get("interval"); i != "" {
+		if interval, err = parseDuration(i); err != nil || interval < 0 {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errDuration,
+			})
+			return
+		}
+	}
+	grpcStatus := q.Get("grpc-status")
+	if grpcStatus != "" {
+		if _, err = strconv.Atoi(grpcStatus); err != nil {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errGRPCStatus,
+			})
+			return
+		}
+	}
+
+	span := zipkin.SpanOrNoopFromContext(ctx)
+	span.Tag("chunked.count", strconv.Itoa(count))
+	span.Tag("chunked.size", strconv.Itoa(size))
+
+	h := w.Header()
+	h.Set("Content-Type", "application/octet-stream")
+	h.Add("Trailer", "X-Chunk-Count")
+	h.Add("Trailer", "X-Checksum")
+	if grpcStatus != "" {
+		h.Add("Trailer", "Grpc-Status")
+		h.Add("Trailer", "Grpc-Message")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	chunk := bytes.Repeat([]byte("x"), size)
+	sum := sha256.New()
+	sent := 0
+	for ; sent < count; sent++ {
+		if sent > 0 && interval > 0 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return
+			}
+		}
+		if _, err = w.Write(chunk); err != nil {
+			return
+		}
+		_, _ = sum.Write(chunk)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	h.Set("X-Chunk-Count", strconv.Itoa(sent))
+	h.Set("X-Checksum", "sha256:"+hex.EncodeToString(sum.Sum(nil)))
+	if grpcStatus != "" {
+		h.Set("Grpc-Status", grpcStatus)
+		h.Set("Grpc-Message", "emulated grpc-status "+grpcStatus)
+	}
+}
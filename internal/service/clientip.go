@@ -0,0 +1,140 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// clientIPConfig holds the settings for determining the original client IP of
+// requests which passed through proxies.
+type clientIPConfig struct {
+	hops  int
+	cidrs []*net.IPNet
+}
+
+// trusted returns true if the address at the provided position in the chain,
+// counting from the closest hop, belongs to a trusted proxy.
+func (c clientIPConfig) trusted(pos int, ip net.IP) bool {
+	if pos < c.hops {
+		return true
+	}
+	for _, cidr := range c.cidrs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDRs parses a list of CIDRs or plain IP addresses.
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	cidrs := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, cidr, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
+
+// forwardedChain returns the chain of addresses the request passed through,
+// ordered from the original client to the closest hop. The Forwarded header
+// takes precedence over X-Forwarded-For.
+func forwardedChain(h http.Header) []string {
+	var chain []string
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+					if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+						chain = append(chain, forwardedNode(kv[1]))
+					}
+				}
+			}
+		}
+		return chain
+	}
+	for _, value := range h.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(value, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				chain = append(chain, addr)
+			}
+		}
+	}
+	return chain
+}
+
+// forwardedNode strips quotes, brackets and ports from a Forwarded node.
+func forwardedNode(node string) string {
+	node = strings.Trim(node, `"`)
+	if strings.HasPrefix(node, "[") {
+		if i := strings.Index(node, "]"); i > 0 {
+			return node[1:i]
+		}
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return node
+}
+
+// hostIP returns the IP part of a host:port address.
+func hostIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// clientIP returns the original client IP of the request. Walking the chain of
+// forwarded addresses back from the peer address, trusted hops are skipped and
+// the first untrusted address is returned. Obfuscated or unparsable addresses
+// stop the walk.
+func (c clientIPConfig) clientIP(r *http.Request) string {
+	chain := append(forwardedChain(r.Header), hostIP(r.RemoteAddr))
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := net.ParseIP(chain[i])
+		if ip == nil || i == 0 || !c.trusted(len(chain)-1-i, ip) {
+			return chain[i]
+		}
+	}
+	return ""
+}
+
+// clientIPTagger tags server spans with the original client IP and the peer
+// address the request was received from.
+func (ep *Endpoints) clientIPTagger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := zipkin.SpanOrNoopFromContext(r.Context())
+		span.Tag("client.ip", ep.clientIPCfg.clientIP(r))
+		span.Tag("net.peer.ip", hostIP(r.RemoteAddr))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,64 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	cidrs, err := parseCIDRs([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		cfg      clientIPConfig
+		header   http.Header
+		expected string
+	}{
+		{"no headers", clientIPConfig{}, nil, "10.1.1.1"},
+		{"untrusted", clientIPConfig{}, http.Header{
+			"X-Forwarded-For": {"1.2.3.4"},
+		}, "10.1.1.1"},
+		{"one hop", clientIPConfig{hops: 1}, http.Header{
+			"X-Forwarded-For": {"6.6.6.6, 1.2.3.4"},
+		}, "1.2.3.4"},
+		{"two hops", clientIPConfig{hops: 2}, http.Header{
+			"X-Forwarded-For": {"1.2.3.4, 5.6.7.8"},
+		}, "1.2.3.4"},
+		{"too many hops", clientIPConfig{hops: 5}, http.Header{
+			"X-Forwarded-For": {"1.2.3.4", "5.6.7.8"},
+		}, "1.2.3.4"},
+		{"cidrs", clientIPConfig{cidrs: cidrs}, http.Header{
+			"X-Forwarded-For": {"1.2.3.4, 192.168.1.1, 10.2.2.2"},
+		}, "1.2.3.4"},
+		{"forwarded", clientIPConfig{hops: 1}, http.Header{
+			"Forwarded":       {`for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"`},
+			"X-Forwarded-For": {"6.6.6.6"},
+		}, "2001:db8::1"},
+		{"obfuscated", clientIPConfig{hops: 2}, http.Header{
+			"Forwarded": {`for=_hidden, for=192.0.2.60`},
+		}, "_hidden"},
+	}
+	for _, tt := range tests {
+		r := &http.Request{RemoteAddr: "10.1.1.1:1234", Header: tt.header}
+		if ip := tt.cfg.clientIP(r); ip != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.expected, ip)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// hop timing headers, allowing callers to compare their wall clock with the
+// wall clock of the next hop.
+const (
+	hopSentHeader   = "X-Topo-Sent"
+	hopTimingHeader = "X-Topo-Hop-Timing"
+	clockSkewHeader = "X-Topo-Clock-Skew"
+)
+
+// hopTiming holds the wall clock timestamps of a single call between two hops,
+// t1 and t4 taken by the caller and t2 and t3 taken by the callee.
+type hopTiming struct {
+	sent      time.Time // t1: request sent by the caller
+	received  time.Time // t2: request received by the callee
+	responded time.Time // t3: response sent by the callee
+	done      time.Time // t4: response received by the caller
+}
+
+// offset returns the apparent clock offset of the callee relative to the
+// caller, as calculated by NTP.
+func (h hopTiming) offset() time.Duration {
+	return (h.received.Sub(h.sent) + h.responded.Sub(h.done)) / 2
+}
+
+// roundTrip returns the time spent on the network, excluding the time the
+// callee spent handling the request.
+func (h hopTiming) roundTrip() time.Duration {
+	return h.done.Sub(h.sent) - h.responded.Sub(h.received)
+}
+
+// String returns the clock skew header value for the call from caller to
+// callee. Negative flight times are a tell of clock drift between the hops.
+func (h hopTiming) String(caller, callee string) string {
+	return fmt.Sprintf("%s->%s;offset=%s;rtt=%s;request=%s;response=%s",
+		caller, callee, h.offset(), h.roundTrip(),
+		h.received.Sub(h.sent), h.done.Sub(h.responded))
+}
+
+// parseHopTiming parses the hop timing header value returned by the callee,
+// holding its receive and respond timestamps in Unix nanoseconds.
+func parseHopTiming(v string) (received, responded time.Time, ok bool) {
+	for _, field := range strings.Split(v, ";") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return time.Time{}, time.Time{}, false
+		}
+		ns, err := strconv.ParseInt(kv[1], 10, 64)
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		switch kv[0] {
+		case "received":
+			received = time.Unix(0, ns)
+		case "responded":
+			responded = time.Unix(0, ns)
+		}
+	}
+	return received, responded, !received.IsZero() && !responded.IsZero()
+}
+
+// timingWriter sets the hop timing header when the response headers are
+// written.
+type timingWriter struct {
+	http.ResponseWriter
+	now         func() time.Time
+	received    time.Time
+	wroteHeader bool
+}
+
+func (t *timingWriter) WriteHeader(code int) {
+	if t.wroteHeader {
+		return
+	}
+	t.wroteHeader = true
+	t.Header().Set(hopTimingHeader, fmt.Sprintf("received=%d;responded=%d",
+		t.received.UnixNano(), t.now().UnixNano()))
+	t.ResponseWriter.WriteHeader(code)
+}
+
+func (t *timingWriter) Write(b []byte) (int, error) {
+	if !t.wroteHeader {
+		t.WriteHeader(http.StatusOK)
+	}
+	return t.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher.
+func (t *timingWriter) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (t *timingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := t.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// now returns the wall clock of this instance, including the artificial clock
+// skew applied to its spans.
+func (ep *Endpoints) now() time.Time {
+	return time.Now().Add(ep.SvcTracer.ClockSkew)
+}
+
+// hopTimer reports the wall clock receive and respond timestamps to callers
+// which sent their own timestamp, so they can compute the apparent clock skew.
+// The apparent request flight time is tagged on the server span.
+func (ep *Endpoints) hopTimer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received := ep.now()
+		sent, err := strconv.ParseInt(r.Header.Get(hopSentHeader), 10, 64)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		zipkin.SpanOrNoopFromContext(r.Context()).Tag("clock.request.flight",
+			received.Sub(time.Unix(0, sent)).String())
+		next.ServeHTTP(&timingWriter{ResponseWriter: w, now: ep.now, received: received}, r)
+	})
+}
+
+// timeHop sends the request using rt, adding the clock skew details of the
+// call to the response headers if the callee reported its hop timing. As
+// responses pass back through the chain, the clock skew header accumulates an
+// entry per hop.
+func (ep *Endpoints) timeHop(rt http.RoundTripper, r *http.Request, callee string) (*http.Response, error) {
+	h := hopTiming{sent: ep.now()}
+	r.Header.Set(hopSentHeader, strconv.FormatInt(h.sent.UnixNano(), 10))
+	res, err := rt.RoundTrip(r)
+	if err != nil {
+		return res, err
+	}
+	h.done = ep.now()
+	var ok bool
+	if h.received, h.responded, ok = parseHopTiming(res.Header.Get(hopTimingHeader)); !ok {
+		return res, nil
+	}
+	res.Header.Del(hopTimingHeader)
+	res.Header.Add(clockSkewHeader, h.String(ep.ServiceName, callee))
+	span := zipkin.SpanOrNoopFromContext(r.Context())
+	span.Tag("clock.offset."+callee, h.offset().String())
+	span.Tag("clock.rtt."+callee, h.roundTrip().String())
+	return res, nil
+}
@@ -0,0 +1,52 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHopTiming(t *testing.T) {
+	t1 := time.Unix(1000, 0)
+	// callee clock runs 50ms ahead, 10ms network latency each way and 5ms of
+	// handling time
+	h := hopTiming{
+		sent:      t1,
+		received:  t1.Add(60 * time.Millisecond),
+		responded: t1.Add(65 * time.Millisecond),
+		done:      t1.Add(25 * time.Millisecond),
+	}
+	if o := h.offset(); o != 50*time.Millisecond {
+		t.Errorf("expected offset 50ms, got %s", o)
+	}
+	if rtt := h.roundTrip(); rtt != 20*time.Millisecond {
+		t.Errorf("expected rtt 20ms, got %s", rtt)
+	}
+	expected := "svca->svcb;offset=50ms;rtt=20ms;request=60ms;response=-40ms"
+	if s := h.String("svca", "svcb"); s != expected {
+		t.Errorf("expected %q, got %q", expected, s)
+	}
+
+	received, responded, ok := parseHopTiming("received=1000060000000;responded=1000065000000")
+	if !ok || !received.Equal(h.received) || !responded.Equal(h.responded) {
+		t.Errorf("unexpected hop timing: %v %v %v", received, responded, ok)
+	}
+	for _, v := range []string{"", "received=1", "received=x;responded=1", "received"} {
+		if _, _, ok = parseHopTiming(v); ok {
+			t.Errorf("%q: expected parse failure", v)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+
+	tzipkin "github.com/basvanbeek/topology-tester/pkg/zipkin"
+)
+
+// coalesceMetrics holds the request coalescing counters, exposed through
+// expvar. Leaders equal the outbound calls made, followers the calls saved.
+var coalesceMetrics = expvar.NewMap("coalescing")
+
+// flightRecorder buffers a downstream response so it can be shared with the
+// coalesced requests.
+type flightRecorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (f *flightRecorder) Header() http.Header {
+	return f.header
+}
+
+func (f *flightRecorder) WriteHeader(code int) {
+	if f.code == 0 {
+		f.code = code
+	}
+}
+
+func (f *flightRecorder) Write(b []byte) (int, error) {
+	if f.code == 0 {
+		f.code = http.StatusOK
+	}
+	return f.body.Write(b)
+}
+
+// writeTo writes the recorded response to w, labeling the role of the request.
+func (f *flightRecorder) writeTo(w http.ResponseWriter, role string) {
+	for k, v := range f.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Coalesce-Role", role)
+	w.Header().Set("Content-Length", strconv.Itoa(f.body.Len()))
+	code := f.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	w.WriteHeader(code)
+	_, _ = w.Write(f.body.Bytes())
+}
+
+// flight holds an outbound call shared by identical concurrent requests.
+type flight struct {
+	done      chan struct{}
+	res       *flightRecorder
+	leader    tzipkin.SpanContext
+	followers int
+}
+
+// flightGroup coalesces identical concurrent requests into a single call, as
+// done by singleflight.
+type flightGroup struct {
+	mtx     sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: make(map[string]*flight)}
+}
+
+// join returns the flight in progress for key, or starts a new one in which
+// case the caller is the leader and must land it.
+func (g *flightGroup) join(key string, sc tzipkin.SpanContext) (f *flight, leader bool) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if f, ok := g.flights[key]; ok {
+		f.followers++
+		return f, false
+	}
+	f = &flight{done: make(chan struct{}), leader: sc}
+	g.flights[key] = f
+	return f, true
+}
+
+// land shares the response of the flight with its followers, returning the
+// amount of followers.
+func (g *flightGroup) land(key string, f *flight, res *flightRecorder) int {
+	g.mtx.Lock()
+	delete(g.flights, key)
+	followers := f.followers
+	g.mtx.Unlock()
+
+	f.res = res
+	close(f.done)
+	return followers
+}
+
+// coalesce makes the outbound call using call, unless an identical call is in
+// flight already, in which case its response is shared. Server spans are
+// tagged with the role of the request and the leader's trace id or the amount
+// of followers. Followers record their wait in a span linked to the leader's
+// server span, as their response is produced in the leader's trace.
+func (ep *Endpoints) coalesce(w http.ResponseWriter, r *http.Request, key string, call func(w http.ResponseWriter)) {
+	ctx := r.Context()
+	span := zipkin.SpanOrNoopFromContext(ctx)
+	f, leader := ep.flights.join(key, span.Context())
+	if !leader {
+		coalesceMetrics.Add("followers", 1)
+		span.Tag("coalesce.role", "follower")
+		span.Tag("coalesce.leader.traceid", f.leader.TraceID.String())
+		wait, _ := ep.tracer.StartSpanFromContext(ctx, "coalesce-wait", tzipkin.Links(f.leader))
+		select {
+		case <-ctx.Done():
+			wait.Finish()
+		case <-f.done:
+			wait.Finish()
+			f.res.writeTo(w, "follower")
+		}
+		return
+	}
+
+	coalesceMetrics.Add("leaders", 1)
+	span.Tag("coalesce.role", "leader")
+	rec := &flightRecorder{header: make(http.Header)}
+	defer func() {
+		// followers must never wait forever, not even if the call panics
+		followers := ep.flights.land(key, f, rec)
+		span.Tag("coalesce.followers", strconv.Itoa(followers))
+	}()
+	call(rec)
+	rec.writeTo(w, "leader")
+}
+
+// setCoalesce allows one to enable or disable coalescing of identical
+// concurrent GET and HEAD requests by the proxy handler into a single
+// downstream call.
+//
+// Example paths:
+//
+//	/coalesce/on      coalesce identical concurrent proxy requests
+//	/coalesce/off     make a downstream call for each proxy request
+func (ep *Endpoints) setCoalesce(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	enabled, err := parseBool(mux.Vars(r)["enabled"])
+	if err != nil {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errBool,
+		})
+		return
+	}
+
+	ep.coalescing.Store(enabled)
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: fmt.Sprintf("request coalescing set to: %t", enabled),
+	})
+}
@@ -0,0 +1,126 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	zmw "github.com/openzipkin/zipkin-go/middleware/http"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+)
+
+func TestFlightGroup(t *testing.T) {
+	g := newFlightGroup()
+
+	sc := func(id uint64) model.SpanContext {
+		return model.SpanContext{TraceID: model.TraceID{Low: id}, ID: model.ID(id)}
+	}
+	leader, ok := g.join("GET svcb/a", sc(1))
+	if !ok {
+		t.Fatal("expected first request to lead the flight")
+	}
+	for i := 0; i < 2; i++ {
+		f, ok := g.join("GET svcb/a", sc(2))
+		if ok || f != leader || f.leader != sc(1) {
+			t.Fatalf("expected request %d to follow the flight of trace 1", i)
+		}
+	}
+	if _, ok := g.join("GET svcb/b", sc(3)); !ok {
+		t.Error("expected request for another key to lead its own flight")
+	}
+
+	res := &flightRecorder{code: 200}
+	if followers := g.land("GET svcb/a", leader, res); followers != 2 {
+		t.Errorf("expected 2 followers, got %d", followers)
+	}
+	<-leader.done
+	if leader.res != res {
+		t.Error("expected followers to share the leader's response")
+	}
+	if _, ok := g.join("GET svcb/a", sc(4)); !ok {
+		t.Error("expected request after landing to lead a new flight")
+	}
+}
+
+func TestCoalesceLinks(t *testing.T) {
+	rec := recorder.NewReporter()
+	tracer, err := zipkin.NewTracer(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := &Endpoints{flights: newFlightGroup(), tracer: tracer}
+	release := make(chan struct{})
+	handler := zmw.NewServerMiddleware(tracer)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ep.coalesce(w, r, "GET svcb/a", func(w http.ResponseWriter) {
+			<-release
+			_, _ = w.Write([]byte("ok"))
+		})
+	}))
+	serve := func() <-chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/proxy/svcb/a", nil))
+			done <- w
+		}()
+		return done
+	}
+	followers := func() int {
+		ep.flights.mtx.Lock()
+		defer ep.flights.mtx.Unlock()
+		if f, ok := ep.flights.flights["GET svcb/a"]; ok {
+			return f.followers
+		}
+		return -1
+	}
+
+	leader := serve()
+	for followers() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	follower := serve()
+	for followers() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	for _, done := range []<-chan *httptest.ResponseRecorder{leader, follower} {
+		if w := <-done; w.Body.String() != "ok" {
+			t.Errorf("expected the shared response, got %q", w.Body.String())
+		}
+	}
+
+	spans := make(map[string]model.SpanModel)
+	for _, s := range rec.Flush() {
+		key := s.Name
+		if s.Kind == model.Server {
+			key = s.Tags["coalesce.role"]
+		}
+		spans[key] = s
+	}
+	wait, ok := spans["coalesce-wait"]
+	if !ok {
+		t.Fatalf("expected a coalesce-wait span, got %v", spans)
+	}
+	if l, f := spans["leader"], spans["follower"]; wait.TraceID != f.TraceID ||
+		wait.Tags["link.0.traceid"] != l.TraceID.String() ||
+		wait.Tags["link.0.spanid"] != l.ID.String() {
+		t.Errorf("expected the follower's wait to link to the leader's span, got %v", wait.Tags)
+	}
+}
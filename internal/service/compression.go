@@ -0,0 +1,140 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// supported response content encodings
+const (
+	encodingGzip   = "gzip"
+	encodingBrotli = "br"
+)
+
+// encodeWriter compresses the response body using the negotiated encoding,
+// unless the handler already set a Content-Encoding itself (e.g. a proxied
+// response which was compressed downstream).
+type encodeWriter struct {
+	http.ResponseWriter
+	encoding    string
+	enc         io.WriteCloser
+	wroteHeader bool
+}
+
+func (e *encodeWriter) WriteHeader(code int) {
+	if e.wroteHeader {
+		return
+	}
+	e.wroteHeader = true
+	h := e.Header()
+	if h.Get("Content-Encoding") == "" && code != http.StatusNoContent &&
+		code != http.StatusNotModified {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", e.encoding)
+		h.Add("Vary", "Accept-Encoding")
+		switch e.encoding {
+		case encodingGzip:
+			e.enc = gzip.NewWriter(e.ResponseWriter)
+		case encodingBrotli:
+			e.enc = brotli.NewWriter(e.ResponseWriter)
+		}
+	}
+	e.ResponseWriter.WriteHeader(code)
+}
+
+func (e *encodeWriter) Write(b []byte) (int, error) {
+	if !e.wroteHeader {
+		e.WriteHeader(http.StatusOK)
+	}
+	if e.enc == nil {
+		return e.ResponseWriter.Write(b)
+	}
+	return e.enc.Write(b)
+}
+
+// Flush implements http.Flusher.
+func (e *encodeWriter) Flush() {
+	if f, ok := e.enc.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := e.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (e *encodeWriter) close() {
+	if e.enc != nil {
+		_ = e.enc.Close()
+	}
+}
+
+// negotiateEncoding returns the first of the enabled encodings accepted by the
+// client or an empty string if none are acceptable.
+func negotiateEncoding(acceptEncoding string, enabled []string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(fields) > 1 && strings.TrimSpace(fields[1]) == "q=0" {
+			continue
+		}
+		accepted[name] = true
+	}
+	for _, enc := range enabled {
+		if accepted[enc] || accepted["*"] {
+			return enc
+		}
+	}
+	return ""
+}
+
+// compression compresses responses using the enabled encodings as negotiated
+// with the client. It also injects the bad encoding fault, where responses
+// claim to be gzip encoded while the body is sent uncompressed.
+func (ep *Endpoints) compression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := ep.badEncoding.Load()
+		topoKnobsFromContext(r.Context()).percentage("badencoding", &b)
+
+		if ep.rand.Int31n(100) < b {
+			zipkin.SpanOrNoopFromContext(r.Context()).Tag("fault", "bad-encoding")
+			w.Header().Set("Content-Encoding", encodingGzip)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		enc := negotiateEncoding(r.Header.Get("Accept-Encoding"), ep.compressions)
+		if enc == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ew := &encodeWriter{ResponseWriter: w, encoding: enc}
+		defer ew.close()
+		next.ServeHTTP(ew, r)
+	})
+}
+
+// setBadEncoding allows one to set the percentage of responses claiming to be
+// gzip encoded while being sent uncompressed.
+func (ep *Endpoints) setBadEncoding(w http.ResponseWriter, r *http.Request) {
+	ep.setPercentage(w, r, &ep.badEncoding, "bad encoding")
+}
@@ -0,0 +1,329 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+const defaultProcs = 8
+
+// supported proc latency distributions
+const (
+	distFixed       = "fixed"
+	distUniform     = "uniform"
+	distNormal      = "normal"
+	distExponential = "exponential"
+)
+
+// procLatency samples the run time of emulated local methods.
+type procLatency struct {
+	dist   string
+	jitter time.Duration
+	base   []time.Duration // base latency per proc index, cycled
+}
+
+// parseProcLatency parses the latency distribution query parameters, falling
+// back to d as base latency of all procs.
+func parseProcLatency(d time.Duration, query map[string][]string) (procLatency, error) {
+	get := func(key string) string {
+		if v := query[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	l := procLatency{dist: strings.ToLower(get("dist")), base: []time.Duration{d}}
+	switch l.dist {
+	case "":
+		l.dist = distFixed
+	case distFixed, distUniform, distNormal, distExponential:
+	default:
+		return procLatency{}, errProcLatency
+	}
+	if v := get("latencies"); v != "" {
+		l.base = nil
+		for _, s := range strings.Split(v, ",") {
+			d, err := parseDuration(strings.TrimSpace(s))
+			if err != nil || d < 0 {
+				return procLatency{}, errProcLatency
+			}
+			l.base = append(l.base, d)
+		}
+	}
+	l.jitter = -1
+	if v := get("jitter"); v != "" {
+		var err error
+		if l.jitter, err = parseDuration(v); err != nil || l.jitter < 0 {
+			return procLatency{}, errProcLatency
+		}
+	}
+	return l, nil
+}
+
+// sample returns a run time for proc i. Uniform and normal distributions
+// spread around the base latency by jitter, half the base latency by default,
+// while the exponential distribution has the base latency as its mean.
+func (l procLatency) sample(i int) time.Duration {
+	d := l.base[i%len(l.base)]
+	jitter := l.jitter
+	if jitter < 0 {
+		jitter = d / 2
+	}
+	switch l.dist {
+	case distUniform:
+		d += time.Duration((rand.Float64()*2 - 1) * float64(jitter))
+	case distNormal:
+		d += time.Duration(rand.NormFloat64() * float64(jitter))
+	case distExponential:
+		d = time.Duration(rand.ExpFloat64() * float64(d))
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// procRunner runs a tree of emulated local methods.
+type procRunner struct {
+	tracer  *zipkin.Tracer
+	mode    string
+	procs   int
+	latency procLatency
+}
+
+// run runs the procs of a single level, serial, in parallel or mixed, each
+// running the next level as its children until depth is reached. Every proc
+// derives its span from the context it was started with, so children are
+// nested under their proc. Procs are skipped once the context is done.
+func (p *procRunner) run(ctx context.Context, prefix string, depth int) {
+	if depth == 0 {
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(p.procs)
+	proc := func(i int) {
+		defer wg.Done()
+		if ctx.Err() != nil {
+			return
+		}
+		name := fmt.Sprintf("%s-%d", prefix, i)
+		span, ctx := p.tracer.StartSpanFromContext(ctx, name)
+		defer span.Finish()
+
+		d := p.latency.sample(i)
+		span.Tag("duration", d.String())
+		p.run(ctx, name, depth-1)
+		sleep(ctx, d)
+	}
+	for i := 0; i < p.procs; i++ {
+		switch {
+		case p.mode == "parallel", p.mode == "mixed" && i%2 == 0:
+			go proc(i)
+		default:
+			proc(i)
+		}
+	}
+	wg.Wait()
+}
+
+// emulateConcurrency instructs this service to run fake heavy local methods,
+// 8 by default or the amount set by the procs query parameter. The methods
+// will take the provided duration as their run time, unless the latencies
+// query parameter lists a run time per method. The dist query parameter
+// samples the run times from a fixed (default), uniform, normal or exponential
+// distribution, spread by the jitter query parameter. The concurrency argument
+// will instruct these methods to run serial, in parallel, or mixed serial and
+// parallel. With a depth query parameter above 1, each method runs the same
+// amount of methods as its children, down to the given depth. The methods are
+// instrumented as local spans, so they will show up in your trace graph.
+// Requests exceeding the amount of spans set by --ep-longtrace-max-spans are
+// rejected.
+//
+// Example paths:
+//
+//	/local/parallel/latency/50ms                          8 parallel procs of 50ms
+//	/local/serial/latency/10ms?procs=3&depth=3            3 procs nested 3 levels deep
+//	/local/mixed/latency/0?latencies=10ms,200ms           alternating 10ms and 200ms procs
+//	/local/parallel/latency/100ms?dist=normal&jitter=20ms normally distributed run times
+//	/local/parallel/latency/100ms?dist=exponential        exponentially distributed run times
+func (ep *Endpoints) emulateConcurrency(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx   = r.Context()
+		vars  = mux.Vars(r)
+		query = r.URL.Query()
+	)
+	d, err := parseDuration(vars["duration"])
+	if err != nil || d < 0 {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errDuration,
+		})
+		return
+	}
+	mode := strings.ToLower(vars["concurrency"])
+	switch mode {
+	case "serial", "mixed", "parallel":
+	default:
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errConcurrency,
+		})
+		return
+	}
+	procs, depth := defaultProcs, 1
+	for key, v := range map[string]*int{"procs": &procs, "depth": &depth} {
+		s := query.Get(key)
+		if s == "" {
+			continue
+		}
+		if *v, err = strconv.Atoi(s); err != nil || *v <= 0 {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errProcs,
+			})
+			return
+		}
+	}
+	latency, err := parseProcLatency(d, query)
+	if err != nil {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errProcLatency,
+		})
+		return
+	}
+	// the tree holds procs^1 + ... + procs^depth spans
+	total, ok := longTraceSize(1, procs, depth, ep.longTraceMax+1)
+	if !ok {
+		ep.writeResponse(ctx, w, response{
+			Code:    http.StatusBadRequest,
+			Error:   errProcsSize,
+			Message: fmt.Sprintf("maximum amount of spans: %d", ep.longTraceMax),
+		})
+		return
+	}
+
+	p := &procRunner{
+		tracer:  ep.tracer,
+		mode:    mode,
+		procs:   procs,
+		latency: latency,
+	}
+	p.run(ctx, "proc", depth)
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: fmt.Sprintf("ran %d local spans", total-1),
+	})
+}
+
+// localTree creates a tree of nested local spans, depth levels deep with
+// breadth children per span, each taking the provided latency as run time.
+// Siblings run serial unless the concurrency query parameter is set to
+// parallel or mixed, and the dist and jitter query parameters sample the run
+// times as for /local. Trees exceeding the amount of spans set by
+// --ep-longtrace-max-spans are rejected. Creation stops when the client goes
+// away.
+//
+// Example paths:
+//
+//	/localtree/10/1/1ms                                a chain of 10 nested spans
+//	/localtree/4/3/5ms                                 120 spans, 3 children each
+//	/localtree/6/2/10ms?concurrency=parallel           126 spans, siblings in parallel
+//	/localtree/3/4/20ms?dist=exponential               exponentially distributed run times
+func (ep *Endpoints) localTree(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx   = r.Context()
+		vars  = mux.Vars(r)
+		query = r.URL.Query()
+	)
+	depth, err := strconv.Atoi(vars["depth"])
+	if err != nil || depth <= 0 {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errTree,
+		})
+		return
+	}
+	breadth, err := strconv.Atoi(vars["breadth"])
+	if err != nil || breadth <= 0 {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errTree,
+		})
+		return
+	}
+	d, err := parseDuration(vars["latency"])
+	if err != nil || d < 0 {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errDuration,
+		})
+		return
+	}
+	mode := strings.ToLower(query.Get("concurrency"))
+	switch mode {
+	case "":
+		mode = "serial"
+	case "serial", "mixed", "parallel":
+	default:
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errConcurrency,
+		})
+		return
+	}
+	latency, err := parseProcLatency(d, query)
+	if err != nil {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errProcLatency,
+		})
+		return
+	}
+	total, ok := longTraceSize(1, breadth, depth, ep.longTraceMax+1)
+	if !ok {
+		ep.writeResponse(ctx, w, response{
+			Code:    http.StatusBadRequest,
+			Error:   errProcsSize,
+			Message: fmt.Sprintf("maximum amount of spans: %d", ep.longTraceMax),
+		})
+		return
+	}
+
+	zipkin.SpanOrNoopFromContext(ctx).Tag("localtree.spans", strconv.FormatInt(total-1, 10))
+	p := &procRunner{
+		tracer:  ep.tracer,
+		mode:    mode,
+		procs:   breadth,
+		latency: latency,
+	}
+	p.run(ctx, "node", depth)
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: fmt.Sprintf("created a tree of %d local spans", total-1),
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+)
+
+func TestProcLatency(t *testing.T) {
+	for _, q := range []string{"dist=pareto", "latencies=10ms,x", "latencies=-1s", "jitter=-1s"} {
+		query, _ := url.ParseQuery(q)
+		if _, err := parseProcLatency(time.Second, query); err != errProcLatency {
+			t.Errorf("%s: expected %v, got %v", q, errProcLatency, err)
+		}
+	}
+
+	query, _ := url.ParseQuery("latencies=10ms,20ms")
+	l, err := parseProcLatency(time.Second, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 10 * time.Millisecond} {
+		if d := l.sample(i); d != expected {
+			t.Errorf("proc %d: expected %s, got %s", i, expected, d)
+		}
+	}
+
+	query, _ = url.ParseQuery("dist=uniform&jitter=10ms")
+	if l, err = parseProcLatency(100*time.Millisecond, query); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if d := l.sample(i); d < 90*time.Millisecond || d > 110*time.Millisecond {
+			t.Fatalf("expected uniform sample within jitter, got %s", d)
+		}
+	}
+
+	query, _ = url.ParseQuery("dist=normal&jitter=1s")
+	if l, err = parseProcLatency(0, query); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if d := l.sample(i); d < 0 {
+			t.Fatalf("expected non negative sample, got %s", d)
+		}
+	}
+}
+
+func TestProcRunner(t *testing.T) {
+	rec := recorder.NewReporter()
+	tracer, err := zipkin.NewTracer(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tracer.StartSpan("request")
+	ctx := zipkin.NewContext(context.Background(), root)
+
+	p := &procRunner{
+		tracer:  tracer,
+		mode:    "parallel",
+		procs:   3,
+		latency: procLatency{dist: distFixed, base: []time.Duration{0}},
+	}
+	p.run(ctx, "proc", 2)
+
+	spans := rec.Flush()
+	if len(spans) != 12 {
+		t.Fatalf("expected 12 spans, got %d", len(spans))
+	}
+	ids := map[string]string{}
+	for _, s := range spans {
+		ids[s.Name] = s.ID.String()
+	}
+	for _, s := range spans {
+		parent := root.Context().ID.String()
+		if len(s.Name) > len("proc-0") {
+			parent = ids[s.Name[:len("proc-0")]]
+		}
+		if s.ParentID == nil || s.ParentID.String() != parent {
+			t.Errorf("%s: expected parent %s, got %v", s.Name, parent, s.ParentID)
+		}
+	}
+
+	// nothing is created for clients which went away
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	p.run(ctx, "proc", 2)
+	if spans = rec.Flush(); len(spans) != 0 {
+		t.Errorf("expected no spans after cancellation, got %d", len(spans))
+	}
+}
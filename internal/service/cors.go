@@ -0,0 +1,136 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// supported CORS fault modes
+const (
+	corsFaultNone    = "none"
+	corsFaultOmit    = "omit"
+	corsFaultCorrupt = "corrupt"
+
+	// corruptOrigin is returned as allowed origin when corrupting CORS headers.
+	corruptOrigin = "https://corrupted.invalid"
+)
+
+// corsConfig holds the app-level CORS settings.
+type corsConfig struct {
+	origins []string
+	methods []string
+	headers []string
+	maxAge  int
+}
+
+// allowOrigin returns the value for the Access-Control-Allow-Origin header or
+// an empty string if the origin is not allowed.
+func (c corsConfig) allowOrigin(origin string) string {
+	for _, o := range c.origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// cors handles CORS requests if allowed origins are configured, so app-level
+// CORS can be compared with CORS handled by a gateway. Depending on the fault
+// mode, CORS headers are omitted or corrupted.
+func (ep *Endpoints) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(ep.corsCfg.origins) == 0 || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ep.mtx.RLock()
+		fault := ep.corsFault
+		ep.mtx.RUnlock()
+
+		span := zipkin.SpanOrNoopFromContext(r.Context())
+		preflight := r.Method == http.MethodOptions &&
+			r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			span.Tag("cors.preflight", "true")
+		}
+
+		h := w.Header()
+		switch fault {
+		case corsFaultOmit:
+			span.Tag("fault", "cors-omit")
+		case corsFaultCorrupt:
+			span.Tag("fault", "cors-corrupt")
+			h.Add("Access-Control-Allow-Origin", corruptOrigin)
+			h.Add("Access-Control-Allow-Origin", "*")
+			h.Set("Access-Control-Allow-Methods", "NONE")
+		default:
+			allowed := ep.corsCfg.allowOrigin(origin)
+			h.Add("Vary", "Origin")
+			if allowed == "" {
+				span.Tag("cors.origin", "denied")
+				break
+			}
+			h.Set("Access-Control-Allow-Origin", allowed)
+			if !preflight {
+				break
+			}
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			methods := ep.corsCfg.methods
+			if len(methods) == 0 {
+				methods = []string{r.Header.Get("Access-Control-Request-Method")}
+			}
+			h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			if len(ep.corsCfg.headers) > 0 {
+				h.Set("Access-Control-Allow-Headers", strings.Join(ep.corsCfg.headers, ", "))
+			} else if req := r.Header.Get("Access-Control-Request-Headers"); req != "" {
+				h.Set("Access-Control-Allow-Headers", req)
+			}
+			if ep.corsCfg.maxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(ep.corsCfg.maxAge))
+			}
+		}
+
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setCORSFault allows one to set the CORS fault mode, where omit leaves out
+// all CORS headers and corrupt returns invalid CORS headers. Mode none returns
+// to regular CORS handling.
+func (ep *Endpoints) setCORSFault(w http.ResponseWriter, r *http.Request) {
+	mode := mux.Vars(r)["mode"]
+
+	ep.mtx.Lock()
+	ep.corsFault = mode
+	ep.mtx.Unlock()
+
+	ep.writeResponse(r.Context(), w, response{
+		Code:    http.StatusOK,
+		Message: fmt.Sprintf("cors fault set to: %s", mode),
+	})
+}
@@ -0,0 +1,167 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// supported crash modes
+const (
+	crashPanic    = "panic"
+	crashExit     = "exit"
+	crashDeadlock = "deadlock"
+	crashSegfault = "segfault"
+	crashOOM      = "oom"
+	crashStuck    = "stuck"
+)
+
+// crash instructs this service to crash with the provided mode and message
+// after the crash delay (default 5 seconds, adjustable with the delay query
+// parameter). Supported modes:
+//
+//	panic     panic with the provided message
+//	exit      exit the process with the exit code set by the code query
+//	          parameter (default 1)
+//	deadlock  deadlock on the crash mode's own mutexes so all handlers hang
+//	segfault  kill the process with a segmentation violation (SIGSEGV)
+//	oom       allocate memory until the process gets killed
+//	stuck     let all handlers hang while the process stays otherwise healthy
+//
+// Example path: /crash/exit/oopsie?code=3&delay=1s
+func (ep *Endpoints) crash(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx   = r.Context()
+		vars  = mux.Vars(r)
+		msg   = vars["message"]
+		mode  = vars["mode"]
+		delay = ep.crashDelay
+		code  = 1
+		err   error
+	)
+	if mode == "" {
+		mode = crashPanic
+	}
+	if s := r.URL.Query().Get("delay"); s != "" {
+		if delay, err = parseDuration(s); err != nil || delay < 0 {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errDuration,
+			})
+			return
+		}
+	}
+	if s := r.URL.Query().Get("code"); s != "" {
+		if code, err = strconv.Atoi(s); err != nil {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errExitCode,
+			})
+			return
+		}
+	}
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: fmt.Sprintf("crashing (%s) in %s", mode, delay),
+	})
+
+	go func() {
+		time.Sleep(delay)
+		log.Printf("crash requested (%s): %s", mode, msg)
+		switch mode {
+		case crashPanic:
+			panic("crash requested: " + msg)
+		case crashExit:
+			os.Exit(code)
+		case crashDeadlock:
+			ep.deadlock()
+		case crashSegfault:
+			segfault()
+		case crashOOM:
+			exhaustMemory()
+		case crashStuck:
+			ep.stuck.Store(true)
+		}
+	}()
+}
+
+// deadlock creates a classic lock ordering deadlock between the deadlock
+// mutex, which every request briefly takes through stuckHandler, and another
+// mutex, so goroutine dumps show the actual deadlock.
+func (ep *Endpoints) deadlock() {
+	var (
+		other   sync.Mutex
+		started sync.WaitGroup
+	)
+	started.Add(2)
+	go func() {
+		ep.deadlockMtx.Lock()
+		started.Done()
+		started.Wait()
+		other.Lock()
+	}()
+	go func() {
+		other.Lock()
+		started.Done()
+		started.Wait()
+		ep.deadlockMtx.Lock()
+	}()
+}
+
+// fault dereferences a nil pointer with crash tracebacks enabled, so the
+// process aborts with a signal instead of exiting like an ordinary panic.
+func fault() {
+	debug.SetTraceback("crash")
+	var p *struct{ n int }
+	p.n++
+}
+
+// exhaustMemory keeps allocating and touching memory until the process is
+// killed by the OOM killer or the runtime gives up.
+func exhaustMemory() {
+	var hog [][]byte
+	for {
+		b := make([]byte, 64<<20)
+		for i := 0; i < len(b); i += 4096 {
+			b[i] = 1
+		}
+		hog = append(hog, b)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// stuckHandler lets all requests hang once the stuck or deadlock crash mode
+// has been triggered.
+func (ep *Endpoints) stuckHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ep.stuck.Load() {
+			<-r.Context().Done()
+			return
+		}
+		ep.deadlockMtx.RLock()
+		ep.deadlockMtx.RUnlock()
+		next.ServeHTTP(w, r)
+	})
+}
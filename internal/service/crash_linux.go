@@ -0,0 +1,36 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// segfault kills the process with SIGSEGV like a native segfault would. The
+// runtime handles SIGSEGV itself, turning faults into panics or fatal errors
+// exiting with code 2, so the default disposition is restored before raising
+// the signal.
+func segfault() {
+	var act [8]uint64 // zeroed struct sigaction: SIG_DFL, no flags, empty mask
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_RT_SIGACTION, uintptr(syscall.SIGSEGV),
+		uintptr(unsafe.Pointer(&act)), 0, 8, 0, 0); errno == 0 {
+		_ = syscall.Kill(os.Getpid(), syscall.SIGSEGV)
+		time.Sleep(time.Second)
+	}
+	fault()
+}
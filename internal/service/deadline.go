@@ -0,0 +1,94 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// policies for injected latency running past the server write deadline
+const (
+	overrunFail  = "fail"
+	overrunSleep = "sleep"
+)
+
+// deadlineMargin is the time reserved before the write deadline to write the
+// timeout response.
+const deadlineMargin = 50 * time.Millisecond
+
+type writeDeadlineKey struct{}
+
+// writeDeadline records when the server write timeout expires for the request,
+// so handlers injecting latency can tell whether their response will make it.
+func (ep *Endpoints) writeDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t := ep.writeTimeout.Load(); t > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), writeDeadlineKey{}, time.Now().Add(t)))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// injectLatency sleeps for the latency d. If the server write deadline expires
+// first, the server would silently drop the response, so the overrun is tagged
+// on the server span and logged. With the fail policy the sleep ends just
+// before the deadline and a 504 is returned instead, while the sleep policy
+// sleeps for the full latency regardless. It returns false if the request has
+// been handled, either because the client went away or because a timeout
+// response was written.
+func (ep *Endpoints) injectLatency(ctx context.Context, w http.ResponseWriter, d time.Duration) bool {
+	deadline, ok := ctx.Value(writeDeadlineKey{}).(time.Time)
+	remaining := time.Until(deadline) - deadlineMargin
+	if ok && d > remaining {
+		timeout := ep.writeTimeout.Load()
+		zipkin.SpanOrNoopFromContext(ctx).Tag("latency.overrun", (d - remaining).String())
+		log.Printf("injected latency of %s exceeds the server write timeout of %s (policy: %s)",
+			d, timeout, ep.latencyOverrun)
+		if ep.latencyOverrun == overrunFail {
+			if !sleep(ctx, remaining) {
+				cancelMetrics.Add("latency", 1)
+				return false
+			}
+			ep.writeResponse(ctx, w, response{
+				Code:      http.StatusGatewayTimeout,
+				Error:     errLatencyTimeout,
+				ErrorInfo: injected("LATENCY_EXCEEDS_TIMEOUT", http.StatusGatewayTimeout),
+				Message: fmt.Sprintf("injected latency of %s exceeds the server write timeout of %s",
+					d, timeout),
+			})
+			return false
+		}
+	}
+	if !sleep(ctx, d) {
+		cancelMetrics.Add("latency", 1)
+		return false
+	}
+	return true
+}
+
+// exceedsWriteTimeout returns a note to add to messages confirming a latency
+// setting if the latency exceeds the server write timeout.
+func (ep *Endpoints) exceedsWriteTimeout(d time.Duration) string {
+	if t := ep.writeTimeout.Load(); t > 0 && d >= t {
+		return fmt.Sprintf(" (exceeds the server write timeout of %s, policy: %s)", t, ep.latencyOverrun)
+	}
+	return ""
+}
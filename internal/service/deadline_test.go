@@ -0,0 +1,68 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	zmw "github.com/openzipkin/zipkin-go/middleware/http"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+)
+
+func TestInjectLatency(t *testing.T) {
+	tracer, err := zipkin.NewTracer(recorder.NewReporter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(policy string, timeout, latency time.Duration) (*httptest.ResponseRecorder, bool, time.Duration) {
+		ep := &Endpoints{latencyOverrun: policy}
+		ep.writeTimeout.Store(timeout)
+		var (
+			rec   = httptest.NewRecorder()
+			ok    bool
+			start = time.Now()
+		)
+		zmw.NewServerMiddleware(tracer)(ep.writeDeadline(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok = ep.injectLatency(r.Context(), w, latency)
+		}))).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec, ok, time.Since(start)
+	}
+
+	if _, ok, took := serve(overrunFail, 0, 10*time.Millisecond); !ok || took < 10*time.Millisecond {
+		t.Errorf("expected latency to be injected without write timeout, took %s", took)
+	}
+	if _, ok, _ := serve(overrunFail, time.Second, 10*time.Millisecond); !ok {
+		t.Errorf("expected latency within the write timeout to be injected")
+	}
+	rec, ok, took := serve(overrunFail, 100*time.Millisecond, time.Minute)
+	if ok || rec.Code != http.StatusGatewayTimeout || took > time.Second {
+		t.Errorf("expected a 504 before the write deadline, got %t, %d after %s", ok, rec.Code, took)
+	}
+	if _, ok, took := serve(overrunSleep, 50*time.Millisecond, 100*time.Millisecond); !ok || took < 100*time.Millisecond {
+		t.Errorf("expected the full latency to be injected, took %s", took)
+	}
+
+	ep := &Endpoints{latencyOverrun: overrunFail}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if ep.injectLatency(ctx, httptest.NewRecorder(), time.Minute) {
+		t.Errorf("expected latency to be aborted on cancellation")
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+const (
+	// defaultDependencyPath is the path probed on required dependencies not
+	// providing one.
+	defaultDependencyPath = "/readyz"
+	// dependencyProbeTimeout bounds the probe calls made on a readiness check.
+	dependencyProbeTimeout = 2 * time.Second
+)
+
+// parseDependency parses required dependency configuration in the form of
+// "host[:port][/path]", returning the probe target.
+func parseDependency(s string) (host, target string, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.HasPrefix(s, "/") {
+		return "", "", errDependency
+	}
+	host, target = s, s+defaultDependencyPath
+	if idx := strings.Index(s, "/"); idx > 0 {
+		host = s[:idx]
+		target = s
+	}
+	return host, target, nil
+}
+
+// dependencyGate keeps track of the required dependencies which have not yet
+// responded successfully to a probe call.
+type dependencyGate struct {
+	mtx     sync.Mutex
+	targets map[string]string
+	healthy map[string]bool
+}
+
+func newDependencyGate(targets map[string]string) *dependencyGate {
+	return &dependencyGate{
+		targets: targets,
+		healthy: make(map[string]bool, len(targets)),
+	}
+}
+
+// reset requires all dependencies to respond successfully again.
+func (g *dependencyGate) reset() {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.healthy = make(map[string]bool, len(g.targets))
+}
+
+// pending probes the dependencies which have not responded successfully yet,
+// returning the ones still failing. Once a dependency responded successfully
+// it is no longer probed.
+func (g *dependencyGate) pending(ctx context.Context, client *http.Client) []string {
+	g.mtx.Lock()
+	probes := make(map[string]string, len(g.targets))
+	for host, target := range g.targets {
+		if !g.healthy[host] {
+			probes[host] = target
+		}
+	}
+	g.mtx.Unlock()
+	if len(probes) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dependencyProbeTimeout)
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		mtx     sync.Mutex
+		pending []string
+	)
+	for host, target := range probes {
+		wg.Add(1)
+		go func(host, target string) {
+			defer wg.Done()
+			if probe(ctx, client, target) {
+				g.mtx.Lock()
+				g.healthy[host] = true
+				g.mtx.Unlock()
+				return
+			}
+			mtx.Lock()
+			pending = append(pending, host)
+			mtx.Unlock()
+		}(host, target)
+	}
+	wg.Wait()
+	sort.Strings(pending)
+	return pending
+}
+
+// probe calls the target, reporting whether it responded with a 2xx status.
+func probe(ctx context.Context, client *http.Client, target string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+target, nil)
+	if err != nil {
+		return false
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	_ = res.Body.Close()
+	return res.StatusCode >= 200 && res.StatusCode < 300
+}
+
+// dependenciesReady reports whether all required dependencies responded
+// successfully to a probe call, tagging the server span with the ones still
+// pending.
+func (ep *Endpoints) dependenciesReady(ctx context.Context) ([]string, bool) {
+	pending := ep.deps.pending(ctx, ep.client)
+	if len(pending) > 0 {
+		zipkin.SpanOrNoopFromContext(ctx).Tag("dependencies.pending", strings.Join(pending, ","))
+		return pending, false
+	}
+	return nil, true
+}
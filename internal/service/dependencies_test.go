@@ -0,0 +1,74 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseDependency(t *testing.T) {
+	tests := []struct {
+		in, host, target string
+		ok               bool
+	}{
+		{"svcb", "svcb", "svcb/readyz", true},
+		{" svcb:8000 ", "svcb:8000", "svcb:8000/readyz", true},
+		{"svcc:8000/healthz", "svcc:8000", "svcc:8000/healthz", true},
+		{"/healthz", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		host, target, err := parseDependency(tt.in)
+		if host != tt.host || target != tt.target || (err == nil) != tt.ok {
+			t.Errorf("%q: expected %q, %q (%t), got %q, %q (%v)",
+				tt.in, tt.host, tt.target, tt.ok, host, target, err)
+		}
+	}
+}
+
+func TestDependencyGate(t *testing.T) {
+	var ready, probes int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+		if atomic.LoadInt32(&ready) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	g := newDependencyGate(map[string]string{host: host + "/readyz"})
+	ctx := context.Background()
+	if pending := g.pending(ctx, srv.Client()); len(pending) != 1 || pending[0] != host {
+		t.Errorf("expected %s to be pending, got %v", host, pending)
+	}
+	atomic.StoreInt32(&ready, 1)
+	if pending := g.pending(ctx, srv.Client()); len(pending) != 0 {
+		t.Errorf("expected no pending dependencies, got %v", pending)
+	}
+	atomic.StoreInt32(&ready, 0)
+	if pending := g.pending(ctx, srv.Client()); len(pending) != 0 || atomic.LoadInt32(&probes) != 2 {
+		t.Errorf("expected healthy dependency not to be probed again, got %v after %d probes", pending, atomic.LoadInt32(&probes))
+	}
+	g.reset()
+	if pending := g.pending(ctx, srv.Client()); len(pending) != 1 {
+		t.Errorf("expected dependency to be pending after reset, got %v", pending)
+	}
+}
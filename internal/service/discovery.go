@@ -0,0 +1,260 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/basvanbeek/topology-tester/pkg/kubernetes"
+)
+
+// supported service discovery mechanisms for proxy targets
+const (
+	discoveryDNS        = "dns"
+	discoveryStatic     = "static"
+	discoveryKubernetes = "kubernetes"
+	discoveryConsul     = "consul"
+
+	// discoveryTTL is the time resolved endpoints are cached for.
+	discoveryTTL = 5 * time.Second
+)
+
+// discovery resolves a service name into the addresses of its endpoints.
+type discovery interface {
+	resolve(ctx context.Context, service string) ([]string, error)
+}
+
+// staticDiscovery resolves services using a fixed map of endpoints. Targets
+// with a port not configured as such are looked up without it.
+type staticDiscovery map[string][]string
+
+func (s staticDiscovery) resolve(_ context.Context, service string) ([]string, error) {
+	if addrs, ok := s[service]; ok {
+		return addrs, nil
+	}
+	if host, _, err := net.SplitHostPort(service); err == nil {
+		return s[host], nil
+	}
+	return nil, nil
+}
+
+// parseStaticEndpoint parses a static endpoint in the form of
+// service=host:port.
+func parseStaticEndpoint(s string) (string, string, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", errStaticEndpoint
+	}
+	if _, _, err := net.SplitHostPort(parts[1]); err != nil {
+		return "", "", errStaticEndpoint
+	}
+	return parts[0], parts[1], nil
+}
+
+// kubernetesDiscovery resolves services using the Endpoints of the Kubernetes
+// Service with the same name. Names in the form of service.namespace are
+// looked up in the provided namespace. The port of the target selects the
+// endpoint port by name or number, targets without a port use the first port.
+type kubernetesDiscovery struct {
+	client *kubernetes.Client
+}
+
+func (k *kubernetesDiscovery) resolve(ctx context.Context, service string) ([]string, error) {
+	name, namespace := splitServiceName(service)
+	if namespace == "" {
+		namespace = k.client.Namespace()
+	}
+	var ep struct {
+		Subsets []struct {
+			Addresses []struct {
+				IP string `json:"ip"`
+			} `json:"addresses"`
+			Ports []endpointPort `json:"ports"`
+		} `json:"subsets"`
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/endpoints/%s",
+		url.PathEscape(namespace), url.PathEscape(name))
+	if err := k.client.Get(ctx, path, &ep); err != nil {
+		return nil, err
+	}
+	_, target, _ := net.SplitHostPort(service)
+	var addrs []string
+	for _, subset := range ep.Subsets {
+		port, ok := selectPort(subset.Ports, target)
+		if !ok {
+			continue
+		}
+		for _, a := range subset.Addresses {
+			addrs = append(addrs, net.JoinHostPort(a.IP, port))
+		}
+	}
+	return addrs, nil
+}
+
+// endpointPort is a port of a Kubernetes Endpoints subset.
+type endpointPort struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+}
+
+// selectPort returns the port matching the target port by name or number, the
+// first port if no target port is provided, or the only port if none matches,
+// as the target may use the Service port mapping to a different target port.
+func selectPort(ports []endpointPort, target string) (string, bool) {
+	if len(ports) == 0 {
+		return "", false
+	}
+	if target == "" {
+		return strconv.Itoa(ports[0].Port), true
+	}
+	for _, p := range ports {
+		if p.Name == target || strconv.Itoa(p.Port) == target {
+			return strconv.Itoa(p.Port), true
+		}
+	}
+	if len(ports) == 1 {
+		return strconv.Itoa(ports[0].Port), true
+	}
+	return "", false
+}
+
+// consulDiscovery resolves services using the healthy instances registered in
+// the Consul catalog.
+type consulDiscovery struct {
+	address string
+	client  *http.Client
+}
+
+func (c *consulDiscovery) resolve(ctx context.Context, service string) ([]string, error) {
+	name, _ := splitServiceName(service)
+	u := fmt.Sprintf("http://%s/v1/health/service/%s?passing=true",
+		c.address, url.PathEscape(name))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	var entries []struct {
+		Node struct {
+			Address string
+		}
+		Service struct {
+			Address string
+			Port    int
+		}
+	}
+	if err = json.NewDecoder(res.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(e.Service.Port)))
+	}
+	return addrs, nil
+}
+
+// cachedDiscovery caches the resolved endpoints of the wrapped discovery for a
+// short time, so not every proxied request results in a registry lookup.
+type cachedDiscovery struct {
+	discovery
+
+	mtx     sync.Mutex
+	entries map[string]cachedEndpoints
+}
+
+type cachedEndpoints struct {
+	addrs   []string
+	expires time.Time
+}
+
+func newCachedDiscovery(d discovery) *cachedDiscovery {
+	return &cachedDiscovery{discovery: d, entries: make(map[string]cachedEndpoints)}
+}
+
+func (c *cachedDiscovery) resolve(ctx context.Context, service string) ([]string, error) {
+	c.mtx.Lock()
+	e, ok := c.entries[service]
+	c.mtx.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.addrs, nil
+	}
+	addrs, err := c.discovery.resolve(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	c.mtx.Lock()
+	c.entries[service] = cachedEndpoints{addrs: addrs, expires: time.Now().Add(discoveryTTL)}
+	c.mtx.Unlock()
+	return addrs, nil
+}
+
+// splitServiceName splits a proxy target into its service name and namespace,
+// dropping any port, e.g. "svcb.demo:8000" results in "svcb" and "demo".
+func splitServiceName(host string) (string, string) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	parts := strings.SplitN(host, ".", 3)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// newDiscovery returns the configured service discovery or nil if proxy
+// targets are resolved through DNS.
+func (ep *Endpoints) newDiscovery() (discovery, error) {
+	switch ep.discoveryMode {
+	case discoveryStatic:
+		s := make(staticDiscovery)
+		for _, e := range ep.staticEndpoints {
+			service, addr, _ := parseStaticEndpoint(e) // validated in Validate
+			s[service] = append(s[service], addr)
+		}
+		return s, nil
+	case discoveryKubernetes:
+		client, err := kubernetes.NewInClusterClient()
+		if err != nil {
+			return nil, err
+		}
+		return newCachedDiscovery(&kubernetesDiscovery{client: client}), nil
+	case discoveryConsul:
+		return newCachedDiscovery(&consulDiscovery{
+			address: ep.consulAddress,
+			client:  &http.Client{Timeout: 5 * time.Second},
+		}), nil
+	}
+	return nil, nil
+}
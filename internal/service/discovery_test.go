@@ -0,0 +1,87 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/basvanbeek/topology-tester/pkg/kubernetes"
+)
+
+func TestStaticDiscovery(t *testing.T) {
+	d := staticDiscovery{
+		"svcb":      {"10.0.0.1:8000", "10.0.0.2:8000"},
+		"svcc:9000": {"10.0.0.3:9000"},
+	}
+	for _, tt := range []struct {
+		target   string
+		expected []string
+	}{
+		{"svcb", []string{"10.0.0.1:8000", "10.0.0.2:8000"}},
+		{"svcb:8000", []string{"10.0.0.1:8000", "10.0.0.2:8000"}},
+		{"svcc:9000", []string{"10.0.0.3:9000"}},
+		{"svcc", nil},
+		{"svcd", nil},
+	} {
+		addrs, err := d.resolve(context.Background(), tt.target)
+		if err != nil || !reflect.DeepEqual(addrs, tt.expected) {
+			t.Errorf("%s: expected %v, got %v (%v)", tt.target, tt.expected, addrs, err)
+		}
+	}
+}
+
+func TestKubernetesDiscovery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/namespaces/default/endpoints/svcb":
+			_, _ = w.Write([]byte(`{"subsets": [
+				{"addresses": [{"ip": "10.0.0.1"}, {"ip": "10.0.0.2"}],
+				 "ports": [{"name": "http", "port": 8000}, {"name": "grpc", "port": 9000}]},
+				{"addresses": [{"ip": "10.0.0.3"}], "ports": []}
+			]}`))
+		case "/api/v1/namespaces/other/endpoints/svcc":
+			_, _ = w.Write([]byte(`{"subsets": [
+				{"addresses": [{"ip": "10.0.1.1"}], "ports": [{"name": "http", "port": 8080}]}
+			]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	d := &kubernetesDiscovery{client: kubernetes.NewClient(srv.URL, "token", "default", srv.Client())}
+
+	for _, tt := range []struct {
+		target   string
+		expected []string
+		err      bool
+	}{
+		{target: "svcb", expected: []string{"10.0.0.1:8000", "10.0.0.2:8000"}},
+		{target: "svcb:grpc", expected: []string{"10.0.0.1:9000", "10.0.0.2:9000"}},
+		{target: "svcb:9000", expected: []string{"10.0.0.1:9000", "10.0.0.2:9000"}},
+		{target: "svcb:1234"},
+		// the Service port may map to a different target port
+		{target: "svcc.other:80", expected: []string{"10.0.1.1:8080"}},
+		{target: "svcd", err: true},
+	} {
+		addrs, err := d.resolve(context.Background(), tt.target)
+		if (err != nil) != tt.err || !reflect.DeepEqual(addrs, tt.expected) {
+			t.Errorf("%s: expected %v, got %v (%v)", tt.target, tt.expected, addrs, err)
+		}
+	}
+}
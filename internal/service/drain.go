@@ -0,0 +1,40 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"strconv"
+
+	pkghttp "github.com/basvanbeek/topology-tester/pkg/http"
+)
+
+// Drain marks readiness down on shutdown, so load balancers stop sending new
+// requests while the in flight requests are drained.
+func (ep *Endpoints) Drain() {
+	ep.mtx.Lock()
+	ep.draining = true
+	ep.mtx.Unlock()
+}
+
+// Drained emits a span summarizing the requests drained on shutdown. The HTTP
+// service must stop before the tracer for the span to be reported.
+func (ep *Endpoints) Drained(summary pkghttp.DrainSummary) {
+	span := ep.tracer.StartSpan("drain")
+	span.Tag("drain.inflight", strconv.FormatInt(summary.InFlight, 10))
+	span.Tag("drain.drained", strconv.FormatInt(summary.Drained, 10))
+	span.Tag("drain.aborted", strconv.FormatInt(summary.Aborted, 10))
+	span.Tag("drain.time", summary.DrainTime.String())
+	span.FinishedWithDuration(summary.Took)
+}
@@ -0,0 +1,112 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// supported egress proxy schemes
+const (
+	egressHTTP    = "http"
+	egressHTTPS   = "https"
+	egressSOCKS5  = "socks5"
+	egressConnect = "connect"
+)
+
+// dialFunc matches the signature of http.Transport.DialContext.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// parseEgressProxy parses the URL of the forward proxy to route outbound calls
+// through.
+func parseEgressProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case egressHTTP, egressHTTPS, egressSOCKS5, egressConnect:
+	default:
+		return nil, errEgressProxy
+	}
+	if u.Host == "" {
+		return nil, errEgressProxy
+	}
+	return u, nil
+}
+
+// setEgressProxy routes the outbound calls of the transport through the
+// provided forward proxy. HTTP and HTTPS proxies receive plain HTTP requests in
+// absolute form and tunnel TLS using CONNECT, SOCKS5 proxies tunnel all
+// connections and the connect scheme tunnels all connections through an HTTP
+// proxy using CONNECT.
+func setEgressProxy(t *http.Transport, proxy *url.URL, dial dialFunc) {
+	if proxy.Scheme != egressConnect {
+		t.Proxy = http.ProxyURL(proxy)
+		return
+	}
+	t.Proxy = nil
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, proxy.Host)
+		if err != nil {
+			return nil, err
+		}
+		if err = connect(ctx, conn, proxy, addr); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// connect requests the proxy on the other side of conn to tunnel the
+// connection to addr.
+func connect(ctx context.Context, conn net.Conn, proxy *url.URL, addr string) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString(
+			[]byte(proxy.User.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	// the body of a successful CONNECT response is the tunnel itself, so it
+	// must not be drained
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy CONNECT to %s failed: %s", addr, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// supported response content types
+const (
+	contentTypeJSON     = "application/json"
+	contentTypeXML      = "application/xml"
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeMsgpack  = "application/msgpack"
+)
+
+// contentTypeAliases maps accepted media types to the supported content types.
+var contentTypeAliases = map[string]string{
+	"application/json":       contentTypeJSON,
+	"application/xml":        contentTypeXML,
+	"text/xml":               contentTypeXML,
+	"application/x-protobuf": contentTypeProtobuf,
+	"application/protobuf":   contentTypeProtobuf,
+	"application/msgpack":    contentTypeMsgpack,
+	"application/x-msgpack":  contentTypeMsgpack,
+}
+
+type contentTypeKey struct{}
+
+// negotiateContentType returns the supported content type most preferred by
+// the Accept header, JSON if none of them are acceptable.
+func negotiateContentType(accept string) string {
+	var (
+		best  = contentTypeJSON
+		bestQ float64
+	)
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		ct, ok := contentTypeAliases[strings.ToLower(strings.TrimSpace(fields[0]))]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && kv[0] == "q" {
+				q, _ = strconv.ParseFloat(kv[1], 64)
+			}
+		}
+		if q > bestQ {
+			best, bestQ = ct, q
+		}
+	}
+	return best
+}
+
+// contentNegotiation stores the response content type negotiated with the
+// client in the request context, for writeResponse to use.
+func (ep *Endpoints) contentNegotiation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ct := negotiateContentType(r.Header.Get("Accept"))
+		if ct != contentTypeJSON {
+			r = r.WithContext(context.WithValue(r.Context(), contentTypeKey{}, ct))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// contentType returns the negotiated response content type.
+func contentType(ctx context.Context) string {
+	if ct, ok := ctx.Value(contentTypeKey{}).(string); ok {
+		return ct
+	}
+	return contentTypeJSON
+}
+
+// marshalAs encodes v in the provided non JSON content type. The value is
+// converted to its generic JSON representation first, so all content types
+// share the JSON field names. Protobuf payloads are encoded as a
+// google.protobuf.Struct message.
+func marshalAs(ct string, v interface{}) ([]byte, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var generic interface{}
+	if err = dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	switch ct {
+	case contentTypeXML:
+		buf.WriteString(xml.Header)
+		enc := xml.NewEncoder(&buf)
+		enc.Indent("", "  ")
+		if err = encodeXML(enc, "response", "", generic); err != nil {
+			return nil, err
+		}
+		if err = enc.Flush(); err != nil {
+			return nil, err
+		}
+	case contentTypeProtobuf:
+		if m, ok := generic.(map[string]interface{}); ok {
+			encodeProtoStruct(&buf, m)
+		}
+	case contentTypeMsgpack:
+		encodeMsgpack(&buf, generic)
+	}
+	return buf.Bytes(), nil
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validXMLName returns true if s can be used as XML element name.
+func validXMLName(s string) bool {
+	if s == "" || strings.HasPrefix(strings.ToLower(s), "xml") {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c == '-' || c == '.' || c >= '0' && c <= '9'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// encodeXML encodes a generic JSON value as element. Object members become
+// child elements, or entry elements with a key attribute if the member name
+// is not a valid element name. Array items become item elements.
+func encodeXML(enc *xml.Encoder, name, key string, v interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if key != "" {
+		start.Attr = []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}}
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(t) {
+			var err error
+			if validXMLName(k) {
+				err = encodeXML(enc, k, "", t[k])
+			} else {
+				err = encodeXML(enc, "entry", k, t[k])
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range t {
+			if err := encodeXML(enc, "item", "", item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	case json.Number:
+		if err := enc.EncodeToken(xml.CharData(t.String())); err != nil {
+			return err
+		}
+	case string:
+		if err := enc.EncodeToken(xml.CharData(t)); err != nil {
+			return err
+		}
+	case bool:
+		if err := enc.EncodeToken(xml.CharData(strconv.FormatBool(t))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// encodeMsgpack encodes a generic JSON value as MessagePack.
+func encodeMsgpack(buf *bytes.Buffer, v interface{}) {
+	switch t := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if t {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			switch {
+			case i >= 0 && i <= 127:
+				buf.WriteByte(byte(i))
+			case i < 0 && i >= -32:
+				buf.WriteByte(byte(int8(i)))
+			default:
+				buf.WriteByte(0xd3)
+				_ = binary.Write(buf, binary.BigEndian, i)
+			}
+			return
+		}
+		f, _ := t.Float64()
+		buf.WriteByte(0xcb)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+	case string:
+		n := len(t)
+		switch {
+		case n < 32:
+			buf.WriteByte(0xa0 | byte(n))
+		case n < 1<<8:
+			buf.WriteByte(0xd9)
+			buf.WriteByte(byte(n))
+		case n < 1<<16:
+			buf.WriteByte(0xda)
+			_ = binary.Write(buf, binary.BigEndian, uint16(n))
+		default:
+			buf.WriteByte(0xdb)
+			_ = binary.Write(buf, binary.BigEndian, uint32(n))
+		}
+		buf.WriteString(t)
+	case []interface{}:
+		msgpackHeader(buf, len(t), 0x90, 0xdc)
+		for _, item := range t {
+			encodeMsgpack(buf, item)
+		}
+	case map[string]interface{}:
+		msgpackHeader(buf, len(t), 0x80, 0xde)
+		for _, k := range sortedKeys(t) {
+			encodeMsgpack(buf, k)
+			encodeMsgpack(buf, t[k])
+		}
+	}
+}
+
+// msgpackHeader writes the header of a MessagePack array or map holding n
+// elements, using the fix format if possible or the 16 or 32 bit format
+// following code otherwise.
+func msgpackHeader(buf *bytes.Buffer, n int, fix, code byte) {
+	switch {
+	case n < 16:
+		buf.WriteByte(fix | byte(n))
+	case n < 1<<16:
+		buf.WriteByte(code)
+		_ = binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(code + 1)
+		_ = binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func protoTag(buf *bytes.Buffer, field, wire int) {
+	protoVarint(buf, uint64(field<<3|wire))
+}
+
+func protoVarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func protoBytes(buf *bytes.Buffer, field int, b []byte) {
+	protoTag(buf, field, wireBytes)
+	protoVarint(buf, uint64(len(b)))
+	buf.Write(b)
+}
+
+// encodeProtoStruct encodes an object as google.protobuf.Struct.
+func encodeProtoStruct(buf *bytes.Buffer, m map[string]interface{}) {
+	for _, k := range sortedKeys(m) {
+		var entry, value bytes.Buffer
+		encodeProtoValue(&value, m[k])
+		protoBytes(&entry, 1, []byte(k))
+		protoBytes(&entry, 2, value.Bytes())
+		protoBytes(buf, 1, entry.Bytes())
+	}
+}
+
+// encodeProtoValue encodes a generic JSON value as google.protobuf.Value.
+func encodeProtoValue(buf *bytes.Buffer, v interface{}) {
+	switch t := v.(type) {
+	case nil:
+		protoTag(buf, 1, wireVarint)
+		protoVarint(buf, 0)
+	case json.Number:
+		f, _ := t.Float64()
+		protoTag(buf, 2, wireFixed64)
+		_ = binary.Write(buf, binary.LittleEndian, math.Float64bits(f))
+	case string:
+		protoBytes(buf, 3, []byte(t))
+	case bool:
+		protoTag(buf, 4, wireVarint)
+		if t {
+			protoVarint(buf, 1)
+		} else {
+			protoVarint(buf, 0)
+		}
+	case map[string]interface{}:
+		var s bytes.Buffer
+		encodeProtoStruct(&s, t)
+		protoBytes(buf, 5, s.Bytes())
+	case []interface{}:
+		var list bytes.Buffer
+		for _, item := range t {
+			var value bytes.Buffer
+			encodeProtoValue(&value, item)
+			protoBytes(&list, 1, value.Bytes())
+		}
+		protoBytes(buf, 6, list.Bytes())
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNegotiateContentType(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected string
+	}{
+		{"", contentTypeJSON},
+		{"*/*", contentTypeJSON},
+		{"text/html", contentTypeJSON},
+		{"text/xml", contentTypeXML},
+		{"application/x-msgpack", contentTypeMsgpack},
+		{"application/protobuf", contentTypeProtobuf},
+		{"application/json;q=0.5, application/xml", contentTypeXML},
+		{"application/xml;q=0.2, application/msgpack;q=0.8", contentTypeMsgpack},
+		{"application/xml;q=0", contentTypeJSON},
+	}
+	for _, tt := range tests {
+		if ct := negotiateContentType(tt.accept); ct != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.accept, tt.expected, ct)
+		}
+	}
+}
+
+func TestMarshalAs(t *testing.T) {
+	v := map[string]interface{}{"a": 1, "b": []interface{}{true, nil}, "c": "x"}
+	tests := []struct {
+		ct       string
+		expected []byte
+	}{
+		{contentTypeMsgpack, []byte{
+			0x83,
+			0xa1, 'a', 0x01,
+			0xa1, 'b', 0x92, 0xc3, 0xc0,
+			0xa1, 'c', 0xa1, 'x',
+		}},
+		{contentTypeProtobuf, []byte{
+			0x0a, 0x0e, 0x0a, 0x01, 'a', 0x12, 0x09, 0x11, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f,
+			0x0a, 0x0f, 0x0a, 0x01, 'b', 0x12, 0x0a, 0x32, 0x08, 0x0a, 0x02, 0x20, 0x01, 0x0a, 0x02, 0x08, 0x00,
+			0x0a, 0x08, 0x0a, 0x01, 'c', 0x12, 0x03, 0x1a, 0x01, 'x',
+		}},
+	}
+	for _, tt := range tests {
+		raw, err := marshalAs(tt.ct, v)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.ct, err)
+		}
+		if !bytes.Equal(raw, tt.expected) {
+			t.Errorf("%s: expected % x, got % x", tt.ct, tt.expected, raw)
+		}
+	}
+}
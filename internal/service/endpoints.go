@@ -16,28 +16,45 @@
 package service
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
+	"log"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/openzipkin/zipkin-go"
-	zmw "github.com/openzipkin/zipkin-go/middleware/http"
+
+	"github.com/basvanbeek/topology-tester/pkg"
 )
 
-// setErrors allows one to set the percentage of error responses this service
-// will generate on the main echoHandler.
-func (ep *Endpoints) setErrors(w http.ResponseWriter, r *http.Request) {
+// parseDuration parses a duration string or, if not a duration string, a raw
+// number of milliseconds.
+func parseDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		// not a duration string, let's see if it is a raw number...
+		var i int
+		if i, err = strconv.Atoi(s); err != nil {
+			// not a raw number either...
+			return 0, err
+		}
+		d = time.Duration(i) * time.Millisecond
+	}
+	return d, nil
+}
+
+// setPercentage parses the percentage path variable and stores it in the
+// provided knob.
+func (ep *Endpoints) setPercentage(w http.ResponseWriter, r *http.Request, knob *atomicInt32, name string) {
 	ctx := r.Context()
-	strErrors, ok := mux.Vars(r)["percentage"]
+	strPercentage, ok := mux.Vars(r)["percentage"]
 	if !ok {
 		ep.writeResponse(ctx, w, response{
 			Code:  http.StatusBadRequest,
@@ -46,7 +63,7 @@ func (ep *Endpoints) setErrors(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	i, err := strconv.Atoi(strErrors)
+	i, err := strconv.Atoi(strPercentage)
 	if err != nil {
 		ep.writeResponse(ctx, w, response{
 			Code:  http.StatusBadRequest,
@@ -61,60 +78,82 @@ func (ep *Endpoints) setErrors(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	ep.mtx.Lock()
-	ep.errors = int32(i)
-	ep.mtx.Unlock()
+	knob.Store(int32(i))
 
 	ep.writeResponse(ctx, w, response{
 		Code:    http.StatusOK,
-		Message: fmt.Sprintf("errors percentage set to: %d%%", i),
+		Message: fmt.Sprintf("%s percentage set to: %d%%", name, i),
 	})
 }
 
+// parseBool parses the many ways one could express a boolean in a path.
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "1", "on", "yes", "y", "true", "t":
+		return true, nil
+	case "0", "off", "no", "n", "false", "f":
+		return false, nil
+	}
+	return false, errBool
+}
+
+// setErrors allows one to set the percentage of error responses this service
+// will generate on the main echoHandler.
+func (ep *Endpoints) setErrors(w http.ResponseWriter, r *http.Request) {
+	ep.setPercentage(w, r, &ep.errors, "errors")
+}
+
 // setDoubleHeaders allows one to set the percentage of double headers this
 // service will generate on the main echoHandler.
 func (ep *Endpoints) setDoubleHeaders(w http.ResponseWriter, r *http.Request) {
+	ep.setPercentage(w, r, &ep.headers, "double headers")
+}
+
+// setLatency allows one to set the latency in miliseconds this service will
+// generate on the main echoHandler.
+func (ep *Endpoints) setLatency(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	strErrors, ok := mux.Vars(r)["percentage"]
+	strErrors, ok := mux.Vars(r)["duration"]
 	if !ok {
 		ep.writeResponse(ctx, w, response{
 			Code:  http.StatusBadRequest,
-			Error: errPercentage,
+			Error: errDuration,
 		})
 		return
 	}
 
-	i, err := strconv.Atoi(strErrors)
+	d, err := parseDuration(strErrors)
 	if err != nil {
 		ep.writeResponse(ctx, w, response{
 			Code:  http.StatusBadRequest,
-			Error: errPercentage,
+			Error: errDuration,
 		})
 		return
 	}
-	if i < 0 || i > 100 {
+
+	if d < 0 {
 		ep.writeResponse(ctx, w, response{
 			Code:  http.StatusBadRequest,
-			Error: errPercentage,
+			Error: errDuration,
 		})
 		return
 	}
-	ep.mtx.Lock()
-	ep.headers = int32(i)
-	ep.mtx.Unlock()
+
+	ep.duration.Store(d)
 
 	ep.writeResponse(ctx, w, response{
 		Code:    http.StatusOK,
-		Message: fmt.Sprintf("double headers percentage set to: %d%%", i),
+		Message: fmt.Sprintf("duration set to: %s", d.String()) + ep.exceedsWriteTimeout(d),
 	})
 }
 
-// setLatency allows one to set the latency in miliseconds this service will
-// generate on the main echoHandler.
-func (ep *Endpoints) setLatency(w http.ResponseWriter, r *http.Request) {
+// delay sleeps for the provided duration for this request only and then
+// echoes the request, so a single slow trace can be shown without changing the
+// latency of the service.
+func (ep *Endpoints) delay(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	strErrors, ok := mux.Vars(r)["duration"]
-	if !ok {
+	d, err := parseDuration(mux.Vars(r)["duration"])
+	if err != nil || d < 0 {
 		ep.writeResponse(ctx, w, response{
 			Code:  http.StatusBadRequest,
 			Error: errDuration,
@@ -122,37 +161,11 @@ func (ep *Endpoints) setLatency(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d, err := time.ParseDuration(strErrors)
-	if err != nil {
-		// not a duration string, let's see if it is a raw number...
-		var i int
-		if i, err = strconv.Atoi(strErrors); err != nil {
-			// not a raw number either...
-			ep.writeResponse(ctx, w, response{
-				Code:  http.StatusBadRequest,
-				Error: errDuration,
-			})
-			return
-		}
-		d = time.Duration(i) * time.Millisecond
-	}
-
-	if d < 0 {
-		ep.writeResponse(ctx, w, response{
-			Code:  http.StatusBadRequest,
-			Error: errDuration,
-		})
+	zipkin.SpanOrNoopFromContext(ctx).Tag("delay", d.String())
+	if !ep.injectLatency(ctx, w, d) {
 		return
 	}
-
-	ep.mtx.Lock()
-	ep.duration = d
-	ep.mtx.Unlock()
-
-	ep.writeResponse(ctx, w, response{
-		Code:    http.StatusOK,
-		Message: fmt.Sprintf("duration set to: %s", d.String()),
-	})
+	ep.echoHandler(w, r)
 }
 
 // setHandleFailures allows one to set behavior of this service's proxy handler.
@@ -171,13 +184,8 @@ func (ep *Endpoints) setHandleFailures(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var h bool
-	switch strings.ToLower(handleFailures) {
-	case "1", "on", "yes", "y", "true", "t":
-		h = true
-	case "0", "off", "no", "n", "false", "f":
-		h = false
-	default:
+	h, err := parseBool(handleFailures)
+	if err != nil {
 		ep.writeResponse(ctx, w, response{
 			Code:  http.StatusBadRequest,
 			Error: errHandleFailures,
@@ -185,9 +193,7 @@ func (ep *Endpoints) setHandleFailures(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ep.mtx.Lock()
-	ep.handleFailures = h
-	ep.mtx.Unlock()
+	ep.handleFailures.Store(h)
 
 	ep.writeResponse(ctx, w, response{
 		Code:    http.StatusOK,
@@ -195,107 +201,6 @@ func (ep *Endpoints) setHandleFailures(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// crash instructs this service to crash with the provided method after 5
-// seconds of receiving this directive.
-func (ep *Endpoints) crash(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	msg := mux.Vars(r)["message"]
-	ep.writeResponse(ctx, w, response{
-		Code:    http.StatusOK,
-		Message: "crashing in 5 seconds",
-	})
-
-	go func() {
-		time.Sleep(5 * time.Second)
-		panic("crash requested: " + msg)
-	}()
-}
-
-// emulateConcurrency instructs this service to run 8 fake heavy local methods.
-// The methods will take the provided duration as their run time. The
-// concurrency argument will instruct these methods to run serial, in parallel,
-// or mixed serial and parallel. The methods are instrumented as local spans,
-// so they will show up in your trace graph.
-func (ep *Endpoints) emulateConcurrency(w http.ResponseWriter, r *http.Request) {
-	var (
-		ctx   = r.Context()
-		vars  = mux.Vars(r)
-		d     time.Duration
-		err   error
-		pSpan = zipkin.SpanFromContext(r.Context()).Context()
-	)
-	if strErrors, ok := vars["duration"]; ok {
-		d, err = time.ParseDuration(strErrors)
-		if err != nil {
-			// not a duration string, let's see if it is a raw number...
-			var i int
-			if i, err = strconv.Atoi(strErrors); err != nil {
-				// not a raw number either...
-				ep.writeResponse(ctx, w, response{
-					Code:  http.StatusBadRequest,
-					Error: errDuration,
-				})
-				return
-			}
-			d = time.Duration(i) * time.Millisecond
-		}
-		if d < 0 {
-			ep.writeResponse(ctx, w, response{
-				Code:  http.StatusBadRequest,
-				Error: errDuration,
-			})
-			return
-		}
-	}
-
-	// we will be emulating 8 heavy internal functions
-	var wg sync.WaitGroup
-	wg.Add(8)
-
-	proc := func(i int) {
-		defer wg.Done()
-		span := ep.tracer.StartSpan(fmt.Sprintf("proc-%d", i), zipkin.Parent(pSpan))
-		defer span.Finish()
-
-		span.Tag("duration", d.String())
-		time.Sleep(d)
-	}
-
-	c := vars["concurrency"]
-	switch strings.ToLower(c) {
-	case "serial":
-		for i := 0; i < 8; i++ {
-			proc(i)
-		}
-	case "mixed":
-		for i := 0; i < 8; i++ {
-			if i%2 == 0 {
-				go proc(i)
-				continue
-			}
-			proc(i)
-		}
-	case "parallel":
-		for i := 0; i < 8; i++ {
-			go proc(i)
-		}
-	default:
-		ep.writeResponse(ctx, w, response{
-			Code:  http.StatusBadRequest,
-			Error: errConcurrency,
-		})
-		return
-	}
-
-	// wait until all goroutines are finished
-	wg.Wait()
-
-	ep.writeResponse(ctx, w, response{
-		Code:    http.StatusOK,
-		Message: "ran several local spans",
-	})
-}
-
 // proxy parses and strips the first /proxy/service:port directive from the path
 // and reverse proxies the remaining path request to the targeted service.
 // This allows us to hop from service to service by providing path chunks
@@ -304,9 +209,26 @@ func (ep *Endpoints) emulateConcurrency(w http.ResponseWriter, r *http.Request)
 // Example path: /proxy/svcf/proxy/svcd/proxy/svcb/errors/50
 // This path will hop from app ingress to svdf, svcd, svcb, where this final
 // svcb will receive an /errors/50 request to handle.
+//
+// The service can also be a weighted set of services, in which case the
+// traffic is split between them by this service itself. If a hash header is
+// configured and present on the request, the service is selected by
+// consistently hashing its value instead. The selected service is returned in
+// the X-Proxy-Target response header.
+//
+// Services are resolved through DNS unless another discovery mechanism is
+// configured, in which case the selected endpoint is returned in the
+// X-Proxy-Endpoint response header.
+//
+// Example path: /proxy/svcb=80,svcb-v2=20/proxy/svcc
+//
+// Fault knobs can be set inline for the next hop only, overriding its settings
+// for this request without affecting concurrent requests.
+//
+// Example path: /proxy/svcb[latency=1s,errors=20]/proxy/svcc
 func (ep *Endpoints) proxy(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	host, ok := mux.Vars(r)["service"]
+	hop, ok := mux.Vars(r)["service"]
 	if !ok {
 		ep.writeResponse(ctx, w, response{
 			Code:  http.StatusBadRequest,
@@ -314,98 +236,295 @@ func (ep *Endpoints) proxy(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	service, directives, err := parseHopDirectives(hop)
+	if err != nil {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: err.(pkg.Error),
+		})
+		return
+	}
+	targets, err := parseSplit(service)
+	if err != nil {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errSplit,
+		})
+		return
+	}
+	host := targets[0].host
+	var hashKey string
+	if len(targets) > 1 {
+		if ep.hashHeader != "" {
+			hashKey = r.Header.Get(ep.hashHeader)
+		}
+		if hashKey != "" {
+			host = pickHashed(targets, hashKey)
+		} else {
+			host = pickWeighted(ep.rand, targets)
+		}
+		w.Header().Set("X-Proxy-Target", host)
+	}
 
+	d := ep.duration.Load()
+	e := ep.errors.Load()
+	h := ep.handleFailures.Load()
+	coalescing := ep.coalescing.Load()
 	ep.mtx.RLock()
-	d := ep.duration
-	e := ep.errors
-	h := ep.handleFailures
+	rules := ep.reqHeaderRules
 	ep.mtx.RUnlock()
+	knobs := topoKnobsFromContext(ctx)
+	knobs.duration("latency", &d)
+	knobs.percentage("errors", &e)
+	ep.warmingUp(ctx, &d, &e)
 
 	// inject configured latency
-	time.Sleep(d)
+	if !ep.injectLatency(ctx, w, d) {
+		return
+	}
 
-	if rand.Int31n(100) < e {
+	if ep.rand.Int31n(100) < e || ep.burning(ctx) {
 		// return error response...
 		ep.writeResponse(ctx, w, response{
-			Code:  http.StatusInternalServerError,
-			Error: errInternal,
+			Code:      http.StatusInternalServerError,
+			Error:     errInternal,
+			ErrorInfo: injected("INJECTED_ERROR", http.StatusInternalServerError),
 		})
 		return
 	}
 
+	span := zipkin.SpanOrNoopFromContext(ctx)
+	if len(targets) > 1 {
+		span.Tag("proxy.split", service)
+		span.Tag("proxy.target", host)
+		if hashKey != "" {
+			span.Tag("proxy.hash.header", ep.hashHeader)
+		}
+	}
+	// only safe methods are cached, keyed like coalesced requests by method
+	cacheKey := r.Method + " " + host + r.URL.RequestURI()
+	cacheable := ep.respCache.enabled() && (r.Method == http.MethodGet || r.Method == http.MethodHead)
+	if cacheable {
+		if e, ok := ep.respCache.get(cacheKey); ok {
+			span.Tag("cache", "hit")
+			e.write(w)
+			return
+		}
+		span.Tag("cache", "miss")
+	}
+
+	endpoint := host
+	if ep.discovery != nil {
+		addrs, err := ep.discovery.resolve(ctx, host)
+		if err != nil || len(addrs) == 0 {
+			if err != nil {
+				log.Printf("error while resolving %s: %v", host, err)
+			}
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadGateway,
+				Error: errNoEndpoints,
+			})
+			return
+		}
+		endpoint = addrs[ep.rand.Intn(len(addrs))]
+		span.Tag("proxy.discovery", ep.discoveryMode)
+		span.Tag("proxy.endpoint", endpoint)
+		w.Header().Set("X-Proxy-Endpoint", endpoint)
+	}
+	ep.mtx.RLock()
+	egress := ep.transportCfg.EgressProxy
+	ep.mtx.RUnlock()
+	if egress != nil {
+		span.Tag("proxy.egress", egress.Host)
+	}
+
 	r.Header = r.Header.Clone()
+	// the transport propagates the transaction from the span context baggage
+	r.Header.Del(transactionBaggage)
 	r.Host = host // this is needed or Envoy will get confused where to route it
 	r.Header.Add("Proxied-By", ep.ServiceName)
+	for _, rule := range rules {
+		rule.apply(r.Header)
+	}
+	if len(directives) > 0 {
+		r.Header.Set(topoHopHeader, directives.String())
+	}
+	if err := ep.authorize(r); err != nil {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadGateway,
+			Error: errToken,
+		})
+		return
+	}
+	ep.duplicateHost(r)
+	ep.bigRequestHeaders(r)
+	ep.emitExpectContinue(r)
+	ep.slowBody(r)
 	var (
-		svc  = fmt.Sprintf("http://%s", host)
-		path = strings.TrimPrefix(r.URL.Path, "/proxy/"+host)
+		svc  = fmt.Sprintf("http://%s", endpoint)
+		path = strings.TrimPrefix(r.URL.Path, "/proxy/"+hop)
 		u, _ = url.Parse(svc)
-		p    = httputil.NewSingleHostReverseProxy(u)
+		p    = ep.reverseProxy(u)
+		mod  modifyResponse
 	)
 
-	p.Transport, _ = zmw.NewTransport(ep.tracer, zmw.RoundTripper(p.Transport))
 	r.URL, _ = url.Parse(svc + path)
 
+	if cacheable {
+		mod = func(res *http.Response) error {
+			if res.StatusCode != http.StatusOK {
+				return nil
+			}
+			raw, err := ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+			if err != nil {
+				return err
+			}
+			ep.respCache.set(cacheKey, res.StatusCode, res.Header, raw)
+			res.Body = ioutil.NopCloser(bytes.NewReader(raw))
+			res.Header.Set("X-Cache", "MISS")
+			return nil
+		}
+	}
 	if h {
-		p.ModifyResponse = func(res *http.Response) error {
+		cacheResponse := mod
+		mod = func(res *http.Response) error {
 			if res.StatusCode == 200 {
 				// proceed unaltered
+				if cacheResponse != nil {
+					return cacheResponse(res)
+				}
 				return nil
 			}
 			// let's mimick a service that did a client request which failed,
 			// but due to nice business logic it is still able to handle
 			// the failure gracefully and return success status itself.
 			res.StatusCode = 200
-			raw, _ := ioutil.ReadAll(res.Body)
-			_ = res.Body.Close()
+			raw, truncated := ep.readFailedBody(res)
+			msg := fmt.Sprintf("%s called %s and got error return: %s",
+				ep.ServiceName, svc+path, string(raw))
+			if truncated {
+				msg += " (truncated)"
+			}
 			ep.writeResponse(ctx, w, response{
-				Code: http.StatusOK,
-				Message: fmt.Sprintf(
-					"%s called %s and got error return: %s",
-					ep.ServiceName, svc+path, string(raw)),
+				Code:    http.StatusOK,
+				Message: msg,
 			})
 			// bail proxy logic, we returned details upstream ourselves
 			return errors.New("bail")
 		}
 	}
+	if mod != nil {
+		r = r.WithContext(context.WithValue(r.Context(), modifyResponseKey{}, mod))
+	}
+	if coalescing && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		ep.coalesce(w, r, cacheKey, func(rec http.ResponseWriter) {
+			// the graceful failure handling above writes to w as well
+			w = rec
+			p.ServeHTTP(w, r)
+		})
+		return
+	}
 	p.ServeHTTP(w, r)
 }
 
+// authorize attaches a bearer token to the outbound request if an OAuth2 token
+// source has been configured.
+func (ep *Endpoints) authorize(r *http.Request) error {
+	if !ep.SvcOAuth.Enabled() {
+		return nil
+	}
+	token, err := ep.SvcOAuth.Token(r.Context())
+	if err != nil {
+		log.Printf("error while fetching access token: %v", err)
+		return err
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
 // echoHandler returns the received request handlers, potentially setting double
 // headers (for testing Envoy sidecars), or fail with an error. The method will
 // take at least as long as the set latency. Double headers and errors will
-// occur with the set percentages in the service.
+// occur with the set percentages in the service. Request bodies are read up to
+// the configured maximum size and optionally echoed back. If a schema is
+// configured, requests not matching it are rejected with a 400. If a response
+// template is set, it renders the response body instead.
 func (ep *Endpoints) echoHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	body, err := ep.readBody(r)
+	if err != nil {
+		code := http.StatusBadRequest
+		if err == errBodyTooLarge {
+			code = http.StatusRequestEntityTooLarge
+		}
+		ep.writeResponse(ctx, w, response{
+			Code:  code,
+			Error: err.(pkg.Error),
+		})
+		return
+	}
+	if ep.schema != nil {
+		if errs := ep.validateRequest(r, body); len(errs) > 0 {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errValidation,
+				Data:  errs,
+			})
+			return
+		}
+	}
+
 	// retrieve our behavioral config
+	d := ep.duration.Load()
+	h := ep.headers.Load()
+	e := ep.errors.Load()
 	ep.mtx.RLock()
-	d := ep.duration
-	h := ep.headers
-	e := ep.errors
+	t := ep.template
 	ep.mtx.RUnlock()
+	knobs := topoKnobsFromContext(ctx)
+	knobs.duration("latency", &d)
+	knobs.percentage("headers", &h)
+	knobs.percentage("errors", &e)
+	ep.warmingUp(ctx, &d, &e)
 
 	// inject configured latency
-	time.Sleep(d)
+	if !ep.injectLatency(ctx, w, d) {
+		return
+	}
 
-	if rand.Int31n(100) < e {
+	if ep.rand.Int31n(100) < e || ep.burning(ctx) {
 		// return error response...
 		ep.writeResponse(ctx, w, response{
-			Code:  http.StatusInternalServerError,
-			Error: errInternal,
+			Code:      http.StatusInternalServerError,
+			Error:     errInternal,
+			ErrorInfo: injected("INJECTED_ERROR", http.StatusInternalServerError),
 		})
 		return
 	}
 
-	if rand.Int31n(100) < h {
+	if ep.rand.Int31n(100) < h {
 		// set some double headers
 		w.WriteHeader(http.StatusOK)
 		w.Header().Add("Content-Type", "text/html")
 		w.Header().Add("Content-Type", "application/json")
 	}
 
+	if t != nil {
+		ep.writeTemplate(w, r, t, body)
+		return
+	}
+	if !ep.echoBody {
+		body = nil
+	}
+
 	// emulate successful response, sending request headers received
 	ep.writeResponse(ctx, w, response{
-		Code:    http.StatusOK,
-		Headers: r.Header,
+		Code:     http.StatusOK,
+		Method:   r.Method,
+		ClientIP: ep.clientIPCfg.clientIP(r),
+		Headers:  ep.headerFilter.filter(r.Header),
+		Identity: peerIdentity(r),
+		Body:     string(body),
 	})
 }
@@ -0,0 +1,102 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// headerPeerMetadata holds the metadata of the calling workload as injected by
+// the Istio sidecar, a base64 encoded google.protobuf.Struct.
+const headerPeerMetadata = "x-envoy-peer-metadata"
+
+// envoyInfo holds the telemetry related headers of a request.
+type envoyInfo struct {
+	Envoy        map[string][]string    `json:"envoy"`
+	B3           map[string][]string    `json:"b3"`
+	TraceParent  []string               `json:"traceparent,omitempty"`
+	RequestID    []string               `json:"requestID,omitempty"`
+	PeerMetadata map[string]interface{} `json:"peerMetadata,omitempty"`
+	PeerError    string                 `json:"peerMetadataError,omitempty"`
+}
+
+// telemetryHeaders collects the Envoy, B3, W3C trace context and request id
+// headers and decodes the peer metadata if present.
+func telemetryHeaders(hdr http.Header) envoyInfo {
+	info := envoyInfo{
+		Envoy: map[string][]string{},
+		B3:    map[string][]string{},
+	}
+	for key, values := range hdr {
+		key = strings.ToLower(key)
+		switch {
+		case strings.HasPrefix(key, "x-envoy-"):
+			info.Envoy[key] = values
+		case strings.HasPrefix(key, "x-b3-"):
+			info.B3[key] = values
+		case key == "traceparent":
+			info.TraceParent = values
+		case key == "x-request-id":
+			info.RequestID = values
+		}
+	}
+	if md := hdr.Get(headerPeerMetadata); md != "" {
+		var err error
+		if info.PeerMetadata, err = decodePeerMetadata(md); err != nil {
+			info.PeerError = err.Error()
+		}
+	}
+	return info
+}
+
+// decodePeerMetadata decodes the base64 encoded peer metadata. Istio encodes
+// it as a protobuf Struct, for convenience JSON objects are accepted as well.
+func decodePeerMetadata(md string) (map[string]interface{}, error) {
+	b, err := base64.StdEncoding.DecodeString(md)
+	if err != nil {
+		if b, err = base64.RawStdEncoding.DecodeString(md); err != nil {
+			return nil, err
+		}
+	}
+	var m map[string]interface{}
+	if json.Unmarshal(b, &m) == nil {
+		return m, nil
+	}
+	var s structpb.Struct
+	if err = proto.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	return s.AsMap(), nil
+}
+
+// envoyInfo echoes the x-envoy-*, x-b3-*, traceparent and x-request-id
+// headers of the request and decodes the x-envoy-peer-metadata header if
+// present, showing what the sidecars injected up to this hop.
+//
+// Example paths:
+//
+//	/envoyinfo
+func (ep *Endpoints) envoyInfo(w http.ResponseWriter, r *http.Request) {
+	ep.writeResponse(r.Context(), w, response{
+		Code: http.StatusOK,
+		Data: telemetryHeaders(r.Header),
+	})
+}
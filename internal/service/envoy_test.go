@@ -0,0 +1,69 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestTelemetryHeaders(t *testing.T) {
+	md, err := structpb.NewStruct(map[string]interface{}{
+		"NAME":      "frontend-5d4f",
+		"NAMESPACE": "shop",
+		"LABELS":    map[string]interface{}{"app": "frontend"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := proto.Marshal(md)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := telemetryHeaders(http.Header{
+		"X-Envoy-Attempt-Count":    {"2"},
+		"X-Envoy-Peer-Metadata":    {base64.StdEncoding.EncodeToString(b)},
+		"X-Envoy-Peer-Metadata-Id": {"sidecar~10.0.0.1~frontend-5d4f.shop~shop.svc.cluster.local"},
+		"X-B3-Traceid":             {"463ac35c9f6413ad"},
+		"Traceparent":              {"00-463ac35c9f6413ad48485a3953bb6124-a2fb4a1d1a96d312-01"},
+		"X-Request-Id":             {"abc"},
+		"Content-Type":             {"text/plain"},
+	})
+	if len(info.Envoy) != 3 || info.Envoy["x-envoy-attempt-count"][0] != "2" {
+		t.Errorf("unexpected envoy headers: %v", info.Envoy)
+	}
+	if len(info.B3) != 1 || len(info.TraceParent) != 1 || len(info.RequestID) != 1 {
+		t.Errorf("unexpected trace headers: %+v", info)
+	}
+	if info.PeerError != "" {
+		t.Fatalf("unexpected peer metadata error: %s", info.PeerError)
+	}
+	if info.PeerMetadata["NAMESPACE"] != "shop" {
+		t.Errorf("expected peer namespace shop, got %v", info.PeerMetadata["NAMESPACE"])
+	}
+	if labels, ok := info.PeerMetadata["LABELS"].(map[string]interface{}); !ok || labels["app"] != "frontend" {
+		t.Errorf("expected peer labels, got %v", info.PeerMetadata["LABELS"])
+	}
+
+	info = telemetryHeaders(http.Header{"X-Envoy-Peer-Metadata": {"%%%"}})
+	if info.PeerError == "" || info.PeerMetadata != nil {
+		t.Errorf("expected peer metadata error, got %+v", info)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/openzipkin/zipkin-go"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+)
+
+// error categories, allowing clients to handle errors without parsing their
+// messages
+const (
+	categoryInjected    = "injected"    // fault injected on purpose
+	categoryClient      = "client"      // invalid request
+	categoryCapacity    = "capacity"    // limit reached, retry later
+	categoryUnavailable = "unavailable" // instance not serving right now
+	categoryDependency  = "dependency"  // downstream call could not be made
+	categoryInternal    = "internal"    // failure of the service itself
+)
+
+// errorInfo is the machine readable description of an error response.
+type errorInfo struct {
+	Code      string `json:"code"`
+	Category  string `json:"category"`
+	Retryable bool   `json:"retryable"`
+}
+
+// errorInfos describes the errors which are not classified by their status
+// code alone.
+var errorInfos = map[pkg.Error]errorInfo{
+	errUnhealthy:        {"UNHEALTHY", categoryInjected, true},
+	errRateLimited:      {"RATE_LIMITED", categoryCapacity, true},
+	errBulkheadFull:     {"BULKHEAD_FULL", categoryCapacity, true},
+	errQueueFull:        {"QUEUE_FULL", categoryCapacity, true},
+	errLongTraceRunning: {"LONGTRACE_RUNNING", categoryCapacity, true},
+	errReplayRunning:    {"REPLAY_RUNNING", categoryCapacity, true},
+	errNotLeader:        {"NOT_LEADER", categoryUnavailable, true},
+	errNotReady:         {"NOT_READY", categoryUnavailable, true},
+	errDependencies:     {"DEPENDENCIES_NOT_READY", categoryUnavailable, true},
+	errDraining:         {"DRAINING", categoryUnavailable, true},
+	errNoEndpoints:      {"NO_ENDPOINTS", categoryDependency, true},
+	errToken:            {"TOKEN_UNAVAILABLE", categoryDependency, true},
+	errReporter:         {"REPORTER_FAILING", categoryInternal, true},
+	errIdentity:         {"IDENTITY_NOT_ALLOWED", categoryClient, false},
+	errValidation:       {"SCHEMA_VALIDATION", categoryClient, false},
+	errBodyTooLarge:     {"BODY_TOO_LARGE", categoryClient, false},
+	errMethod:           {"METHOD_NOT_ALLOWED", categoryClient, false},
+	errExpectation:      {"EXPECTATION_REQUIRED", categoryClient, false},
+}
+
+// injected returns the error info of a fault injected on purpose, retryable
+// unless the status code says otherwise.
+func injected(code string, status int) *errorInfo {
+	return &errorInfo{
+		Code:      code,
+		Category:  categoryInjected,
+		Retryable: status >= 500 || status == http.StatusTooManyRequests,
+	}
+}
+
+// classifyError returns the error info of err, falling back to a
+// classification by status code, e.g. BAD_REQUEST in the client category.
+func classifyError(err pkg.Error, status int) *errorInfo {
+	if info, ok := errorInfos[err]; ok {
+		return &info
+	}
+	info := errorInfo{
+		Code: strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+				return r
+			case r >= 'a' && r <= 'z':
+				return r - 'a' + 'A'
+			case r == '\'':
+				return -1
+			}
+			return '_'
+		}, http.StatusText(status)),
+	}
+	switch {
+	case status == http.StatusTooManyRequests:
+		info.Category, info.Retryable = categoryCapacity, true
+	case status == http.StatusRequestTimeout:
+		info.Category, info.Retryable = categoryClient, true
+	case status < 500:
+		info.Category = categoryClient
+	case status == http.StatusBadGateway, status == http.StatusGatewayTimeout:
+		info.Category, info.Retryable = categoryDependency, true
+	case status == http.StatusServiceUnavailable:
+		info.Category, info.Retryable = categoryUnavailable, true
+	default:
+		info.Category = categoryInternal
+	}
+	if info.Code == "" {
+		info.Code = "UNKNOWN"
+	}
+	return &info
+}
+
+// tagError tags the server span with the category and code of an error
+// response.
+func tagError(ctx context.Context, info *errorInfo) {
+	span := zipkin.SpanOrNoopFromContext(ctx)
+	span.Tag("error.kind", info.Category)
+	span.Tag("error.code", info.Code)
+}
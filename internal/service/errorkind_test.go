@@ -0,0 +1,50 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err      pkg.Error
+		status   int
+		expected errorInfo
+	}{
+		{errQueueFull, http.StatusServiceUnavailable, errorInfo{"QUEUE_FULL", categoryCapacity, true}},
+		{errDraining, http.StatusServiceUnavailable, errorInfo{"DRAINING", categoryUnavailable, true}},
+		{errDuration, http.StatusBadRequest, errorInfo{"BAD_REQUEST", categoryClient, false}},
+		{errInternal, http.StatusInternalServerError, errorInfo{"INTERNAL_SERVER_ERROR", categoryInternal, false}},
+		{errInternal, http.StatusBadGateway, errorInfo{"BAD_GATEWAY", categoryDependency, true}},
+		{errStatusCode, http.StatusTeapot, errorInfo{"IM_A_TEAPOT", categoryClient, false}},
+		{errStatusCode, 599, errorInfo{"UNKNOWN", categoryInternal, false}},
+	}
+	for _, tt := range tests {
+		if info := classifyError(tt.err, tt.status); *info != tt.expected {
+			t.Errorf("%s (%d): expected %+v, got %+v", tt.err, tt.status, tt.expected, *info)
+		}
+	}
+
+	if info := injected("INJECTED_STATUS", http.StatusNotFound); info.Retryable || info.Category != categoryInjected {
+		t.Errorf("expected injected 404 not to be retryable, got %+v", *info)
+	}
+	if info := injected("INJECTED_STATUS", http.StatusServiceUnavailable); !info.Retryable {
+		t.Errorf("expected injected 503 to be retryable, got %+v", *info)
+	}
+}
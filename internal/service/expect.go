@@ -0,0 +1,149 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// supported Expect: 100-continue handling modes
+const (
+	expectNone    = "none"
+	expectRequire = "require"
+	expectDelay   = "delay"
+	expectNever   = "never"
+	expectEmit    = "emit"
+
+	defaultExpectDelay = time.Second
+)
+
+// expectsContinue returns true if the request asks for a 100 Continue interim
+// response.
+func expectsContinue(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+}
+
+// expectContinue alters the handling of requests sending Expect: 100-continue.
+// The Go HTTP server sends the 100 Continue interim response when the request
+// body is first read, so delaying the first read delays the interim response
+// and replacing the body with an empty one makes sure it is never sent.
+func (ep *Endpoints) expectContinue(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ep.mtx.RLock()
+		mode := ep.expectMode
+		delay := ep.expectDelay
+		ep.mtx.RUnlock()
+
+		expects := expectsContinue(r)
+		span := zipkin.SpanOrNoopFromContext(r.Context())
+		if expects {
+			span.Tag("http.expect", "100-continue")
+		}
+		switch {
+		case mode == expectRequire && !expects && r.Body != http.NoBody:
+			ep.writeResponse(r.Context(), w, response{
+				Code:  http.StatusExpectationFailed,
+				Error: errExpectation,
+			})
+			return
+		case mode == expectDelay && expects:
+			span.Tag("fault", "expect-delay")
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(delay):
+			}
+		case mode == expectNever && expects:
+			span.Tag("fault", "expect-never")
+			r.Body = http.NoBody
+			r.ContentLength = 0
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// emitExpectContinue adds Expect: 100-continue to outbound requests with a
+// body if enabled, so the next hop and any proxy in between must handle the
+// interim response. The transport waits up to its ExpectContinueTimeout for it
+// before sending the body anyway.
+func (ep *Endpoints) emitExpectContinue(r *http.Request) {
+	if !ep.expectEmit.Load() || r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return
+	}
+	r.Header.Set("Expect", "100-continue")
+}
+
+// setExpectMode allows one to set how requests sending Expect: 100-continue
+// are handled. Mode require rejects requests with a body not sending it with a
+// 417, delay postpones the 100 Continue interim response by the duration query
+// parameter (1s by default) and never leaves it out by ignoring the request
+// body. Mode none returns to regular handling. The emit mode toggles adding the header to
+// proxied requests with a body.
+//
+// Example paths:
+//
+//	/expect/require                  reject request bodies not sent with Expect
+//	/expect/delay?duration=5s        send 100 Continue after 5 seconds
+//	/expect/never                    never send 100 Continue
+//	/expect/emit/on                  send Expect on proxied requests
+func (ep *Endpoints) setExpectMode(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	mode := vars["mode"]
+
+	var msg string
+	if mode == expectEmit {
+		emit, err := parseBool(vars["value"])
+		if err != nil {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errBool,
+			})
+			return
+		}
+		ep.expectEmit.Store(emit)
+		msg = fmt.Sprintf("emit expect set to: %t", emit)
+	} else {
+		delay := defaultExpectDelay
+		if v := r.URL.Query().Get("duration"); v != "" {
+			var err error
+			if delay, err = parseDuration(v); err != nil || delay < 0 {
+				ep.writeResponse(ctx, w, response{
+					Code:  http.StatusBadRequest,
+					Error: errDuration,
+				})
+				return
+			}
+		}
+		ep.mtx.Lock()
+		ep.expectMode, ep.expectDelay = mode, delay
+		ep.mtx.Unlock()
+		msg = fmt.Sprintf("expect mode set to: %s", mode)
+		if mode == expectDelay {
+			msg += fmt.Sprintf(" (%s)", delay)
+		}
+	}
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+	})
+}
@@ -0,0 +1,160 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+)
+
+// scopedFault holds a fault setting which only applies to service instances
+// within its scope, e.g. a specific version or instance.
+type scopedFault struct {
+	scope string
+	knob  string
+	value string
+}
+
+// parseScopedFault parses a scoped fault setting in the form of
+// scope:knob=value, where knob is one of errors, headers, latency or
+// badencoding.
+func parseScopedFault(s string) (scopedFault, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return scopedFault{}, errScopedFault
+	}
+	kv := strings.SplitN(parts[1], "=", 2)
+	if len(kv) != 2 {
+		return scopedFault{}, errScopedFault
+	}
+	f := scopedFault{scope: parts[0], knob: kv[0], value: kv[1]}
+	if err := validateKnob(f.knob, f.value); err != nil {
+		return scopedFault{}, err
+	}
+	return f, nil
+}
+
+// parseScopedFaults parses validated scoped fault settings.
+func parseScopedFaults(flags []string) []scopedFault {
+	faults := make([]scopedFault, 0, len(flags))
+	for _, s := range flags {
+		f, _ := parseScopedFault(s) // validated in Validate
+		faults = append(faults, f)
+	}
+	return faults
+}
+
+// validateKnob checks if the value is valid for the provided fault knob.
+func validateKnob(knob, value string) error {
+	switch knob {
+	case "errors", "headers", "badencoding":
+		p, err := strconv.Atoi(value)
+		if err != nil || p < 0 || p > 100 {
+			return errPercentage
+		}
+	case "latency":
+		d, err := parseDuration(value)
+		if err != nil || d < 0 {
+			return errDuration
+		}
+	default:
+		return errScopedFault
+	}
+	return nil
+}
+
+// setKnob sets a validated fault knob.
+func (ep *Endpoints) setKnob(knob, value string) {
+	switch knob {
+	case "errors":
+		p, _ := strconv.Atoi(value)
+		ep.errors.Store(int32(p))
+	case "headers":
+		p, _ := strconv.Atoi(value)
+		ep.headers.Store(int32(p))
+	case "badencoding":
+		p, _ := strconv.Atoi(value)
+		ep.badEncoding.Store(int32(p))
+	case "latency":
+		d, _ := parseDuration(value)
+		ep.duration.Store(d)
+	}
+}
+
+// applyScopedFaults applies the fault settings whose scope matches this
+// service instance, overriding the unscoped settings.
+func (ep *Endpoints) applyScopedFaults(faults []scopedFault, matches func(scope string) bool) {
+	for _, f := range faults {
+		if matches(f.scope) {
+			ep.setKnob(f.knob, f.value)
+		}
+	}
+}
+
+// matchesInstance returns true if the selector matches this instance, either
+// by its hostname or by its ordinal, being the numeric suffix of the hostname
+// as found with StatefulSet pods (e.g. 2 matches svcb-2).
+func (ep *Endpoints) matchesInstance(selector string) bool {
+	if selector == ep.instance {
+		return true
+	}
+	if _, err := strconv.Atoi(selector); err != nil {
+		return false
+	}
+	return strings.HasSuffix(ep.instance, "-"+selector)
+}
+
+// instanceFault allows one to set a fault knob on a specific instance only,
+// so outlier detection can be demonstrated with a single Deployment. Other
+// instances receiving the request leave their settings untouched, which makes
+// it safe to send the request to all instances (e.g. through the controller).
+//
+// Example paths:
+//
+//	/admin/instance/svcb-6f7d9c-x2x7k/errors/100   fail all requests on a pod
+//	/admin/instance/2/latency/1s                   slow down StatefulSet pod 2
+func (ep *Endpoints) instanceFault(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	selector, knob, value := vars["instance"], vars["knob"], vars["value"]
+	if err := validateKnob(knob, value); err != nil {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: err.(pkg.Error),
+		})
+		return
+	}
+
+	if !ep.matchesInstance(selector) {
+		ep.writeResponse(ctx, w, response{
+			Code: http.StatusOK,
+			Message: fmt.Sprintf("%s not applied, instance %s does not match %s",
+				knob, ep.instance, selector),
+		})
+		return
+	}
+	ep.setKnob(knob, value)
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: fmt.Sprintf("%s set to: %s on instance %s", knob, value, ep.instance),
+	})
+}
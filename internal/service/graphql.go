@@ -0,0 +1,239 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// gqlField is a top level field selection of a GraphQL query.
+type gqlField struct {
+	Alias string
+	Name  string
+}
+
+type gqlError struct {
+	Message string   `json:"message"`
+	Path    []string `json:"path,omitempty"`
+}
+
+type gqlResponse struct {
+	Data   map[string]interface{} `json:"data,omitempty"`
+	Errors []gqlError             `json:"errors,omitempty"`
+}
+
+// parseResolver parses resolver configuration in the form of
+// "field=host[:port][/path]".
+func parseResolver(s string) (field, target string, err error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errResolver
+	}
+	field, target = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if !strings.Contains(target, "/") {
+		target += "/"
+	}
+	return field, target, nil
+}
+
+// topLevelFields returns the top level field selections of the provided
+// GraphQL query. As resolvers only fan out to downstream services, nested
+// selections and arguments are accepted but ignored. Fragments are not
+// supported.
+func topLevelFields(query string) ([]gqlField, error) {
+	var (
+		fields []gqlField
+		depth  int
+		parens int
+		alias  string
+		rs     = []rune(query)
+	)
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		switch {
+		case c == '#':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			for i++; i < len(rs) && rs[i] != '"'; i++ {
+				if rs[i] == '\\' {
+					i++
+				}
+			}
+		case c == '(':
+			parens++
+		case c == ')':
+			parens--
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth < 0 {
+				return nil, errQuery
+			}
+		case c == '.' && depth == 1 && parens == 0:
+			return nil, errQuery
+		case c == '@':
+			// skip directive names, their arguments are skipped as usual
+			for i+1 < len(rs) && (rs[i+1] == '_' || unicode.IsLetter(rs[i+1]) ||
+				unicode.IsDigit(rs[i+1])) {
+				i++
+			}
+		case depth == 1 && parens == 0 && (c == '_' || unicode.IsLetter(c)):
+			start := i
+			for i+1 < len(rs) && (rs[i+1] == '_' || unicode.IsLetter(rs[i+1]) ||
+				unicode.IsDigit(rs[i+1])) {
+				i++
+			}
+			name := string(rs[start : i+1])
+			// check if this identifier is an alias
+			j := i + 1
+			for j < len(rs) && unicode.IsSpace(rs[j]) {
+				j++
+			}
+			if j < len(rs) && rs[j] == ':' {
+				alias, i = name, j
+				continue
+			}
+			if alias == "" {
+				alias = name
+			}
+			fields = append(fields, gqlField{Alias: alias, Name: name})
+			alias = ""
+		}
+	}
+	if depth != 0 || parens != 0 || len(fields) == 0 {
+		return nil, errQuery
+	}
+	return fields, nil
+}
+
+// graphql implements a GraphQL facade, modelling the backend for frontend
+// pattern. Each top level field of the query is resolved by calling the
+// downstream service configured for it. Resolvers run in parallel and are
+// instrumented as local spans, with the downstream calls as their children.
+//
+// Example query: { alpha beta: zeta }
+// This query will call the services configured for the alpha and zeta fields
+// and return their responses as the alpha and beta properties.
+func (ep *Endpoints) graphql(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("query")
+	if r.Method == http.MethodPost {
+		var req struct {
+			Query string `json:"query"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			ep.writeGraphQL(w, http.StatusBadRequest, gqlResponse{
+				Errors: []gqlError{{Message: err.Error()}},
+			})
+			return
+		}
+		query = req.Query
+	}
+
+	fields, err := topLevelFields(query)
+	if err != nil {
+		ep.writeGraphQL(w, http.StatusBadRequest, gqlResponse{
+			Errors: []gqlError{{Message: err.Error()}},
+		})
+		return
+	}
+
+	var (
+		wg  sync.WaitGroup
+		mtx sync.Mutex
+		res = gqlResponse{Data: make(map[string]interface{}, len(fields))}
+	)
+	for _, f := range fields {
+		wg.Add(1)
+		go func(f gqlField) {
+			defer wg.Done()
+			data, err := ep.resolve(r, f)
+			mtx.Lock()
+			defer mtx.Unlock()
+			res.Data[f.Alias] = data
+			if err != nil {
+				res.Errors = append(res.Errors, gqlError{
+					Message: err.Error(),
+					Path:    []string{f.Alias},
+				})
+			}
+		}(f)
+	}
+	wg.Wait()
+
+	ep.writeGraphQL(w, http.StatusOK, res)
+}
+
+// resolve calls the downstream service configured for the provided field.
+func (ep *Endpoints) resolve(r *http.Request, f gqlField) (interface{}, error) {
+	span, ctx := ep.tracer.StartSpanFromContext(r.Context(), "resolve "+f.Name)
+	defer span.Finish()
+
+	target, ok := ep.resolvers[f.Name]
+	if !ok {
+		zipkin.TagError.Set(span, errResolver.Error())
+		return nil, fmt.Errorf("no resolver for field %q", f.Name)
+	}
+	span.Tag("graphql.field", f.Name)
+	span.Tag("graphql.target", target)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Proxied-By", ep.ServiceName)
+	if err = ep.authorize(req); err != nil {
+		return nil, errToken
+	}
+	res, err := ep.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	raw, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if err = json.Unmarshal(raw, &data); err != nil {
+		data = string(raw)
+	}
+	if res.StatusCode != http.StatusOK {
+		return data, fmt.Errorf("%s returned status code %d", target, res.StatusCode)
+	}
+	return data, nil
+}
+
+func (ep *Endpoints) writeGraphQL(w http.ResponseWriter, code int, res gqlResponse) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(res); err != nil {
+		log.Printf("error while writing http response: %v", err)
+	}
+}
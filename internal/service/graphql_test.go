@@ -0,0 +1,54 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTopLevelFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected []gqlField
+		err      error
+	}{
+		{"simple", "{ alpha beta }", []gqlField{{"alpha", "alpha"}, {"beta", "beta"}}, nil},
+		{"alias", "{ a: alpha b : beta }", []gqlField{{"a", "alpha"}, {"b", "beta"}}, nil},
+		{
+			"named-query",
+			`query Users($id: ID) { users(id: $id, q: "x{") { name } # comment {
+			orders @include(if: true) { id } }`,
+			[]gqlField{{"users", "users"}, {"orders", "orders"}},
+			nil,
+		},
+		{"fragment", "{ ...F }", nil, errQuery},
+		{"unbalanced", "{ alpha { beta }", nil, errQuery},
+		{"empty", "{ }", nil, errQuery},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := topLevelFields(tt.query)
+			if err != tt.err {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if !reflect.DeepEqual(fields, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, fields)
+			}
+		})
+	}
+}
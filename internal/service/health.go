@@ -0,0 +1,69 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// healthz is the liveness endpoint of this service. It reports failure while
+// liveness sabotage is active.
+func (ep *Endpoints) healthz(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	ep.mtx.RLock()
+	failUntil := ep.healthFailUntil
+	ep.mtx.RUnlock()
+
+	if time.Now().Before(failUntil) {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusInternalServerError,
+			Error: errUnhealthy,
+		})
+		return
+	}
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: "ok",
+	})
+}
+
+// failHealth allows one to let the liveness endpoint fail for the provided
+// duration without otherwise affecting traffic, so restart behavior of the
+// kubelet can be tested. A duration of 0 ends active liveness sabotage.
+func (ep *Endpoints) failHealth(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	d, err := parseDuration(mux.Vars(r)["duration"])
+	if err != nil || d < 0 {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errDuration,
+		})
+		return
+	}
+
+	ep.mtx.Lock()
+	ep.healthFailUntil = time.Now().Add(d)
+	ep.mtx.Unlock()
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: fmt.Sprintf("liveness failing for: %s", d),
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+)
+
+// hop path headers, accumulating the services passed and the time spent in
+// each of them as responses travel back through the chain.
+const (
+	topoPathHeader    = "X-Topo-Path"
+	topoLatencyHeader = "X-Topo-Path-Latency"
+)
+
+// pathSummary prepends this service and the time it took to respond to the
+// hop path headers returned by the next hop, if enabled.
+func (ep *Endpoints) pathSummary(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ep.topoPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		pw := &pathWriter{ResponseWriter: w, service: ep.ServiceName, start: time.Now()}
+		next.ServeHTTP(pw, r)
+		if !pw.wroteHeader && !pw.hijacked {
+			// handler did not write a response, net/http sends our headers
+			pw.summarize()
+		}
+	})
+}
+
+// pathWriter adds this hop to the hop path headers when the response headers
+// are written.
+type pathWriter struct {
+	http.ResponseWriter
+	service     string
+	start       time.Time
+	wroteHeader bool
+	hijacked    bool
+}
+
+// summarize prepends this hop to the hop path headers copied from the
+// response of the next hop, if any.
+func (p *pathWriter) summarize() {
+	h := p.Header()
+	path, latency := p.service, p.service+"="+time.Since(p.start).Round(time.Microsecond).String()
+	if downstream := h.Get(topoPathHeader); downstream != "" {
+		path += "->" + downstream
+	}
+	if downstream := h.Get(topoLatencyHeader); downstream != "" {
+		latency += ", " + downstream
+	}
+	h.Set(topoPathHeader, path)
+	h.Set(topoLatencyHeader, latency)
+}
+
+func (p *pathWriter) WriteHeader(code int) {
+	if p.wroteHeader {
+		return
+	}
+	p.wroteHeader = true
+	p.summarize()
+	p.ResponseWriter.WriteHeader(code)
+}
+
+func (p *pathWriter) Write(b []byte) (int, error) {
+	if !p.wroteHeader {
+		p.WriteHeader(http.StatusOK)
+	}
+	return p.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher.
+func (p *pathWriter) Flush() {
+	if f, ok := p.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (p *pathWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := p.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	p.hijacked = true
+	return h.Hijack()
+}
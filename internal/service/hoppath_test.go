@@ -0,0 +1,55 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPathSummary(t *testing.T) {
+	svcb := &Endpoints{ServiceName: "svcb", topoPath: true}
+	downstream := svcb.pathSummary(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// svca copies the response headers of svcb like the reverse proxy does
+	svca := &Endpoints{ServiceName: "svca", topoPath: true}
+	upstream := svca.pathSummary(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		downstream.ServeHTTP(rec, r)
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+	}))
+
+	rec := httptest.NewRecorder()
+	upstream.ServeHTTP(rec, httptest.NewRequest("GET", "/proxy/svcb", nil))
+	if path := rec.Header().Get(topoPathHeader); path != "svca->svcb" {
+		t.Errorf("expected path svca->svcb, got %q", path)
+	}
+	latency := strings.Split(rec.Header().Get(topoLatencyHeader), ", ")
+	if len(latency) != 2 || !strings.HasPrefix(latency[0], "svca=") || !strings.HasPrefix(latency[1], "svcb=") {
+		t.Errorf("expected latency per hop, got %q", latency)
+	}
+
+	svca.topoPath = false
+	rec = httptest.NewRecorder()
+	upstream.ServeHTTP(rec, httptest.NewRequest("GET", "/proxy/svcb", nil))
+	if path := rec.Header().Get(topoPathHeader); path != "svcb" {
+		t.Errorf("expected path of the next hop only, got %q", path)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// identity holds the details of a verified client certificate presented to
+// this service when it terminates TLS itself.
+type identity struct {
+	SpiffeID string   `json:"spiffeID,omitempty"`
+	Subject  string   `json:"subject,omitempty"`
+	DNSNames []string `json:"dnsNames,omitempty"`
+	URIs     []string `json:"uris,omitempty"`
+}
+
+// peerIdentity returns the identity of the client certificate used on the
+// request's TLS connection or nil if no client certificate was presented.
+func peerIdentity(r *http.Request) *identity {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := r.TLS.PeerCertificates[0]
+	id := &identity{
+		Subject:  cert.Subject.String(),
+		DNSNames: cert.DNSNames,
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+		if u.Scheme == "spiffe" && id.SpiffeID == "" {
+			id.SpiffeID = u.String()
+		}
+	}
+	return id
+}
+
+// matches returns true if the identity holds the provided SPIFFE ID, URI SAN
+// or DNS SAN.
+func (id *identity) matches(name string) bool {
+	if id == nil {
+		return false
+	}
+	for _, u := range id.URIs {
+		if u == name {
+			return true
+		}
+	}
+	for _, d := range id.DNSNames {
+		if strings.EqualFold(d, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// identityCheck tags the server span with the peer identity and, if a required
+// identity has been set, rejects requests not originating from it. The admin
+// endpoints are exempt so the requirement can always be lifted again.
+func (ep *Endpoints) identityCheck(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := peerIdentity(r)
+		if span := zipkin.SpanFromContext(r.Context()); span != nil && id != nil {
+			if id.SpiffeID != "" {
+				span.Tag("tls.peer.spiffe_id", id.SpiffeID)
+			}
+			span.Tag("tls.peer.subject", id.Subject)
+			var sans []string
+			sans = append(sans, id.URIs...)
+			sans = append(sans, id.DNSNames...)
+			if len(sans) > 0 {
+				span.Tag("tls.peer.san", strings.Join(sans, ","))
+			}
+		}
+
+		ep.mtx.RLock()
+		required := ep.requiredIdentity
+		ep.mtx.RUnlock()
+
+		if required != "" && !id.matches(required) &&
+			!strings.HasPrefix(r.URL.Path, "/admin/") {
+			ep.writeResponse(r.Context(), w, response{
+				Code:  http.StatusForbidden,
+				Error: errIdentity,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireIdentity allows one to set the client identity (SPIFFE ID, URI or DNS
+// SAN) required to be presented by clients to access this service. Use
+// /admin/identity/reset to lift the requirement again.
+func (ep *Endpoints) requireIdentity(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	name := mux.Vars(r)["identity"]
+
+	ep.mtx.Lock()
+	ep.requiredIdentity = name
+	ep.mtx.Unlock()
+
+	msg := "required identity reset"
+	if name != "" {
+		msg = fmt.Sprintf("required identity set to: %s", name)
+	}
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+	})
+}
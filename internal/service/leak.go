@@ -0,0 +1,164 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// leakMetrics holds the leaked resource gauges, exposed through expvar.
+var leakMetrics = expvar.NewMap("leaks")
+
+// leakStats holds the leaked resource statistics as reported by the leak
+// endpoints.
+type leakStats struct {
+	Goroutines      int64 `json:"leakedGoroutines"`
+	Connections     int   `json:"leakedConnections"`
+	TotalGoroutines int   `json:"totalGoroutines"`
+}
+
+// leaks keeps track of intentionally leaked resources so they can be released
+// on request.
+type leaks struct {
+	mtx        sync.Mutex
+	stop       chan struct{}
+	goroutines int64
+	conns      []net.Conn
+}
+
+func newLeaks() *leaks {
+	return &leaks{stop: make(chan struct{})}
+}
+
+// leakGoroutines starts n goroutines which block until the leaks are stopped.
+func (l *leaks) leakGoroutines(n int) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	stop := l.stop
+	for i := 0; i < n; i++ {
+		go func() { <-stop }()
+	}
+	l.goroutines += int64(n)
+	leakMetrics.Add("goroutines", int64(n))
+}
+
+// leakConnection opens a TCP connection to target and keeps it open until the
+// leaks are stopped.
+func (l *leaks) leakConnection(target string) error {
+	conn, err := net.DialTimeout("tcp", target, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	l.mtx.Lock()
+	l.conns = append(l.conns, conn)
+	l.mtx.Unlock()
+	leakMetrics.Add("connections", 1)
+	return nil
+}
+
+// release stops all leaked goroutines and closes all leaked connections.
+func (l *leaks) release() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	close(l.stop)
+	l.stop = make(chan struct{})
+	for _, conn := range l.conns {
+		_ = conn.Close()
+	}
+	leakMetrics.Add("goroutines", -l.goroutines)
+	leakMetrics.Add("connections", -int64(len(l.conns)))
+	l.goroutines, l.conns = 0, nil
+}
+
+func (l *leaks) stats() leakStats {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return leakStats{
+		Goroutines:      l.goroutines,
+		Connections:     len(l.conns),
+		TotalGoroutines: runtime.NumGoroutine(),
+	}
+}
+
+// leakGoroutines leaks the provided amount of goroutines on each request, so
+// runtime metrics and profiles show a slowly degrading service.
+func (ep *Endpoints) leakGoroutines(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	n, err := strconv.Atoi(mux.Vars(r)["countPerRequest"])
+	if err != nil || n < 0 {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errCount,
+		})
+		return
+	}
+	ep.leaks.leakGoroutines(n)
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: fmt.Sprintf("leaked %d goroutines", n),
+		Data:    ep.leaks.stats(),
+	})
+}
+
+// leakConnection opens a TCP connection to the provided target host:port on
+// each request and never closes it.
+func (ep *Endpoints) leakConnection(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	target := mux.Vars(r)["target"]
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errTarget,
+		})
+		return
+	}
+	if err := ep.leaks.leakConnection(target); err != nil {
+		ep.writeResponse(ctx, w, response{
+			Code:    http.StatusBadGateway,
+			Error:   errInternal,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: fmt.Sprintf("leaked connection to %s", target),
+		Data:    ep.leaks.stats(),
+	})
+}
+
+// stopLeaks releases all intentionally leaked resources.
+func (ep *Endpoints) stopLeaks(w http.ResponseWriter, r *http.Request) {
+	ep.leaks.release()
+
+	ep.writeResponse(r.Context(), w, response{
+		Code:    http.StatusOK,
+		Message: "leaked resources released",
+		Data:    ep.leaks.stats(),
+	})
+}
@@ -0,0 +1,229 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+const (
+	defaultLongTraceMaxSpans = 100000
+
+	// long traces of at least this size log their progress
+	longTraceProgressMin = 1000
+	// longTraceWorkers is the maximum amount of goroutines creating fanout
+	// spans of a long trace.
+	longTraceWorkers = 256
+)
+
+// longTraceMetrics holds the long trace counters, exposed through expvar.
+var longTraceMetrics = expvar.NewMap("longtrace")
+
+// longTraceSize returns the amount of local spans created for a long trace of
+// the provided amount of sequential spans, each with fanout parallel children
+// nested depth levels deep. It returns false if the size exceeds max.
+func longTraceSize(spans, fanout, depth int, max int64) (int64, bool) {
+	perStep, level := int64(1), int64(1)
+	for i := 0; i < depth && fanout > 0; i++ {
+		level *= int64(fanout)
+		perStep += level
+		if perStep > max {
+			return 0, false
+		}
+	}
+	if int64(spans) > max/perStep {
+		return 0, false
+	}
+	return perStep * int64(spans), true
+}
+
+// longTraceBuilder creates the local spans of a long trace, reporting its
+// progress.
+type longTraceBuilder struct {
+	tracer   *zipkin.Tracer
+	workers  chan struct{}
+	fanout   int
+	depth    int
+	duration time.Duration
+	total    int64
+	created  int64
+	traceID  string
+}
+
+func (b *longTraceBuilder) finish(span zipkin.Span) {
+	span.Finish()
+	longTraceMetrics.Add("spans", 1)
+	n := atomic.AddInt64(&b.created, 1)
+	if b.total >= longTraceProgressMin && n%(b.total/10) == 0 {
+		log.Printf("long trace %s: created %d of %d spans", b.traceID, n, b.total)
+	}
+}
+
+// fanOut creates fanout parallel children of parent, recursing until the
+// configured depth is reached. Children are created by at most
+// longTraceWorkers goroutines, once all are busy the remaining children are
+// created inline. Creation stops when ctx is done.
+func (b *longTraceBuilder) fanOut(ctx context.Context, parent model.SpanContext, depth int) {
+	if depth == 0 || b.fanout == 0 {
+		return
+	}
+	child := func(i int) {
+		span := b.tracer.StartSpan(fmt.Sprintf("fanout-%d", i), zipkin.Parent(parent))
+		b.fanOut(ctx, span.Context(), depth-1)
+		sleep(ctx, b.duration)
+		b.finish(span)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < b.fanout && ctx.Err() == nil; i++ {
+		select {
+		case b.workers <- struct{}{}:
+			wg.Add(1)
+			go func(i int) {
+				defer func() {
+					<-b.workers
+					wg.Done()
+				}()
+				child(i)
+			}(i)
+		default:
+			child(i)
+		}
+	}
+	wg.Wait()
+}
+
+// longTrace creates a trace holding the provided amount of sequential local
+// spans to stress backend ingestion and UI rendering. Each sequential span can
+// have parallel children set by the fanout query parameter, nested as deep as
+// the depth query parameter (1 by default), and take the duration query
+// parameter as run time. Traces exceeding the amount of spans set by
+// --ep-longtrace-max-spans are rejected and only a single long trace is
+// created at a time. Progress of large traces is logged and span counts are
+// exposed through the longtrace expvar map. Creation stops when the client
+// goes away.
+//
+// Example paths:
+//
+//	/longtrace/1000                          1000 sequential spans
+//	/longtrace/100?fanout=10                 100 spans with 10 parallel children each
+//	/longtrace/10?fanout=4&depth=3           10 spans with a tree of 4^3 children each
+//	/longtrace/50?duration=10ms              50 spans taking 10ms each
+func (ep *Endpoints) longTrace(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	spans, err := strconv.Atoi(mux.Vars(r)["spans"])
+	if err != nil || spans <= 0 {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errSpans,
+		})
+		return
+	}
+	fanout, depth := 0, 1
+	if v := query.Get("fanout"); v != "" {
+		if fanout, err = strconv.Atoi(v); err != nil || fanout < 0 {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errFanout,
+			})
+			return
+		}
+	}
+	if v := query.Get("depth"); v != "" {
+		if depth, err = strconv.Atoi(v); err != nil || depth < 0 {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errDepth,
+			})
+			return
+		}
+	}
+	var d time.Duration
+	if v := query.Get("duration"); v != "" {
+		if d, err = parseDuration(v); err != nil || d < 0 {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errDuration,
+			})
+			return
+		}
+	}
+	total, ok := longTraceSize(spans, fanout, depth, ep.longTraceMax)
+	if !ok {
+		ep.writeResponse(ctx, w, response{
+			Code:    http.StatusBadRequest,
+			Error:   errLongTraceSize,
+			Message: fmt.Sprintf("maximum amount of spans: %d", ep.longTraceMax),
+		})
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&ep.longTraceRunning, 0, 1) {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusConflict,
+			Error: errLongTraceRunning,
+		})
+		return
+	}
+	defer atomic.StoreInt32(&ep.longTraceRunning, 0)
+
+	span := zipkin.SpanOrNoopFromContext(ctx)
+	span.Tag("longtrace.spans", strconv.FormatInt(total, 10))
+	b := &longTraceBuilder{
+		tracer:   ep.tracer,
+		workers:  make(chan struct{}, longTraceWorkers),
+		fanout:   fanout,
+		depth:    depth,
+		duration: d,
+		total:    total,
+		traceID:  traceID(ctx),
+	}
+	longTraceMetrics.Add("traces", 1)
+	start := time.Now()
+	for i := 0; i < spans && ctx.Err() == nil; i++ {
+		step := ep.tracer.StartSpan(fmt.Sprintf("step-%d", i), zipkin.Parent(span.Context()))
+		b.fanOut(ctx, step.Context(), depth)
+		sleep(ctx, d)
+		b.finish(step)
+	}
+	created := atomic.LoadInt64(&b.created)
+	if created < total {
+		log.Printf("long trace %s: aborted after %d of %d spans", b.traceID, created, total)
+	}
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: fmt.Sprintf("created long trace with %d local spans", created),
+		Data: map[string]interface{}{
+			"spans":    created,
+			"total":    total,
+			"fanout":   fanout,
+			"depth":    depth,
+			"duration": time.Since(start).String(),
+		},
+	})
+}
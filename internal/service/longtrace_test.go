@@ -0,0 +1,103 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+)
+
+func TestLongTraceSize(t *testing.T) {
+	tests := []struct {
+		spans, fanout, depth int
+		max                  int64
+		expected             int64
+		ok                   bool
+	}{
+		{1000, 0, 1, 100000, 1000, true},
+		{100, 10, 1, 100000, 1100, true},
+		{10, 4, 3, 100000, 850, true},
+		{10, 4, 0, 100000, 10, true},
+		{100000, 0, 1, 100000, 100000, true},
+		{100001, 0, 1, 100000, 0, false},
+		{1, 10, 6, 100000, 0, false},
+		{1, 1 << 30, 1 << 10, 100000, 0, false},
+	}
+	for _, tt := range tests {
+		size, ok := longTraceSize(tt.spans, tt.fanout, tt.depth, tt.max)
+		if size != tt.expected || ok != tt.ok {
+			t.Errorf("%d spans, fanout %d, depth %d: expected %d (%t), got %d (%t)",
+				tt.spans, tt.fanout, tt.depth, tt.expected, tt.ok, size, ok)
+		}
+	}
+}
+
+func TestLongTraceFanOut(t *testing.T) {
+	tracer, err := zipkin.NewTracer(recorder.NewReporter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	newBuilder := func(fanout, depth, workers int, d time.Duration) *longTraceBuilder {
+		total, _ := longTraceSize(1, fanout, depth, 1<<20)
+		return &longTraceBuilder{
+			tracer:   tracer,
+			workers:  make(chan struct{}, workers),
+			fanout:   fanout,
+			depth:    depth,
+			duration: d,
+			total:    total - 1,
+		}
+	}
+
+	// all children are created while the goroutines stay bounded
+	b := newBuilder(4, 4, 8, time.Millisecond)
+	goroutines := runtime.NumGoroutine()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.fanOut(context.Background(), model.SpanContext{}, b.depth)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			if n := runtime.NumGoroutine() - goroutines; n > 8+2 {
+				t.Fatalf("expected at most 8 workers, got %d goroutines", n)
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+	if b.created != b.total {
+		t.Errorf("expected %d spans, got %d", b.total, b.created)
+	}
+
+	// creation stops when the context is done
+	b = newBuilder(10, 3, 4, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	b.fanOut(ctx, model.SpanContext{}, b.depth)
+	if time.Since(start) > 5*time.Second || b.created >= b.total {
+		t.Errorf("expected cancelled fanout to stop early, created %d of %d spans in %s",
+			b.created, b.total, time.Since(start))
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// names of the catch-all routes, whose method handling differs from the other
+// routes
+const (
+	routeEcho  = "echo"
+	routeProxy = "proxy"
+)
+
+// headWriter discards the response body of HEAD requests while counting its
+// size, so the Content-Length of the equivalent GET response can be announced
+// even for bodies too large for net/http to buffer.
+type headWriter struct {
+	http.ResponseWriter
+	code int
+	size int64
+}
+
+func (h *headWriter) WriteHeader(code int) {
+	if h.code == 0 {
+		h.code = code
+	}
+}
+
+func (h *headWriter) Write(b []byte) (int, error) {
+	if h.code == 0 {
+		h.code = http.StatusOK
+	}
+	h.size += int64(len(b))
+	return len(b), nil
+}
+
+// Flush implements http.Flusher. Headers are held back until the handler
+// returns, as the Content-Length is not known before.
+func (h *headWriter) Flush() {}
+
+func (h *headWriter) finish() {
+	if h.code == 0 {
+		h.code = http.StatusOK
+	}
+	hdr := h.Header()
+	if h.code >= http.StatusOK && h.code != http.StatusNoContent &&
+		h.code != http.StatusNotModified && hdr.Get("Content-Length") == "" {
+		hdr.Del("Transfer-Encoding")
+		hdr.Set("Content-Length", strconv.FormatInt(h.size, 10))
+	}
+	h.ResponseWriter.WriteHeader(h.code)
+}
+
+// routeMethods returns the methods accepted by the provided route, including
+// the implicit HEAD for GET routes and OPTIONS.
+func routeMethods(route *mux.Route) []string {
+	methods, err := route.GetMethods()
+	if err != nil {
+		// route accepts any method
+		return anyMethods
+	}
+	return appendMethods(nil, methods...)
+}
+
+// appendMethods adds the provided methods to the list if not yet present,
+// adding HEAD for GET and OPTIONS for any method.
+func appendMethods(list []string, methods ...string) []string {
+	for _, method := range methods {
+		add := []string{method}
+		if method == http.MethodGet {
+			add = append(add, http.MethodHead)
+		}
+		add = append(add, http.MethodOptions)
+		for _, m := range add {
+			found := false
+			for _, l := range list {
+				if l == m {
+					found = true
+					break
+				}
+			}
+			if !found {
+				list = append(list, m)
+			}
+		}
+	}
+	return list
+}
+
+// methodRoute is a route accepting only a set of methods.
+type methodRoute struct {
+	route   *mux.Route
+	methods []string
+}
+
+// headKey flags GET requests dispatched for a HEAD request.
+type headKey struct{}
+
+// indexMethods records the routes accepting only a set of methods and the
+// methods they accept, once all routes have been registered.
+func (ep *Endpoints) indexMethods() {
+	ep.methodRoutes = nil
+	_ = ep.router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		if _, err := route.GetMethods(); err == nil {
+			ep.methodRoutes = append(ep.methodRoutes, methodRoute{route: route, methods: routeMethods(route)})
+		}
+		return nil
+	})
+}
+
+// mismatchedRoutes returns the routes matching the request path but not its
+// method.
+func (ep *Endpoints) mismatchedRoutes(r *http.Request) []methodRoute {
+	var routes []methodRoute
+	for _, rt := range ep.methodRoutes {
+		var m mux.RouteMatch
+		if !rt.route.Match(r, &m) && m.MatchErr == mux.ErrMethodMismatch {
+			routes = append(routes, rt)
+		}
+	}
+	return routes
+}
+
+// requestMethod returns the method of the request as sent by the client.
+func requestMethod(r *http.Request) string {
+	if head, _ := r.Context().Value(headKey{}).(bool); head {
+		return http.MethodHead
+	}
+	return r.Method
+}
+
+// headRouter dispatches HEAD requests which would fall through to the echo
+// handler as GET requests if a GET route matches them, so they are served by
+// that route passing all router middlewares, with methods discarding the body.
+func (ep *Endpoints) headRouter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m mux.RouteMatch
+		if r.Method != http.MethodHead || !ep.router.Match(r, &m) ||
+			m.Route.GetName() != routeEcho {
+			next.ServeHTTP(w, r)
+			return
+		}
+		get := r.WithContext(context.WithValue(r.Context(), headKey{}, true))
+		get.Method = http.MethodGet
+		for _, rt := range ep.methodRoutes {
+			if rt.route.Match(get, &mux.RouteMatch{}) {
+				next.ServeHTTP(w, get)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// methods enforces the methods accepted by our routes. Requests for a known
+// path using an unsupported method are rejected with a 405 and an Allow header
+// instead of falling through to the echo handler. HEAD requests dispatched to
+// a GET route by headRouter are served without a body, announcing the
+// Content-Length of the GET response. OPTIONS requests are answered with the
+// Allow header of the route. Proxied requests are forwarded as is.
+func (ep *Endpoints) methods(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil || route.GetName() == routeProxy {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if requestMethod(r) == http.MethodHead && r.Method != http.MethodHead {
+			r = r.WithContext(r.Context())
+			r.Method = http.MethodHead
+		}
+
+		var allowed []string
+		if route.GetName() == routeEcho {
+			for _, rt := range ep.mismatchedRoutes(r) {
+				allowed = appendMethods(allowed, rt.methods...)
+			}
+		}
+
+		switch {
+		case r.Method == http.MethodOptions:
+			if allowed == nil {
+				allowed = routeMethods(route)
+			}
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			w.WriteHeader(http.StatusNoContent)
+		case allowed != nil:
+			zipkin.SpanOrNoopFromContext(r.Context()).Tag("http.allow", strings.Join(allowed, ", "))
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			ep.writeResponse(r.Context(), w, response{
+				Code:  http.StatusMethodNotAllowed,
+				Error: errMethod,
+			})
+		case r.Method == http.MethodHead:
+			hw := &headWriter{ResponseWriter: w}
+			next.ServeHTTP(hw, r)
+			hw.finish()
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
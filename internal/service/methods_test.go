@@ -0,0 +1,95 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+	zmw "github.com/openzipkin/zipkin-go/middleware/http"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+)
+
+func TestMethods(t *testing.T) {
+	rec := recorder.NewReporter()
+	tracer, err := zipkin.NewTracer(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := &Endpoints{spanName: spanNameRoute}
+	router := mux.NewRouter()
+	router.Methods("GET").Path("/items/{id}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("expected HEAD request, got %s", r.Method)
+		}
+		_, _ = w.Write([]byte("item " + mux.Vars(r)["id"]))
+	})
+	router.Methods("POST").Path("/items").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router.PathPrefix("/proxy/{service}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}).Name(routeProxy)
+	// wrapped, so only the route name identifies the echo handler
+	router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ep.echoHandler(w, r)
+	}).Name(routeEcho)
+	var inner int
+	router.Use(ep.spanNamer, ep.methods, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			inner++
+			w.Header().Set("X-Inner", "true")
+			next.ServeHTTP(w, r)
+		})
+	})
+	ep.router = router
+	ep.indexMethods()
+	if len(ep.methodRoutes) != 2 {
+		t.Fatalf("expected 2 method routes, got %d", len(ep.methodRoutes))
+	}
+	handler := zmw.NewServerMiddleware(tracer)(ep.headRouter(router))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("HEAD", "/items/42", nil))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "7" {
+		t.Errorf("expected bodyless 200 with the GET content length, got %d %q %v",
+			w.Code, w.Body.String(), w.Header())
+	}
+	if inner != 1 || w.Header().Get("X-Inner") != "true" {
+		t.Errorf("expected HEAD request to pass the inner middlewares once, got %d", inner)
+	}
+	if spans := rec.Flush(); len(spans) != 1 || spans[0].Name != "head /items/{id}" {
+		t.Errorf("expected span named after the HEAD request, got %+v", spans)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "/items", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST, OPTIONS" {
+		t.Errorf("expected 405 allowing POST, got %d %v", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "/proxy/items", nil))
+	if w.Code != http.StatusAccepted {
+		t.Errorf("expected proxied request to be forwarded as is, got %d %v", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/items/42", nil))
+	if w.Code != http.StatusNoContent || w.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("expected 204 allowing GET, got %d %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// supported span naming strategies for server spans
+const (
+	spanNameRoute  = "route"
+	spanNamePath   = "path"
+	spanNameMethod = "method"
+)
+
+// spanNamer renames the server span created by the Zipkin middleware based on
+// the configured span naming strategy. By default, spans are named after the
+// matched mux route template (e.g. "get /proxy/{service}") which keeps span
+// names low cardinality, even for long proxy chains.
+func (ep *Endpoints) spanNamer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := zipkin.SpanFromContext(r.Context())
+		if span == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		method := strings.ToLower(requestMethod(r))
+		switch ep.spanName {
+		case spanNameRoute:
+			if route := mux.CurrentRoute(r); route != nil {
+				if tpl, err := route.GetPathTemplate(); err == nil {
+					span.SetName(method + " " + tpl)
+				}
+			}
+		case spanNamePath:
+			span.SetName(method + " " + r.URL.Path)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,42 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+)
+
+// observabilityStatus returns the connectivity status of the span reporter,
+// so reporting failures such as an unreachable or failing collector are
+// surfaced instead of spans silently disappearing. A 503 is returned while
+// sending spans fails.
+//
+// Example paths:
+//
+//	/admin/observability/status
+func (ep *Endpoints) observabilityStatus(w http.ResponseWriter, r *http.Request) {
+	status := ep.SvcTracer.GetReporterStatus()
+
+	res := response{
+		Code: http.StatusOK,
+		Data: status,
+	}
+	if !status.Healthy {
+		res.Code = http.StatusServiceUnavailable
+		res.Error = errReporter
+		res.Message = status.LastError
+	}
+	ep.writeResponse(r.Context(), w, res)
+}
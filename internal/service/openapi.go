@@ -0,0 +1,245 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gorilla/mux"
+)
+
+// methodAny denotes routes which accept any HTTP method.
+const methodAny = "ANY"
+
+// anyMethods lists the methods documented for routes accepting any method.
+var anyMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+var (
+	// reRouteVar matches mux route variables with an optional pattern.
+	reRouteVar = regexp.MustCompile(`{([^:}]+)(?::([^}]+))?}`)
+	// reEnum matches mux route variable patterns listing fixed values.
+	reEnum = regexp.MustCompile(`^[a-z0-9-]+(\|[a-z0-9-]+)*$`)
+)
+
+// routeInfo describes a route registered on our router.
+type routeInfo struct {
+	path    string
+	methods []string
+	prefix  bool
+	handler string
+	params  []routeParam
+}
+
+// routeParam describes a path variable of a route.
+type routeParam struct {
+	name string
+	enum []string
+}
+
+// routes returns the routes currently registered on our router.
+func (ep *Endpoints) routes() []routeInfo {
+	var routes []routeInfo
+	_ = ep.router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// route accepts any method
+			methods = []string{methodAny}
+		}
+		re, _ := route.GetPathRegexp()
+		ri := routeInfo{
+			path:    reRouteVar.ReplaceAllString(tpl, "{$1}"),
+			methods: methods,
+			prefix:  !strings.HasSuffix(re, "$"),
+			handler: handlerName(route.GetHandler()),
+		}
+		for _, m := range reRouteVar.FindAllStringSubmatch(tpl, -1) {
+			p := routeParam{name: m[1]}
+			if reEnum.MatchString(m[2]) {
+				p.enum = strings.Split(m[2], "|")
+			}
+			ri.params = append(ri.params, p)
+		}
+		routes = append(routes, ri)
+		return nil
+	})
+	return routes
+}
+
+// handlerName returns the name of the Endpoints method serving a route. It is
+// a documentation label only, routing decisions rely on route names.
+func handlerName(h http.Handler) string {
+	if h == nil {
+		return ""
+	}
+	name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		name = name[idx+1:]
+	}
+	return name
+}
+
+// knobs returns the current values of the runtime adjustable settings.
+func (ep *Endpoints) knobs() map[string]interface{} {
+	ep.mtx.RLock()
+	defer ep.mtx.RUnlock()
+
+	rules := make([]string, 0, len(ep.reqHeaderRules))
+	for _, rule := range ep.reqHeaderRules {
+		rules = append(rules, rule.String())
+	}
+	return map[string]interface{}{
+		"errors":      ep.errors.Load(),
+		"headers":     ep.headers.Load(),
+		"badencoding": ep.badEncoding.Load(),
+		"violations": map[string]int32{
+			violationContentLength: ep.badContentLength.Load(),
+			violationStatusLine:    ep.badStatusLine.Load(),
+			violationEOF:           ep.prematureEOF.Load(),
+			violationDuplicateHost: ep.dupHost.Load(),
+		},
+		"blackhole":        ep.blackholes.Load(),
+		"idle":             ep.idleConns.Load(),
+		"latency":          ep.duration.Load().String(),
+		"graceful":         ep.handleFailures.Load(),
+		"slowbody":         ep.slowBodyRate.Load(),
+		"slowread":         ep.slowReadRate.Load(),
+		"reqheaders":       rules,
+		"requiredIdentity": ep.requiredIdentity,
+		"startupMode":      ep.startupMode,
+		"startupDelay":     ep.startupDelay.String(),
+		"cacheTTL":         ep.respCache.stats().TTL,
+		"version":          ep.version,
+		"seed":             ep.rand.seed,
+	}
+}
+
+// openAPI serves an OpenAPI 3 document describing all registered routes.
+func (ep *Endpoints) openAPI(w http.ResponseWriter, _ *http.Request) {
+	var (
+		paths = make(map[string]map[string]interface{})
+		seen  = make(map[string]int)
+	)
+	for _, ri := range ep.routes() {
+		path := ri.path
+		if ri.prefix && path != "/" {
+			// the remaining path is handled by the next hop
+			path = strings.TrimSuffix(path, "/") + "/{path}"
+			ri.params = append(ri.params, routeParam{name: "path"})
+		}
+		params := make([]map[string]interface{}, 0, len(ri.params))
+		for _, p := range ri.params {
+			schema := map[string]interface{}{"type": "string"}
+			if len(p.enum) > 0 {
+				schema["enum"] = p.enum
+			}
+			params = append(params, map[string]interface{}{
+				"name":     p.name,
+				"in":       "path",
+				"required": true,
+				"schema":   schema,
+			})
+		}
+		ops, ok := paths[path]
+		if !ok {
+			ops = make(map[string]interface{})
+			paths[path] = ops
+		}
+		methods := ri.methods
+		if len(methods) == 1 && methods[0] == methodAny {
+			methods = anyMethods
+		}
+		for _, method := range methods {
+			// handlers can serve multiple routes, keep operation ids unique
+			id := ri.handler
+			if n := seen[ri.handler]; n > 0 {
+				id = fmt.Sprintf("%s%d", ri.handler, n+1)
+			}
+			seen[ri.handler]++
+			op := map[string]interface{}{
+				"operationId": id,
+				"summary":     ri.handler,
+				"responses": map[string]interface{}{
+					"default": map[string]interface{}{
+						"description": "service response",
+					},
+				},
+			}
+			if len(params) > 0 {
+				op["parameters"] = params
+			}
+			ops[strings.ToLower(method)] = op
+		}
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   ep.ServiceName,
+			"version": ep.version,
+		},
+		"paths": paths,
+	}
+	w.Header().Add("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		log.Printf("error while writing http response: %v", err)
+	}
+}
+
+// endpoints serves a human readable listing of all registered routes together
+// with the current values of the runtime adjustable settings.
+func (ep *Endpoints) endpoints(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "ROUTES\n")
+	for _, ri := range ep.routes() {
+		path := ri.path
+		if ri.prefix {
+			path = strings.TrimSuffix(path, "/") + "/..."
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", strings.Join(ri.methods, ","), path, ri.handler)
+	}
+
+	knobs := ep.knobs()
+	names := make([]string, 0, len(knobs))
+	for name := range knobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(tw, "\nSETTINGS\n")
+	for _, name := range names {
+		fmt.Fprintf(tw, "%s\t%v\n", name, knobs[name])
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,85 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// defaultPriorityHeader is the default request header holding the priority of
+// a request.
+const defaultPriorityHeader = "X-Priority"
+
+// request priorities
+const (
+	priorityHigh   = "high"
+	priorityNormal = "normal"
+	priorityLow    = "low"
+)
+
+// priority decisions of the bulkhead, worker pool and rate limiter
+const (
+	decisionBypass    = "bypass"
+	decisionAdmitted  = "admitted"
+	decisionThrottled = "throttled"
+)
+
+// parsePriority returns the priority of a priority header value. Unknown
+// values are treated as normal priority.
+func parsePriority(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case priorityHigh, "critical", "urgent":
+		return priorityHigh
+	case priorityLow, "background", "bulk":
+		return priorityLow
+	}
+	return priorityNormal
+}
+
+// priority returns the priority of the request, tagging the server span with
+// it if provided.
+func (ep *Endpoints) priority(r *http.Request) string {
+	if ep.priorityHeader == "" {
+		return priorityNormal
+	}
+	value := r.Header.Get(ep.priorityHeader)
+	if value == "" {
+		return priorityNormal
+	}
+	p := parsePriority(value)
+	zipkin.SpanOrNoopFromContext(r.Context()).Tag("priority", p)
+	return p
+}
+
+// share returns the percentage of a limit available to requests of priority p.
+// Low priority requests only get a share of the capacity, so they are
+// throttled first.
+func (ep *Endpoints) share(p string) int {
+	if p == priorityLow {
+		return ep.priorityLowShare
+	}
+	return 100
+}
+
+// tagDecision tags the server span with the priority decision of a limiter,
+// if the request has a priority other than normal.
+func tagDecision(r *http.Request, p, limiter, decision string) {
+	if p != priorityNormal {
+		zipkin.SpanOrNoopFromContext(r.Context()).Tag("priority."+limiter, decision)
+	}
+}
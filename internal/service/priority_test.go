@@ -0,0 +1,37 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import "testing"
+
+func TestParsePriority(t *testing.T) {
+	tests := []struct {
+		in, expected string
+	}{
+		{"high", priorityHigh},
+		{" HIGH ", priorityHigh},
+		{"critical", priorityHigh},
+		{"low", priorityLow},
+		{"background", priorityLow},
+		{"normal", priorityNormal},
+		{"5", priorityNormal},
+		{"", priorityNormal},
+	}
+	for _, tt := range tests {
+		if got := parsePriority(tt.in); got != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.in, tt.expected, got)
+		}
+	}
+}
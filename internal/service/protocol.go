@@ -0,0 +1,170 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// supported protocol violations
+const (
+	violationContentLength = "contentlength"
+	violationStatusLine    = "statusline"
+	violationEOF           = "eof"
+	violationDuplicateHost = "duplicatehost"
+)
+
+// contentLengthSkew is the amount of bytes the announced Content-Length
+// exceeds the actual body size with when injecting the wrong Content-Length
+// fault.
+const contentLengthSkew = 1024
+
+// violationWriter buffers a response so it can be written to the hijacked
+// connection in a malformed way.
+type violationWriter struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (v *violationWriter) Header() http.Header {
+	return v.header
+}
+
+func (v *violationWriter) WriteHeader(code int) {
+	if v.code == 0 {
+		v.code = code
+	}
+}
+
+func (v *violationWriter) Write(b []byte) (int, error) {
+	if v.code == 0 {
+		v.code = http.StatusOK
+	}
+	return v.body.Write(b)
+}
+
+// pickViolation returns the response protocol violation to inject, if any.
+func (ep *Endpoints) pickViolation() string {
+	switch {
+	case ep.rand.Int31n(100) < ep.badContentLength.Load():
+		return violationContentLength
+	case ep.rand.Int31n(100) < ep.badStatusLine.Load():
+		return violationStatusLine
+	case ep.rand.Int31n(100) < ep.prematureEOF.Load():
+		return violationEOF
+	}
+	return ""
+}
+
+// protocolViolation renders a percentage of responses as invalid HTTP by
+// writing them directly to the hijacked connection. Depending on the enabled
+// violations, the response announces a Content-Length larger than the body, has
+// a garbage status line or is cut off halfway through the body. The connection
+// is closed after each malformed response.
+func (ep *Endpoints) protocolViolation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		violation := ep.pickViolation()
+		hj, ok := w.(http.Hijacker)
+		if violation == "" || !ok {
+			// HTTP/2 connections can't be hijacked
+			next.ServeHTTP(w, r)
+			return
+		}
+		span := zipkin.SpanOrNoopFromContext(r.Context())
+		span.Tag("fault", "protocol-violation")
+		span.Tag("fault.violation", violation)
+
+		vw := &violationWriter{header: w.Header().Clone()}
+		next.ServeHTTP(vw, r)
+		if vw.code == 0 {
+			vw.code = http.StatusOK
+		}
+
+		conn, buf, err := hj.Hijack()
+		if err != nil {
+			log.Printf("unable to hijack connection: %v", err)
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		body := vw.body.Bytes()
+		h := vw.header
+		h.Del("Transfer-Encoding")
+		h.Set("Connection", "close")
+		h.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+		switch violation {
+		case violationContentLength:
+			h.Set("Content-Length", strconv.Itoa(len(body)+contentLengthSkew))
+		case violationEOF:
+			body = body[:len(body)/2]
+		}
+		if violation == violationStatusLine {
+			_, _ = buf.WriteString("HTTP/1.1 9x9 \x00garbage\x7f\r\n")
+		} else {
+			_, _ = fmt.Fprintf(buf, "HTTP/1.1 %d %s\r\n", vw.code, http.StatusText(vw.code))
+		}
+		_ = h.Write(buf)
+		_, _ = buf.WriteString("\r\n")
+		_, _ = buf.Write(body)
+		_ = buf.Flush()
+	})
+}
+
+// duplicateHost adds a second Host header to a percentage of outbound
+// requests.
+func (ep *Endpoints) duplicateHost(r *http.Request) {
+	if ep.rand.Int31n(100) >= ep.dupHost.Load() {
+		return
+	}
+	zipkin.SpanOrNoopFromContext(r.Context()).Tag("fault", "duplicate-host")
+	// the canonical Host key is excluded when writing the request headers,
+	// a lowercase key is not
+	r.Header["host"] = []string{r.Host}
+}
+
+// setViolation allows one to set the percentage of responses or outbound
+// requests violating the HTTP protocol.
+//
+// Example paths:
+//
+//	/violation/contentlength/10     announce a too large Content-Length
+//	/violation/statusline/10        send a garbage status line
+//	/violation/eof/10               close the connection halfway the body
+//	/violation/duplicatehost/10     send proxied requests with two Host headers
+func (ep *Endpoints) setViolation(w http.ResponseWriter, r *http.Request) {
+	var knob *atomicInt32
+	kind := mux.Vars(r)["kind"]
+	switch kind {
+	case violationContentLength:
+		knob = &ep.badContentLength
+	case violationStatusLine:
+		knob = &ep.badStatusLine
+	case violationEOF:
+		knob = &ep.prematureEOF
+	case violationDuplicateHost:
+		knob = &ep.dupHost
+	}
+	ep.setPercentage(w, r, knob, kind)
+}
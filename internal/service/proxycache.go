@@ -0,0 +1,101 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+)
+
+// maxCachedProxies bounds the number of targets we keep a reverse proxy for.
+// Proxies for targets beyond this are created on demand.
+const maxCachedProxies = 1024
+
+// proxyMetrics holds the reverse proxy cache counters, exposed through expvar.
+var proxyMetrics = expvar.NewMap("proxies")
+
+type modifyResponseKey struct{}
+
+// modifyResponse is a per request hook applied to the proxied response.
+type modifyResponse func(*http.Response) error
+
+// proxyCache holds a reverse proxy per target so outbound connections are
+// pooled across requests and client spans reflect realistic connection reuse.
+// The proxies resolve the current outbound transport on each call so changes
+// made through /admin/transport are picked up without flushing the cache.
+type proxyCache struct {
+	mtx     sync.RWMutex
+	proxies map[string]*httputil.ReverseProxy
+}
+
+func newProxyCache() *proxyCache {
+	return &proxyCache{proxies: make(map[string]*httputil.ReverseProxy)}
+}
+
+// get returns the reverse proxy for the target, creating it if needed.
+func (c *proxyCache) get(target string, newProxy func() *httputil.ReverseProxy) *httputil.ReverseProxy {
+	c.mtx.RLock()
+	p, ok := c.proxies[target]
+	c.mtx.RUnlock()
+	if ok {
+		proxyMetrics.Add("hits", 1)
+		return p
+	}
+	proxyMetrics.Add("misses", 1)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if p, ok = c.proxies[target]; ok {
+		return p
+	}
+	p = newProxy()
+	if len(c.proxies) < maxCachedProxies {
+		c.proxies[target] = p
+		proxyMetrics.Add("cached", 1)
+	}
+	return p
+}
+
+// reverseProxy returns the reverse proxy for the target. Per request response
+// handling is passed in the request context under modifyResponseKey.
+func (ep *Endpoints) reverseProxy(target *url.URL) *httputil.ReverseProxy {
+	return ep.proxies.get(target.String(), func() *httputil.ReverseProxy {
+		p := httputil.NewSingleHostReverseProxy(target)
+		p.Transport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			return ep.roundTripper().RoundTrip(r)
+		})
+		p.ModifyResponse = func(res *http.Response) error {
+			if modify, ok := res.Request.Context().Value(modifyResponseKey{}).(modifyResponse); ok {
+				return modify(res)
+			}
+			return nil
+		}
+		p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			if r.Context().Err() == context.Canceled {
+				// the client is gone, there is no one to report the failure to
+				cancelMetrics.Add("downstream", 1)
+				return
+			}
+			log.Printf("http: proxy error: %v", err)
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		return p
+	})
+}
@@ -0,0 +1,53 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net/http/httputil"
+	"testing"
+)
+
+func TestProxyCache(t *testing.T) {
+	var (
+		c       = newProxyCache()
+		created int
+	)
+	newProxy := func() *httputil.ReverseProxy {
+		created++
+		return &httputil.ReverseProxy{}
+	}
+	a := c.get("http://svc1", newProxy)
+	if b := c.get("http://svc1", newProxy); a != b {
+		t.Errorf("expected the cached proxy to be reused")
+	}
+	if b := c.get("http://svc2", newProxy); a == b {
+		t.Errorf("expected a separate proxy per target")
+	}
+	if created != 2 {
+		t.Errorf("created: want 2, have %d", created)
+	}
+
+	for i := len(c.proxies); i < maxCachedProxies; i++ {
+		c.get(fmt.Sprintf("http://svc-%d", i), newProxy)
+	}
+	created = 0
+	c.get("http://overflow", newProxy)
+	c.get("http://overflow", newProxy)
+	if created != 2 || len(c.proxies) != maxCachedProxies {
+		t.Errorf("expected targets beyond the limit not to be cached, created %d, cached %d",
+			created, len(c.proxies))
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// queueMetrics holds the worker pool gauges and counters, exposed through
+// expvar.
+var queueMetrics = expvar.NewMap("queue")
+
+type queueWaitKey struct{}
+
+// queueStats holds the settings and current state of the worker pool.
+type queueStats struct {
+	Workers int `json:"workers"`
+	Depth   int `json:"depth"`
+	Busy    int `json:"busy"`
+	Waiting int `json:"waiting"`
+}
+
+// waiter is a request waiting for a worker.
+type waiter struct {
+	ready chan struct{}
+	low   bool
+}
+
+// workQueue funnels requests through a bounded pool of workers. Requests
+// finding all workers busy wait in a FIFO queue of the configured depth,
+// requests finding the queue full are rejected. Low priority requests are only
+// handed a worker if no other requests are waiting. With 0 workers the pool is
+// disabled.
+type workQueue struct {
+	mtx     sync.Mutex
+	workers int
+	depth   int
+	busy    int
+	waiting []waiter
+}
+
+// acquire takes a worker, waiting in the queue if all workers are busy. The
+// request may only use the share percentage of the queue depth.
+func (q *workQueue) acquire(ctx context.Context, share int) error {
+	q.mtx.Lock()
+	if q.workers == 0 || (q.busy < q.workers && len(q.waiting) == 0) {
+		q.busy++
+		q.mtx.Unlock()
+		return nil
+	}
+	if len(q.waiting) >= q.depth*share/100 {
+		q.mtx.Unlock()
+		queueMetrics.Add("rejected", 1)
+		return errQueueFull
+	}
+	ready := make(chan struct{})
+	q.waiting = append(q.waiting, waiter{ready: ready, low: share < 100})
+	q.mtx.Unlock()
+	queueMetrics.Add("queued", 1)
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		q.mtx.Lock()
+		defer q.mtx.Unlock()
+		for i, wt := range q.waiting {
+			if wt.ready == ready {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// a worker was handed over while giving up, pass it on
+		q.busy--
+		q.dispatch()
+		return ctx.Err()
+	}
+}
+
+// release returns the worker taken by acquire, handing it over to the longest
+// waiting request, preferring requests which are not low priority.
+func (q *workQueue) release() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	q.busy--
+	q.dispatch()
+}
+
+// dispatch hands over idle workers to waiting requests. The caller must hold
+// the mutex.
+func (q *workQueue) dispatch() {
+	for len(q.waiting) > 0 && (q.workers == 0 || q.busy < q.workers) {
+		next := 0
+		for i, wt := range q.waiting {
+			if !wt.low {
+				next = i
+				break
+			}
+		}
+		close(q.waiting[next].ready)
+		q.waiting = append(q.waiting[:next], q.waiting[next+1:]...)
+		q.busy++
+	}
+}
+
+// setWorkers changes the amount of workers, dispatching waiting requests if
+// workers were added.
+func (q *workQueue) setWorkers(workers int) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	q.workers = workers
+	q.dispatch()
+}
+
+// setDepth changes the queue depth.
+func (q *workQueue) setDepth(depth int) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	q.depth = depth
+}
+
+func (q *workQueue) stats() queueStats {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return queueStats{
+		Workers: q.workers,
+		Depth:   q.depth,
+		Busy:    q.busy,
+		Waiting: len(q.waiting),
+	}
+}
+
+// queueWait returns the time the request waited for a worker.
+func queueWait(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Value(queueWaitKey{}).(time.Duration)
+	return d, ok
+}
+
+// workerPool funnels requests through the bounded worker pool, recording the
+// time spent waiting for a worker as a local span, so the traces show
+// realistic queueing delays. Requests finding the queue full are rejected with
+// a 503. High priority requests bypass the worker pool and low priority
+// requests only get a share of the queue. The admin endpoints are exempt so
+// the service can always be inspected.
+func (ep *Endpoints) workerPool(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ep.queue.stats().Workers == 0 || strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p := ep.priority(r)
+		if p == priorityHigh {
+			queueMetrics.Add("bypassed", 1)
+			tagDecision(r, p, "queue", decisionBypass)
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		span, _ := ep.tracer.StartSpanFromContext(ctx, "queue-wait")
+		start := time.Now()
+		err := ep.queue.acquire(ctx, ep.share(p))
+		wait := time.Since(start)
+		span.Tag("queue.wait", wait.String())
+		if err != nil {
+			zipkin.TagError.Set(span, err.Error())
+		}
+		span.Finish()
+		if err == errQueueFull {
+			tagDecision(r, p, "queue", decisionThrottled)
+			zipkin.SpanOrNoopFromContext(ctx).Tag("fault", "queue-full")
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusServiceUnavailable,
+				Error: errQueueFull,
+			})
+			return
+		}
+		if err != nil {
+			// client went away while waiting
+			return
+		}
+		tagDecision(r, p, "queue", decisionAdmitted)
+		defer ep.queue.release()
+		w.Header().Set("X-Queue-Wait", wait.String())
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, queueWaitKey{}, wait)))
+	})
+}
+
+// workQueue allows one to inspect and change the worker pool settings. Setting
+// the amount of workers to 0 disables the worker pool.
+//
+// Example paths:
+//
+//	/admin/queue                  show the settings and current state
+//	/admin/queue/workers/4        handle at most 4 requests concurrently
+//	/admin/queue/depth/50         queue at most 50 requests
+func (ep *Endpoints) workQueue(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	var msg string
+	if setting, ok := vars["setting"]; ok {
+		n, err := strconv.Atoi(vars["value"])
+		if err != nil || n < 0 {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errCount,
+			})
+			return
+		}
+		switch setting {
+		case "workers":
+			ep.queue.setWorkers(n)
+		case "depth":
+			ep.queue.setDepth(n)
+		}
+		msg = fmt.Sprintf("queue %s set to: %d", setting, n)
+	}
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+		Data:    ep.queue.stats(),
+	})
+}
@@ -0,0 +1,98 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWorkQueue(t *testing.T) {
+	q := &workQueue{workers: 1, depth: 1}
+	ctx := context.Background()
+
+	if err := q.acquire(ctx, 100); err != nil {
+		t.Fatalf("expected idle worker, got %v", err)
+	}
+	acquired := make(chan error)
+	go func() { acquired <- q.acquire(ctx, 100) }()
+	for q.stats().Waiting != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := q.acquire(ctx, 100); err != errQueueFull {
+		t.Errorf("expected %v, got %v", errQueueFull, err)
+	}
+
+	q.release()
+	if err := <-acquired; err != nil {
+		t.Errorf("expected queued request to be handed the worker, got %v", err)
+	}
+	if s := q.stats(); s.Busy != 1 || s.Waiting != 0 {
+		t.Errorf("expected 1 busy worker and no waiting requests, got %+v", s)
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := q.acquire(cctx, 100); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if s := q.stats(); s.Waiting != 0 {
+		t.Errorf("expected abandoned request to leave the queue, got %+v", s)
+	}
+
+	go func() { acquired <- q.acquire(ctx, 100) }()
+	for q.stats().Waiting != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	q.setWorkers(2)
+	if err := <-acquired; err != nil {
+		t.Errorf("expected added worker to be handed out, got %v", err)
+	}
+
+}
+
+func TestWorkQueuePriority(t *testing.T) {
+	q := &workQueue{workers: 1, depth: 4}
+	ctx := context.Background()
+
+	if err := q.acquire(ctx, 100); err != nil {
+		t.Fatalf("expected idle worker, got %v", err)
+	}
+	order := make(chan string, 3)
+	wait := func(name string, share, waiting int) {
+		go func() {
+			if err := q.acquire(ctx, share); err == nil {
+				order <- name
+			}
+		}()
+		for q.stats().Waiting != waiting {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wait("low", 50, 1)
+	wait("low", 50, 2)
+	if err := q.acquire(ctx, 50); err != errQueueFull {
+		t.Errorf("expected low priority share of the queue to be full, got %v", err)
+	}
+	wait("normal", 100, 3)
+
+	for _, expected := range []string{"normal", "low", "low"} {
+		q.release()
+		if got := <-order; got != expected {
+			t.Errorf("expected %s priority request to be handed the worker, got %s", expected, got)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// randShards is the number of independently locked random sources. It is
+// fixed rather than derived from GOMAXPROCS so a seed yields the same sequence
+// of fault decisions on any machine.
+const randShards = 16
+
+// faultRand is a concurrency safe random source for the fault decisions. The
+// draws are spread round robin over seeded shards so concurrent requests don't
+// contend on the single lock of the global math/rand source. Replaying the same
+// sequential workload with the same seed reproduces the same faults.
+type faultRand struct {
+	seed   int64
+	next   uint32
+	shards [randShards]randShard
+}
+
+type randShard struct {
+	mtx sync.Mutex
+	rnd *rand.Rand
+}
+
+func newFaultRand(seed int64) *faultRand {
+	f := &faultRand{seed: seed}
+	for i := range f.shards {
+		f.shards[i].rnd = rand.New(rand.NewSource(seed + int64(i)))
+	}
+	return f
+}
+
+// shard returns the next shard to draw from, locked.
+func (f *faultRand) shard() *randShard {
+	s := &f.shards[(atomic.AddUint32(&f.next, 1)-1)%randShards]
+	s.mtx.Lock()
+	return s
+}
+
+// Int31n returns a random number in [0,n).
+func (f *faultRand) Int31n(n int32) int32 {
+	s := f.shard()
+	defer s.mtx.Unlock()
+	return s.rnd.Int31n(n)
+}
+
+// Intn returns a random number in [0,n).
+func (f *faultRand) Intn(n int) int {
+	s := f.shard()
+	defer s.mtx.Unlock()
+	return s.rnd.Intn(n)
+}
+
+// Float64 returns a random number in [0.0,1.0).
+func (f *faultRand) Float64() float64 {
+	s := f.shard()
+	defer s.mtx.Unlock()
+	return s.rnd.Float64()
+}
+
+// adminConfig returns the current values of the runtime adjustable settings
+// and the seed of the random source, so a run of fault decisions can be
+// reproduced by starting an instance with the same --ep-seed.
+//
+// Example paths:
+//
+//	/admin/config
+func (ep *Endpoints) adminConfig(w http.ResponseWriter, r *http.Request) {
+	ep.writeResponse(r.Context(), w, response{
+		Code: http.StatusOK,
+		Data: ep.knobs(),
+	})
+}
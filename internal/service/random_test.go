@@ -0,0 +1,61 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestFaultRandReproducible(t *testing.T) {
+	draw := func(seed int64) []int32 {
+		f := newFaultRand(seed)
+		seq := make([]int32, 100)
+		for i := range seq {
+			seq[i] = f.Int31n(100)
+		}
+		return seq
+	}
+	a, b, c := draw(42), draw(42), draw(43)
+	same := true
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("draw %d: expected the same sequence for the same seed", i)
+		}
+		same = same && a[i] == c[i]
+	}
+	if same {
+		t.Errorf("expected a different sequence for a different seed")
+	}
+}
+
+func TestFaultRandConcurrent(t *testing.T) {
+	f := newFaultRand(1)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if n := f.Intn(10); n < 0 || n >= 10 {
+					t.Errorf("out of range: %d", n)
+					return
+				}
+				_ = f.Float64()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// rateLimiter implements a token bucket allowing rate requests per second with
+// bursts of up to burst requests.
+type rateLimiter struct {
+	mtx    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	b := float64(burst)
+	if b <= 0 {
+		b = math.Max(1, math.Ceil(rate))
+	}
+	return &rateLimiter{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+// allow takes a token from the bucket, returning the time to wait for the
+// next token if none is available. Requests entitled to a share percentage
+// below 100 only take a token while more than the remaining part of the burst
+// is left in the bucket, so that part is reserved for other requests.
+func (l *rateLimiter) allow(share int) (bool, time.Duration) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	need := 1 + l.burst*float64(100-share)/100
+	if l.tokens >= need {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+}
+
+// rateLimit rejects requests exceeding the configured rate limit with a 429.
+// High priority requests bypass the rate limit and low priority requests are
+// rejected once their share of the burst is used. The admin endpoints are
+// exempt and do not use up the burst, so the service can always be inspected
+// and adjusted under load.
+func (ep *Endpoints) rateLimit(next http.Handler) http.Handler {
+	limiter := newRateLimiter(ep.rateLimitRPS, ep.rateLimitBurst)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p := ep.priority(r)
+		if p == priorityHigh {
+			tagDecision(r, p, "ratelimit", decisionBypass)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := limiter.allow(ep.share(p)); !ok {
+			tagDecision(r, p, "ratelimit", decisionThrottled)
+			zipkin.SpanOrNoopFromContext(r.Context()).Tag("fault", "rate-limited")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			ep.writeResponse(r.Context(), w, response{
+				Code:  http.StatusTooManyRequests,
+				Error: errRateLimited,
+			})
+			return
+		}
+		tagDecision(r, p, "ratelimit", decisionAdmitted)
+		next.ServeHTTP(w, r)
+	})
+}
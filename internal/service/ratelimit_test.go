@@ -0,0 +1,82 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openzipkin/zipkin-go"
+	zmw "github.com/openzipkin/zipkin-go/middleware/http"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+)
+
+func TestRateLimiterShare(t *testing.T) {
+	l := newRateLimiter(0.001, 10)
+
+	// low priority requests may only drain half of the burst
+	admitted := 0
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.allow(50); ok {
+			admitted++
+		}
+	}
+	if admitted != 5 {
+		t.Errorf("expected 5 low priority requests to be admitted, got %d", admitted)
+	}
+	ok, wait := l.allow(50)
+	if ok || wait <= 0 {
+		t.Errorf("expected low priority request to be throttled with a wait, got %t %s", ok, wait)
+	}
+
+	// the reserved part of the burst remains available to other requests
+	admitted = 0
+	for i := 0; i < 10; i++ {
+		if ok, _ := l.allow(100); ok {
+			admitted++
+		}
+	}
+	if admitted != 5 {
+		t.Errorf("expected 5 normal priority requests to be admitted, got %d", admitted)
+	}
+}
+
+func TestRateLimitAdmin(t *testing.T) {
+	tracer, err := zipkin.NewTracer(recorder.NewReporter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := &Endpoints{rateLimitRPS: 0.001, rateLimitBurst: 1}
+	handler := zmw.NewServerMiddleware(tracer)(ep.rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	serve := func(path string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+
+	// admin requests are exempt and leave the burst to other requests
+	for i := 0; i < 3; i++ {
+		if code := serve("/admin/queue"); code != http.StatusOK {
+			t.Errorf("expected admin request to be exempt, got %d", code)
+		}
+	}
+	if code := serve("/"); code != http.StatusOK {
+		t.Errorf("expected request to be admitted from the untouched burst, got %d", code)
+	}
+	if code := serve("/"); code != http.StatusTooManyRequests {
+		t.Errorf("expected request exceeding the burst to be throttled, got %d", code)
+	}
+}
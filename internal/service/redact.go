@@ -0,0 +1,104 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// redacted replaces the values of redacted headers.
+const redacted = "[REDACTED]"
+
+// defaultRedactHeaders holds the headers redacted by default.
+var defaultRedactHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// headerFilter decides which request headers are echoed in responses and
+// tagged on spans, and which of them have their values redacted.
+type headerFilter struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+func newHeaderFilter(allow, deny []string) headerFilter {
+	return headerFilter{allow: headerNames(allow), deny: headerNames(deny)}
+}
+
+func headerNames(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			set[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	return set
+}
+
+// allowed returns true if the header may be exposed.
+func (f headerFilter) allowed(name string) bool {
+	return len(f.allow) == 0 || f.allow[http.CanonicalHeaderKey(name)]
+}
+
+// value returns the header value as it may be exposed.
+func (f headerFilter) value(name, value string) string {
+	if f.deny[http.CanonicalHeaderKey(name)] {
+		return redacted
+	}
+	return value
+}
+
+// filter returns a copy of the headers holding only the allowed headers with
+// the denied header values redacted.
+func (f headerFilter) filter(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for name, values := range h {
+		if !f.allowed(name) {
+			continue
+		}
+		for _, value := range values {
+			out[name] = append(out[name], f.value(name, value))
+		}
+	}
+	return out
+}
+
+// headerTagger tags the configured request headers on the server span as
+// http.header.{name}, and the headers mapped by --observability-header-tags
+// under their mapped tag name, redacting their values where needed.
+func (ep *Endpoints) headerTagger(next http.Handler) http.Handler {
+	var headerTags map[string]string
+	if ep.SvcTracer != nil {
+		headerTags = ep.SvcTracer.HeaderTags
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(ep.spanHeaders) > 0 || len(headerTags) > 0 {
+			span := zipkin.SpanOrNoopFromContext(r.Context())
+			tag := func(name, key string) {
+				if value := r.Header.Get(name); value != "" && ep.headerFilter.allowed(name) {
+					span.Tag(key, ep.headerFilter.value(name, value))
+				}
+			}
+			for _, name := range ep.spanHeaders {
+				tag(name, "http.header."+strings.ToLower(name))
+			}
+			for name, key := range headerTags {
+				tag(name, key)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
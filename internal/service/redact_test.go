@@ -0,0 +1,97 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/openzipkin/zipkin-go"
+	zmw "github.com/openzipkin/zipkin-go/middleware/http"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+
+	tzipkin "github.com/basvanbeek/topology-tester/pkg/zipkin"
+)
+
+func TestHeaderFilter(t *testing.T) {
+	h := http.Header{
+		"Authorization": {"Bearer secret"},
+		"Cookie":        {"a=1", "b=2"},
+		"X-Tenant":      {"acme"},
+	}
+
+	f := newHeaderFilter(nil, defaultRedactHeaders)
+	expected := http.Header{
+		"Authorization": {redacted},
+		"Cookie":        {redacted, redacted},
+		"X-Tenant":      {"acme"},
+	}
+	if out := f.filter(h); !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %v, got %v", expected, out)
+	}
+
+	f = newHeaderFilter([]string{"x-tenant", "authorization"}, []string{"authorization"})
+	expected = http.Header{
+		"Authorization": {redacted},
+		"X-Tenant":      {"acme"},
+	}
+	if out := f.filter(h); !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %v, got %v", expected, out)
+	}
+	if h.Get("Authorization") != "Bearer secret" {
+		t.Error("expected request headers to be left untouched")
+	}
+}
+
+func TestHeaderTagger(t *testing.T) {
+	rec := recorder.NewReporter()
+	tracer, err := zipkin.NewTracer(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := &Endpoints{
+		SvcTracer: &tzipkin.Service{HeaderTags: map[string]string{
+			"x-team":        "team",
+			"authorization": "auth",
+		}},
+		spanHeaders:  []string{"X-Tenant", "Cookie"},
+		headerFilter: newHeaderFilter(nil, defaultRedactHeaders),
+	}
+	handler := zmw.NewServerMiddleware(tracer)(ep.headerTagger(http.NotFoundHandler()))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Team", "payments")
+	req.Header.Set("X-Tenant", "acme")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "a=1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := rec.Flush()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	for tag, expected := range map[string]string{
+		"team":                 "payments",
+		"auth":                 redacted,
+		"http.header.x-tenant": "acme",
+		"http.header.cookie":   redacted,
+	} {
+		if value := spans[0].Tags[tag]; value != expected {
+			t.Errorf("tag %s: expected %q, got %q", tag, expected, value)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// redirectCode returns the redirect status code requested through the code
+// query parameter, defaulting to 302.
+func redirectCode(r *http.Request) (int, error) {
+	c := r.URL.Query().Get("code")
+	if c == "" {
+		return http.StatusFound, nil
+	}
+	code, err := strconv.Atoi(c)
+	if err != nil || code < http.StatusMultipleChoices || code > http.StatusPermanentRedirect {
+		return 0, errRedirectCode
+	}
+	return code, nil
+}
+
+// redirect issues a chain of count redirects before echoing the request. By
+// default relative redirects are used, the type query parameter allows for
+// absolute redirects and the code query parameter sets the status code.
+//
+// Example paths:
+//
+//	/redirect/3                     3 relative 302 redirects
+//	/redirect/3?type=absolute       3 absolute redirects
+//	/redirect/2?code=307            2 relative 307 redirects
+func (ep *Endpoints) redirect(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	count, err := strconv.Atoi(mux.Vars(r)["count"])
+	if err != nil || count < 0 {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errCount,
+		})
+		return
+	}
+	code, err := redirectCode(r)
+	if err != nil {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errRedirectCode,
+		})
+		return
+	}
+	if count == 0 {
+		ep.echoHandler(w, r)
+		return
+	}
+
+	location := &url.URL{
+		Path:     fmt.Sprintf("/redirect/%d", count-1),
+		RawQuery: r.URL.RawQuery,
+	}
+	if r.URL.Query().Get("type") == "absolute" {
+		location.Scheme, location.Host = "http", r.Host
+		if r.TLS != nil {
+			location.Scheme = "https"
+		}
+	}
+	span := zipkin.SpanOrNoopFromContext(ctx)
+	span.Tag("redirect.remaining", strconv.Itoa(count-1))
+	span.Tag("redirect.location", location.String())
+	http.Redirect(w, r, location.String(), code)
+}
+
+// redirectTo redirects to the URL provided in the url query parameter, which
+// allows for redirecting to other services.
+//
+// Example path: /redirect-to?url=http://svcb/redirect/2&code=301
+func (ep *Endpoints) redirectTo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	target := r.URL.Query().Get("url")
+	if _, err := url.Parse(target); err != nil || target == "" {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errRedirectURL,
+		})
+		return
+	}
+	code, err := redirectCode(r)
+	if err != nil {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errRedirectCode,
+		})
+		return
+	}
+	zipkin.SpanOrNoopFromContext(ctx).Tag("redirect.location", target)
+	http.Redirect(w, r, target, code)
+}
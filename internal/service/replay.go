@@ -0,0 +1,351 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+
+	tzipkin "github.com/basvanbeek/topology-tester/pkg/zipkin"
+)
+
+// maxReplayLines is the maximum amount of recorded requests accepted for a
+// single replay.
+const maxReplayLines = 100000
+
+// replayLine holds a recorded request. Its time is either an offset from the
+// start of the recording or a timestamp, in which case the offset is relative
+// to the earliest timestamp of the recording.
+type replayLine struct {
+	Offset    string            `json:"offset,omitempty"`
+	Timestamp time.Time         `json:"timestamp,omitempty"`
+	Method    string            `json:"method,omitempty"`
+	Path      string            `json:"path"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Body      string            `json:"body,omitempty"`
+
+	offset time.Duration
+}
+
+// replayStatus holds the progress of a replay as reported by the replay
+// endpoints.
+type replayStatus struct {
+	Running     bool             `json:"running"`
+	Target      string           `json:"target,omitempty"`
+	Speed       float64          `json:"speed,omitempty"`
+	Total       int              `json:"total"`
+	Sent        int64            `json:"sent"`
+	Failed      int64            `json:"failed"`
+	StatusCodes map[string]int64 `json:"statusCodes,omitempty"`
+	Started     *time.Time       `json:"started,omitempty"`
+	Elapsed     string           `json:"elapsed,omitempty"`
+}
+
+// replayer replays recorded requests through the instrumented client,
+// preserving their relative timing. Only one replay runs at a time.
+type replayer struct {
+	tracer  *tzipkin.Tracer
+	mtx     sync.Mutex
+	status  replayStatus
+	cancel  context.CancelFunc
+	sent    int64
+	failed  int64
+	codes   map[string]int64
+	stopped chan struct{}
+}
+
+// parseReplay parses recorded requests from newline delimited JSON, ordered
+// by their offset.
+func parseReplay(r io.Reader) ([]replayLine, error) {
+	var (
+		lines   []replayLine
+		first   time.Time
+		scanner = bufio.NewScanner(r)
+	)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		raw := strings.TrimSpace(scanner.Text())
+		if raw == "" {
+			continue
+		}
+		if len(lines) == maxReplayLines {
+			return nil, fmt.Errorf("%w: more than %d lines", errReplay, maxReplayLines)
+		}
+		var l replayLine
+		if err := json.Unmarshal([]byte(raw), &l); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", errReplay, n, err)
+		}
+		if !strings.HasPrefix(l.Path, "/") {
+			return nil, fmt.Errorf("%w: line %d: expected an absolute path", errReplay, n)
+		}
+		if l.Offset != "" {
+			d, err := parseDuration(l.Offset)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("%w: line %d: %v", errReplay, n, errDuration)
+			}
+			l.offset = d
+		} else if !l.Timestamp.IsZero() && (first.IsZero() || l.Timestamp.Before(first)) {
+			first = l.Timestamp
+		}
+		if l.Method == "" {
+			l.Method = http.MethodGet
+		}
+		lines = append(lines, l)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errReplay, err)
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%w: no requests found", errReplay)
+	}
+	for i := range lines {
+		if lines[i].Offset == "" && !lines[i].Timestamp.IsZero() {
+			lines[i].offset = lines[i].Timestamp.Sub(first)
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].offset < lines[j].offset
+	})
+	return lines, nil
+}
+
+// start replays the provided requests against target in the background,
+// scaling their offsets by 1/speed. The replayed requests are linked to the
+// span found in ctx, which triggered the replay. It returns false if a replay
+// is already running.
+func (p *replayer) start(ctx context.Context, client *http.Client, target string, speed float64, lines []replayLine) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.status.Running {
+		return false
+	}
+	base := context.Background()
+	if span := zipkin.SpanFromContext(ctx); span != nil {
+		base = zipkin.NewContext(base, span)
+	}
+	ctx, cancel := context.WithCancel(base)
+	p.cancel = cancel
+	p.stopped = make(chan struct{})
+	p.codes = make(map[string]int64)
+	atomic.StoreInt64(&p.sent, 0)
+	atomic.StoreInt64(&p.failed, 0)
+	now := time.Now()
+	p.status = replayStatus{
+		Running: true,
+		Target:  target,
+		Speed:   speed,
+		Total:   len(lines),
+		Started: &now,
+	}
+	go p.run(ctx, client, target, speed, lines)
+	return true
+}
+
+func (p *replayer) run(ctx context.Context, client *http.Client, target string, speed float64, lines []replayLine) {
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		p.mtx.Lock()
+		p.status.Running = false
+		p.status.Elapsed = time.Since(*p.status.Started).Round(time.Millisecond).String()
+		p.cancel()
+		close(p.stopped)
+		p.mtx.Unlock()
+	}()
+
+	start := time.Now()
+	for _, l := range lines {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(start.Add(time.Duration(float64(l.offset) / speed)))):
+		}
+		wg.Add(1)
+		go func(l replayLine) {
+			defer wg.Done()
+			p.record(p.send(ctx, client, target, l))
+		}(l)
+	}
+}
+
+// send replays a single request, returning its status code or 0 on failure.
+// Each replayed request starts a new trace linked to the triggering request.
+func (p *replayer) send(ctx context.Context, client *http.Client, target string, l replayLine) int {
+	if p.tracer != nil {
+		var span tzipkin.Span
+		span, ctx = tzipkin.StartLinkedSpan(ctx, p.tracer, "replay")
+		defer span.Finish()
+	}
+	var body io.Reader
+	if l.Body != "" {
+		body = strings.NewReader(l.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, l.Method, "http://"+target+l.Path, body)
+	if err != nil {
+		return 0
+	}
+	for k, v := range l.Headers {
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	_ = res.Body.Close()
+	return res.StatusCode
+}
+
+func (p *replayer) record(code int) {
+	atomic.AddInt64(&p.sent, 1)
+	if code == 0 {
+		atomic.AddInt64(&p.failed, 1)
+		return
+	}
+	p.mtx.Lock()
+	p.codes[strconv.Itoa(code)]++
+	p.mtx.Unlock()
+}
+
+// stop cancels a running replay and waits for its requests to finish.
+func (p *replayer) stop() {
+	p.mtx.Lock()
+	cancel, stopped := p.cancel, p.stopped
+	p.mtx.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-stopped
+	}
+}
+
+func (p *replayer) stats() replayStatus {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	s := p.status
+	s.Sent = atomic.LoadInt64(&p.sent)
+	s.Failed = atomic.LoadInt64(&p.failed)
+	s.StatusCodes = make(map[string]int64, len(p.codes))
+	for k, v := range p.codes {
+		s.StatusCodes[k] = v
+	}
+	if s.Running {
+		s.Elapsed = time.Since(*s.Started).Round(time.Millisecond).String()
+	}
+	return s
+}
+
+// replay allows one to replay recorded traffic through the instrumented
+// client, preserving the relative timing of the requests, so the traffic
+// pattern of a past incident can be reproduced in the test topology. The
+// recording is posted as newline delimited JSON, each line holding the
+// offset (or timestamp), method, path, headers and body of a request.
+// Requests are sent to the target query parameter (host:port), which defaults
+// to this service, and speed scales the timing, e.g. 2 replays twice as fast.
+//
+// Example paths:
+//
+//	POST /admin/replay?target=svcb:8000&speed=2   start a replay
+//	GET  /admin/replay                            show the replay progress
+//	GET  /admin/replay/stop                       stop the running replay
+func (ep *Endpoints) replay(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch {
+	case mux.Vars(r)["action"] == "stop":
+		ep.replays.stop()
+		ep.writeResponse(ctx, w, response{
+			Code:    http.StatusOK,
+			Message: "replay stopped",
+			Data:    ep.replays.stats(),
+		})
+		return
+	case r.Method == http.MethodGet:
+		ep.writeResponse(ctx, w, response{
+			Code: http.StatusOK,
+			Data: ep.replays.stats(),
+		})
+		return
+	}
+
+	if !ep.Leader.IsLeader() {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusConflict,
+			Error: errNotLeader,
+			Data:  map[string]string{"leader": ep.Leader.Leader()},
+		})
+		return
+	}
+
+	q := r.URL.Query()
+	target := q.Get("target")
+	if target == "" {
+		target = r.Host
+	}
+	speed := 1.0
+	if s := q.Get("speed"); s != "" {
+		var err error
+		if speed, err = strconv.ParseFloat(s, 64); err != nil || speed <= 0 {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errSpeed,
+			})
+			return
+		}
+	}
+	lines, err := parseReplay(r.Body)
+	if err != nil {
+		ep.writeResponse(ctx, w, response{
+			Code:    http.StatusBadRequest,
+			Error:   errReplay,
+			Message: err.Error(),
+		})
+		return
+	}
+	if !ep.replays.start(ctx, ep.client, target, speed, lines) {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusConflict,
+			Error: errReplayRunning,
+			Data:  ep.replays.stats(),
+		})
+		return
+	}
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusAccepted,
+		Message: fmt.Sprintf("replaying %d requests to %s", len(lines), target),
+		Data:    ep.replays.stats(),
+	})
+}
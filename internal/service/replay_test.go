@@ -0,0 +1,104 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+)
+
+func TestParseReplay(t *testing.T) {
+	lines, err := parseReplay(strings.NewReader(`
+{"timestamp": "2022-03-01T10:00:01.5Z", "method": "POST", "path": "/proxy/svcc", "body": "{}"}
+{"timestamp": "2022-03-01T10:00:00Z", "path": "/proxy/svcb", "headers": {"x-user": "a"}}
+
+{"offset": "250ms", "path": "/status/503"}
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []struct {
+		path   string
+		method string
+		offset time.Duration
+	}{
+		{"/proxy/svcb", "GET", 0},
+		{"/status/503", "GET", 250 * time.Millisecond},
+		{"/proxy/svcc", "POST", 1500 * time.Millisecond},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %d", len(expected), len(lines))
+	}
+	for i, e := range expected {
+		l := lines[i]
+		if l.Path != e.path || l.Method != e.method || l.offset != e.offset {
+			t.Errorf("line %d: expected %s %s at %s, got %s %s at %s", i,
+				e.method, e.path, e.offset, l.Method, l.Path, l.offset)
+		}
+	}
+
+	for _, s := range []string{"", "{", `{"path": "proxy/svcb"}`, `{"path": "/", "offset": "-1s"}`} {
+		if _, err := parseReplay(strings.NewReader(s)); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestReplayLinks(t *testing.T) {
+	rec := recorder.NewReporter()
+	tracer, err := zipkin.NewTracer(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	trigger := tracer.StartSpan("trigger")
+	p := &replayer{tracer: tracer}
+	lines := []replayLine{{Method: "GET", Path: "/a"}, {Method: "GET", Path: "/b"}}
+	if !p.start(zipkin.NewContext(context.Background(), trigger), srv.Client(), srv.Listener.Addr().String(), 1, lines) {
+		t.Fatal("expected replay to start")
+	}
+	trigger.Finish()
+	for p.stats().Running {
+		time.Sleep(time.Millisecond)
+	}
+	if s := p.stats(); s.Sent != 2 || s.Failed != 0 {
+		t.Fatalf("expected 2 replayed requests, got %+v", s)
+	}
+
+	sc := trigger.Context()
+	traces := make(map[model.TraceID]bool)
+	for _, s := range rec.Flush() {
+		if s.Name != "replay" {
+			continue
+		}
+		traces[s.TraceID] = true
+		if s.TraceID == sc.TraceID || s.Tags["link.0.spanid"] != sc.ID.String() {
+			t.Errorf("expected replayed request in a new trace linked to the trigger, got %+v", s)
+		}
+	}
+	if len(traces) != 2 {
+		t.Errorf("expected a trace per replayed request, got %d", len(traces))
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// supported header rule actions
+const (
+	headerAdd    = "add"
+	headerSet    = "set"
+	headerRemove = "remove"
+)
+
+// headerRule describes a manipulation of request headers before proxying a
+// request to the next hop.
+type headerRule struct {
+	Action string
+	Name   string
+	Value  string
+}
+
+// parseHeaderRule parses rules in the form of "set:name=value",
+// "add:name=value" or "remove:name". Remove rules support a trailing wildcard
+// to remove all headers with a given prefix, e.g. "remove:x-envoy-*".
+func parseHeaderRule(s string) (headerRule, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return headerRule{}, errHeaderRule
+	}
+	h := headerRule{Action: strings.ToLower(strings.TrimSpace(parts[0]))}
+	switch h.Action {
+	case headerAdd, headerSet:
+		kv := strings.SplitN(parts[1], "=", 2)
+		if len(kv) != 2 {
+			return headerRule{}, errHeaderRule
+		}
+		h.Name, h.Value = strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+	case headerRemove:
+		h.Name = strings.TrimSpace(parts[1])
+	default:
+		return headerRule{}, errHeaderRule
+	}
+	if h.Name == "" || h.Name == "*" {
+		return headerRule{}, errHeaderRule
+	}
+	return h, nil
+}
+
+// String implements fmt.Stringer and returns the rule in its parsable form.
+func (h headerRule) String() string {
+	if h.Action == headerRemove {
+		return h.Action + ":" + h.Name
+	}
+	return h.Action + ":" + h.Name + "=" + h.Value
+}
+
+// apply executes the header rule on the provided headers.
+func (h headerRule) apply(hdr http.Header) {
+	switch h.Action {
+	case headerAdd:
+		hdr.Add(h.Name, h.Value)
+	case headerSet:
+		hdr.Set(h.Name, h.Value)
+	case headerRemove:
+		if !strings.HasSuffix(h.Name, "*") {
+			hdr.Del(h.Name)
+			return
+		}
+		prefix := strings.ToLower(strings.TrimSuffix(h.Name, "*"))
+		for key := range hdr {
+			if strings.HasPrefix(strings.ToLower(key), prefix) {
+				delete(hdr, key)
+			}
+		}
+	}
+}
+
+// reqHeaders allows one to list and change the request header rules applied by
+// the proxy handler before forwarding a request to the next hop.
+//
+// Example paths:
+//
+//	/admin/reqheaders                       list the current rules
+//	/admin/reqheaders/set/x-tenant/acme     overwrite the x-tenant header
+//	/admin/reqheaders/add/x-tenant/acme     add a x-tenant header value
+//	/admin/reqheaders/remove/x-envoy-*      remove all x-envoy-* headers
+//	/admin/reqheaders/reset                 remove all rules
+func (ep *Endpoints) reqHeaders(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	var msg string
+	if action, ok := vars["action"]; ok {
+		switch action {
+		case "reset":
+			ep.mtx.Lock()
+			ep.reqHeaderRules = nil
+			ep.mtx.Unlock()
+			msg = "request header rules reset"
+		default:
+			s := action + ":" + vars["name"]
+			if action != headerRemove {
+				s += "=" + vars["value"]
+			}
+			rule, err := parseHeaderRule(s)
+			if err != nil {
+				ep.writeResponse(ctx, w, response{
+					Code:  http.StatusBadRequest,
+					Error: errHeaderRule,
+				})
+				return
+			}
+			ep.mtx.Lock()
+			ep.reqHeaderRules = append(ep.reqHeaderRules, rule)
+			ep.mtx.Unlock()
+			msg = fmt.Sprintf("request header rule added: %s", rule)
+		}
+	}
+
+	ep.mtx.RLock()
+	rules := make([]string, 0, len(ep.reqHeaderRules))
+	for _, rule := range ep.reqHeaderRules {
+		rules = append(rules, rule.String())
+	}
+	ep.mtx.RUnlock()
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+		Data:    rules,
+	})
+}
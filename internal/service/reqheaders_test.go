@@ -0,0 +1,85 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []string
+		in       http.Header
+		expected http.Header
+	}{
+		{
+			"set",
+			[]string{"set:x-tenant=acme"},
+			http.Header{"X-Tenant": {"other"}},
+			http.Header{"X-Tenant": {"acme"}},
+		},
+		{
+			"add",
+			[]string{"add:x-tenant=acme"},
+			http.Header{"X-Tenant": {"other"}},
+			http.Header{"X-Tenant": {"other", "acme"}},
+		},
+		{
+			"remove",
+			[]string{"remove:x-tenant"},
+			http.Header{"X-Tenant": {"other"}, "Accept": {"*/*"}},
+			http.Header{"Accept": {"*/*"}},
+		},
+		{
+			"remove-wildcard",
+			[]string{"remove:x-envoy-*"},
+			http.Header{
+				"X-Envoy-Attempt-Count": {"1"},
+				"X-Envoy-Peer-Metadata": {"abc"},
+				"X-Request-Id":          {"123"},
+			},
+			http.Header{"X-Request-Id": {"123"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, s := range tt.rules {
+				rule, err := parseHeaderRule(s)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if rule.String() != s {
+					t.Errorf("expected %q, got %q", s, rule.String())
+				}
+				rule.apply(tt.in)
+			}
+			if !reflect.DeepEqual(tt.in, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, tt.in)
+			}
+		})
+	}
+}
+
+func TestParseHeaderRuleErrors(t *testing.T) {
+	for _, s := range []string{"", "set", "set:x-tenant", "remove:", "remove:*", "drop:x-tenant"} {
+		if _, err := parseHeaderRule(s); err != errHeaderRule {
+			t.Errorf("%q: expected %v, got %v", s, errHeaderRule, err)
+		}
+	}
+}
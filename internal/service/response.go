@@ -16,39 +16,134 @@
 package service
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"log"
 	"net/http"
+	"sync"
 
 	"github.com/openzipkin/zipkin-go"
 
 	"github.com/basvanbeek/topology-tester/pkg"
 )
 
+// maxPooledBuffer is the capacity above which response buffers are not
+// returned to the pool, so a few large responses don't pin memory.
+const maxPooledBuffer = 64 << 10
+
+// jsonBuffer holds a response buffer with its JSON encoder, pooled so the JSON
+// response path doesn't allocate an encoder and buffer per request.
+type jsonBuffer struct {
+	bytes.Buffer
+	enc *json.Encoder
+}
+
+var jsonBuffers = sync.Pool{
+	New: func() interface{} {
+		b := &jsonBuffer{}
+		b.enc = json.NewEncoder(&b.Buffer)
+		b.enc.SetIndent("", "  ")
+		return b
+	},
+}
+
 type response struct {
-	Service string      `json:"service"`
-	Code    int         `json:"statusCode"`
-	TraceID string      `json:"traceID"`
-	Message string      `json:"message,omitempty"`
-	Error   pkg.Error   `json:"error,omitempty"`
-	Headers http.Header `json:"headers,omitempty"`
+	Service   string      `json:"service,omitempty"`
+	Version   string      `json:"version,omitempty"`
+	Code      int         `json:"statusCode"`
+	TraceID   string      `json:"traceID"`
+	Method    string      `json:"method,omitempty"`
+	ClientIP  string      `json:"clientIP,omitempty"`
+	Message   string      `json:"message,omitempty"`
+	Error     pkg.Error   `json:"error,omitempty"`
+	ErrorInfo *errorInfo  `json:"errorInfo,omitempty"`
+	Headers   http.Header `json:"headers,omitempty"`
+	Identity  *identity   `json:"identity,omitempty"`
+	Body      string      `json:"body,omitempty"`
+	QueueWait string      `json:"queueWait,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
 }
 
 func (ep *Endpoints) writeResponse(ctx context.Context, w http.ResponseWriter, res response) {
 	res.Service = ep.ServiceName
+	res.Version = ep.version
 	res.TraceID = traceID(ctx)
-	w.Header().Add("Content-Type", "application/json")
+	if wait, ok := queueWait(ctx); ok {
+		res.QueueWait = wait.String()
+	}
+	if res.ErrorInfo == nil && res.Error != "" {
+		res.ErrorInfo = classifyError(res.Error, res.Code)
+	}
+	if res.ErrorInfo != nil {
+		tagError(ctx, res.ErrorInfo)
+	}
+	ct := contentType(ctx)
+	w.Header().Add("Content-Type", ct)
 	if res.Code > 0 {
 		w.WriteHeader(res.Code)
 	}
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(res); err != nil {
+	if ct != contentTypeJSON {
+		raw, err := marshalAs(ct, res)
+		if err == nil {
+			_, err = w.Write(raw)
+		}
+		if err != nil {
+			log.Printf("error while writing http response: %v", err)
+		}
+		return
+	}
+	if err := ep.writeJSON(w, res); err != nil {
 		log.Printf("error while writing http response: %v", err)
 	}
 }
 
+// responsePrefix returns the opening of indented JSON responses holding the
+// static service and version fields, so they are marshaled once.
+func responsePrefix(service, version string) []byte {
+	var b bytes.Buffer
+	b.WriteString("{\n  \"service\": ")
+	raw, _ := json.Marshal(service)
+	b.Write(raw)
+	if version != "" {
+		b.WriteString(",\n  \"version\": ")
+		raw, _ = json.Marshal(version)
+		b.Write(raw)
+	}
+	b.WriteString(",\n")
+	return b.Bytes()
+}
+
+// writeJSON writes the response as indented JSON using a pooled buffer and
+// encoder, prepending the pre-marshaled static fields to the encoded dynamic
+// fields.
+func (ep *Endpoints) writeJSON(w http.ResponseWriter, res response) error {
+	prefix := ep.respPrefix
+	if prefix == nil {
+		prefix = responsePrefix(ep.ServiceName, ep.version)
+	}
+	res.Service, res.Version = "", ""
+
+	b := jsonBuffers.Get().(*jsonBuffer)
+	defer func() {
+		if b.Cap() <= maxPooledBuffer {
+			jsonBuffers.Put(b)
+		}
+	}()
+	b.Reset()
+	b.Write(prefix)
+	n := b.Len()
+	if err := b.enc.Encode(res); err != nil {
+		return err
+	}
+	// drop the opening brace of the dynamic fields, the statusCode field is
+	// always present
+	raw := b.Bytes()
+	raw = append(raw[:n], raw[n+len("{\n"):]...)
+	_, err := w.Write(raw)
+	return err
+}
+
 func traceID(ctx context.Context) string {
 	return zipkin.SpanFromContext(ctx).Context().TraceID.String()
 }
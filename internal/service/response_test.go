@@ -0,0 +1,57 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteJSON(t *testing.T) {
+	for _, ep := range []*Endpoints{
+		{ServiceName: "svca"},
+		{ServiceName: "svc<b>", version: "v2"},
+	} {
+		ep.respPrefix = responsePrefix(ep.ServiceName, ep.version)
+		for _, res := range []response{
+			{Code: http.StatusOK, TraceID: "4902008fd0dd9add"},
+			{Code: http.StatusInternalServerError, Error: errInternal, ErrorInfo: injected("INJECTED_ERROR", 500)},
+			{Code: http.StatusOK, Message: "a & b", Data: map[string]interface{}{"spans": []int{1, 2}}},
+		} {
+			var want bytes.Buffer
+			expected := res
+			expected.Service, expected.Version = ep.ServiceName, ep.version
+			enc := json.NewEncoder(&want)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(expected); err != nil {
+				t.Fatal(err)
+			}
+
+			// repeated writes reuse pooled buffers
+			for i := 0; i < 2; i++ {
+				rec := httptest.NewRecorder()
+				if err := ep.writeJSON(rec, res); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(rec.Body.Bytes(), want.Bytes()) {
+					t.Errorf("expected:\n%s\ngot:\n%s", want.String(), rec.Body.String())
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// sampling allows one to inspect and adjust the trace sampling of this
+// service at runtime. Setting a target switches to adaptive sampling, where
+// the sample rate is adjusted to reach the target amount of sampled traces
+// per second. A target of 0 returns to the fixed sample rate.
+//
+// Example paths:
+//
+//	/admin/sampling                   show the current settings
+//	/admin/sampling/rate/0.25         sample 25% of new traces
+//	/admin/sampling/target/100        sample about 100 traces per second
+//	/admin/sampling/target/0          return to the fixed sample rate
+func (ep *Endpoints) sampling(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	sampler := ep.SvcTracer.GetSampler()
+
+	var msg string
+	if setting, ok := vars["setting"]; ok {
+		value, err := strconv.ParseFloat(vars["value"], 64)
+		if err == nil {
+			switch setting {
+			case "rate":
+				err = sampler.SetRate(value)
+			case "target":
+				err = sampler.SetTarget(value)
+			}
+		}
+		if err != nil {
+			ep.writeResponse(ctx, w, response{
+				Code:    http.StatusBadRequest,
+				Error:   errSampling,
+				Message: err.Error(),
+			})
+			return
+		}
+		msg = fmt.Sprintf("sample %s set to: %s", setting, vars["value"])
+	}
+
+	rate, target, effective := sampler.Settings()
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+		Data: map[string]interface{}{
+			"rate":          rate,
+			"target":        target,
+			"effectiveRate": effective,
+		},
+	})
+}
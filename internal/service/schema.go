@@ -0,0 +1,249 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// schema holds the subset of JSON Schema supported when validating inbound
+// requests: type, enum, properties, required, additionalProperties (boolean
+// only), items, minimum, maximum, minLength, maxLength and pattern.
+type schema struct {
+	Type                 string             `json:"type"`
+	Enum                 []interface{}      `json:"enum"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+
+	re *regexp.Regexp
+}
+
+// loadSchema reads and compiles the JSON schema found at path.
+func loadSchema(path string) (*schema, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s schema
+	if err = json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	if err = s.compile(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// compile validates the schema and compiles its patterns.
+func (s *schema) compile() error {
+	switch s.Type {
+	case "", "object", "array", "string", "number", "integer", "boolean", "null":
+	default:
+		return fmt.Errorf("unsupported type %q", s.Type)
+	}
+	if s.Pattern != "" {
+		var err error
+		if s.re, err = regexp.Compile(s.Pattern); err != nil {
+			return err
+		}
+	}
+	for _, p := range s.Properties {
+		if err := p.compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+	return nil
+}
+
+// validate returns the list of violations found when validating v, as decoded
+// by encoding/json, against the schema.
+func (s *schema) validate(path string, v interface{}) []string {
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if s.Type != "" && !hasType(v, s.Type) {
+		fail("expected %s", s.Type)
+		return errs
+	}
+	if len(s.Enum) > 0 {
+		var found bool
+		for _, e := range s.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("value not in enum %v", s.Enum)
+		}
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := t[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(t))
+		for name := range t {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					fail("unexpected property %q", name)
+				}
+				continue
+			}
+			errs = append(errs, p.validate(path+"."+name, t[name])...)
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range t {
+				errs = append(errs, s.Items.validate(path+"["+strconv.Itoa(i)+"]", item)...)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && t < *s.Minimum {
+			fail("%v is less than minimum %v", t, *s.Minimum)
+		}
+		if s.Maximum != nil && t > *s.Maximum {
+			fail("%v is greater than maximum %v", t, *s.Maximum)
+		}
+	case string:
+		if s.MinLength != nil && len([]rune(t)) < *s.MinLength {
+			fail("length is less than minLength %d", *s.MinLength)
+		}
+		if s.MaxLength != nil && len([]rune(t)) > *s.MaxLength {
+			fail("length is greater than maxLength %d", *s.MaxLength)
+		}
+		if s.re != nil && !s.re.MatchString(t) {
+			fail("does not match pattern %q", s.Pattern)
+		}
+	}
+	return errs
+}
+
+// hasType returns true if v, as decoded by encoding/json, is of the provided
+// JSON Schema type.
+func hasType(v interface{}, typ string) bool {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return typ == "object"
+	case []interface{}:
+		return typ == "array"
+	case string:
+		return typ == "string"
+	case float64:
+		return typ == "number" || (typ == "integer" && t == math.Trunc(t))
+	case bool:
+		return typ == "boolean"
+	case nil:
+		return typ == "null"
+	}
+	return false
+}
+
+// queryObject converts query parameters into an object to validate, coercing
+// the values into the types expected by the schema properties. Values which
+// can't be coerced are kept as string so they fail validation.
+func (s *schema) queryObject(q url.Values) map[string]interface{} {
+	obj := make(map[string]interface{}, len(q))
+	for name, values := range q {
+		p := s.Properties[name]
+		if p != nil && p.Type == "array" {
+			items := make([]interface{}, 0, len(values))
+			for _, value := range values {
+				items = append(items, coerce(p.Items, value))
+			}
+			obj[name] = items
+			continue
+		}
+		obj[name] = coerce(p, values[0])
+	}
+	return obj
+}
+
+func coerce(s *schema, value string) interface{} {
+	if s == nil {
+		return value
+	}
+	switch s.Type {
+	case "number", "integer":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// validateRequest validates the JSON request body, or the query parameters if
+// the request has no body, against the configured schema. The outcome is
+// tagged on the server span, so client errors stand out in the topology.
+func (ep *Endpoints) validateRequest(r *http.Request, body []byte) []string {
+	var (
+		v    interface{}
+		errs []string
+	)
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &v); err != nil {
+			errs = []string{"$: invalid JSON: " + err.Error()}
+		}
+	} else {
+		v = ep.schema.queryObject(r.URL.Query())
+	}
+	if errs == nil {
+		errs = ep.schema.validate("$", v)
+	}
+
+	span := zipkin.SpanOrNoopFromContext(r.Context())
+	if len(errs) == 0 {
+		span.Tag("validation", "passed")
+		return nil
+	}
+	span.Tag("validation", "failed")
+	span.Tag("validation.errors", strconv.Itoa(len(errs)))
+	span.Tag("validation.error", errs[0])
+	return errs
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+)
+
+const testSchema = `{
+  "type": "object",
+  "required": ["name"],
+  "additionalProperties": false,
+  "properties": {
+    "name":  {"type": "string", "minLength": 2, "pattern": "^[a-z]+$"},
+    "age":   {"type": "integer", "minimum": 0, "maximum": 150},
+    "role":  {"enum": ["admin", "user"]},
+    "tags":  {"type": "array", "items": {"type": "string", "maxLength": 3}}
+  }
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	var s schema
+	if err := json.Unmarshal([]byte(testSchema), &s); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in   string
+		errs int
+	}{
+		{`{"name": "bob"}`, 0},
+		{`{"name": "bob", "age": 42, "role": "user", "tags": ["a", "b"]}`, 0},
+		{`{}`, 1},
+		{`[]`, 1},
+		{`{"name": "b"}`, 1},
+		{`{"name": "Bob"}`, 1},
+		{`{"name": "bob", "age": 4.2}`, 1},
+		{`{"name": "bob", "age": 200}`, 1},
+		{`{"name": "bob", "role": "root"}`, 1},
+		{`{"name": "bob", "tags": ["long", 1]}`, 2},
+		{`{"name": "bob", "extra": true}`, 1},
+	}
+	for _, tt := range tests {
+		var v interface{}
+		if err := json.Unmarshal([]byte(tt.in), &v); err != nil {
+			t.Fatal(err)
+		}
+		if errs := s.validate("$", v); len(errs) != tt.errs {
+			t.Errorf("%s: expected %d errors, got %v", tt.in, tt.errs, errs)
+		}
+	}
+
+	q, _ := url.ParseQuery("name=bob&age=42&tags=a&tags=b")
+	if errs := s.validate("$", s.queryObject(q)); len(errs) != 0 {
+		t.Errorf("expected query to be valid, got %v", errs)
+	}
+	q, _ = url.ParseQuery("name=bob&age=old")
+	if errs := s.validate("$", s.queryObject(q)); len(errs) != 1 {
+		t.Errorf("expected 1 error, got %v", errs)
+	}
+}
+
+func TestSchemaCompile(t *testing.T) {
+	for _, in := range []string{
+		`{"type": "float"}`,
+		`{"properties": {"a": {"pattern": "("}}}`,
+	} {
+		var s schema
+		if err := json.Unmarshal([]byte(in), &s); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.compile(); err == nil {
+			t.Errorf("%s: expected error", in)
+		}
+	}
+}
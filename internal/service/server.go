@@ -0,0 +1,92 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// serverTimeouts allows one to inspect and change the timeouts and header size
+// limit of the HTTP server. Changing a setting replaces the server without
+// closing the listeners, while the previous server drains its connections.
+//
+// Example paths:
+//
+//	/admin/server                            show the current settings
+//	/admin/server/readtimeout/10s            set ReadTimeout
+//	/admin/server/readheadertimeout/2s       set ReadHeaderTimeout
+//	/admin/server/writetimeout/30s           set WriteTimeout
+//	/admin/server/idletimeout/60s            set IdleTimeout
+//	/admin/server/maxheaderbytes/8192        set MaxHeaderBytes
+func (ep *Endpoints) serverTimeouts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	if ep.SvcHTTP == nil {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusNotImplemented,
+			Error: errInternal,
+		})
+		return
+	}
+	t := ep.SvcHTTP.Timeouts()
+
+	var msg string
+	if setting, ok := vars["setting"]; ok {
+		var err error
+		value := vars["value"]
+		switch setting {
+		case "readtimeout":
+			t.Read, err = parseDuration(value)
+		case "readheadertimeout":
+			t.ReadHeader, err = parseDuration(value)
+		case "writetimeout":
+			t.Write, err = parseDuration(value)
+		case "idletimeout":
+			t.Idle, err = parseDuration(value)
+		case "maxheaderbytes":
+			t.MaxHeaderBytes, err = strconv.Atoi(value)
+		}
+		if err == nil && (t.Read < 0 || t.ReadHeader < 0 || t.Write < 0 || t.Idle < 0 || t.MaxHeaderBytes < 0) {
+			err = errServerSetting
+		}
+		if err != nil {
+			ep.writeResponse(ctx, w, response{
+				Code:    http.StatusBadRequest,
+				Error:   errServerSetting,
+				Message: err.Error(),
+			})
+			return
+		}
+		ep.SvcHTTP.SetTimeouts(t)
+		ep.writeTimeout.Store(t.Write)
+		msg = fmt.Sprintf("server %s set to: %s", setting, value)
+	}
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+		Data: map[string]interface{}{
+			"readTimeout":       t.Read.String(),
+			"readHeaderTimeout": t.ReadHeader.String(),
+			"writeTimeout":      t.Write.String(),
+			"idleTimeout":       t.Idle.String(),
+			"maxHeaderBytes":    t.MaxHeaderBytes,
+		},
+	})
+}
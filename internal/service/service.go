@@ -17,52 +17,353 @@ package service
 
 import (
 	"errors"
+	"expvar"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	zmw "github.com/openzipkin/zipkin-go/middleware/http"
+	"github.com/openzipkin/zipkin-go/propagation/baggage"
 	"github.com/tetratelabs/multierror"
 	"github.com/tetratelabs/run"
 
 	"github.com/basvanbeek/topology-tester/pkg"
+	pkghttp "github.com/basvanbeek/topology-tester/pkg/http"
+	"github.com/basvanbeek/topology-tester/pkg/kubernetes"
+	"github.com/basvanbeek/topology-tester/pkg/leader"
+	"github.com/basvanbeek/topology-tester/pkg/middleware"
+	"github.com/basvanbeek/topology-tester/pkg/oauth"
+	"github.com/basvanbeek/topology-tester/pkg/sockopt"
 	"github.com/basvanbeek/topology-tester/pkg/zipkin"
 )
 
 const (
 	flagDuration       = "ep-duration"
+	flagOverrun        = "ep-latency-overrun"
 	flagErrors         = "ep-errors"
 	flagHeaders        = "ep-headers"
 	flagHandleFailures = "ep-handle-failures"
+	flagGracefulMax    = "ep-graceful-body-max"
+	flagGracefulDrain  = "ep-graceful-body-drain"
+	flagSpanName       = "ep-span-name"
+	flagReqHeaders     = "ep-reqheaders"
+	flagIdentity       = "ep-require-identity"
+	flagResolvers      = "ep-graphql-resolvers"
+	flagCacheTTL       = "ep-cache-ttl"
+	flagCacheSize      = "ep-cache-size"
+	flagCompression    = "ep-compression"
+	flagBadEncoding    = "ep-bad-encoding"
+	flagSlowBodyRate   = "ep-slow-body-rate"
+	flagSlowBodySize   = "ep-slow-body-size"
+	flagSlowReadRate   = "ep-slow-read-rate"
+	flagMaxIdleConns   = "ep-max-idle-conns"
+	flagMaxConnsHost   = "ep-max-conns-per-host"
+	flagIdleTimeout    = "ep-idle-conn-timeout"
+	flagNoKeepAlive    = "ep-disable-keep-alives"
+	flagDialReusePort  = "ep-dial-reuseport"
+	flagDialNoDelay    = "ep-dial-nodelay"
+	flagDialKeepAlive  = "ep-dial-keepalive"
+	flagEgressProxy    = "ep-egress-proxy"
+	flagCrashDelay     = "ep-crash-delay"
+	flagStartupDelay   = "ep-startup-delay"
+	flagStartupMode    = "ep-startup-mode"
+	flagVersion        = "ep-service-version"
+	flagVersionFaults  = "ep-version-faults"
+	flagInstanceFaults = "ep-instance-faults"
+	flagTenantHeader   = "ep-tenant-header"
+	flagTenantFaults   = "ep-tenant-faults"
+	flagTransactions   = "ep-transactions"
+	flagHashHeader     = "ep-hash-header"
+	flagTrustedHops    = "ep-trusted-hops"
+	flagTrustedProxies = "ep-trusted-proxies"
+	flagDiscovery      = "ep-discovery"
+	flagMaxBodySize    = "ep-max-body-size"
+	flagCORSOrigins    = "ep-cors-origins"
+	flagCORSMethods    = "ep-cors-methods"
+	flagCORSHeaders    = "ep-cors-headers"
+	flagCORSMaxAge     = "ep-cors-max-age"
+	flagCORSFault      = "ep-cors-fault"
+	flagEchoBody       = "ep-echo-body"
+	flagEchoSchema     = "ep-echo-schema"
+	flagEchoHeaders    = "ep-echo-headers"
+	flagEchoTemplate   = "ep-echo-template"
+	flagTemplateType   = "ep-echo-template-content-type"
+	flagRedactHeaders  = "ep-redact-headers"
+	flagSpanHeaders    = "ep-span-headers"
+	flagStaticEndpoint = "ep-discovery-static"
+	flagConsulAddress  = "ep-discovery-consul"
+	flagAccessLog      = "ep-access-log"
+	flagReqMetrics     = "ep-request-metrics"
+	flagRateLimit      = "ep-rate-limit"
+	flagRateLimitBurst = "ep-rate-limit-burst"
+	flagTopoConfig     = "ep-topo-config-header"
+	flagLongTraceMax   = "ep-longtrace-max-spans"
+	flagCoalesce       = "ep-coalesce"
+	flagBulkhead       = "ep-bulkhead"
+	flagQueueWorkers   = "ep-queue-workers"
+	flagQueueDepth     = "ep-queue-depth"
+	flagPriorityHeader = "ep-priority-header"
+	flagPriorityShare  = "ep-priority-low-share"
+	flagWarmupDuration = "ep-warmup-duration"
+	flagWarmupRequests = "ep-warmup-requests"
+	flagWarmupLatency  = "ep-warmup-latency"
+	flagWarmupErrors   = "ep-warmup-errors"
+	flagBurnSLO        = "ep-burn-slo"
+	flagBurnRate       = "ep-burn-rate"
+	flagDependencies   = "ep-required-dependencies"
+	flagTopoPath       = "ep-topo-path"
+	flagSeed           = "ep-seed"
 
-	errProxyService   pkg.Error = "invalid or no proxy service set"
-	errPercentage     pkg.Error = "expected percentage value between 0 and 100"
-	errDuration       pkg.Error = "expected a zero or positive duration"
-	errConcurrency    pkg.Error = "invalid or no concurrency type set"
-	errInternal       pkg.Error = "internal service failure occurred"
-	errHandleFailures pkg.Error = "expected boolean value for handling failures"
+	errEgressProxy      pkg.Error = "expected proxy URL with scheme http, https, socks5 or connect"
+	errTrustedHops      pkg.Error = "expected a zero or positive number of trusted hops"
+	errTemplate         pkg.Error = "invalid response template"
+	errValidation       pkg.Error = "request does not match schema"
+	errMethod           pkg.Error = "method not allowed"
+	errProxyService     pkg.Error = "invalid or no proxy service set"
+	errPercentage       pkg.Error = "expected percentage value between 0 and 100"
+	errDuration         pkg.Error = "expected a zero or positive duration"
+	errOverrun          pkg.Error = "expected latency overrun policy fail or sleep"
+	errLatencyTimeout   pkg.Error = "injected latency exceeds the server write timeout"
+	errConcurrency      pkg.Error = "invalid or no concurrency type set"
+	errProcs            pkg.Error = "expected a positive amount of procs and depth"
+	errProcLatency      pkg.Error = "expected a fixed, uniform, normal or exponential dist and zero or positive latencies"
+	errTree             pkg.Error = "expected a positive depth and breadth"
+	errProcsSize        pkg.Error = "local spans exceed the maximum amount of spans, see --ep-longtrace-max-spans"
+	errInternal         pkg.Error = "internal service failure occurred"
+	errHandleFailures   pkg.Error = "expected boolean value for handling failures"
+	errSpanName         pkg.Error = "expected one of: route, path, method"
+	errHeaderRule       pkg.Error = "expected header rule as set:name=value, add:name=value or remove:name"
+	errToken            pkg.Error = "unable to obtain access token for downstream call"
+	errIdentity         pkg.Error = "client identity not allowed"
+	errResolver         pkg.Error = "expected resolver as field=host[:port][/path]"
+	errQuery            pkg.Error = "invalid or unsupported GraphQL query"
+	errCacheSize        pkg.Error = "expected a zero or positive number of entries"
+	errCompression      pkg.Error = "expected one of: gzip, br"
+	errRate             pkg.Error = "expected a zero or positive rate in bytes per second"
+	errReadBody         pkg.Error = "unable to read request body"
+	errBool             pkg.Error = "expected a boolean value"
+	errConnections      pkg.Error = "expected a zero or positive number of connections"
+	errTransport        pkg.Error = "invalid transport setting"
+	errSampling         pkg.Error = "invalid sampling setting"
+	errTracingFault     pkg.Error = "invalid tracing fault setting"
+	errReporter         pkg.Error = "span reporter is failing"
+	errSpanRecorder     pkg.Error = "spans are not kept in memory, see --zipkin-memory-spans"
+	errTraceID          pkg.Error = "expected a hex encoded trace id"
+	errCount            pkg.Error = "expected a zero or positive count"
+	errTarget           pkg.Error = "expected target as host:port"
+	errExitCode         pkg.Error = "expected an integer exit code"
+	errUnhealthy        pkg.Error = "service is unhealthy"
+	errNotReady         pkg.Error = "service is not ready"
+	errStartupMode      pkg.Error = "expected one of: listener, readiness"
+	errChunkSize        pkg.Error = "expected a chunk size between 1 and 1048576 bytes"
+	errGRPCStatus       pkg.Error = "expected an integer grpc-status"
+	errStatusCode       pkg.Error = "expected a status code between 200 and 599"
+	errRedirectCode     pkg.Error = "expected a redirect status code between 300 and 308"
+	errRedirectURL      pkg.Error = "expected a redirect url"
+	errCORSFault        pkg.Error = "expected one of: none, omit, corrupt"
+	errMaxAge           pkg.Error = "expected a zero or positive max age"
+	errBodyTooLarge     pkg.Error = "request body too large"
+	errBodySize         pkg.Error = "expected a zero or positive body size"
+	errDiscovery        pkg.Error = "expected one of: dns, static, kubernetes, consul"
+	errStaticEndpoint   pkg.Error = "expected static endpoint as service=host:port"
+	errNoEndpoints      pkg.Error = "no endpoints found for proxy service"
+	errSplit            pkg.Error = "expected proxy service as host[:port] or weighted set host=weight,..."
+	errScopedFault      pkg.Error = "expected fault as scope:knob=value with knob one of: errors, headers, latency, badencoding"
+	errRateLimited      pkg.Error = "rate limit exceeded"
+	errRateLimit        pkg.Error = "expected a zero or positive rate in requests per second"
+	errReplay           pkg.Error = "invalid replay recording"
+	errReplayRunning    pkg.Error = "a replay is already running"
+	errNotLeader        pkg.Error = "not the leader replica"
+	errSpeed            pkg.Error = "expected a positive replay speed"
+	errHopDirectives    pkg.Error = "expected proxy path directives as service[knob=value,...]"
+	errTopoConfig       pkg.Error = "expected topology config as [service.]knob=value;... with knob one of: errors, headers, latency, badencoding"
+	errSpans            pkg.Error = "expected a positive amount of spans"
+	errFanout           pkg.Error = "expected a zero or positive fan-out"
+	errDepth            pkg.Error = "expected a zero or positive depth"
+	errLongTraceSize    pkg.Error = "long trace exceeds the maximum amount of spans, see --ep-longtrace-max-spans"
+	errLongTraceRunning pkg.Error = "a long trace is already being created"
+	errBenchSize        pkg.Error = "benchmark exceeds the maximum amount of spans, see --ep-longtrace-max-spans"
+	errBenchRunning     pkg.Error = "a span benchmark is already running"
+	errAnomalyCount     pkg.Error = "expected a count between 1 and 1000"
+	errLateDelay        pkg.Error = "expected a delay between 0 and 1h"
+	errHeaderSize       pkg.Error = "expected a header size between 1 and 1048576"
+	errHeaderTarget     pkg.Error = "expected one of: response, request, both"
+	errCharset          pkg.Error = "expected a charset name"
+	errExpectation      pkg.Error = "expected request with Expect: 100-continue"
+	errBulkhead         pkg.Error = "expected bulkhead limit as route=limit with a zero or positive limit"
+	errBulkheadFull     pkg.Error = "too many concurrent requests"
+	errQueueFull        pkg.Error = "request queue is full"
+	errDependency       pkg.Error = "expected dependency as host[:port][/path]"
+	errDependencies     pkg.Error = "required dependencies are not ready"
+	errDraining         pkg.Error = "service is draining"
+	errServerSetting    pkg.Error = "invalid server setting"
+	errBurnSLO          pkg.Error = "expected an SLO percentage above 0 and below 100"
+	errTransactions     pkg.Error = "expected weighted transactions as name=weight,..."
+	errBurnRate         pkg.Error = "expected burn rates within the error budget as rate[x][:duration], e.g. 14x:5m,6x:1h"
+
+	defaultCrashDelay = 5 * time.Second
+
+	defaultGracefulBodyMax = 64 << 10
+
+	defaultCacheSize = 1000
+
+	defaultQueueDepth = 100
+
+	defaultPriorityLowShare = 50
 )
 
+// requestMetrics holds the request counters of the metrics middleware, exposed
+// through expvar.
+var requestMetrics = expvar.NewMap("requests")
+
+// requestLatency holds the request latency histogram of the metrics
+// middleware, with trace id exemplars.
+var requestLatency = middleware.NewLatencyHistogram("http_server_request_duration_seconds",
+	"Duration of the HTTP requests served.", middleware.DefaultLatencyBuckets)
+
 // Endpoints implements a run.Config compatible group of Endpoints which will
 // register themselves on the provided http service, using the provided Zipkin
 // tracer to instrument themselves.
 type Endpoints struct {
 	// dependencies
 	SvcTracer *zipkin.Service
+	SvcOAuth  *oauth.Service
+	SvcHTTP   *pkghttp.Service
+	// Leader decides which replica generates background traffic, nil if
+	// every replica does.
+	Leader *leader.Elector
 
 	ServiceName string
+	// Units holds the enabled state of the other run units, reported by the
+	// version endpoint.
+	Units map[string]bool
 
-	handler http.Handler
-	tracer  *zipkin.Tracer
+	handler          http.Handler
+	instance         string
+	router           *mux.Router
+	methodRoutes     []methodRoute
+	version          string
+	hashHeader       string
+	topoConfigHeader string
+	clientIPCfg      clientIPConfig
+	trustedProxies   []string
+	maxBodySize      int64
+	gracefulBodyMax  int64
+	gracefulDrain    bool
+	echoBody         bool
+	schemaFile       string
+	echoHeaders      []string
+	templateFile     string
+	templateType     string
+	redactHeaders    []string
+	spanHeaders      []string
+	headerFilter     headerFilter
+	schema           *schema
+	corsCfg          corsConfig
+	discoveryMode    string
+	staticEndpoints  []string
+	consulAddress    string
+	discovery        discovery
+	versionFlags     []string
+	instanceFlags    []string
+	tenantFlags      []string
+	tenantHeader     string
+	tenantKnobs      tenantKnobs
+	tenantStats      tenantStats
+	txFlag           string
+	txTargets        []splitTarget
+	tracer           *zipkin.Tracer
+	spanName         string
+	reqHeaderFlags   []string
+	resolverFlags    []string
+	resolvers        map[string]string
+	client           *http.Client
+	baseTransport    *http.Transport
+	transport        http.RoundTripper
+	transportCfg     transportConfig
+	egressProxy      string
+	leaks            *leaks
+	calls            *callStats
+	replays          *replayer
+	flights          *flightGroup
+	bulkheads        *bulkhead
+	bulkheadFlags    []string
+	queue            *workQueue
+	queueWorkers     int
+	queueDepth       int
+	priorityHeader   string
+	priorityLowShare int
+	warm             *warmup
+	warmupDuration   time.Duration
+	warmupRequests   int64
+	warmupLatency    time.Duration
+	warmupErrors     int32
+	burn             *burnRate
+	burnSLO          float64
+	burnFlags        []string
+	deps             *dependencyGate
+	draining         bool
+	depFlags         []string
+	crashDelay       time.Duration
+	respCache        *responseCache
+	proxies          *proxyCache
+	cacheTTL         time.Duration
+	cacheSize        int
+	compressions     []string
+	accessLog        bool
+	requestMetrics   bool
+	rateLimitRPS     float64
+	rateLimitBurst   int
+	longTraceMax     int64
+	longTraceRunning int32
+	benchRunning     int32
+	respPrefix       []byte
+	seed             int64
+	rand             *faultRand
+	topoPath         bool
+	latencyOverrun   string
+
+	// service globals read on every request, safe for concurrent use
+	errors           atomicInt32
+	headers          atomicInt32
+	duration         atomicDuration
+	handleFailures   atomicBool
+	badEncoding      atomicInt32
+	badContentLength atomicInt32
+	badStatusLine    atomicInt32
+	prematureEOF     atomicInt32
+	dupHost          atomicInt32
+	expectEmit       atomicBool
+	coalescing       atomicBool
+	blackholes       atomicInt32
+	idleConns        atomicInt32
+	slowBodyRate     atomicInt
+	slowBodySize     atomicInt
+	slowReadRate     atomicInt
+	stuck            atomicBool
+	writeTimeout     atomicDuration
+
+	// deadlockMtx is owned by the deadlock crash mode
+	deadlockMtx sync.RWMutex
 
 	// service globals protected by mutex mtx
-	mtx            sync.RWMutex
-	errors         int32
-	headers        int32
-	duration       time.Duration
-	handleFailures bool
+	mtx              sync.RWMutex
+	reqHeaderRules   []headerRule
+	requiredIdentity string
+	headerFault      headerFault
+	charsetFault     charsetFault
+	expectMode       string
+	expectDelay      time.Duration
+	corsFault        string
+	healthFailUntil  time.Time
+	startupMode      string
+	startupDelay     time.Duration
+	readyAt          time.Time
+	template         *echoTemplate
 }
 
 // Name implements run.Unit.
@@ -72,19 +373,296 @@ func (ep *Endpoints) Name() string {
 
 // FlagSet implements run.Config.
 func (ep *Endpoints) FlagSet() *run.FlagSet {
+	if ep.spanName == "" {
+		ep.spanName = spanNameRoute
+	}
+	if ep.cacheSize == 0 {
+		ep.cacheSize = defaultCacheSize
+	}
+	if ep.crashDelay == 0 {
+		ep.crashDelay = defaultCrashDelay
+	}
+	if ep.latencyOverrun == "" {
+		ep.latencyOverrun = overrunFail
+	}
+	if ep.gracefulBodyMax == 0 {
+		ep.gracefulBodyMax = defaultGracefulBodyMax
+	}
+	if ep.queueDepth == 0 {
+		ep.queueDepth = defaultQueueDepth
+	}
+	if ep.priorityHeader == "" {
+		ep.priorityHeader = defaultPriorityHeader
+	}
+	if ep.priorityLowShare == 0 {
+		ep.priorityLowShare = defaultPriorityLowShare
+	}
+	if ep.topoConfigHeader == "" {
+		ep.topoConfigHeader = defaultTopoConfigHeader
+	}
+	if ep.tenantHeader == "" {
+		ep.tenantHeader = defaultTenantHeader
+	}
+	if ep.burnSLO == 0 {
+		ep.burnSLO = defaultBurnSLO
+	}
+	if ep.longTraceMax == 0 {
+		ep.longTraceMax = defaultLongTraceMaxSpans
+	}
+	if ep.startupMode == "" {
+		ep.startupMode = startupListener
+	}
+	if ep.discoveryMode == "" {
+		ep.discoveryMode = discoveryDNS
+	}
+	if ep.redactHeaders == nil {
+		ep.redactHeaders = defaultRedactHeaders
+	}
+	if ep.corsFault == "" {
+		ep.corsFault = corsFaultNone
+	}
+	if ep.transportCfg == (transportConfig{}) {
+		def := http.DefaultTransport.(*http.Transport)
+		ep.transportCfg = transportConfig{
+			MaxIdleConns:    def.MaxIdleConns,
+			MaxConnsPerHost: def.MaxConnsPerHost,
+			IdleConnTimeout: def.IdleConnTimeout,
+			Socket:          sockopt.Options{NoDelay: true},
+		}
+	}
 	flags := run.NewFlagSet("Endpoint options")
 
-	flags.Int32Var(&ep.errors, flagErrors, ep.errors,
+	flags.Var(&ep.errors, flagErrors,
 		`Percentage of errors on echo handler`)
 
-	flags.Int32Var(&ep.headers, flagHeaders, ep.headers,
+	flags.Var(&ep.headers, flagHeaders,
 		`Percentage of double headers on echo handler`)
 
-	flags.DurationVar(&ep.duration, flagDuration, ep.duration,
+	flags.Var(&ep.duration, flagDuration,
 		`Duration of a request on echo handler`)
 
-	flags.BoolVar(&ep.handleFailures, flagHandleFailures, ep.handleFailures,
-		`Handle failures when proxying and return OK to requestor`)
+	flags.StringVar(&ep.latencyOverrun, flagOverrun, ep.latencyOverrun,
+		`Injected latency running past the server write timeout either responds with a 504 before the deadline ("fail") or sleeps regardless ("sleep")`)
+
+	flags.VarPF(&ep.handleFailures, flagHandleFailures, "",
+		`Handle failures when proxying and return OK to requestor`).NoOptDefVal = "true"
+
+	flags.Int64Var(&ep.gracefulBodyMax, flagGracefulMax, ep.gracefulBodyMax,
+		`Maximum bytes of a failed downstream response included when handling failures, 0 means no limit`)
+
+	flags.BoolVar(&ep.gracefulDrain, flagGracefulDrain, ep.gracefulDrain,
+		`Drain the remainder of truncated failed downstream responses so the connection is reused, instead of closing it`)
+
+	flags.StringVar(&ep.spanName, flagSpanName, ep.spanName,
+		`Server span naming: "route" (template), "path" (raw path) or "method"`)
+
+	flags.StringVar(&ep.version, flagVersion, ep.version,
+		`Version of this service, used to label spans and responses`)
+
+	flags.StringSliceVar(&ep.versionFlags, flagVersionFaults, ep.versionFlags,
+		`Fault settings for a specific service version, e.g. "v2:errors=20,v2:latency=100ms"`)
+
+	flags.StringSliceVar(&ep.instanceFlags, flagInstanceFaults, ep.instanceFlags,
+		`Fault settings for a specific instance by hostname or ordinal, e.g. "svcb-2:errors=50" or "2:errors=50"`)
+
+	flags.StringVar(&ep.tenantHeader, flagTenantHeader, ep.tenantHeader,
+		`Request header identifying the tenant, tagged on spans and keying tenant faults and metrics, empty disables`)
+
+	flags.StringSliceVar(&ep.tenantFlags, flagTenantFaults, ep.tenantFlags,
+		`Fault settings for the requests of a specific tenant, e.g. "acme:latency=200ms" or "acme:errors=10"`)
+
+	flags.StringVar(&ep.txFlag, flagTransactions, ep.txFlag,
+		`Weighted synthetic business transactions started by requests without one, propagated as baggage, e.g. "checkout=20,search=70,login=10"`)
+
+	flags.StringVar(&ep.hashHeader, flagHashHeader, ep.hashHeader,
+		`Request header to consistently hash on when proxying to a set of services, e.g. "x-user-id"`)
+
+	flags.StringVar(&ep.topoConfigHeader, flagTopoConfig, ep.topoConfigHeader,
+		`Request header holding fault knobs for a single request, e.g. "errors=50;svcb.latency=200ms", empty disables`)
+
+	flags.VarPF(&ep.coalescing, flagCoalesce, "",
+		`Coalesce identical concurrent GET and HEAD proxy requests into a single downstream call`).NoOptDefVal = "true"
+
+	flags.BoolVar(&ep.topoPath, flagTopoPath, ep.topoPath,
+		`Add the services passed and their latency to the X-Topo-Path and X-Topo-Path-Latency response headers`)
+
+	flags.StringSliceVar(&ep.bulkheadFlags, flagBulkhead, ep.bulkheadFlags,
+		`Max concurrent requests per route, rejecting with a 503 when saturated, e.g. "/proxy/{service}=10" or "*=50" for each route`)
+
+	flags.IntVar(&ep.queueWorkers, flagQueueWorkers, ep.queueWorkers,
+		`Funnel requests through a pool of this many workers, reporting the queue wait time, 0 disables`)
+
+	flags.IntVar(&ep.queueDepth, flagQueueDepth, ep.queueDepth,
+		`Max requests waiting for a worker before rejecting them with a 503`)
+
+	flags.StringVar(&ep.priorityHeader, flagPriorityHeader, ep.priorityHeader,
+		`Request header holding the priority (high, normal, low) honored by the bulkhead, worker pool and rate limiter`)
+
+	flags.IntVar(&ep.priorityLowShare, flagPriorityShare, ep.priorityLowShare,
+		`Percentage of the bulkhead limits, queue depth and rate limit burst available to low priority requests`)
+
+	flags.DurationVar(&ep.warmupDuration, flagWarmupDuration, ep.warmupDuration,
+		`Warm-up window starting with the first request, during which latency and errors are elevated`)
+
+	flags.Int64Var(&ep.warmupRequests, flagWarmupRequests, ep.warmupRequests,
+		`Warm-up window in requests, the warm-up ends with whichever window passes first`)
+
+	flags.DurationVar(&ep.warmupLatency, flagWarmupLatency, ep.warmupLatency,
+		`Latency added at the start of the warm-up, decreasing linearly to none`)
+
+	flags.Int32Var(&ep.warmupErrors, flagWarmupErrors, ep.warmupErrors,
+		`Error percentage added at the start of the warm-up, decreasing linearly to none`)
+
+	flags.Float64Var(&ep.burnSLO, flagBurnSLO, ep.burnSLO,
+		`SLO percentage whose error budget is burned by the burn rate schedule`)
+
+	flags.StringSliceVar(&ep.burnFlags, flagBurnRate, ep.burnFlags,
+		`Burn rate schedule as rate[x][:duration] phases starting with the service, e.g. "14x:5m,6x:1h,1x"`)
+
+	flags.StringSliceVar(&ep.depFlags, flagDependencies, ep.depFlags,
+		`Downstream services which must respond successfully to a probe before becoming ready, e.g. "svcb,svcc:8000/healthz"`)
+
+	flags.Int64Var(&ep.longTraceMax, flagLongTraceMax, ep.longTraceMax,
+		`Maximum amount of local spans a single /longtrace, /local or /localtree request may create`)
+
+	flags.IntVar(&ep.clientIPCfg.hops, flagTrustedHops, ep.clientIPCfg.hops,
+		`Number of trusted proxy hops in front of this service when determining the client IP from forwarded headers`)
+
+	flags.StringSliceVar(&ep.trustedProxies, flagTrustedProxies, ep.trustedProxies,
+		`Trusted proxy addresses or CIDRs skipped when determining the client IP, e.g. "10.0.0.0/8"`)
+
+	flags.Int64Var(&ep.maxBodySize, flagMaxBodySize, ep.maxBodySize,
+		`Maximum request body size in bytes accepted by the echo handler, 0 means no limit`)
+
+	flags.BoolVar(&ep.echoBody, flagEchoBody, ep.echoBody,
+		`Echo request bodies back in the echo handler response`)
+
+	flags.StringSliceVar(&ep.echoHeaders, flagEchoHeaders, ep.echoHeaders,
+		`Request headers to echo back and tag on spans, all when empty`)
+
+	flags.StringVar(&ep.templateFile, flagEchoTemplate, ep.templateFile,
+		`Path to a Go template rendering the echo response body`)
+
+	flags.StringVar(&ep.templateType, flagTemplateType, ep.templateType,
+		`Content type of responses rendered by the echo response template`)
+
+	flags.StringSliceVar(&ep.redactHeaders, flagRedactHeaders, ep.redactHeaders,
+		`Request headers to redact in echoed headers and span tags`)
+
+	flags.StringSliceVar(&ep.spanHeaders, flagSpanHeaders, ep.spanHeaders,
+		`Request headers to tag on the server span as http.header.{name}, e.g. "x-tenant"`)
+
+	flags.StringVar(&ep.schemaFile, flagEchoSchema, ep.schemaFile,
+		`Path to a JSON schema the echo handler validates JSON bodies or query parameters against`)
+
+	flags.StringSliceVar(&ep.corsCfg.origins, flagCORSOrigins, ep.corsCfg.origins,
+		`Allowed CORS origins, enables app-level CORS handling, e.g. "https://app.example.com" or "*"`)
+
+	flags.StringSliceVar(&ep.corsCfg.methods, flagCORSMethods, ep.corsCfg.methods,
+		`Allowed CORS methods, defaults to the requested method`)
+
+	flags.StringSliceVar(&ep.corsCfg.headers, flagCORSHeaders, ep.corsCfg.headers,
+		`Allowed CORS request headers, defaults to the requested headers`)
+
+	flags.IntVar(&ep.corsCfg.maxAge, flagCORSMaxAge, ep.corsCfg.maxAge,
+		`CORS preflight max age in seconds`)
+
+	flags.StringVar(&ep.corsFault, flagCORSFault, ep.corsFault,
+		`CORS fault mode, one of: none, omit, corrupt`)
+
+	flags.StringVar(&ep.discoveryMode, flagDiscovery, ep.discoveryMode,
+		`Proxy target discovery, one of: dns, static, kubernetes, consul`)
+
+	flags.StringSliceVar(&ep.staticEndpoints, flagStaticEndpoint, ep.staticEndpoints,
+		`Static proxy target endpoints, e.g. "svcb=10.0.0.1:8000,svcb=10.0.0.2:8000"`)
+
+	flags.StringVar(&ep.consulAddress, flagConsulAddress, ep.consulAddress,
+		`Consul agent address used for discovery, e.g. "consul:8500"`)
+
+	flags.BoolVar(&ep.accessLog, flagAccessLog, ep.accessLog,
+		`Write an access log line per request to stdout`)
+
+	flags.BoolVar(&ep.requestMetrics, flagReqMetrics, ep.requestMetrics,
+		`Count requests and responses by status class, exposed through expvar, and record their latency with trace exemplars at /metrics of the admin server`)
+
+	flags.Float64Var(&ep.rateLimitRPS, flagRateLimit, ep.rateLimitRPS,
+		`Reject requests exceeding this amount of requests per second with a 429, 0 disables`)
+
+	flags.IntVar(&ep.rateLimitBurst, flagRateLimitBurst, ep.rateLimitBurst,
+		`Amount of requests allowed in bursts above the rate limit, defaults to the rate limit`)
+
+	flags.StringSliceVar(&ep.reqHeaderFlags, flagReqHeaders, ep.reqHeaderFlags,
+		`Request header rules applied when proxying, e.g. "remove:x-envoy-*,set:x-tenant=acme"`)
+
+	flags.StringVar(&ep.requiredIdentity, flagIdentity, ep.requiredIdentity,
+		`Client certificate identity (SPIFFE ID or SAN) required to access this service`)
+
+	flags.StringSliceVar(&ep.resolverFlags, flagResolvers, ep.resolverFlags,
+		`GraphQL field resolvers, enables /graphql, e.g. "users=svcb:8000/users"`)
+
+	flags.DurationVar(&ep.cacheTTL, flagCacheTTL, ep.cacheTTL,
+		`TTL of cached proxy responses, 0 disables the cache`)
+
+	flags.IntVar(&ep.cacheSize, flagCacheSize, ep.cacheSize,
+		`Maximum number of cached proxy responses`)
+
+	flags.StringSliceVar(&ep.compressions, flagCompression, ep.compressions,
+		`Response compression encodings in order of preference, e.g. "br,gzip"`)
+
+	flags.Var(&ep.badEncoding, flagBadEncoding,
+		`Percentage of responses claiming gzip encoding while uncompressed`)
+
+	flags.Var(&ep.slowBodyRate, flagSlowBodyRate,
+		`Rate in bytes/sec to send proxied request bodies at, 0 disables`)
+
+	flags.Var(&ep.slowBodySize, flagSlowBodySize,
+		`Size of the synthetic body sent when throttling requests without body`)
+
+	flags.Var(&ep.slowReadRate, flagSlowReadRate,
+		`Rate in bytes/sec to read incoming request bodies at, 0 disables`)
+
+	flags.Int64Var(&ep.seed, flagSeed, ep.seed,
+		`Seed of the random source for fault decisions, 0 picks a random seed, shown by /admin/config`)
+
+	flags.DurationVar(&ep.crashDelay, flagCrashDelay, ep.crashDelay,
+		`Default delay before executing a requested crash`)
+
+	flags.DurationVar(&ep.startupDelay, flagStartupDelay, ep.startupDelay,
+		`Delay after start before the service accepts traffic`)
+
+	flags.StringVar(&ep.startupMode, flagStartupMode, ep.startupMode,
+		`Startup delay behavior, one of: listener, readiness`)
+
+	flags.IntVar(&ep.transportCfg.MaxIdleConns, flagMaxIdleConns,
+		ep.transportCfg.MaxIdleConns,
+		`Maximum number of idle outbound connections, 0 means no limit`)
+
+	flags.IntVar(&ep.transportCfg.MaxConnsPerHost, flagMaxConnsHost,
+		ep.transportCfg.MaxConnsPerHost,
+		`Maximum number of outbound connections per host, 0 means no limit`)
+
+	flags.DurationVar(&ep.transportCfg.IdleConnTimeout, flagIdleTimeout,
+		ep.transportCfg.IdleConnTimeout,
+		`Timeout after which idle outbound connections are closed`)
+
+	flags.BoolVar(&ep.transportCfg.DisableKeepAlives, flagNoKeepAlive,
+		ep.transportCfg.DisableKeepAlives,
+		`Disable keep-alive on outbound connections`)
+
+	flags.BoolVar(&ep.transportCfg.Socket.ReusePort, flagDialReusePort,
+		ep.transportCfg.Socket.ReusePort,
+		`Set SO_REUSEPORT on outbound connections`)
+
+	flags.BoolVar(&ep.transportCfg.Socket.NoDelay, flagDialNoDelay,
+		ep.transportCfg.Socket.NoDelay,
+		`Set TCP_NODELAY on outbound connections, disabling Nagle's algorithm`)
+
+	flags.DurationVar(&ep.transportCfg.Socket.KeepAlive, flagDialKeepAlive,
+		ep.transportCfg.Socket.KeepAlive,
+		`TCP keepalive period of outbound connections, 0 uses the default and a negative value disables keepalive`)
+
+	flags.StringVar(&ep.egressProxy, flagEgressProxy, ep.egressProxy,
+		`Forward proxy to route outbound calls through, e.g. "http://egress:3128", "socks5://egress:1080" or "connect://egress:3128"`)
 
 	return flags
 }
@@ -93,21 +671,268 @@ func (ep *Endpoints) FlagSet() *run.FlagSet {
 func (ep *Endpoints) Validate() error {
 	var mErr error
 
-	if ep.errors < 0 || ep.errors > 100 {
+	if e := ep.errors.Load(); e < 0 || e > 100 {
 		mErr = multierror.Append(mErr,
 			fmt.Errorf(pkg.FlagErr, flagErrors, errPercentage),
 		)
 	}
-	if ep.headers < 0 || ep.headers > 100 {
+	if h := ep.headers.Load(); h < 0 || h > 100 {
 		mErr = multierror.Append(mErr,
 			fmt.Errorf(pkg.FlagErr, flagHeaders, errPercentage),
 		)
 	}
-	if ep.duration < 0 {
+	if ep.duration.Load() < 0 {
 		mErr = multierror.Append(mErr,
 			fmt.Errorf(pkg.FlagErr, flagDuration, errDuration),
 		)
 	}
+	if ep.latencyOverrun != overrunFail && ep.latencyOverrun != overrunSleep {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagOverrun, errOverrun),
+		)
+	}
+	if b := ep.badEncoding.Load(); b < 0 || b > 100 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagBadEncoding, errPercentage),
+		)
+	}
+	for _, enc := range ep.compressions {
+		if enc != encodingGzip && enc != encodingBrotli {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, flagCompression, errCompression),
+			)
+		}
+	}
+	for flag, conns := range map[string]int{
+		flagMaxIdleConns: ep.transportCfg.MaxIdleConns,
+		flagMaxConnsHost: ep.transportCfg.MaxConnsPerHost,
+	} {
+		if conns < 0 {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, flag, errConnections),
+			)
+		}
+	}
+	if ep.longTraceMax <= 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagLongTraceMax, errSpans),
+		)
+	}
+	if ep.rateLimitRPS < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagRateLimit, errRateLimit),
+		)
+	}
+	if ep.rateLimitBurst < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagRateLimitBurst, errCount),
+		)
+	}
+	if ep.crashDelay < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagCrashDelay, errDuration),
+		)
+	}
+	if ep.startupDelay < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagStartupDelay, errDuration),
+		)
+	}
+	if ep.startupMode != startupListener && ep.startupMode != startupReadiness {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagStartupMode, errStartupMode),
+		)
+	}
+	if ep.egressProxy != "" {
+		if _, err := parseEgressProxy(ep.egressProxy); err != nil {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, flagEgressProxy, err),
+			)
+		}
+	}
+	if ep.transportCfg.IdleConnTimeout < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagIdleTimeout, errDuration),
+		)
+	}
+	for flag, rate := range map[string]int{
+		flagSlowBodyRate: ep.slowBodyRate.Load(),
+		flagSlowBodySize: ep.slowBodySize.Load(),
+		flagSlowReadRate: ep.slowReadRate.Load(),
+	} {
+		if rate < 0 {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, flag, errRate),
+			)
+		}
+	}
+	if ep.cacheTTL < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagCacheTTL, errDuration),
+		)
+	}
+	if ep.cacheSize < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagCacheSize, errCacheSize),
+		)
+	}
+	if ep.queueWorkers < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagQueueWorkers, errCount),
+		)
+	}
+	if ep.queueDepth < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagQueueDepth, errCount),
+		)
+	}
+	if ep.priorityLowShare < 0 || ep.priorityLowShare > 100 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagPriorityShare, errPercentage),
+		)
+	}
+	if ep.warmupDuration < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagWarmupDuration, errDuration),
+		)
+	}
+	if ep.warmupRequests < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagWarmupRequests, errCount),
+		)
+	}
+	if ep.warmupLatency < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagWarmupLatency, errDuration),
+		)
+	}
+	if ep.warmupErrors < 0 || ep.warmupErrors > 100 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagWarmupErrors, errPercentage),
+		)
+	}
+	if ep.txFlag != "" {
+		if _, err := parseSplit(ep.txFlag); err != nil {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, flagTransactions, errTransactions),
+			)
+		}
+	}
+	if ep.burnSLO <= 0 || ep.burnSLO >= 100 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagBurnSLO, errBurnSLO),
+		)
+	} else if schedule, err := parseBurnSchedule(ep.burnFlags); err != nil || !validBurnSchedule(ep.burnSLO, schedule) {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagBurnRate, errBurnRate),
+		)
+	}
+	switch ep.spanName {
+	case spanNameRoute, spanNamePath, spanNameMethod:
+	default:
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagSpanName, errSpanName),
+		)
+	}
+	for _, rule := range ep.reqHeaderFlags {
+		if _, err := parseHeaderRule(rule); err != nil {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, flagReqHeaders, err),
+			)
+		}
+	}
+	if ep.corsCfg.maxAge < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagCORSMaxAge, errMaxAge),
+		)
+	}
+	switch ep.corsFault {
+	case corsFaultNone, corsFaultOmit, corsFaultCorrupt:
+	default:
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagCORSFault, errCORSFault),
+		)
+	}
+	if ep.clientIPCfg.hops < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagTrustedHops, errTrustedHops),
+		)
+	}
+	if _, err := parseCIDRs(ep.trustedProxies); err != nil {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagTrustedProxies, err),
+		)
+	}
+	if ep.maxBodySize < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagMaxBodySize, errBodySize),
+		)
+	}
+	if ep.gracefulBodyMax < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagGracefulMax, errBodySize),
+		)
+	}
+	switch ep.discoveryMode {
+	case discoveryDNS, discoveryStatic:
+	case discoveryKubernetes:
+		if !kubernetes.InCluster() {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, flagDiscovery, kubernetes.ErrNotInCluster),
+			)
+		}
+	case discoveryConsul:
+		if ep.consulAddress == "" {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, flagConsulAddress, pkg.ErrRequired),
+			)
+		}
+	default:
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagDiscovery, errDiscovery),
+		)
+	}
+	for _, e := range ep.staticEndpoints {
+		if _, _, err := parseStaticEndpoint(e); err != nil {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, flagStaticEndpoint, err),
+			)
+		}
+	}
+	for flag, faults := range map[string][]string{
+		flagVersionFaults:  ep.versionFlags,
+		flagInstanceFaults: ep.instanceFlags,
+		flagTenantFaults:   ep.tenantFlags,
+	} {
+		for _, fault := range faults {
+			if _, err := parseScopedFault(fault); err != nil {
+				mErr = multierror.Append(mErr,
+					fmt.Errorf(pkg.FlagErr, flag, err),
+				)
+			}
+		}
+	}
+	for _, resolver := range ep.resolverFlags {
+		if _, _, err := parseResolver(resolver); err != nil {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, flagResolvers, err),
+			)
+		}
+	}
+	for _, limit := range ep.bulkheadFlags {
+		if _, _, err := parseBulkhead(limit); err != nil {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, flagBulkhead, err),
+			)
+		}
+	}
+	for _, dep := range ep.depFlags {
+		if _, _, err := parseDependency(dep); err != nil {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, flagDependencies, err),
+			)
+		}
+	}
 
 	return mErr
 }
@@ -118,18 +943,211 @@ func (ep *Endpoints) PreRun() error {
 		return errors.New("missing Zipkin tracer to attach to")
 	}
 
+	for _, rule := range ep.reqHeaderFlags {
+		h, _ := parseHeaderRule(rule) // validated in Validate
+		ep.reqHeaderRules = append(ep.reqHeaderRules, h)
+	}
+	if ep.seed == 0 {
+		ep.seed = time.Now().UnixNano()
+	}
+	ep.rand = newFaultRand(ep.seed)
+	ep.respPrefix = responsePrefix(ep.ServiceName, ep.version)
+	ep.respCache = newResponseCache(ep.cacheTTL, ep.cacheSize)
+	ep.proxies = newProxyCache()
+	ep.flights = newFlightGroup()
+	ep.bulkheads = newBulkhead()
+	ep.queue = &workQueue{workers: ep.queueWorkers, depth: ep.queueDepth}
+	ep.warm = &warmup{
+		duration: ep.warmupDuration,
+		requests: ep.warmupRequests,
+		latency:  ep.warmupLatency,
+		errors:   ep.warmupErrors,
+	}
+	schedule, _ := parseBurnSchedule(ep.burnFlags) // validated in Validate
+	ep.burn = &burnRate{slo: ep.burnSLO, schedule: schedule, start: time.Now()}
+	for _, limit := range ep.bulkheadFlags {
+		route, n, _ := parseBulkhead(limit) // validated in Validate
+		ep.bulkheads.setLimit(route, n)
+	}
+	ep.clientIPCfg.cidrs, _ = parseCIDRs(ep.trustedProxies) // validated in Validate
+	ep.headerFilter = newHeaderFilter(ep.echoHeaders, ep.redactHeaders)
+	var err error
+	if ep.schemaFile != "" {
+		if ep.schema, err = loadSchema(ep.schemaFile); err != nil {
+			return fmt.Errorf(pkg.FlagErr, flagEchoSchema, err)
+		}
+	}
+	if ep.templateFile != "" {
+		if ep.template, err = loadTemplate(ep.templateFile, ep.templateType); err != nil {
+			return fmt.Errorf(pkg.FlagErr, flagEchoTemplate, err)
+		}
+	}
+	if ep.instance, err = os.Hostname(); err != nil {
+		return err
+	}
+	ep.applyScopedFaults(parseScopedFaults(ep.versionFlags), ep.matchesVersion)
+	ep.applyScopedFaults(parseScopedFaults(ep.instanceFlags), ep.matchesInstance)
+	ep.tenantKnobs = parseTenantKnobs(ep.tenantFlags)
+	if ep.txFlag != "" {
+		ep.txTargets, _ = parseSplit(ep.txFlag) // validated in Validate
+	}
+	if ep.discovery, err = ep.newDiscovery(); err != nil {
+		return err
+	}
+	ep.leaks = newLeaks()
+	ep.calls = newCallStats()
+	ep.replays = &replayer{}
+	targets := make(map[string]string, len(ep.depFlags))
+	for _, dep := range ep.depFlags {
+		host, target, _ := parseDependency(dep) // validated in Validate
+		targets[host] = target
+	}
+	ep.deps = newDependencyGate(targets)
+	if ep.SvcHTTP != nil {
+		ep.writeTimeout.Store(ep.SvcHTTP.Timeouts().Write)
+		if note := ep.exceedsWriteTimeout(ep.duration.Load()); note != "" {
+			log.Printf("%s %s%s", flagDuration, ep.duration.Load(), note)
+		}
+	}
+	ep.startup(ep.startupMode, ep.startupDelay)
+	ep.resolvers = make(map[string]string, len(ep.resolverFlags))
+	for _, resolver := range ep.resolverFlags {
+		field, target, _ := parseResolver(resolver) // validated in Validate
+		ep.resolvers[field] = target
+	}
+
 	// create our service router
 	router := mux.NewRouter()
-	router.Methods("GET").Path("/headers/{percentage}").HandlerFunc(ep.setDoubleHeaders)
-	router.Methods("GET").Path("/errors/{percentage}").HandlerFunc(ep.setErrors)
-	router.Methods("GET").Path("/graceful/{handleFailures}").HandlerFunc(ep.setHandleFailures)
-	router.Methods("GET").Path("/latency/{duration}").HandlerFunc(ep.setLatency)
+	router.Methods("GET").Path("/healthz").HandlerFunc(ep.healthz)
+	router.Methods("GET").Path("/readyz").HandlerFunc(ep.readyz)
+	router.Methods("GET").Path("/version").HandlerFunc(ep.versionInfo)
+	router.Methods("GET").Path("/envoyinfo").HandlerFunc(ep.envoyInfo)
+	router.Methods("GET").Path("/openapi.json").HandlerFunc(ep.openAPI)
+	router.Methods("GET").Path("/endpoints").HandlerFunc(ep.endpoints)
+	router.Methods("GET").Path("/ui").HandlerFunc(ep.ui)
+	router.Methods("GET").Path("/topology").HandlerFunc(ep.topology)
+	router.Path("/headers/{percentage}").HandlerFunc(ep.setDoubleHeaders)
+	router.Path("/errors/{percentage}").HandlerFunc(ep.setErrors)
+	router.Path("/badencoding/{percentage}").HandlerFunc(ep.setBadEncoding)
+	router.Path("/graceful/{handleFailures}").HandlerFunc(ep.setHandleFailures)
+	router.Path("/latency/{duration}").HandlerFunc(ep.setLatency)
+	router.Path("/delay/{duration}").HandlerFunc(ep.delay)
+	router.Path("/{direction:slowbody|slowread}/{rate}").HandlerFunc(ep.setSlowRate)
+	router.Path("/{fault:blackhole|idle}/{percentage}").HandlerFunc(ep.setTCPFault)
+	router.Path("/violation/{kind:contentlength|statusline|eof|duplicatehost}/{percentage}").HandlerFunc(ep.setViolation)
+	router.Path("/cors/{mode:none|omit|corrupt}").HandlerFunc(ep.setCORSFault)
+	router.Path("/bigheaders/{kind:large|many|cookie}/{percentage}").HandlerFunc(ep.setHeaderFault)
+	router.Path("/charset/{kind:mismatch|bom|invalid}/{percentage}").HandlerFunc(ep.setCharsetFault)
+	router.Path("/expect/{mode:none|require|delay|never}").HandlerFunc(ep.setExpectMode)
+	router.Path("/expect/{mode:emit}/{value}").HandlerFunc(ep.setExpectMode)
+	router.Path("/coalesce/{enabled}").HandlerFunc(ep.setCoalesce)
+	router.Path("/status/{code}").HandlerFunc(ep.status)
+	router.Path("/chunked/{count}/{size}").HandlerFunc(ep.chunked)
+	router.Path("/redirect/{count}").HandlerFunc(ep.redirect)
+	router.Path("/redirect-to").HandlerFunc(ep.redirectTo)
 	router.Methods("GET").Path("/crash/{message}").HandlerFunc(ep.crash)
+	router.Methods("GET").Path("/crash/{mode:panic|exit|deadlock|segfault|oom|stuck}/{message}").HandlerFunc(ep.crash)
 	router.Methods("GET").Path("/local/{concurrency}/latency/{duration}").HandlerFunc(ep.emulateConcurrency)
-	router.Methods("GET").PathPrefix("/proxy/{service}").HandlerFunc(ep.proxy)
-	router.Methods("GET").PathPrefix("/").HandlerFunc(ep.echoHandler)
+	router.Methods("GET").Path("/localtree/{depth}/{breadth}/{latency}").HandlerFunc(ep.localTree)
+	router.Methods("GET").Path("/longtrace/{spans}").HandlerFunc(ep.longTrace)
+	router.Methods("GET").Path("/bench/spans/{count}").HandlerFunc(ep.benchSpans)
+	router.Methods("GET").Path("/anomaly/{kind:orphan|late|duplicate}").HandlerFunc(ep.spanAnomaly)
+	router.Methods("GET").Path("/leak/goroutines/{countPerRequest}").HandlerFunc(ep.leakGoroutines)
+	router.Methods("GET").Path("/leak/connections/{target}").HandlerFunc(ep.leakConnection)
+	router.Methods("GET").Path("/admin/leaks/stop").HandlerFunc(ep.stopLeaks)
+	router.Methods("GET").Path("/admin/idle/release").HandlerFunc(ep.releaseIdle)
+	router.Methods("GET").Path("/admin/instance/{instance}/{knob:errors|headers|latency|badencoding}/{value}").HandlerFunc(ep.instanceFault)
+	router.Methods("GET").Path("/admin/tenants").HandlerFunc(ep.tenantFaults)
+	router.Methods("GET").Path("/admin/tenant/{tenant}/reset").HandlerFunc(ep.tenantFaults)
+	router.Methods("GET").Path("/admin/tenant/{tenant}/{knob:errors|headers|latency|badencoding}/{value}").HandlerFunc(ep.tenantFaults)
+	router.Methods("GET").Path("/admin/transactions").HandlerFunc(ep.transactionState)
+	router.Methods("GET").Path("/admin/transactions/{action:disable}").HandlerFunc(ep.transactionState)
+	router.Methods("GET").Path("/admin/transactions/weights/{weights}").HandlerFunc(ep.transactionState)
+	router.Methods("GET").Path("/admin/health/fail/{duration}").HandlerFunc(ep.failHealth)
+	router.Methods("GET").Path("/admin/restart-behavior").HandlerFunc(ep.restartBehavior)
+	router.Methods("GET").Path("/admin/restart-behavior/{mode:listener|readiness}/{delay}").HandlerFunc(ep.restartBehavior)
+	router.Methods("GET").Path("/admin/reqheaders").HandlerFunc(ep.reqHeaders)
+	router.Methods("GET").Path("/admin/reqheaders/{action:reset}").HandlerFunc(ep.reqHeaders)
+	router.Methods("GET").Path("/admin/reqheaders/{action:remove}/{name}").HandlerFunc(ep.reqHeaders)
+	router.Methods("GET").Path("/admin/reqheaders/{action:add|set}/{name}/{value}").HandlerFunc(ep.reqHeaders)
+	router.Methods("GET").Path("/admin/cache").HandlerFunc(ep.cache)
+	router.Methods("GET").Path("/admin/cache/{action:purge}").HandlerFunc(ep.cache)
+	router.Methods("GET").Path("/admin/cache/{action:ttl}/{duration}").HandlerFunc(ep.cache)
+	router.Methods("GET").Path("/admin/bulkhead").HandlerFunc(ep.bulkhead)
+	router.Methods("GET").Path("/admin/bulkhead/limit/{limit}").HandlerFunc(ep.bulkhead)
+	router.Methods("GET").Path("/admin/queue").HandlerFunc(ep.workQueue)
+	router.Methods("GET").Path("/admin/queue/{setting:workers|depth}/{value}").HandlerFunc(ep.workQueue)
+	router.Methods("GET").Path("/admin/warmup").HandlerFunc(ep.warmupState)
+	router.Methods("GET").Path("/admin/warmup/{action:restart}").HandlerFunc(ep.warmupState)
+	router.Methods("GET").Path("/admin/burnrate").HandlerFunc(ep.burnRateState)
+	router.Methods("GET").Path("/admin/burnrate/{action:restart}").HandlerFunc(ep.burnRateState)
+	router.Methods("GET").Path("/admin/burnrate/schedule/{schedule}").HandlerFunc(ep.burnRateState)
+	router.Methods("GET").Path("/admin/transport").HandlerFunc(ep.outboundTransport)
+	router.Methods("GET").Path("/admin/transport/{setting:maxidleconns|maxconnsperhost|idletimeout|keepalive}/{value}").HandlerFunc(ep.outboundTransport)
+	router.Methods("GET").Path("/admin/server").HandlerFunc(ep.serverTimeouts)
+	router.Methods("GET").Path("/admin/server/{setting:readtimeout|readheadertimeout|writetimeout|idletimeout|maxheaderbytes}/{value}").HandlerFunc(ep.serverTimeouts)
+	router.Methods("GET").Path("/admin/config").HandlerFunc(ep.adminConfig)
+	router.Methods("GET").Path("/admin/observability/status").HandlerFunc(ep.observabilityStatus)
+	router.Methods("GET").Path("/admin/spans").HandlerFunc(ep.recordedSpans)
+	router.Methods("GET").Path("/admin/sampling").HandlerFunc(ep.sampling)
+	router.Methods("GET").Path("/admin/sampling/{setting:rate|target}/{value}").HandlerFunc(ep.sampling)
+	router.Methods("GET").Path("/admin/tracing/faults").HandlerFunc(ep.tracingFaults)
+	router.Methods("GET").Path("/admin/tracing/faults/{fault:drop|truncate|delay}/{value}").HandlerFunc(ep.tracingFaults)
+	router.Methods("GET").Path("/admin/template").HandlerFunc(ep.responseTemplate)
+	router.Methods("POST", "PUT").Path("/admin/template").HandlerFunc(ep.responseTemplate)
+	router.Methods("GET").Path("/admin/template/{action:reset}").HandlerFunc(ep.responseTemplate)
+	router.Methods("GET", "POST").Path("/admin/replay").HandlerFunc(ep.replay)
+	router.Methods("GET").Path("/admin/replay/{action:stop}").HandlerFunc(ep.replay)
+	router.Methods("GET").Path("/admin/identity/reset").HandlerFunc(ep.requireIdentity)
+	router.Methods("GET").Path("/admin/identity/require/{identity:.+}").HandlerFunc(ep.requireIdentity)
+	if len(ep.resolvers) > 0 {
+		router.Methods("GET", "POST").Path("/graphql").HandlerFunc(ep.graphql)
+	}
+	router.PathPrefix("/proxy/{service}").HandlerFunc(ep.proxy).Name(routeProxy)
+	router.PathPrefix("/").HandlerFunc(ep.echoHandler).Name(routeEcho)
+	router.Use(ep.cancellation, ep.writeDeadline, ep.hopTimer, ep.pathSummary, ep.stuckHandler, ep.spanNamer, ep.bulkheadLimiter, ep.workerPool, ep.contentNegotiation, ep.topoConfig, ep.tenants, ep.transactions, ep.versionTagger, ep.clientIPTagger, ep.headerTagger, ep.blackhole, ep.protocolViolation, ep.bigHeaders, ep.cors, ep.methods, ep.expectContinue, ep.slowRead, ep.compression, ep.charsetFaults)
+	ep.router = router
+	ep.indexMethods()
 	ep.tracer = ep.SvcTracer.GetTracer()
-	ep.handler = zmw.NewServerMiddleware(ep.tracer)(router)
+	ep.replays.tracer = ep.tracer
+
+	// compose the middlewares wrapping the router, trace context extraction
+	// must precede the Zipkin server middleware
+	chain := &middleware.Chain{}
+	if ep.SvcTracer.ElasticTraceparent {
+		chain.Use(middleware.Tracing, zipkin.ExtractElasticTraceparent)
+	}
+	if ep.SvcTracer.XRayTraceHeader {
+		chain.Use(middleware.Tracing, zipkin.ExtractXRayTraceHeader)
+	}
+	if ep.SvcTracer.CloudTraceContext {
+		chain.Use(middleware.Tracing, zipkin.ExtractCloudTraceContext)
+	}
+	chain.Use(middleware.Tracing, zmw.NewServerMiddleware(ep.tracer, zmw.TagResponseSize(true),
+		zmw.EnableBaggage(baggage.New(transactionBaggage))))
+	if ep.requestMetrics {
+		chain.Use(middleware.Metrics, middleware.RequestMetrics(requestMetrics), middleware.RequestLatency(requestLatency))
+	}
+	if ep.accessLog {
+		chain.Use(middleware.Logging, middleware.AccessLog(log.New(os.Stdout, "", log.LstdFlags)))
+	}
+	chain.Use(middleware.Auth, ep.identityCheck)
+	if ep.rateLimitRPS > 0 {
+		chain.Use(middleware.RateLimit, ep.rateLimit)
+	}
+	ep.handler = chain.Then(ep.headRouter(router))
+
+	if ep.egressProxy != "" {
+		ep.transportCfg.EgressProxy, _ = parseEgressProxy(ep.egressProxy) // validated in Validate
+	}
+	if ep.baseTransport, ep.transport, err = ep.newTransport(ep.transportCfg); err != nil {
+		return err
+	}
+	ep.client = &http.Client{
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			return ep.roundTripper().RoundTrip(r)
+		}),
+	}
 
 	return nil
 }
@@ -140,6 +1158,12 @@ func (ep *Endpoints) Handler() http.Handler {
 	return ep.handler
 }
 
+// Metrics returns the HTTP handler exposing the request latency histogram in
+// the OpenMetrics text format, recorded with --ep-request-metrics.
+func (ep *Endpoints) Metrics() http.Handler {
+	return requestLatency
+}
+
 var (
 	_ run.Config    = (*Endpoints)(nil)
 	_ run.PreRunner = (*Endpoints)(nil)
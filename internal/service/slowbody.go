@@ -0,0 +1,137 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// slowReader throttles reads from the underlying reader to the provided rate
+// in bytes per second.
+type slowReader struct {
+	ctx  context.Context
+	r    io.Reader
+	rate int
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	chunk := s.rate / 10
+	if chunk < 1 {
+		chunk = 1
+	}
+	if len(p) > chunk {
+		p = p[:chunk]
+	}
+	select {
+	case <-s.ctx.Done():
+		return 0, s.ctx.Err()
+	case <-time.After(time.Duration(len(p)) * time.Second / time.Duration(s.rate)):
+	}
+	return s.r.Read(p)
+}
+
+func (s *slowReader) Close() error {
+	if c, ok := s.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// slowBody replaces the body of an outbound request with one that is sent at
+// the configured rate. If the request has no body, a synthetic body of the
+// configured size is sent instead.
+func (ep *Endpoints) slowBody(r *http.Request) {
+	rate := ep.slowBodyRate.Load()
+	size := ep.slowBodySize.Load()
+
+	if rate <= 0 {
+		return
+	}
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		if size <= 0 {
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(bytes.Repeat([]byte("x"), size)))
+		r.ContentLength = int64(size)
+	}
+	zipkin.SpanOrNoopFromContext(r.Context()).Tag("slow.body.rate", strconv.Itoa(rate))
+	r.Body = &slowReader{ctx: r.Context(), r: r.Body, rate: rate}
+}
+
+// slowRead reads incoming request bodies at the configured rate before handing
+// the request to the actual handler, emulating a service which is slow in
+// consuming request payloads.
+func (ep *Endpoints) slowRead(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rate := ep.slowReadRate.Load()
+
+		if rate <= 0 || r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		zipkin.SpanOrNoopFromContext(r.Context()).Tag("slow.read.rate", strconv.Itoa(rate))
+		raw, err := ioutil.ReadAll(&slowReader{ctx: r.Context(), r: r.Body, rate: rate})
+		_ = r.Body.Close()
+		if err != nil {
+			ep.writeResponse(r.Context(), w, response{
+				Code:  http.StatusRequestTimeout,
+				Error: errReadBody,
+			})
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(raw))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setSlowRate allows one to set the rate in bytes per second for sending
+// outbound request bodies (/slowbody/{rate}) or reading inbound request bodies
+// (/slowread/{rate}). A rate of 0 disables throttling.
+func (ep *Endpoints) setSlowRate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	rate, err := strconv.Atoi(mux.Vars(r)["rate"])
+	if err != nil || rate < 0 {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errRate,
+		})
+		return
+	}
+
+	var msg string
+	switch mux.Vars(r)["direction"] {
+	case "slowbody":
+		ep.slowBodyRate.Store(rate)
+		msg = fmt.Sprintf("slow body rate set to: %d bytes/sec", rate)
+	case "slowread":
+		ep.slowReadRate.Store(rate)
+		msg = fmt.Sprintf("slow read rate set to: %d bytes/sec", rate)
+	}
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+	})
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+// recordedSpans returns the finished spans kept in memory by the tracer,
+// allowing integration tests to assert span structure without a tracing
+// backend. Spans are returned oldest first and can be limited to a single
+// trace.
+//
+// Example paths:
+//
+//	/admin/spans                           all recorded spans
+//	/admin/spans?traceid=4902008fd0dd9add  spans of a single trace
+func (ep *Endpoints) recordedSpans(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var traceID *model.TraceID
+	if id := r.URL.Query().Get("traceid"); id != "" {
+		tid, err := model.TraceIDFromHex(id)
+		if err != nil {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errTraceID,
+			})
+			return
+		}
+		traceID = &tid
+	}
+
+	spans, ok := ep.SvcTracer.GetSpans(traceID)
+	if !ok {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusNotFound,
+			Error: errSpanRecorder,
+		})
+		return
+	}
+	ep.writeResponse(ctx, w, response{
+		Code: http.StatusOK,
+		Data: spans,
+	})
+}
@@ -0,0 +1,100 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// splitTarget is a downstream service with its relative weight.
+type splitTarget struct {
+	host   string
+	weight int
+}
+
+// parseSplit parses a proxy service directive which is either a single host or
+// a weighted set of hosts, e.g. "svcb=80,svcb-v2=20". Hosts without an explicit
+// weight get a weight of 1.
+func parseSplit(s string) ([]splitTarget, error) {
+	var (
+		targets []splitTarget
+		total   int
+	)
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		t := splitTarget{host: kv[0], weight: 1}
+		if t.host == "" {
+			return nil, errSplit
+		}
+		if len(kv) == 2 {
+			w, err := strconv.Atoi(kv[1])
+			if err != nil || w < 0 {
+				return nil, errSplit
+			}
+			t.weight = w
+		}
+		total += t.weight
+		targets = append(targets, t)
+	}
+	if total == 0 {
+		return nil, errSplit
+	}
+	return targets, nil
+}
+
+// pickWeighted randomly selects one of the targets honoring their weights.
+func pickWeighted(rnd *faultRand, targets []splitTarget) string {
+	var total int
+	for _, t := range targets {
+		total += t.weight
+	}
+	n := rnd.Intn(total)
+	for _, t := range targets {
+		if n < t.weight {
+			return t.host
+		}
+		n -= t.weight
+	}
+	return targets[len(targets)-1].host
+}
+
+// pickHashed consistently selects one of the targets for the provided key using
+// weighted rendezvous hashing. The same key always results in the same target
+// as long as the set of targets is unchanged, and only keys of a removed target
+// move elsewhere.
+func pickHashed(targets []splitTarget, key string) string {
+	var (
+		host string
+		best = -1.0
+	)
+	for _, t := range targets {
+		if t.weight == 0 {
+			continue
+		}
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(t.host))
+		// map the hash onto (0,1) and scale by weight
+		u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		if score := float64(t.weight) / -math.Log(u); score > best {
+			host, best = t.host, score
+		}
+	}
+	return host
+}
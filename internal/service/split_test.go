@@ -0,0 +1,77 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestParseSplit(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected []splitTarget
+	}{
+		{"svcb", []splitTarget{{"svcb", 1}}},
+		{"svcb:8000", []splitTarget{{"svcb:8000", 1}}},
+		{"svcb=80,svcb-v2=20", []splitTarget{{"svcb", 80}, {"svcb-v2", 20}}},
+		{"svcb,svcc=0", []splitTarget{{"svcb", 1}, {"svcc", 0}}},
+	}
+	for _, tt := range tests {
+		targets, err := parseSplit(tt.in)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.in, err)
+		}
+		if !reflect.DeepEqual(targets, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.in, tt.expected, targets)
+		}
+	}
+
+	for _, s := range []string{"", "svcb=", "svcb=x", "svcb=-1", "svcb=0", ",svcc"} {
+		if _, err := parseSplit(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestPickWeighted(t *testing.T) {
+	targets := []splitTarget{{"svcb", 1}, {"svcc", 0}}
+	rnd := newFaultRand(1)
+	for i := 0; i < 100; i++ {
+		if host := pickWeighted(rnd, targets); host != "svcb" {
+			t.Fatalf("expected svcb, got %s", host)
+		}
+	}
+}
+
+func TestPickHashed(t *testing.T) {
+	targets := []splitTarget{{"svcb", 1}, {"svcc", 1}, {"svcd", 0}}
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		host := pickHashed(targets, key)
+		if host != pickHashed(targets, key) {
+			t.Fatalf("expected consistent target for key %s", key)
+		}
+		counts[host]++
+	}
+	if counts["svcd"] != 0 {
+		t.Errorf("expected no keys on zero weight target, got %d", counts["svcd"])
+	}
+	if counts["svcb"] < 400 || counts["svcc"] < 400 {
+		t.Errorf("expected balanced distribution, got %v", counts)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// supported startup delay behaviors
+const (
+	startupListener  = "listener"
+	startupReadiness = "readiness"
+)
+
+// startup applies the startup delay. In listener mode the HTTP listener is only
+// opened after the delay, in readiness mode the listener accepts connections
+// but the readiness endpoint fails until the delay has passed. Required
+// dependencies have to respond successfully again before becoming ready.
+func (ep *Endpoints) startup(mode string, delay time.Duration) {
+	if ep.deps != nil {
+		ep.deps.reset()
+	}
+	ep.mtx.Lock()
+	ep.startupMode, ep.startupDelay = mode, delay
+	if mode == startupReadiness {
+		ep.readyAt = time.Now().Add(delay)
+	} else {
+		ep.readyAt = time.Time{}
+	}
+	ep.mtx.Unlock()
+
+	if mode == startupListener && ep.SvcHTTP != nil {
+		ep.SvcHTTP.StartupDelay = delay
+	}
+}
+
+// readyz is the readiness endpoint of this service. It reports failure while
+// the service is still emulating its startup in readiness mode, while any of
+// the required dependencies has not yet responded successfully to a probe, or
+// while draining on shutdown.
+func (ep *Endpoints) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	ep.mtx.RLock()
+	readyAt := ep.readyAt
+	draining := ep.draining
+	ep.mtx.RUnlock()
+
+	if draining {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusServiceUnavailable,
+			Error: errDraining,
+		})
+		return
+	}
+	if time.Now().Before(readyAt) {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusServiceUnavailable,
+			Error: errNotReady,
+		})
+		return
+	}
+	if pending, ok := ep.dependenciesReady(ctx); !ok {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusServiceUnavailable,
+			Error: errDependencies,
+			Data:  map[string]interface{}{"pending": pending},
+		})
+		return
+	}
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: "ok",
+	})
+}
+
+// restartBehavior allows one to inspect and change the startup delay behavior
+// of this service. Changing it emulates a restart of the service right after
+// the response has been sent, so rollout surges and startup probes can be
+// tested without actually restarting the pod.
+//
+// Example paths:
+//
+//	/admin/restart-behavior                 show the current behavior
+//	/admin/restart-behavior/listener/10s    close the listener for 10 seconds
+//	/admin/restart-behavior/readiness/30s   fail readiness for 30 seconds
+func (ep *Endpoints) restartBehavior(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	var (
+		msg   string
+		pause time.Duration = -1
+	)
+	if mode, ok := vars["mode"]; ok {
+		delay, err := parseDuration(vars["delay"])
+		if err != nil || delay < 0 {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errDuration,
+			})
+			return
+		}
+		ep.startup(mode, delay)
+		if mode == startupListener {
+			pause = delay
+		}
+		msg = fmt.Sprintf("restarting with %s startup delay of: %s", mode, delay)
+	}
+
+	ep.mtx.RLock()
+	data := map[string]interface{}{
+		"mode":  ep.startupMode,
+		"delay": ep.startupDelay.String(),
+	}
+	ep.mtx.RUnlock()
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+		Data:    data,
+	})
+
+	if pause >= 0 && ep.SvcHTTP != nil {
+		// closing the listener does not affect established connections, so
+		// this response still reaches the client
+		ep.SvcHTTP.Pause(pause)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// status returns exactly the requested status code without touching the
+// global fault settings. The delay query parameter postpones the response and
+// the body query parameter replaces the default JSON response body.
+//
+// Example paths:
+//
+//	/status/503
+//	/status/429?delay=200ms
+//	/status/418?body=short+and+stout
+func (ep *Endpoints) status(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	code, err := strconv.Atoi(mux.Vars(r)["code"])
+	if err != nil || code < 200 || code > 599 {
+		ep.writeResponse(ctx, w, response{
+			Code:  http.StatusBadRequest,
+			Error: errStatusCode,
+		})
+		return
+	}
+	q := r.URL.Query()
+	if d := q.Get("delay"); d != "" {
+		delay, err := parseDuration(d)
+		if err != nil || delay < 0 {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errDuration,
+			})
+			return
+		}
+		if !ep.injectLatency(ctx, w, delay) {
+			return
+		}
+	}
+
+	var info *errorInfo
+	if code >= 400 {
+		info = injected("INJECTED_STATUS", code)
+	}
+	if body, ok := q["body"]; ok {
+		if info != nil {
+			tagError(ctx, info)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte(body[0]))
+		return
+	}
+	ep.writeResponse(ctx, w, response{
+		Code:      code,
+		Message:   http.StatusText(code),
+		ErrorInfo: info,
+	})
+}
@@ -0,0 +1,85 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// blackhole swallows a percentage of requests. The connection is hijacked and
+// everything the client sends is discarded, but a response is never written.
+// The connection stays open until the client gives up.
+func (ep *Endpoints) blackhole(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok || ep.rand.Int31n(100) >= ep.blackholes.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		zipkin.SpanOrNoopFromContext(r.Context()).Tag("fault", "blackhole")
+
+		conn, buf, err := hj.Hijack()
+		if err != nil {
+			log.Printf("unable to hijack connection: %v", err)
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		// clear the deadlines set by the server
+		_ = conn.SetDeadline(time.Time{})
+		_, _ = io.Copy(ioutil.Discard, buf)
+	})
+}
+
+// setTCPFault allows one to set the percentage of requests which are
+// blackholed or the percentage of connections which are held open idle
+// without ever being served.
+//
+// Example paths:
+//
+//	/blackhole/10     swallow 10% of requests without responding
+//	/idle/10          never serve 10% of newly accepted connections
+func (ep *Endpoints) setTCPFault(w http.ResponseWriter, r *http.Request) {
+	switch mux.Vars(r)["fault"] {
+	case "blackhole":
+		ep.setPercentage(w, r, &ep.blackholes, "blackhole")
+	case "idle":
+		ep.setPercentage(w, r, &ep.idleConns, "idle connections")
+		if ep.SvcHTTP != nil {
+			ep.SvcHTTP.SetIdleConnections(ep.idleConns.Load())
+		}
+	}
+}
+
+// releaseIdle closes all connections held open idle.
+func (ep *Endpoints) releaseIdle(w http.ResponseWriter, r *http.Request) {
+	var n int
+	if ep.SvcHTTP != nil {
+		n = ep.SvcHTTP.IdleConnections()
+		ep.SvcHTTP.ReleaseIdleConnections()
+	}
+
+	ep.writeResponse(r.Context(), w, response{
+		Code:    http.StatusOK,
+		Message: fmt.Sprintf("released %d idle connections", n),
+	})
+}
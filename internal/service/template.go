@@ -0,0 +1,185 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultTemplateType is the content type of templated responses unless
+// configured otherwise.
+const defaultTemplateType = "application/json"
+
+// echoTemplate holds the template rendering the echo response body.
+type echoTemplate struct {
+	src         string
+	contentType string
+	tmpl        *template.Template
+}
+
+// templateData holds the data available to echo response templates.
+type templateData struct {
+	Service  string
+	Version  string
+	Instance string
+	TraceID  string
+	Method   string
+	Host     string
+	Path     string
+	Query    url.Values
+	Headers  http.Header
+	ClientIP string
+	Body     string
+	JSON     interface{}
+	Vars     map[string]string
+	Time     time.Time
+}
+
+// templateFuncs holds the functions available to echo response templates.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"join":  strings.Join,
+}
+
+// parseTemplate parses the source of an echo response template.
+func parseTemplate(src, contentType string) (*echoTemplate, error) {
+	tmpl, err := template.New("echo").Funcs(templateFuncs).Option("missingkey=zero").Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if contentType == "" {
+		contentType = defaultTemplateType
+	}
+	return &echoTemplate{src: src, contentType: contentType, tmpl: tmpl}, nil
+}
+
+// loadTemplate reads and parses the echo response template found at path.
+func loadTemplate(path, contentType string) (*echoTemplate, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseTemplate(string(src), contentType)
+}
+
+// writeTemplate renders the echo response using the provided template.
+func (ep *Endpoints) writeTemplate(w http.ResponseWriter, r *http.Request, t *echoTemplate, body []byte) {
+	ctx := r.Context()
+	data := templateData{
+		Service:  ep.ServiceName,
+		Version:  ep.version,
+		Instance: ep.instance,
+		TraceID:  traceID(ctx),
+		Method:   r.Method,
+		Host:     r.Host,
+		Path:     r.URL.Path,
+		Query:    r.URL.Query(),
+		Headers:  ep.headerFilter.filter(r.Header),
+		ClientIP: ep.clientIPCfg.clientIP(r),
+		Body:     string(body),
+		Vars:     mux.Vars(r),
+		Time:     time.Now(),
+	}
+	if len(body) > 0 {
+		_ = json.Unmarshal(body, &data.JSON)
+	}
+
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		ep.writeResponse(ctx, w, response{
+			Code:    http.StatusInternalServerError,
+			Error:   errTemplate,
+			Message: err.Error(),
+		})
+		return
+	}
+	w.Header().Set("Content-Type", t.contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// responseTemplate allows one to inspect, set or reset the template rendering
+// the echo response body. The template is set from the request body, the
+// content type of the rendered responses from the contentType query parameter.
+//
+// Example paths:
+//
+//	GET  /admin/template                                show the current template
+//	POST /admin/template?contentType=application/xml    set the template
+//	GET  /admin/template/reset                          restore the default response
+func (ep *Endpoints) responseTemplate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var msg string
+	switch {
+	case mux.Vars(r)["action"] == "reset":
+		ep.mtx.Lock()
+		ep.template = nil
+		ep.mtx.Unlock()
+		msg = "response template reset"
+	case r.Method != http.MethodGet:
+		src, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errReadBody,
+			})
+			return
+		}
+		t, err := parseTemplate(string(src), r.URL.Query().Get("contentType"))
+		if err != nil {
+			ep.writeResponse(ctx, w, response{
+				Code:    http.StatusBadRequest,
+				Error:   errTemplate,
+				Message: err.Error(),
+			})
+			return
+		}
+		ep.mtx.Lock()
+		ep.template = t
+		ep.mtx.Unlock()
+		msg = fmt.Sprintf("response template set with content type: %s", t.contentType)
+	}
+
+	ep.mtx.RLock()
+	t := ep.template
+	ep.mtx.RUnlock()
+
+	data := map[string]string{}
+	if t != nil {
+		data["template"] = t.src
+		data["contentType"] = t.contentType
+	}
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+		Data:    data,
+	})
+}
@@ -0,0 +1,244 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+)
+
+const (
+	// defaultTenantHeader is the default request header identifying the
+	// tenant of a request.
+	defaultTenantHeader = "X-Tenant-Id"
+
+	// maxTenants is the maximum amount of tenants with their own metrics,
+	// requests of other tenants are counted as tenantOther.
+	maxTenants  = 100
+	tenantOther = "other"
+)
+
+// tenantMetrics holds the request counters per tenant, exposed through expvar.
+var tenantMetrics = expvar.NewMap("tenants")
+
+// tenantKnobs holds the fault knob settings per tenant.
+type tenantKnobs map[string]map[string]string
+
+// parseTenantKnobs returns the knob settings of validated scoped faults,
+// scoped by tenant.
+func parseTenantKnobs(flags []string) tenantKnobs {
+	knobs := make(tenantKnobs)
+	for _, f := range parseScopedFaults(flags) {
+		knobs.set(f.scope, f.knob, f.value)
+	}
+	return knobs
+}
+
+func (t tenantKnobs) set(tenant, knob, value string) {
+	if t[tenant] == nil {
+		t[tenant] = make(map[string]string)
+	}
+	t[tenant][knob] = value
+}
+
+// tenantStats keeps the metrics of a bounded amount of tenants.
+type tenantStats struct {
+	mtx     sync.Mutex
+	tenants map[string]*expvar.Map
+}
+
+// get returns the metrics of the tenant, or of tenantOther once the maximum
+// amount of tenants is tracked.
+func (s *tenantStats) get(tenant string) *expvar.Map {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.tenants == nil {
+		s.tenants = make(map[string]*expvar.Map)
+	}
+	if m, ok := s.tenants[tenant]; ok {
+		return m
+	}
+	if len(s.tenants) >= maxTenants {
+		tenant = tenantOther
+		if m, ok := s.tenants[tenant]; ok {
+			return m
+		}
+	}
+	m := new(expvar.Map).Init()
+	s.tenants[tenant] = m
+	tenantMetrics.Set(tenant, m)
+	return m
+}
+
+// snapshot returns the metrics of all tenants.
+func (s *tenantStats) snapshot() map[string]json.RawMessage {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	stats := make(map[string]json.RawMessage, len(s.tenants))
+	for tenant, m := range s.tenants {
+		stats[tenant] = json.RawMessage(m.String())
+	}
+	return stats
+}
+
+// tenantWriter records the status code of the response.
+type tenantWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (t *tenantWriter) WriteHeader(code int) {
+	if t.code == 0 {
+		t.code = code
+	}
+	t.ResponseWriter.WriteHeader(code)
+}
+
+func (t *tenantWriter) Write(b []byte) (int, error) {
+	if t.code == 0 {
+		t.code = http.StatusOK
+	}
+	return t.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher.
+func (t *tenantWriter) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (t *tenantWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := t.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// tenants tags the server span with the tenant of the request, applies the
+// fault knobs set for the tenant and counts its requests, responses by status
+// class and latency. Knobs set by the topology config header or proxy path
+// directives override those of the tenant.
+func (ep *Endpoints) tenants(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := r.Header.Get(ep.tenantHeader)
+		if ep.tenantHeader == "" || tenant == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		zipkin.SpanOrNoopFromContext(r.Context()).Tag("tenant", tenant)
+
+		ep.mtx.RLock()
+		knobs := make(topoKnobs, len(ep.tenantKnobs[tenant]))
+		for k, v := range ep.tenantKnobs[tenant] {
+			knobs[k] = v
+		}
+		ep.mtx.RUnlock()
+		if len(knobs) > 0 {
+			for k, v := range topoKnobsFromContext(r.Context()) {
+				knobs[k] = v
+			}
+			r = r.WithContext(context.WithValue(r.Context(), topoConfigKey{}, knobs))
+		}
+
+		m := ep.tenantStats.get(tenant)
+		m.Add("requests", 1)
+		m.Add("inflight", 1)
+		start := time.Now()
+		tw := &tenantWriter{ResponseWriter: w}
+		defer func() {
+			m.Add("inflight", -1)
+			m.AddFloat("latency_seconds", time.Since(start).Seconds())
+			if tw.code != 0 {
+				m.Add("responses_"+strconv.Itoa(tw.code/100)+"xx", 1)
+			}
+		}()
+		next.ServeHTTP(tw, r)
+	})
+}
+
+// tenantFaults allows one to list the fault knobs and metrics per tenant and
+// to set or reset the knobs of a tenant at runtime, e.g. to turn a tenant into
+// a noisy neighbor or to break the SLO of a single tenant.
+//
+// Example paths:
+//
+//	/admin/tenants                      list knobs and metrics per tenant
+//	/admin/tenant/acme/latency/500ms    slow down requests of tenant acme
+//	/admin/tenant/acme/errors/20        fail 20% of the requests of acme
+//	/admin/tenant/acme/reset            remove the knobs of acme
+func (ep *Endpoints) tenantFaults(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	tenant, knob, value := vars["tenant"], vars["knob"], vars["value"]
+
+	var msg string
+	switch {
+	case tenant == "":
+	case knob == "":
+		ep.mtx.Lock()
+		delete(ep.tenantKnobs, tenant)
+		ep.mtx.Unlock()
+		msg = fmt.Sprintf("knobs of tenant %s reset", tenant)
+	default:
+		if err := validateKnob(knob, value); err != nil {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: err.(pkg.Error),
+			})
+			return
+		}
+		ep.mtx.Lock()
+		ep.tenantKnobs.set(tenant, knob, value)
+		ep.mtx.Unlock()
+		msg = fmt.Sprintf("%s set to: %s for tenant %s", knob, value, tenant)
+	}
+
+	ep.mtx.RLock()
+	knobs := make(map[string][]string, len(ep.tenantKnobs))
+	for t, k := range ep.tenantKnobs {
+		for name, v := range k {
+			knobs[t] = append(knobs[t], name+"="+v)
+		}
+		sort.Strings(knobs[t])
+	}
+	ep.mtx.RUnlock()
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+		Data: map[string]interface{}{
+			"header":  ep.tenantHeader,
+			"knobs":   knobs,
+			"metrics": ep.tenantStats.snapshot(),
+		},
+	})
+}
@@ -0,0 +1,83 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTenants(t *testing.T) {
+	ep := &Endpoints{
+		tenantHeader: defaultTenantHeader,
+		tenantKnobs:  parseTenantKnobs([]string{"acme:latency=200ms", "acme:errors=10"}),
+	}
+	var knobs topoKnobs
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		knobs = topoKnobsFromContext(r.Context())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	serve := func(tenant string, cfg topoKnobs) {
+		knobs = nil
+		req := httptest.NewRequest("GET", "/", nil)
+		if tenant != "" {
+			req.Header.Set(defaultTenantHeader, tenant)
+		}
+		if cfg != nil {
+			req = req.WithContext(context.WithValue(req.Context(), topoConfigKey{}, cfg))
+		}
+		ep.tenants(http.HandlerFunc(handler)).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve("acme", topoKnobs{"errors": "50"})
+	if knobs["latency"] != "200ms" || knobs["errors"] != "50" {
+		t.Errorf("expected tenant latency and header errors, got %v", knobs)
+	}
+	serve("other-tenant", nil)
+	if len(knobs) != 0 {
+		t.Errorf("expected no knobs for other tenant, got %v", knobs)
+	}
+	serve("", nil)
+
+	m := ep.tenantStats.get("acme")
+	if v := m.Get("requests"); v == nil || v.String() != "1" {
+		t.Errorf("expected 1 request for acme, got %v", v)
+	}
+	if v := m.Get("responses_5xx"); v == nil || v.String() != "1" {
+		t.Errorf("expected 1 5xx response for acme, got %v", v)
+	}
+	if v := m.Get("inflight"); v == nil || v.String() != "0" {
+		t.Errorf("expected no requests in flight for acme, got %v", v)
+	}
+	if n := len(ep.tenantStats.snapshot()); n != 2 {
+		t.Errorf("expected metrics for 2 tenants, got %d", n)
+	}
+}
+
+func TestTenantStatsBounded(t *testing.T) {
+	var s tenantStats
+	for i := 0; i < maxTenants+10; i++ {
+		s.get(fmt.Sprintf("bounded-%d", i))
+	}
+	if s.get("bounded-new") != s.get(tenantOther) {
+		t.Error("expected tenants beyond the maximum to be counted as other")
+	}
+	if n := len(s.snapshot()); n != maxTenants+1 {
+		t.Errorf("expected %d tracked tenants, got %d", maxTenants+1, n)
+	}
+}
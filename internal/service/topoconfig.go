@@ -0,0 +1,198 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+)
+
+const (
+	// defaultTopoConfigHeader is the default request header holding fault
+	// knobs for a single request.
+	defaultTopoConfigHeader = "X-Topo-Config"
+	// topoHopHeader holds the fault knobs of the inline proxy path directives,
+	// which only apply to the hop receiving them.
+	topoHopHeader = "X-Topo-Hop-Config"
+)
+
+type topoConfigKey struct{}
+
+// topoKnob holds a fault knob setting of the topology config header, which
+// applies to all services in the chain or, if scoped, to the named service
+// only.
+type topoKnob struct {
+	scope string
+	knob  string
+	value string
+}
+
+// topoConfig holds the fault knob settings of the topology config header.
+type topoConfig []topoKnob
+
+// parseTopoConfig parses a topology config header value in the form of
+// "knob=value;svcb.knob=value", where knob is one of errors, headers, latency
+// or badencoding.
+func parseTopoConfig(s string) (topoConfig, error) {
+	var cfg topoConfig
+	for _, field := range strings.Split(s, ";") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, errTopoConfig
+		}
+		k := topoKnob{knob: strings.TrimSpace(kv[0]), value: strings.TrimSpace(kv[1])}
+		if idx := strings.LastIndex(k.knob, "."); idx >= 0 {
+			k.scope, k.knob = k.knob[:idx], k.knob[idx+1:]
+			if k.scope == "" {
+				return nil, errTopoConfig
+			}
+		}
+		if err := validateKnob(k.knob, k.value); err != nil {
+			return nil, err
+		}
+		cfg = append(cfg, k)
+	}
+	return cfg, nil
+}
+
+// String encodes the topology config for the next hop.
+func (c topoConfig) String() string {
+	fields := make([]string, 0, len(c))
+	for _, k := range c {
+		if k.scope != "" {
+			fields = append(fields, k.scope+"."+k.knob+"="+k.value)
+		} else {
+			fields = append(fields, k.knob+"="+k.value)
+		}
+	}
+	return strings.Join(fields, ";")
+}
+
+// resolve returns the knob values applying to the provided service, scoped
+// settings overriding the unscoped ones.
+func (c topoConfig) resolve(service string) map[string]string {
+	knobs := make(map[string]string)
+	for _, k := range c {
+		if k.scope == "" {
+			knobs[k.knob] = k.value
+		}
+	}
+	for _, k := range c {
+		if k.scope == service {
+			knobs[k.knob] = k.value
+		}
+	}
+	return knobs
+}
+
+// topoKnobs holds the resolved knob values of the current request.
+type topoKnobs map[string]string
+
+// topoKnobsFromContext returns the knob values set for the current request by
+// the topology config header.
+func topoKnobsFromContext(ctx context.Context) topoKnobs {
+	knobs, _ := ctx.Value(topoConfigKey{}).(topoKnobs)
+	return knobs
+}
+
+// percentage overrides p with the knob value, if set.
+func (k topoKnobs) percentage(knob string, p *int32) {
+	if v, ok := k[knob]; ok {
+		n, _ := strconv.Atoi(v) // validated in parseTopoConfig
+		*p = int32(n)
+	}
+}
+
+// duration overrides d with the knob value, if set.
+func (k topoKnobs) duration(knob string, d *time.Duration) {
+	if v, ok := k[knob]; ok {
+		*d, _ = parseDuration(v) // validated in parseTopoConfig
+	}
+}
+
+// parseHopDirectives splits the inline directives from a proxy path service,
+// e.g. svcb[latency=1s,errors=20], returning the service and the directives.
+func parseHopDirectives(s string) (string, topoConfig, error) {
+	idx := strings.Index(s, "[")
+	if idx < 0 {
+		return s, nil, nil
+	}
+	if !strings.HasSuffix(s, "]") {
+		return "", nil, errHopDirectives
+	}
+	cfg, err := parseTopoConfig(strings.Replace(s[idx+1:len(s)-1], ",", ";", -1))
+	if err != nil {
+		return "", nil, err
+	}
+	return s[:idx], cfg, nil
+}
+
+// topoConfig applies the fault knobs of the topology config header to the
+// current request only, allowing clients to drive a scenario across a whole
+// chain of services. The header is forwarded to the next hop when proxying.
+// Knobs set by the proxy path directives of the previous hop apply to this hop
+// only and override those of the topology config header.
+func (ep *Endpoints) topoConfig(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			cfg, hop topoConfig
+			err      error
+		)
+		if value := r.Header.Get(ep.topoConfigHeader); ep.topoConfigHeader != "" && value != "" {
+			if cfg, err = parseTopoConfig(value); err == nil {
+				// forward the normalized config to the next hop
+				r.Header.Set(ep.topoConfigHeader, cfg.String())
+			}
+		}
+		if value := r.Header.Get(topoHopHeader); value != "" && err == nil {
+			hop, err = parseTopoConfig(value)
+			r.Header.Del(topoHopHeader)
+		}
+		if err != nil {
+			ep.writeResponse(r.Context(), w, response{
+				Code:  http.StatusBadRequest,
+				Error: err.(pkg.Error),
+			})
+			return
+		}
+
+		knobs := cfg.resolve(ep.ServiceName)
+		for k, v := range hop.resolve(ep.ServiceName) {
+			knobs[k] = v
+		}
+		if len(knobs) > 0 {
+			span := zipkin.SpanOrNoopFromContext(r.Context())
+			if len(cfg) > 0 {
+				span.Tag("topo.config", cfg.String())
+			}
+			if len(hop) > 0 {
+				span.Tag("topo.hop", hop.String())
+			}
+			r = r.WithContext(context.WithValue(r.Context(), topoConfigKey{}, topoKnobs(knobs)))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
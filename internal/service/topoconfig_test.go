@@ -0,0 +1,79 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTopoConfig(t *testing.T) {
+	cfg, err := parseTopoConfig(" errors=50; svcb.latency=200ms;svc.ns.errors=0;")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s := cfg.String(); s != "errors=50;svcb.latency=200ms;svc.ns.errors=0" {
+		t.Errorf("unexpected encoding: %s", s)
+	}
+
+	tests := []struct {
+		service  string
+		expected map[string]string
+	}{
+		{"svca", map[string]string{"errors": "50"}},
+		{"svcb", map[string]string{"errors": "50", "latency": "200ms"}},
+		{"svc.ns", map[string]string{"errors": "0"}},
+	}
+	for _, tt := range tests {
+		if knobs := cfg.resolve(tt.service); !reflect.DeepEqual(knobs, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.service, tt.expected, knobs)
+		}
+	}
+
+	for _, s := range []string{"errors", "errors=101", ".errors=1", "svcb.latency=x", "retries=1"} {
+		if _, err := parseTopoConfig(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestParseHopDirectives(t *testing.T) {
+	tests := []struct {
+		in         string
+		service    string
+		directives string
+	}{
+		{"svcb", "svcb", ""},
+		{"svcb:8000[latency=1s,errors=20]", "svcb:8000", "latency=1s;errors=20"},
+		{"svcb=80,svcc=20[errors=10]", "svcb=80,svcc=20", "errors=10"},
+		{"svcb[]", "svcb", ""},
+	}
+	for _, tt := range tests {
+		service, directives, err := parseHopDirectives(tt.in)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.in, err)
+		}
+		if service != tt.service || directives.String() != tt.directives {
+			t.Errorf("%s: expected %s %q, got %s %q", tt.in, tt.service,
+				tt.directives, service, directives.String())
+		}
+	}
+
+	for _, s := range []string{"svcb[errors=20", "svcb[errors=x]", "svcb[latency]"} {
+		if _, _, err := parseHopDirectives(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
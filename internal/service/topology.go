@@ -0,0 +1,228 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// topologyWindow is the period in which downstream calls are considered
+	// recent enough to be part of the live topology.
+	topologyWindow = 5 * time.Minute
+	// maxTopologyDepth limits the recursion when assembling the topology.
+	maxTopologyDepth = 10
+)
+
+// topologyEdge holds the call statistics from a service instance to a
+// downstream service.
+type topologyEdge struct {
+	Instance string    `json:"instance"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Success  int64     `json:"success"`
+	Errors   int64     `json:"errors"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// topologyGraph is the live topology as observed by the services themselves.
+type topologyGraph struct {
+	Nodes []string        `json:"nodes"`
+	Edges []*topologyEdge `json:"edges"`
+}
+
+// callStats keeps track of the downstream services called by this instance.
+type callStats struct {
+	mtx   sync.Mutex
+	edges map[string]*topologyEdge
+}
+
+func newCallStats() *callStats {
+	return &callStats{edges: make(map[string]*topologyEdge)}
+}
+
+// record registers the outcome of a downstream call.
+func (c *callStats) record(instance, from, to string, res *http.Response, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	e, ok := c.edges[to]
+	if !ok {
+		e = &topologyEdge{Instance: instance, From: from, To: to}
+		c.edges[to] = e
+	}
+	if err != nil || res.StatusCode >= http.StatusInternalServerError {
+		e.Errors++
+	} else {
+		e.Success++
+	}
+	e.LastSeen = time.Now()
+}
+
+// recent returns the downstream calls seen within the topology window.
+func (c *callStats) recent() []topologyEdge {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	cutoff := time.Now().Add(-topologyWindow)
+	edges := make([]topologyEdge, 0, len(c.edges))
+	for to, e := range c.edges {
+		if e.LastSeen.Before(cutoff) {
+			delete(c.edges, to)
+			continue
+		}
+		edges = append(edges, *e)
+	}
+	return edges
+}
+
+// topology reports the downstream services recently called by this instance.
+// If a depth is provided, the downstream services are queried recursively and
+// their topologies are merged into a single graph holding the edges of each
+// instance that was reached.
+//
+// Example paths:
+//
+//	/topology            downstream calls of this instance
+//	/topology?depth=5    topology up to 5 hops deep
+func (ep *Endpoints) topology(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	depth := 0
+	if d := r.URL.Query().Get("depth"); d != "" {
+		var err error
+		if depth, err = strconv.Atoi(d); err != nil || depth < 0 {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errCount,
+			})
+			return
+		}
+		if depth > maxTopologyDepth {
+			depth = maxTopologyDepth
+		}
+	}
+
+	var (
+		edges = ep.calls.recent()
+		mtx   sync.Mutex
+		graph = topologyGraph{
+			Nodes: []string{ep.ServiceName},
+			Edges: make([]*topologyEdge, 0, len(edges)),
+		}
+		wg sync.WaitGroup
+	)
+	for i := range edges {
+		e := edges[i]
+		graph.Edges = append(graph.Edges, &e)
+		if depth == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sub, err := ep.fetchTopology(r, e.To, depth-1)
+			if err != nil {
+				// downstream is unreachable or not a topology tester
+				return
+			}
+			mtx.Lock()
+			graph.merge(sub)
+			mtx.Unlock()
+		}()
+	}
+	wg.Wait()
+	graph.normalize()
+
+	ep.writeResponse(ctx, w, response{
+		Code: http.StatusOK,
+		Data: graph,
+	})
+}
+
+// fetchTopology retrieves the topology as seen by a downstream service.
+func (ep *Endpoints) fetchTopology(r *http.Request, host string, depth int) (*topologyGraph, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet,
+		fmt.Sprintf("http://%s/topology?depth=%d", host, depth), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := ep.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	var sub struct {
+		Data topologyGraph `json:"data"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&sub); err != nil {
+		return nil, err
+	}
+	return &sub.Data, nil
+}
+
+// merge adds the nodes and edges of the provided graph. Edges of instances
+// which are reachable through multiple paths are only added once.
+func (g *topologyGraph) merge(sub *topologyGraph) {
+	g.Nodes = append(g.Nodes, sub.Nodes...)
+	for _, e := range sub.Edges {
+		found := false
+		for _, existing := range g.Edges {
+			if existing.Instance == e.Instance && existing.From == e.From &&
+				existing.To == e.To {
+				found = true
+				break
+			}
+		}
+		if !found {
+			g.Edges = append(g.Edges, e)
+		}
+	}
+}
+
+// normalize adds the edge endpoints as nodes, as not all downstream services
+// need to be topology testers, and deduplicates and sorts the graph.
+func (g *topologyGraph) normalize() {
+	for _, e := range g.Edges {
+		g.Nodes = append(g.Nodes, e.From, e.To)
+	}
+	seen := make(map[string]bool, len(g.Nodes))
+	nodes := g.Nodes[:0]
+	for _, n := range g.Nodes {
+		if !seen[n] {
+			seen[n] = true
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Strings(nodes)
+	g.Nodes = nodes
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		if g.Edges[i].To != g.Edges[j].To {
+			return g.Edges[i].To < g.Edges[j].To
+		}
+		return g.Edges[i].Instance < g.Edges[j].Instance
+	})
+}
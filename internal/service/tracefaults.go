@@ -0,0 +1,84 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// tracingFaults allows one to inspect and adjust the faults injected on the
+// span reporting path of this service at runtime, so "missing spans" symptoms
+// can be practiced against a known ground truth.
+//
+// Example paths:
+//
+//	/admin/tracing/faults                 show the current settings
+//	/admin/tracing/faults/drop/10         drop 10% of the spans
+//	/admin/tracing/faults/truncate/64     truncate tag values to 64 bytes
+//	/admin/tracing/faults/delay/30s       report spans 30 seconds late
+//	/admin/tracing/faults/delay/0s        report spans right away again
+func (ep *Endpoints) tracingFaults(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	faults := ep.SvcTracer.GetReporterFaults()
+
+	var msg string
+	if fault, ok := vars["fault"]; ok {
+		var err error
+		switch value := vars["value"]; fault {
+		case "drop":
+			var p float64
+			if p, err = strconv.ParseFloat(value, 64); err == nil {
+				err = faults.SetDrop(p)
+			}
+		case "truncate":
+			var n int
+			if n, err = strconv.Atoi(value); err == nil {
+				err = faults.SetTruncate(n)
+			}
+		case "delay":
+			var d time.Duration
+			if d, err = parseDuration(value); err == nil {
+				err = faults.SetDelay(d)
+			}
+		}
+		if err != nil {
+			ep.writeResponse(ctx, w, response{
+				Code:    http.StatusBadRequest,
+				Error:   errTracingFault,
+				Message: err.Error(),
+			})
+			return
+		}
+		msg = fmt.Sprintf("tracing fault %s set to: %s", fault, vars["value"])
+	}
+
+	drop, truncate, delay, pending := faults.Settings()
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+		Data: map[string]interface{}{
+			"drop":         drop,
+			"truncateTags": truncate,
+			"delay":        delay.String(),
+			"pendingSpans": pending,
+		},
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// transactionBaggage is the baggage field holding the synthetic business
+// transaction of a request, propagated as header by the Zipkin transport.
+const transactionBaggage = "baggage-transaction"
+
+// transactionMetrics holds the counters of the transactions started by this
+// service, exposed through expvar.
+var transactionMetrics = expvar.NewMap("transactions")
+
+// transactions labels requests with a synthetic business transaction, tagged
+// on the server span as transaction. Requests without a transaction in their
+// baggage start one, picked from the weighted transactions of this service.
+func (ep *Endpoints) transactions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := zipkin.SpanOrNoopFromContext(r.Context())
+		name := r.Header.Get(transactionBaggage)
+		if name == "" {
+			ep.mtx.RLock()
+			targets := ep.txTargets
+			ep.mtx.RUnlock()
+			if len(targets) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			name = pickWeighted(ep.rand, targets)
+			if baggage := span.Context().Baggage; baggage != nil {
+				baggage.Set(transactionBaggage, name)
+			}
+			transactionMetrics.Add(name, 1)
+		}
+		span.Tag("transaction", name)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// transactionState allows one to inspect the transactions started by this
+// service and to change or disable their weighted distribution at runtime.
+//
+// Example paths:
+//
+//	/admin/transactions                              show weights and counts
+//	/admin/transactions/weights/checkout=2,search=7  set the distribution
+//	/admin/transactions/disable                      start no transactions
+func (ep *Endpoints) transactionState(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	var msg string
+	if v, ok := vars["weights"]; ok {
+		targets, err := parseSplit(v)
+		if err != nil {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusBadRequest,
+				Error: errTransactions,
+			})
+			return
+		}
+		ep.mtx.Lock()
+		ep.txTargets = targets
+		ep.mtx.Unlock()
+		msg = fmt.Sprintf("transactions set to: %s", v)
+	} else if _, ok := vars["action"]; ok {
+		ep.mtx.Lock()
+		ep.txTargets = nil
+		ep.mtx.Unlock()
+		msg = "transactions disabled"
+	}
+
+	ep.mtx.RLock()
+	weights := make([]string, 0, len(ep.txTargets))
+	for _, t := range ep.txTargets {
+		weights = append(weights, t.host+"="+strconv.Itoa(t.weight))
+	}
+	ep.mtx.RUnlock()
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+		Data: map[string]interface{}{
+			"weights": weights,
+			"started": json.RawMessage(transactionMetrics.String()),
+		},
+	})
+}
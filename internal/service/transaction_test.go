@@ -0,0 +1,81 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openzipkin/zipkin-go"
+	zmw "github.com/openzipkin/zipkin-go/middleware/http"
+	"github.com/openzipkin/zipkin-go/propagation/baggage"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+)
+
+func TestTransactions(t *testing.T) {
+	rec := recorder.NewReporter()
+	tracer, err := zipkin.NewTracer(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var downstream []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstream = r.Header.Values(transactionBaggage)
+	}))
+	defer backend.Close()
+	rt, err := zmw.NewTransport(tracer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: rt}
+
+	ep := &Endpoints{txTargets: []splitTarget{{host: "checkout", weight: 1}, {host: "search"}}, rand: newFaultRand(1)}
+	handler := zmw.NewServerMiddleware(tracer, zmw.EnableBaggage(baggage.New(transactionBaggage)))(
+		ep.transactions(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req, _ := http.NewRequestWithContext(r.Context(), "GET", backend.URL, nil)
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = res.Body.Close()
+		})),
+	)
+	serve := func(transaction string) string {
+		_ = rec.Flush()
+		req := httptest.NewRequest("GET", "/", nil)
+		if transaction != "" {
+			req.Header.Set(transactionBaggage, transaction)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		for _, s := range rec.Flush() {
+			if s.Kind == "SERVER" {
+				return s.Tags["transaction"]
+			}
+		}
+		return ""
+	}
+
+	if tag := serve(""); tag != "checkout" || len(downstream) != 1 || downstream[0] != "checkout" {
+		t.Errorf("expected checkout transaction to be started and propagated, got %q and %v", tag, downstream)
+	}
+	if tag := serve("login"); tag != "login" || len(downstream) != 1 || downstream[0] != "login" {
+		t.Errorf("expected login transaction to be kept, got %q and %v", tag, downstream)
+	}
+	ep.txTargets = nil
+	if tag := serve(""); tag != "" || len(downstream) != 0 {
+		t.Errorf("expected no transaction, got %q and %v", tag, downstream)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	zmw "github.com/openzipkin/zipkin-go/middleware/http"
+
+	"github.com/basvanbeek/topology-tester/pkg/sockopt"
+	"github.com/basvanbeek/topology-tester/pkg/zipkin"
+)
+
+// transportConfig holds the connection pool settings of the outbound
+// transport used by the proxy and client handlers.
+type transportConfig struct {
+	MaxIdleConns      int
+	MaxConnsPerHost   int
+	IdleConnTimeout   time.Duration
+	DisableKeepAlives bool
+	Socket            sockopt.Options
+	EgressProxy       *url.URL
+}
+
+// roundTripperFunc allows a function to be used as http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper.
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// newTransport creates an instrumented outbound transport using the provided
+// connection pool settings, optionally routing calls through an egress proxy.
+// Client spans are tagged with connection reuse details.
+func (ep *Endpoints) newTransport(cfg transportConfig) (*http.Transport, http.RoundTripper, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.MaxIdleConns = cfg.MaxIdleConns
+	base.MaxConnsPerHost = cfg.MaxConnsPerHost
+	base.IdleConnTimeout = cfg.IdleConnTimeout
+	base.DisableKeepAlives = cfg.DisableKeepAlives
+	dialer := cfg.Socket.Dialer(30 * time.Second)
+	base.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err == nil {
+			cfg.Socket.Apply(conn)
+		}
+		return conn, err
+	}
+	if cfg.EgressProxy != nil {
+		setEgressProxy(base, cfg.EgressProxy, base.DialContext)
+	}
+
+	var next http.RoundTripper = base
+	if ep.SvcTracer.CloudTraceContext {
+		next = zipkin.InjectCloudTraceContext(next)
+	}
+	if ep.SvcTracer.XRayTraceHeader {
+		next = zipkin.InjectXRayTraceHeader(next)
+	}
+	if ep.SvcTracer.ElasticTraceparent {
+		next = zipkin.InjectElasticTraceparent(next)
+	}
+	rt, err := zmw.NewTransport(ep.tracer,
+		zmw.RoundTripper(next),
+		zmw.TransportTrace(true),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return base, rt, nil
+}
+
+// roundTripper returns the current outbound transport, recording the outcome of
+// downstream calls for the live topology and the clock skew between the hops.
+func (ep *Endpoints) roundTripper() http.RoundTripper {
+	ep.mtx.RLock()
+	rt := ep.transport
+	ep.mtx.RUnlock()
+
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path == "/topology" {
+			return rt.RoundTrip(r)
+		}
+		// prefer the logical service name over a discovered endpoint
+		host := r.Host
+		if host == "" {
+			host = r.URL.Host
+		}
+		res, err := ep.timeHop(rt, r, host)
+		ep.calls.record(ep.instance, ep.ServiceName, host, res, err)
+		return res, err
+	})
+}
+
+// outboundTransport allows one to inspect and change the connection pool
+// settings of the outbound transport. Changing a setting replaces the
+// transport, closing the idle connections of the previous one.
+//
+// Example paths:
+//
+//	/admin/transport                        show the current settings
+//	/admin/transport/maxidleconns/10        set MaxIdleConns
+//	/admin/transport/maxconnsperhost/2      set MaxConnsPerHost
+//	/admin/transport/idletimeout/30s        set the idle connection timeout
+//	/admin/transport/keepalive/off          disable keep-alive connections
+func (ep *Endpoints) outboundTransport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	ep.mtx.RLock()
+	cfg := ep.transportCfg
+	ep.mtx.RUnlock()
+
+	var egressProxy string
+	if cfg.EgressProxy != nil {
+		egressProxy = cfg.EgressProxy.Redacted()
+	}
+
+	var msg string
+	if setting, ok := vars["setting"]; ok {
+		var err error
+		value := vars["value"]
+		switch setting {
+		case "maxidleconns":
+			cfg.MaxIdleConns, err = strconv.Atoi(value)
+			if err == nil && cfg.MaxIdleConns < 0 {
+				err = errConnections
+			}
+		case "maxconnsperhost":
+			cfg.MaxConnsPerHost, err = strconv.Atoi(value)
+			if err == nil && cfg.MaxConnsPerHost < 0 {
+				err = errConnections
+			}
+		case "idletimeout":
+			cfg.IdleConnTimeout, err = parseDuration(value)
+			if err == nil && cfg.IdleConnTimeout < 0 {
+				err = errDuration
+			}
+		case "keepalive":
+			var keepAlive bool
+			keepAlive, err = parseBool(value)
+			cfg.DisableKeepAlives = !keepAlive
+		}
+		if err != nil {
+			ep.writeResponse(ctx, w, response{
+				Code:    http.StatusBadRequest,
+				Error:   errTransport,
+				Message: err.Error(),
+			})
+			return
+		}
+		base, rt, err := ep.newTransport(cfg)
+		if err != nil {
+			ep.writeResponse(ctx, w, response{
+				Code:  http.StatusInternalServerError,
+				Error: errInternal,
+			})
+			return
+		}
+		ep.mtx.Lock()
+		prev := ep.baseTransport
+		ep.transportCfg, ep.baseTransport, ep.transport = cfg, base, rt
+		ep.mtx.Unlock()
+		prev.CloseIdleConnections()
+		msg = fmt.Sprintf("transport %s set to: %s", setting, value)
+	}
+
+	ep.writeResponse(ctx, w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+		Data: map[string]interface{}{
+			"maxIdleConns":      cfg.MaxIdleConns,
+			"maxConnsPerHost":   cfg.MaxConnsPerHost,
+			"idleConnTimeout":   cfg.IdleConnTimeout.String(),
+			"disableKeepAlives": cfg.DisableKeepAlives,
+			"reusePort":         cfg.Socket.ReusePort,
+			"noDelay":           cfg.Socket.NoDelay,
+			"tcpKeepAlive":      cfg.Socket.KeepAlive.String(),
+			"egressProxy":       egressProxy,
+		},
+	})
+}
@@ -0,0 +1,31 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	_ "embed" // embeds the dashboard
+	"net/http"
+)
+
+//go:embed ui/index.html
+var uiIndex []byte
+
+// ui serves a small single page dashboard which allows one to inspect and set
+// the runtime settings of this instance and to fire test chains, avoiding the
+// need to curl long paths during live demos.
+func (ep *Endpoints) ui(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(uiIndex)
+}
@@ -0,0 +1,42 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// headerVersion is the response header holding the service version.
+const headerVersion = "X-Service-Version"
+
+// matchesVersion returns true if the scope matches the version of this
+// service.
+func (ep *Endpoints) matchesVersion(scope string) bool {
+	return scope == ep.version
+}
+
+// versionTagger labels server spans and responses with the version of this
+// service, so canary deployments can be told apart in the resulting traces.
+func (ep *Endpoints) versionTagger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ep.version != "" {
+			zipkin.SpanOrNoopFromContext(r.Context()).Tag("service.version", ep.version)
+			w.Header().Set(headerVersion, ep.version)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
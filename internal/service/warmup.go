@@ -0,0 +1,140 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openzipkin/zipkin-go"
+)
+
+// warmup emulates an instance warming up its JIT and caches, adding latency and
+// errors which decrease linearly to none over the warm-up window. The window
+// is a duration or amount of requests, whichever passes first, and starts with
+// the first request.
+type warmup struct {
+	mtx      sync.Mutex
+	duration time.Duration
+	requests int64
+	latency  time.Duration
+	errors   int32
+	start    time.Time
+	served   int64
+}
+
+// warmupStats holds the settings and progress of the warm-up.
+type warmupStats struct {
+	Duration string  `json:"duration"`
+	Requests int64   `json:"requests"`
+	Latency  string  `json:"latency"`
+	Errors   int32   `json:"errors"`
+	Served   int64   `json:"served"`
+	Progress float64 `json:"progress"`
+}
+
+// progress returns the fraction of the warm-up window which has passed. The
+// caller must hold the mutex.
+func (w *warmup) progress(now time.Time) float64 {
+	if w.duration <= 0 && w.requests <= 0 {
+		return 1
+	}
+	var p float64
+	if w.duration > 0 && !w.start.IsZero() {
+		p = float64(now.Sub(w.start)) / float64(w.duration)
+	}
+	if w.requests > 0 {
+		p = math.Max(p, float64(w.served)/float64(w.requests))
+	}
+	return math.Min(p, 1)
+}
+
+// next registers a request, returning the latency and error percentage to add
+// and the progress of the warm-up window. Once warmed up, ok is false.
+func (w *warmup) next(now time.Time) (latency time.Duration, errors int32, progress float64, ok bool) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if progress = w.progress(now); progress >= 1 {
+		return 0, 0, 1, false
+	}
+	if w.start.IsZero() {
+		w.start = now
+	}
+	w.served++
+	remaining := 1 - progress
+	latency = time.Duration(float64(w.latency) * remaining)
+	errors = int32(math.Round(float64(w.errors) * remaining))
+	return latency, errors, progress, true
+}
+
+// restart starts a new warm-up window with the next request.
+func (w *warmup) restart() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.start, w.served = time.Time{}, 0
+}
+
+func (w *warmup) stats() warmupStats {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return warmupStats{
+		Duration: w.duration.String(),
+		Requests: w.requests,
+		Latency:  w.latency.String(),
+		Errors:   w.errors,
+		Served:   w.served,
+		Progress: w.progress(time.Now()),
+	}
+}
+
+// warmingUp adds the warm-up latency and error percentage to the provided
+// values, tagging the server span with the warm-up progress.
+func (ep *Endpoints) warmingUp(ctx context.Context, d *time.Duration, e *int32) {
+	latency, errors, progress, ok := ep.warm.next(time.Now())
+	if !ok {
+		return
+	}
+	*d += latency
+	if *e += errors; *e > 100 {
+		*e = 100
+	}
+	zipkin.SpanOrNoopFromContext(ctx).Tag("warmup.progress", fmt.Sprintf("%.0f%%", progress*100))
+}
+
+// warmupState allows one to inspect the warm-up progress of this instance and
+// restart it, emulating a fresh instance without restarting the pod.
+//
+// Example paths:
+//
+//	/admin/warmup              show the warm-up settings and progress
+//	/admin/warmup/restart      start warming up again with the next request
+func (ep *Endpoints) warmupState(w http.ResponseWriter, r *http.Request) {
+	var msg string
+	if _, ok := mux.Vars(r)["action"]; ok {
+		ep.warm.restart()
+		msg = "warm-up restarted"
+	}
+
+	ep.writeResponse(r.Context(), w, response{
+		Code:    http.StatusOK,
+		Message: msg,
+		Data:    ep.warm.stats(),
+	})
+}
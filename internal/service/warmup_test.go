@@ -0,0 +1,56 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWarmup(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name     string
+		w        *warmup
+		at       time.Duration
+		requests int
+		latency  time.Duration
+		errors   int32
+		ok       bool
+	}{
+		{"disabled", &warmup{latency: time.Second, errors: 50}, 0, 1, 0, 0, false},
+		{"first request", &warmup{duration: time.Minute, latency: time.Second, errors: 50}, 0, 1, time.Second, 50, true},
+		{"halfway duration", &warmup{duration: time.Minute, latency: time.Second, errors: 50}, 30 * time.Second, 2, 500 * time.Millisecond, 25, true},
+		{"duration passed", &warmup{duration: time.Minute, latency: time.Second, errors: 50}, time.Minute, 2, 0, 0, false},
+		{"halfway requests", &warmup{requests: 4, latency: time.Second, errors: 50}, 0, 3, 500 * time.Millisecond, 25, true},
+		{"requests passed", &warmup{requests: 4, latency: time.Second, errors: 50}, 0, 5, 0, 0, false},
+		{"requests first", &warmup{duration: time.Hour, requests: 2, latency: time.Second}, 0, 3, 0, 0, false},
+	}
+	for _, tt := range tests {
+		var (
+			latency time.Duration
+			errors  int32
+			ok      bool
+		)
+		latency, errors, _, ok = tt.w.next(start)
+		for i := 1; i < tt.requests; i++ {
+			latency, errors, _, ok = tt.w.next(start.Add(tt.at))
+		}
+		if latency != tt.latency || errors != tt.errors || ok != tt.ok {
+			t.Errorf("%s: expected %s, %d%% (%t), got %s, %d%% (%t)",
+				tt.name, tt.latency, tt.errors, tt.ok, latency, errors, ok)
+		}
+	}
+}
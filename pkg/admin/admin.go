@@ -0,0 +1,200 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package admin provides an optional HTTP listener exposing runtime
+// diagnostics, kept separate from the main traffic port so profiling does not
+// skew the topology under test.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/tetratelabs/multierror"
+	"github.com/tetratelabs/run"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+)
+
+const (
+	flagListenAddress = "admin-listen-address"
+)
+
+var (
+	_ run.Config    = (*Service)(nil)
+	_ run.PreRunner = (*Service)(nil)
+	_ run.Service   = (*Service)(nil)
+)
+
+// Service implements a run.Group compatible admin HTTP Server. If no listen
+// address is configured the admin server is disabled.
+type Service struct {
+	ListenAddress string
+
+	mux    *http.ServeMux
+	server *http.Server
+	l      net.Listener
+	closer chan struct{}
+}
+
+// Name implements run.Unit.
+func (s *Service) Name() string {
+	return "admin"
+}
+
+// FlagSet implements run.Config.
+func (s *Service) FlagSet() *run.FlagSet {
+	flags := run.NewFlagSet("Admin server options")
+
+	flags.StringVar(
+		&s.ListenAddress,
+		flagListenAddress,
+		s.ListenAddress,
+		`Admin server listen address for pprof, expvar and runtime stats, e.g. ":9000"`)
+
+	return flags
+}
+
+// Validate implements run.Config.
+func (s *Service) Validate() error {
+	var mErr error
+
+	if s.ListenAddress != "" {
+		if _, _, err := net.SplitHostPort(s.ListenAddress); err != nil {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, flagListenAddress, err))
+		}
+	}
+
+	return mErr
+}
+
+// PreRun implements run.PreRunner.
+func (s *Service) PreRun() error {
+	s.closer = make(chan struct{})
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	s.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	s.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	s.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	s.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	s.mux.Handle("/debug/vars", expvar.Handler())
+	s.mux.HandleFunc("/debug/gc", gcStats)
+	s.mux.HandleFunc("/debug/buildinfo", buildInfo)
+	s.server = &http.Server{
+		Handler:     s.mux,
+		ReadTimeout: 5 * time.Second,
+		IdleTimeout: 120 * time.Second,
+	}
+	return nil
+}
+
+// Handle registers an additional handler for the provided pattern on the admin
+// server. It must be called after PreRun.
+func (s *Service) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Handler returns the admin HTTP handler, allowing it to be served on a port
+// of another HTTP server.
+func (s *Service) Handler() http.Handler {
+	return s.mux
+}
+
+// Serve implements run.Service.
+func (s *Service) Serve() (err error) {
+	if s.ListenAddress == "" {
+		// admin server is disabled
+		<-s.closer
+		return nil
+	}
+	s.l, err = net.Listen("tcp", s.ListenAddress)
+	if err != nil {
+		return err
+	}
+	return s.server.Serve(s.l)
+}
+
+// GracefulStop implements run.Service.
+func (s *Service) GracefulStop() {
+	close(s.closer)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_ = s.server.Shutdown(ctx)
+	if s.l != nil {
+		_ = s.l.Close()
+	}
+}
+
+// gcStats returns the garbage collector and memory statistics.
+func gcStats(w http.ResponseWriter, _ *http.Request) {
+	var (
+		gc  debug.GCStats
+		mem runtime.MemStats
+	)
+	debug.ReadGCStats(&gc)
+	runtime.ReadMemStats(&mem)
+
+	writeJSON(w, map[string]interface{}{
+		"numGC":        gc.NumGC,
+		"lastGC":       gc.LastGC,
+		"pauseTotal":   gc.PauseTotal.String(),
+		"heapAlloc":    mem.HeapAlloc,
+		"heapObjects":  mem.HeapObjects,
+		"heapSys":      mem.HeapSys,
+		"totalAlloc":   mem.TotalAlloc,
+		"mallocs":      mem.Mallocs,
+		"frees":        mem.Frees,
+		"numGoroutine": runtime.NumGoroutine(),
+	})
+}
+
+// buildInfo returns the build information embedded in the binary.
+func buildInfo(w http.ResponseWriter, _ *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		http.Error(w, "build info not available", http.StatusNotFound)
+		return
+	}
+	deps := make(map[string]string, len(info.Deps))
+	for _, dep := range info.Deps {
+		deps[dep.Path] = dep.Version
+	}
+	writeJSON(w, map[string]interface{}{
+		"goVersion": runtime.Version(),
+		"path":      info.Path,
+		"main":      info.Main.Version,
+		"deps":      deps,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Add("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("error while writing http response: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package buildinfo holds the build details stamped into the binaries at link
+// time, e.g.:
+//
+//	go build -ldflags "-X github.com/basvanbeek/topology-tester/pkg/buildinfo.GitSHA=$(git rev-parse HEAD)"
+package buildinfo
+
+import "runtime"
+
+// Build details set at link time. They are empty for unofficial builds.
+var (
+	GitSHA    string
+	BuildDate string
+)
+
+// Info holds the build details of the binary.
+type Info struct {
+	GitSHA    string `json:"gitSHA,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build details of the binary.
+func Get() Info {
+	return Info{
+		GitSHA:    GitSHA,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// Tags returns the build details as span tags.
+func Tags() map[string]string {
+	info := Get()
+	tags := map[string]string{"build.go": info.GoVersion}
+	if info.GitSHA != "" {
+		tags["build.sha"] = info.GitSHA
+	}
+	if info.BuildDate != "" {
+		tags["build.date"] = info.BuildDate
+	}
+	return tags
+}
@@ -0,0 +1,63 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// inflight counts the requests being served, so the amount of requests
+// drained on shutdown can be reported.
+type inflight struct {
+	active    int64
+	completed int64
+}
+
+// track wraps a handler, counting its in flight and completed requests.
+func (i *inflight) track(h http.Handler) http.Handler {
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&i.active, 1)
+		defer func() {
+			atomic.AddInt64(&i.active, -1)
+			atomic.AddInt64(&i.completed, 1)
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// DrainSummary holds the outcome of draining the in flight requests on
+// shutdown.
+type DrainSummary struct {
+	InFlight  int64
+	Drained   int64
+	Aborted   int64
+	Took      time.Duration
+	DrainTime time.Duration
+}
+
+func (d DrainSummary) log() {
+	if d.Aborted > 0 {
+		log.Printf("drained %d of %d in flight requests in %s, aborted %d after drain time of %s",
+			d.Drained, d.InFlight, d.Took.Round(time.Millisecond), d.Aborted, d.DrainTime)
+		return
+	}
+	log.Printf("drained %d in flight requests in %s", d.Drained, d.Took.Round(time.Millisecond))
+}
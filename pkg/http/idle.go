@@ -0,0 +1,88 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// idleListener holds on to a percentage of accepted connections without ever
+// reading from or writing to them, leaving them open until the server stops.
+// Connections closed by the client are never noticed, so they linger half-open
+// on the server side.
+type idleListener struct {
+	net.Listener
+	s *Service
+}
+
+// Accept implements net.Listener.
+func (l *idleListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if rand.Int31n(100) >= atomic.LoadInt32(&l.s.idle) {
+			return conn, nil
+		}
+		l.s.idleConns.hold(conn)
+	}
+}
+
+// idleConns keeps track of the connections held by the idle listener.
+type idleConns struct {
+	mtx   sync.Mutex
+	conns []net.Conn
+}
+
+func (i *idleConns) hold(conn net.Conn) {
+	i.mtx.Lock()
+	i.conns = append(i.conns, conn)
+	i.mtx.Unlock()
+}
+
+func (i *idleConns) count() int {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	return len(i.conns)
+}
+
+func (i *idleConns) release() {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	for _, conn := range i.conns {
+		_ = conn.Close()
+	}
+	i.conns = nil
+}
+
+// SetIdleConnections sets the percentage of accepted connections which are
+// held open idle instead of being served.
+func (s *Service) SetIdleConnections(percentage int32) {
+	atomic.StoreInt32(&s.idle, percentage)
+}
+
+// IdleConnections returns the number of connections currently held open idle.
+func (s *Service) IdleConnections() int {
+	return s.idleConns.count()
+}
+
+// ReleaseIdleConnections closes all connections held open idle.
+func (s *Service) ReleaseIdleConnections() {
+	s.idleConns.release()
+}
@@ -0,0 +1,203 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+)
+
+const (
+	proxyHeaderTimeout = 5 * time.Second
+
+	errProxyHeader pkg.Error = "invalid PROXY protocol header"
+)
+
+// proxySignature is the signature starting a PROXY protocol v2 header.
+var proxySignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyListener reads PROXY protocol (v1 and v2) headers from accepted
+// connections, reporting the original source address as the remote address of
+// the connection. Connections without a PROXY protocol header are served as
+// is, so health checks bypassing the load balancer keep working. Headers are
+// read on first use of the connection by the goroutine serving it, bounded by
+// proxyHeaderTimeout, so slow clients never hold up the accept loop.
+type proxyListener struct {
+	net.Listener
+}
+
+// Accept implements net.Listener.
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn is a connection with the remote address as reported by the PROXY
+// protocol header.
+type proxyConn struct {
+	net.Conn
+	r    *bufio.Reader
+	once sync.Once
+
+	// set once the header has been read
+	remote net.Addr
+	err    error
+
+	mtx      sync.Mutex
+	parsed   bool
+	deadline time.Time
+}
+
+// init consumes the PROXY protocol header if present. Connections with an
+// invalid header are closed.
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.remote, c.err = readProxyHeader(c.r)
+		if c.err != nil {
+			_ = c.Conn.Close()
+		}
+
+		// restore the read deadline set by the server, if any
+		c.mtx.Lock()
+		c.parsed = true
+		_ = c.Conn.SetReadDeadline(c.deadline)
+		c.mtx.Unlock()
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if c.init(); c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr implements net.Conn.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.init(); c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// SetDeadline implements net.Conn.
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetWriteDeadline(t); err != nil {
+		return err
+	}
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline implements net.Conn. Deadlines set while the header is being
+// read take effect once it has been read.
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.deadline = t
+	if !c.parsed {
+		return nil
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+// readProxyHeader consumes the PROXY protocol header if present, returning the
+// source address it holds.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(proxySignature))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(sig, proxySignature):
+		return readProxyV2(r)
+	case bytes.HasPrefix(sig, []byte("PROXY ")):
+		return readProxyV1(r)
+	}
+	return nil, nil
+}
+
+// readProxyV1 parses a human readable PROXY protocol v1 header, e.g.
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyV2 parses a binary PROXY protocol v2 header.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, errProxyHeader
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if hdr[12]&0x0f == 0 {
+		// LOCAL command, e.g. health checks of the proxy itself
+		return nil, nil
+	}
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		if len(payload) < 12 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:10])),
+		}, nil
+	case 2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:34])),
+		}, nil
+	}
+	// unsupported address family, keep the peer address
+	return nil, nil
+}
@@ -0,0 +1,111 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProxyListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &proxyListener{Listener: ln}
+	defer func() { _ = l.Close() }()
+
+	// a client which never sends its header must not block accepting others
+	silent, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = silent.Close() }()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+	select {
+	case conn := <-accepted:
+		defer func() { _ = conn.Close() }()
+	case <-time.After(time.Second):
+		t.Fatal("expected Accept not to wait for the PROXY header")
+	}
+
+	v2 := append([]byte{}, proxySignature...)
+	v2 = append(v2, 0x21, 0x11, 0, 12) // PROXY, TCP over IPv4, 12 bytes
+	v2 = append(v2, 192, 0, 2, 2, 198, 51, 100, 1, 0x9c, 0x40, 0x01, 0xbb)
+
+	tests := []struct {
+		name   string
+		header []byte
+		remote string
+		err    bool
+	}{
+		{name: "v1", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"), remote: "192.0.2.1:56324"},
+		{name: "v1 unknown", header: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v2", header: v2, remote: "192.0.2.2:40000"},
+		{name: "none"},
+		{name: "invalid", header: []byte("PROXY TCP4 nonsense\r\n"), err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = client.Close() }()
+			payload := []byte("GET / HTTP/1.1\r\nHost: example\r\n\r\n")
+			if _, err = client.Write(append(tt.header, payload...)); err != nil {
+				t.Fatal(err)
+			}
+			_ = client.(*net.TCPConn).CloseWrite()
+
+			conn, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = conn.Close() }()
+
+			remote := tt.remote
+			if remote == "" {
+				remote = client.LocalAddr().String()
+			}
+			if addr := conn.RemoteAddr().String(); !tt.err && addr != remote {
+				t.Errorf("expected remote address %s, got %s", remote, addr)
+			}
+			b, err := ioutil.ReadAll(conn)
+			if tt.err {
+				if err == nil || err == io.EOF {
+					t.Errorf("expected an invalid header to fail reading, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != string(payload) {
+				t.Errorf("expected %q, got %q", payload, b)
+			}
+		})
+	}
+}
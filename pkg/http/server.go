@@ -17,34 +17,94 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tetratelabs/multierror"
 	"github.com/tetratelabs/run"
 
 	"github.com/basvanbeek/topology-tester/pkg"
+	"github.com/basvanbeek/topology-tester/pkg/sockopt"
 )
 
 const (
 	flagListenAddress = "http-listen-address"
+	flagTLSCert       = "http-tls-cert"
+	flagTLSKey        = "http-tls-key"
+	flagTLSClientCA   = "http-tls-client-ca"
+	flagReusePort     = "http-reuseport"
+	flagNoDelay       = "http-tcp-nodelay"
+	flagKeepAlive     = "http-tcp-keepalive"
+	flagProxyProtocol = "http-proxy-protocol"
+	flagDrainTime     = "http-drain-time"
+	flagDrainDelay    = "http-drain-delay"
+	flagReadTimeout   = "http-read-timeout"
+	flagHeaderTimeout = "http-read-header-timeout"
+	flagWriteTimeout  = "http-write-timeout"
+	flagIdleTimeout   = "http-idle-timeout"
+	flagMaxHeaderSize = "http-max-header-bytes"
 
 	defaultListenAddress = ":8000"
+	defaultDrainTime     = 5 * time.Second
+
+	// HandlerTraffic is the name of the default handler, served by listen
+	// addresses without explicit handler selection.
+	HandlerTraffic = "traffic"
+
+	errNoCertificates pkg.Error = "no certificates found"
+	errHandlerName    pkg.Error = "expected address as host:port with an optional =handler suffix"
+	errNoHandler      pkg.Error = "no handler registered"
+	errDuration       pkg.Error = "expected a zero or positive duration"
+	errSize           pkg.Error = "expected a zero or positive size"
 )
 
 var (
-	_ run.Config  = (*Service)(nil)
-	_ run.Service = (*Service)(nil)
+	_ run.Config    = (*Service)(nil)
+	_ run.PreRunner = (*Service)(nil)
+	_ run.Service   = (*Service)(nil)
 )
 
-// Service implements a run.Group compatible HTTP Server.
+// Service implements a run.Group compatible HTTP Server. It can listen on
+// multiple addresses, each serving the traffic Handler of the embedded
+// http.Server or one of the named Handlers. On shutdown OnDrain is called,
+// allowing readiness to be marked down, after which the in flight requests are
+// drained and OnDrained is called with the outcome.
 type Service struct {
 	ListenAddress string
+	TLSCert       string
+	TLSKey        string
+	TLSClientCA   string
+	StartupDelay  time.Duration
+	Handlers      map[string]http.Handler
+	Socket        sockopt.Options
+	ProxyProtocol bool
+	DrainTime     time.Duration
+	DrainDelay    time.Duration
+	OnDrain       func()
+	OnDrained     func(DrainSummary)
 
 	*http.Server
-	l net.Listener
+	mtx       sync.Mutex
+	addresses []listenAddress
+	servers   map[string]*http.Server
+	tlsConfig *tls.Config
+	ls        []net.Listener
+	pause     chan time.Duration
+	closer    chan struct{}
+	idle      int32
+	idleConns idleConns
+	inflight  inflight
+	queues    []*connQueue
+	failed    chan error
 }
 
 // Name implements run.Unit.
@@ -57,12 +117,16 @@ func (s *Service) FlagSet() *run.FlagSet {
 	if s.ListenAddress == "" {
 		s.ListenAddress = defaultListenAddress
 	}
+	if s.DrainTime == 0 {
+		s.DrainTime = defaultDrainTime
+	}
 	if s.Server == nil {
 		s.Server = &http.Server{
 			ReadTimeout:  5 * time.Second,
 			WriteTimeout: 5 * time.Second,
 			IdleTimeout:  120 * time.Second,
 		}
+		s.Socket.NoDelay = true
 	}
 	flags := run.NewFlagSet("HTTP server options")
 
@@ -70,7 +134,91 @@ func (s *Service) FlagSet() *run.FlagSet {
 		&s.ListenAddress,
 		flagListenAddress, "a",
 		s.ListenAddress,
-		`HTTP server listen address, e.g. ":443" or "localhost:80"`)
+		`HTTP server listen addresses with optional handler selection, e.g. ":443", "localhost:80" or ":8000,:8080=admin"`)
+
+	flags.StringVar(
+		&s.TLSCert,
+		flagTLSCert,
+		s.TLSCert,
+		`Path to PEM encoded certificate, enables TLS termination`)
+
+	flags.StringVar(
+		&s.TLSKey,
+		flagTLSKey,
+		s.TLSKey,
+		`Path to PEM encoded private key belonging to the certificate`)
+
+	flags.StringVar(
+		&s.TLSClientCA,
+		flagTLSClientCA,
+		s.TLSClientCA,
+		`Path to PEM encoded CA bundle used to verify client certificates`)
+
+	flags.BoolVar(
+		&s.Socket.ReusePort,
+		flagReusePort,
+		s.Socket.ReusePort,
+		`Set SO_REUSEPORT on the listeners`)
+
+	flags.BoolVar(
+		&s.Socket.NoDelay,
+		flagNoDelay,
+		s.Socket.NoDelay,
+		`Set TCP_NODELAY on accepted connections, disabling Nagle's algorithm`)
+
+	flags.DurationVar(
+		&s.Socket.KeepAlive,
+		flagKeepAlive,
+		s.Socket.KeepAlive,
+		`TCP keepalive period of accepted connections, 0 uses the default and a negative value disables keepalive`)
+
+	flags.BoolVar(
+		&s.ProxyProtocol,
+		flagProxyProtocol,
+		s.ProxyProtocol,
+		`Accept PROXY protocol v1 and v2 headers, reporting the original client address`)
+
+	flags.DurationVar(
+		&s.Server.ReadTimeout,
+		flagReadTimeout,
+		s.Server.ReadTimeout,
+		`Max duration for reading an entire request, including the body, 0 disables`)
+
+	flags.DurationVar(
+		&s.Server.ReadHeaderTimeout,
+		flagHeaderTimeout,
+		s.Server.ReadHeaderTimeout,
+		`Max duration for reading the request headers, 0 uses the read timeout`)
+
+	flags.DurationVar(
+		&s.Server.WriteTimeout,
+		flagWriteTimeout,
+		s.Server.WriteTimeout,
+		`Max duration from reading the request headers until the response is written, 0 disables`)
+
+	flags.DurationVar(
+		&s.Server.IdleTimeout,
+		flagIdleTimeout,
+		s.Server.IdleTimeout,
+		`Max duration to wait for the next request on keep-alive connections, 0 uses the read timeout`)
+
+	flags.IntVar(
+		&s.Server.MaxHeaderBytes,
+		flagMaxHeaderSize,
+		s.Server.MaxHeaderBytes,
+		`Max size of the request headers in bytes, 0 uses the default of 1MiB`)
+
+	flags.DurationVar(
+		&s.DrainTime,
+		flagDrainTime,
+		s.DrainTime,
+		`Time to keep serving in flight requests on shutdown before closing their connections`)
+
+	flags.DurationVar(
+		&s.DrainDelay,
+		flagDrainDelay,
+		s.DrainDelay,
+		`Time between marking readiness down and closing the listeners on shutdown, allowing load balancers to notice`)
 
 	return flags
 }
@@ -80,7 +228,7 @@ func (s *Service) Validate() error {
 	var mErr error
 
 	if s.ListenAddress != "" {
-		if _, _, err := net.SplitHostPort(s.ListenAddress); err != nil {
+		if _, err := parseListenAddresses(s.ListenAddress); err != nil {
 			mErr = multierror.Append(mErr,
 				fmt.Errorf(pkg.FlagErr, flagListenAddress, err))
 		}
@@ -88,28 +236,359 @@ func (s *Service) Validate() error {
 		mErr = multierror.Append(mErr,
 			fmt.Errorf(pkg.FlagErr, flagListenAddress, pkg.ErrRequired))
 	}
+	if s.TLSCert != "" && s.TLSKey == "" {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagTLSKey, pkg.ErrRequired))
+	}
+	if s.TLSKey != "" && s.TLSCert == "" {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagTLSCert, pkg.ErrRequired))
+	}
+	if s.TLSClientCA != "" && s.TLSCert == "" {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagTLSCert, pkg.ErrRequired))
+	}
+	for flag, d := range map[string]time.Duration{
+		flagReadTimeout:   s.Server.ReadTimeout,
+		flagHeaderTimeout: s.Server.ReadHeaderTimeout,
+		flagWriteTimeout:  s.Server.WriteTimeout,
+		flagIdleTimeout:   s.Server.IdleTimeout,
+	} {
+		if d < 0 {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, flag, errDuration))
+		}
+	}
+	if s.Server.MaxHeaderBytes < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagMaxHeaderSize, errSize))
+	}
+	if s.DrainTime < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagDrainTime, errDuration))
+	}
+	if s.DrainDelay < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagDrainDelay, errDuration))
+	}
 
 	return mErr
 }
 
-// Serve implements run.Service.
-func (s *Service) Serve() (err error) {
-	s.l, err = net.Listen("tcp", s.ListenAddress)
+// PreRun implements run.PreRunner.
+func (s *Service) PreRun() error {
+	s.pause = make(chan time.Duration, 1)
+	s.closer = make(chan struct{})
+	s.addresses, _ = parseListenAddresses(s.ListenAddress) // validated in Validate
+
+	if s.TLSClientCA == "" {
+		return nil
+	}
+	pem, err := ioutil.ReadFile(s.TLSClientCA)
 	if err != nil {
 		return err
 	}
-	return s.Server.Serve(s.l)
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf(pkg.FlagErr, flagTLSClientCA, errNoCertificates)
+	}
+	if s.Server.TLSConfig == nil {
+		s.Server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	s.Server.TLSConfig.ClientCAs = pool
+	s.Server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+
+	return nil
+}
+
+// Serve implements run.Service.
+// If a StartupDelay is set, the listeners will only be opened after the delay
+// has passed. When paused, the listeners are reopened after the pause duration.
+// A percentage of accepted connections can be held open idle, see
+// SetIdleConnections.
+func (s *Service) Serve() error {
+	s.mtx.Lock()
+	s.Server.Handler = s.inflight.track(s.Server.Handler)
+	// the http servers amend their TLS config when serving, keep the original
+	// for creating new servers
+	s.tlsConfig = s.Server.TLSConfig
+	s.mtx.Unlock()
+	delay := s.StartupDelay
+	for {
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-s.closer:
+				return nil
+			}
+		}
+		ls, err := s.listen()
+		if err != nil {
+			return err
+		}
+		err = s.serve(ls)
+		select {
+		case delay = <-s.pause:
+			// emulated restart, reopen our listeners after the pause
+		default:
+			return err
+		}
+	}
+}
+
+// listen opens the listeners for all configured addresses.
+func (s *Service) listen() ([]net.Listener, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.ls = nil
+	for _, addr := range s.addresses {
+		ln, err := s.Socket.ListenConfig().Listen(context.Background(), "tcp", addr.address)
+		if err != nil {
+			for _, l := range s.ls {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		ln = s.Socket.Listener(ln)
+		if s.ProxyProtocol {
+			ln = &proxyListener{Listener: ln}
+		}
+		s.ls = append(s.ls, &idleListener{Listener: ln, s: s})
+	}
+	return s.ls, nil
+}
+
+// serve serves the listeners until one of them or its server fails, in which
+// case the others are closed as well. The first error encountered is returned.
+// Connections established before are still served.
+func (s *Service) serve(ls []net.Listener) error {
+	var (
+		errs   = make(chan error, len(ls))
+		failed = make(chan error, 1)
+	)
+	s.mtx.Lock()
+	s.queues = make([]*connQueue, len(ls))
+	s.failed = failed
+	s.mtx.Unlock()
+	for i, l := range ls {
+		srv, err := s.server(s.addresses[i].handler)
+		if err != nil {
+			errs <- err
+			continue
+		}
+		s.mtx.Lock()
+		s.queues[i] = s.startQueue(l.Addr(), srv)
+		s.mtx.Unlock()
+		go func(i int, l net.Listener) {
+			errs <- s.accept(i, l)
+		}(i, l)
+	}
+	var (
+		err     error
+		pending = len(ls)
+	)
+	select {
+	case err = <-errs:
+		pending--
+	case err = <-failed:
+	}
+	for _, l := range ls {
+		_ = l.Close()
+	}
+	for ; pending > 0; pending-- {
+		<-errs
+	}
+	s.mtx.Lock()
+	for _, q := range s.queues {
+		if q != nil {
+			_ = q.Close()
+		}
+	}
+	s.mtx.Unlock()
+	return err
+}
+
+// accept runs the accept loop of listener i, handing over the connections to
+// the current server of the listener.
+func (s *Service) accept(i int, l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		var prev *connQueue
+		for {
+			s.mtx.Lock()
+			q := s.queues[i]
+			s.mtx.Unlock()
+			if q == prev {
+				// stopped serving while handing over
+				_ = conn.Close()
+				break
+			}
+			if q.deliver(conn) {
+				break
+			}
+			// the server was replaced while handing over, try its successor
+			prev = q
+		}
+	}
+}
+
+// startQueue starts serving a new connection queue with srv. Failures of the
+// server are reported to serve. The caller must hold the mutex.
+func (s *Service) startQueue(addr net.Addr, srv *http.Server) *connQueue {
+	q := newConnQueue(addr)
+	failed := s.failed
+	go func() {
+		var err error
+		if s.TLSCert != "" {
+			err = srv.ServeTLS(q, s.TLSCert, s.TLSKey)
+		} else {
+			err = srv.Serve(q)
+		}
+		if err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+			select {
+			case failed <- err:
+			default:
+			}
+		}
+	}()
+	return q
+}
+
+// server returns the http.Server serving the named handler.
+func (s *Service) server(handler string) (*http.Server, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if handler == HandlerTraffic {
+		return s.Server, nil
+	}
+	if srv, ok := s.servers[handler]; ok {
+		return srv, nil
+	}
+	h, ok := s.Handlers[handler]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", handler, errNoHandler)
+	}
+	if s.servers == nil {
+		s.servers = make(map[string]*http.Server)
+	}
+	srv := withTimeouts(s.inflight.track(h), s.tlsConfig, timeoutsOf(s.Server))
+	s.servers[handler] = srv
+	return srv, nil
+}
+
+// Pause closes the listeners, refusing new connections for the provided
+// duration, after which the listeners are reopened. This allows for emulating a
+// service restart including its startup delay.
+func (s *Service) Pause(d time.Duration) {
+	select {
+	case s.pause <- d:
+	default:
+		// already pausing
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, l := range s.ls {
+		_ = l.Close()
+	}
 }
 
 // GracefulStop implements run.Service.
+// OnDrain is called first, after which new requests are still accepted for the
+// drain delay. Then the listeners are closed and in flight requests are served
+// for up to the drain time, after which their connections are closed. A
+// summary of the drained requests is logged.
 func (s *Service) GracefulStop() {
-	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(5*time.Second))
+	close(s.closer)
+
+	if s.OnDrain != nil {
+		s.OnDrain()
+	}
+	time.Sleep(s.DrainDelay)
+
+	start := time.Now()
+	summary := DrainSummary{
+		InFlight:  atomic.LoadInt64(&s.inflight.active),
+		DrainTime: s.DrainTime,
+	}
+	completed := atomic.LoadInt64(&s.inflight.completed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.DrainTime)
 	defer cancel()
 
+	s.mtx.Lock()
+	for _, l := range s.ls {
+		_ = l.Close()
+	}
+	var servers []*http.Server
 	if s.Server != nil {
-		_ = s.Server.Shutdown(ctx)
+		servers = append(servers, s.Server)
+	}
+	for _, srv := range s.servers {
+		servers = append(servers, srv)
+	}
+	s.mtx.Unlock()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			_ = srv.Shutdown(ctx)
+		}(srv)
 	}
-	if s.l != nil {
-		_ = s.l.Close()
+	wg.Wait()
+
+	summary.Took = time.Since(start)
+	summary.Drained = atomic.LoadInt64(&s.inflight.completed) - completed
+	if ctx.Err() != nil {
+		// drain time exceeded, abort the remaining requests
+		summary.Aborted = atomic.LoadInt64(&s.inflight.active)
+		for _, srv := range servers {
+			_ = srv.Close()
+		}
+	}
+	s.idleConns.release()
+
+	if summary.Drained+summary.Aborted > summary.InFlight {
+		// requests accepted just before the listeners closed
+		summary.InFlight = summary.Drained + summary.Aborted
+	}
+	summary.log()
+	if s.OnDrained != nil {
+		s.OnDrained(summary)
+	}
+}
+
+// listenAddress holds a listen address and the name of the handler to serve on
+// it.
+type listenAddress struct {
+	address string
+	handler string
+}
+
+// parseListenAddresses parses a comma separated list of listen addresses, each
+// optionally suffixed with =handler to select the handler to serve.
+func parseListenAddresses(s string) ([]listenAddress, error) {
+	var addresses []listenAddress
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		addr := listenAddress{address: kv[0], handler: HandlerTraffic}
+		if len(kv) == 2 {
+			if kv[1] == "" {
+				return nil, errHandlerName
+			}
+			addr.handler = kv[1]
+		}
+		if _, _, err := net.SplitHostPort(addr.address); err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, addr)
 	}
+	return addresses, nil
 }
@@ -0,0 +1,95 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"time"
+)
+
+// Timeouts holds the limits of the HTTP servers, which can be changed at
+// runtime.
+type Timeouts struct {
+	Read           time.Duration
+	ReadHeader     time.Duration
+	Write          time.Duration
+	Idle           time.Duration
+	MaxHeaderBytes int
+}
+
+func timeoutsOf(srv *http.Server) Timeouts {
+	return Timeouts{
+		Read:           srv.ReadTimeout,
+		ReadHeader:     srv.ReadHeaderTimeout,
+		Write:          srv.WriteTimeout,
+		Idle:           srv.IdleTimeout,
+		MaxHeaderBytes: srv.MaxHeaderBytes,
+	}
+}
+
+func withTimeouts(h http.Handler, tlsConfig *tls.Config, t Timeouts) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		TLSConfig:         tlsConfig,
+		ReadTimeout:       t.Read,
+		ReadHeaderTimeout: t.ReadHeader,
+		WriteTimeout:      t.Write,
+		IdleTimeout:       t.Idle,
+		MaxHeaderBytes:    t.MaxHeaderBytes,
+	}
+}
+
+// Timeouts returns the current limits of the HTTP servers.
+func (s *Service) Timeouts() Timeouts {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return timeoutsOf(s.Server)
+}
+
+// SetTimeouts changes the limits of the HTTP servers without downtime. The
+// servers are replaced by servers using the new limits, taking over the
+// listeners, while the replaced servers drain their connections for up to the
+// drain time.
+func (s *Service) SetTimeouts(t Timeouts) {
+	s.mtx.Lock()
+	retired := []*http.Server{s.Server}
+	s.Server = withTimeouts(s.Server.Handler, s.tlsConfig, t)
+	current := map[string]*http.Server{HandlerTraffic: s.Server}
+	for name, srv := range s.servers {
+		retired = append(retired, srv)
+		s.servers[name] = withTimeouts(srv.Handler, s.tlsConfig, t)
+		current[name] = s.servers[name]
+	}
+	for i, q := range s.queues {
+		if q == nil || q.closed() {
+			// not serving, e.g. paused
+			continue
+		}
+		s.queues[i] = s.startQueue(q.addr, current[s.addresses[i].handler])
+	}
+	s.mtx.Unlock()
+
+	for _, srv := range retired {
+		go func(srv *http.Server) {
+			ctx, cancel := context.WithTimeout(context.Background(), s.DrainTime)
+			defer cancel()
+			if err := srv.Shutdown(ctx); err != nil {
+				_ = srv.Close()
+			}
+		}(srv)
+	}
+}
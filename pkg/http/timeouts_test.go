@@ -0,0 +1,85 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSetTimeoutsWithoutDowntime(t *testing.T) {
+	s := &Service{ListenAddress: "127.0.0.1:0"}
+	s.FlagSet()
+	if err := s.PreRun(); err != nil {
+		t.Fatal(err)
+	}
+	s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+	})
+	served := make(chan error, 1)
+	go func() { served <- s.Serve() }()
+
+	var addr string
+	for addr == "" {
+		time.Sleep(time.Millisecond)
+		s.mtx.Lock()
+		if len(s.queues) > 0 {
+			addr = s.ls[0].Addr().String()
+		}
+		s.mtx.Unlock()
+	}
+
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(errs)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			res, err := http.Get("http://" + addr + "/")
+			if err != nil {
+				errs <- err
+				return
+			}
+			_, _ = io.Copy(ioutil.Discard, res.Body)
+			_ = res.Body.Close()
+		}
+	}()
+	for i := 1; i <= 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		s.SetTimeouts(Timeouts{Read: time.Duration(i) * time.Second, MaxHeaderBytes: i * 1024})
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(done)
+	if err := <-errs; err != nil {
+		t.Errorf("expected requests to be served while replacing the server, got %v", err)
+	}
+	if to := s.Timeouts(); to.Read != 5*time.Second || to.MaxHeaderBytes != 5*1024 {
+		t.Errorf("expected the last timeouts to apply, got %+v", to)
+	}
+
+	s.GracefulStop()
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Error("expected Serve to return after GracefulStop")
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kubernetes provides a minimal in-cluster Kubernetes API client, using
+// the credentials of the pod's service account.
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Client errors.
+const (
+	// ErrNotInCluster is returned when not running inside a Kubernetes cluster.
+	ErrNotInCluster pkg.Error = "not running inside a Kubernetes cluster"
+	// ErrNotFound is returned when the requested resource does not exist.
+	ErrNotFound pkg.Error = "resource not found"
+	// ErrConflict is returned when a resource to create already exists or a
+	// resource to update has been changed since it was retrieved.
+	ErrConflict pkg.Error = "resource conflict"
+)
+
+// Client is a minimal Kubernetes API client.
+type Client struct {
+	apiServer string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+// InCluster returns true if running inside a Kubernetes cluster.
+func InCluster() bool {
+	return os.Getenv("KUBERNETES_SERVICE_HOST") != ""
+}
+
+// NewInClusterClient returns a client using the service account credentials
+// mounted into the pod.
+func NewInClusterClient() (*Client, error) {
+	if !InCluster() {
+		return nil, ErrNotInCluster
+	}
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("unable to parse cluster CA: %s", serviceAccountDir+"/ca.crt")
+	}
+	namespace, _ := ioutil.ReadFile(serviceAccountDir + "/namespace")
+	return NewClient(
+		"https://"+net.JoinHostPort(
+			os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")),
+		strings.TrimSpace(string(token)),
+		strings.TrimSpace(string(namespace)),
+		&http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		},
+	), nil
+}
+
+// NewClient returns a client for the provided API server, authenticating with
+// the bearer token.
+func NewClient(apiServer, token, namespace string, client *http.Client) *Client {
+	return &Client{
+		apiServer: apiServer,
+		token:     token,
+		namespace: namespace,
+		client:    client,
+	}
+}
+
+// Namespace returns the namespace the pod is running in.
+func (c *Client) Namespace() string {
+	return c.namespace
+}
+
+// Get retrieves the resource at the provided API path and decodes it into v.
+func (c *Client) Get(ctx context.Context, path string, v interface{}) error {
+	return c.do(ctx, http.MethodGet, path, nil, v)
+}
+
+// Create posts the resource in to the collection at the provided API path and
+// decodes the created resource into out.
+func (c *Client) Create(ctx context.Context, path string, in, out interface{}) error {
+	return c.do(ctx, http.MethodPost, path, in, out)
+}
+
+// Update replaces the resource at the provided API path with in and decodes
+// the updated resource into out. If in holds a resource version which is no
+// longer current, ErrConflict is returned.
+func (c *Client) Update(ctx context.Context, path string, in, out interface{}) error {
+	return c.do(ctx, http.MethodPut, path, in, out)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.apiServer+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrConflict
+	default:
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
@@ -0,0 +1,340 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package leader provides leader election between the replicas of a
+// Deployment using a Kubernetes lease, so background work like generating
+// traffic or applying scenarios is done by a single replica only.
+package leader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/multierror"
+	"github.com/tetratelabs/run"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+	"github.com/basvanbeek/topology-tester/pkg/kubernetes"
+)
+
+const (
+	flagElect         = "leader-elect"
+	flagLease         = "leader-lease"
+	flagNamespace     = "leader-namespace"
+	flagLeaseDuration = "leader-lease-duration"
+	flagRenewInterval = "leader-renew-interval"
+
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewInterval = 5 * time.Second
+
+	// microTime is the format of the lease timestamps
+	microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+	errLease         pkg.Error = "expected a lease name"
+	errLeaseDuration pkg.Error = "expected a positive lease duration"
+	errRenewInterval pkg.Error = "expected a positive renew interval shorter than the lease duration"
+)
+
+var (
+	_ run.Config    = (*Elector)(nil)
+	_ run.PreRunner = (*Elector)(nil)
+	_ run.Service   = (*Elector)(nil)
+)
+
+// lease is the coordination.k8s.io/v1 Lease resource.
+type lease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace,omitempty"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec leaseSpec `json:"spec"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+// Elector implements a run.Group compatible leader elector. Replicas compete
+// for a Kubernetes lease, the replica holding it is the leader until it fails
+// to renew the lease or stops. If leader election is disabled, the replica
+// always considers itself the leader.
+type Elector struct {
+	Enabled       bool
+	Lease         string
+	Namespace     string
+	Identity      string
+	LeaseDuration time.Duration
+	RenewInterval time.Duration
+
+	client *kubernetes.Client
+	closer chan struct{}
+
+	mtx      sync.RWMutex
+	leading  bool
+	observed leaseSpec
+	seen     time.Time // local time the observed lease spec last changed
+}
+
+// Name implements run.Unit.
+func (e *Elector) Name() string {
+	return "leader-election"
+}
+
+// FlagSet implements run.Config.
+func (e *Elector) FlagSet() *run.FlagSet {
+	if e.LeaseDuration == 0 {
+		e.LeaseDuration = defaultLeaseDuration
+	}
+	if e.RenewInterval == 0 {
+		e.RenewInterval = defaultRenewInterval
+	}
+	flags := run.NewFlagSet("Leader election options")
+
+	flags.BoolVar(&e.Enabled, flagElect, e.Enabled,
+		`Elect a leader among the replicas using a Kubernetes lease, only the leader runs background work`)
+	flags.StringVar(&e.Lease, flagLease, e.Lease,
+		`Name of the lease to compete for`)
+	flags.StringVar(&e.Namespace, flagNamespace, e.Namespace,
+		`Namespace of the lease, defaults to the namespace of the pod`)
+	flags.DurationVar(&e.LeaseDuration, flagLeaseDuration, e.LeaseDuration,
+		`Duration other replicas wait before taking over a lease which is not renewed`)
+	flags.DurationVar(&e.RenewInterval, flagRenewInterval, e.RenewInterval,
+		`Interval between attempts to acquire or renew the lease`)
+
+	return flags
+}
+
+// Validate implements run.Config.
+func (e *Elector) Validate() error {
+	if !e.Enabled {
+		return nil
+	}
+
+	var mErr error
+
+	if e.Lease == "" {
+		mErr = multierror.Append(mErr, fmt.Errorf(pkg.FlagErr, flagLease, errLease))
+	}
+	if e.LeaseDuration < time.Second {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagLeaseDuration, errLeaseDuration))
+	}
+	if e.RenewInterval <= 0 || e.RenewInterval >= e.LeaseDuration {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagRenewInterval, errRenewInterval))
+	}
+	if !kubernetes.InCluster() {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagElect, kubernetes.ErrNotInCluster))
+	}
+
+	return mErr
+}
+
+// PreRun implements run.PreRunner.
+func (e *Elector) PreRun() (err error) {
+	e.closer = make(chan struct{})
+	if !e.Enabled {
+		return nil
+	}
+	if e.Identity == "" {
+		if e.Identity, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+	if e.client, err = kubernetes.NewInClusterClient(); err != nil {
+		return err
+	}
+	if e.Namespace == "" {
+		e.Namespace = e.client.Namespace()
+	}
+	return nil
+}
+
+// Serve implements run.Service.
+// On stop a held lease is released, so another replica can take over without
+// waiting for the lease to expire.
+func (e *Elector) Serve() error {
+	if !e.Enabled {
+		<-e.closer
+		return nil
+	}
+	ticker := time.NewTicker(e.RenewInterval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), e.RenewInterval)
+		e.setLeading(e.tryAcquireOrRenew(ctx))
+		cancel()
+		select {
+		case <-ticker.C:
+		case <-e.closer:
+			if !e.IsLeader() {
+				return nil
+			}
+			e.setLeading(false)
+			ctx, cancel = context.WithTimeout(context.Background(), e.RenewInterval)
+			defer cancel()
+			if err := e.release(ctx); err != nil {
+				log.Printf("error while releasing lease %s: %v", e.Lease, err)
+			}
+			return nil
+		}
+	}
+}
+
+// GracefulStop implements run.Service.
+func (e *Elector) GracefulStop() {
+	close(e.closer)
+}
+
+// IsLeader returns true if this replica holds the lease or leader election is
+// disabled. A nil Elector is always the leader.
+func (e *Elector) IsLeader() bool {
+	if e == nil || !e.Enabled {
+		return true
+	}
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return e.leading
+}
+
+// Leader returns the identity of the replica holding the lease, if known.
+func (e *Elector) Leader() string {
+	if e == nil || !e.Enabled {
+		return ""
+	}
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return e.observed.HolderIdentity
+}
+
+func (e *Elector) setLeading(leading bool) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if leading == e.leading {
+		return
+	}
+	e.leading = leading
+	if leading {
+		log.Printf("%s acquired lease %s/%s", e.Identity, e.Namespace, e.Lease)
+	} else {
+		log.Printf("%s lost lease %s/%s", e.Identity, e.Namespace, e.Lease)
+	}
+}
+
+func (e *Elector) path() string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", e.Namespace)
+}
+
+// tryAcquireOrRenew returns true if this replica holds the lease after creating
+// it, renewing it or taking over an expired lease. Expiry is judged on the
+// local time the lease was last seen changing, so the clocks of the replicas
+// do not need to be in sync.
+func (e *Elector) tryAcquireOrRenew(ctx context.Context) bool {
+	now := time.Now()
+	spec := leaseSpec{
+		HolderIdentity:       e.Identity,
+		LeaseDurationSeconds: int(e.LeaseDuration / time.Second),
+		AcquireTime:          now.UTC().Format(microTime),
+		RenewTime:            now.UTC().Format(microTime),
+	}
+
+	var l lease
+	err := e.client.Get(ctx, e.path()+"/"+e.Lease, &l)
+	if err == kubernetes.ErrNotFound {
+		l.APIVersion, l.Kind = "coordination.k8s.io/v1", "Lease"
+		l.Metadata.Name, l.Metadata.Namespace = e.Lease, e.Namespace
+		l.Spec = spec
+		if err = e.client.Create(ctx, e.path(), l, &l); err != nil {
+			if err != kubernetes.ErrConflict {
+				log.Printf("error while creating lease %s: %v", e.Lease, err)
+			}
+			return false
+		}
+		e.observe(l.Spec, now)
+		return true
+	}
+	if err != nil {
+		log.Printf("error while retrieving lease %s: %v", e.Lease, err)
+		return false
+	}
+
+	e.observe(l.Spec, now)
+	if l.Spec.HolderIdentity != e.Identity && l.Spec.HolderIdentity != "" &&
+		now.Before(e.expiry()) {
+		// held by another replica
+		return false
+	}
+	if l.Spec.HolderIdentity == e.Identity {
+		spec.AcquireTime = l.Spec.AcquireTime
+		spec.LeaseTransitions = l.Spec.LeaseTransitions
+	} else {
+		spec.LeaseTransitions = l.Spec.LeaseTransitions + 1
+	}
+	l.Spec = spec
+	if err = e.client.Update(ctx, e.path()+"/"+e.Lease, l, &l); err != nil {
+		if err != kubernetes.ErrConflict {
+			log.Printf("error while updating lease %s: %v", e.Lease, err)
+		}
+		return false
+	}
+	e.observe(l.Spec, now)
+	return true
+}
+
+// release gives up the lease if this replica still holds it.
+func (e *Elector) release(ctx context.Context) error {
+	var l lease
+	if err := e.client.Get(ctx, e.path()+"/"+e.Lease, &l); err != nil {
+		return err
+	}
+	if l.Spec.HolderIdentity != e.Identity {
+		return nil
+	}
+	l.Spec.HolderIdentity = ""
+	l.Spec.LeaseDurationSeconds = 1
+	return e.client.Update(ctx, e.path()+"/"+e.Lease, l, &l)
+}
+
+// observe records the lease spec, resetting the local expiry time if it
+// changed.
+func (e *Elector) observe(spec leaseSpec, now time.Time) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if spec != e.observed {
+		e.observed, e.seen = spec, now
+	}
+}
+
+// expiry returns the local time the observed lease expires.
+func (e *Elector) expiry() time.Time {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	d := time.Duration(e.observed.LeaseDurationSeconds) * time.Second
+	if d == 0 {
+		d = e.LeaseDuration
+	}
+	return e.seen.Add(d)
+}
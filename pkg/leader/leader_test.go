@@ -0,0 +1,117 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/basvanbeek/topology-tester/pkg/kubernetes"
+)
+
+// fakeLeases emulates the lease API, rejecting updates of stale versions.
+type fakeLeases struct {
+	mtx     sync.Mutex
+	lease   *lease
+	version int
+}
+
+func (f *fakeLeases) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	var l lease
+	switch r.Method {
+	case http.MethodGet:
+		if f.lease == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	case http.MethodPost, http.MethodPut:
+		_ = json.NewDecoder(r.Body).Decode(&l)
+		if (r.Method == http.MethodPost) != (f.lease == nil) ||
+			(f.lease != nil && l.Metadata.ResourceVersion != f.lease.Metadata.ResourceVersion) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.version++
+		l.Metadata.ResourceVersion = strconv.Itoa(f.version)
+		f.lease = &l
+	}
+	_ = json.NewEncoder(w).Encode(f.lease)
+}
+
+func TestElector(t *testing.T) {
+	srv := httptest.NewServer(&fakeLeases{})
+	defer srv.Close()
+
+	elector := func(id string) *Elector {
+		return &Elector{
+			Enabled:       true,
+			Lease:         "svca",
+			Namespace:     "default",
+			Identity:      id,
+			LeaseDuration: 10 * time.Second,
+			RenewInterval: time.Second,
+			client:        kubernetes.NewClient(srv.URL, "token", "default", srv.Client()),
+		}
+	}
+	a, b := elector("a"), elector("b")
+	ctx := context.Background()
+
+	if !a.tryAcquireOrRenew(ctx) {
+		t.Fatal("expected a to create and acquire the lease")
+	}
+	if b.tryAcquireOrRenew(ctx) {
+		t.Fatal("expected b to wait for the lease held by a")
+	}
+	if !a.tryAcquireOrRenew(ctx) {
+		t.Fatal("expected a to renew the lease")
+	}
+	if b.Leader() != "a" {
+		t.Errorf("expected b to observe a as leader, got %q", b.Leader())
+	}
+
+	// a stops renewing, b takes over once the lease expired locally
+	b.tryAcquireOrRenew(ctx)
+	b.seen = b.seen.Add(-11 * time.Second)
+	if !b.tryAcquireOrRenew(ctx) {
+		t.Fatal("expected b to take over the expired lease")
+	}
+	if a.tryAcquireOrRenew(ctx) {
+		t.Fatal("expected a to have lost the lease")
+	}
+	if b.observed.LeaseTransitions != 1 {
+		t.Errorf("expected 1 lease transition, got %d", b.observed.LeaseTransitions)
+	}
+
+	// b releases the lease, a acquires it right away
+	if err := b.release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !a.tryAcquireOrRenew(ctx) {
+		t.Fatal("expected a to acquire the released lease")
+	}
+
+	var disabled *Elector
+	if !disabled.IsLeader() || !(&Elector{}).IsLeader() {
+		t.Error("expected a disabled elector to be the leader")
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lifecycle records the run.Group lifecycle of the units of a service,
+// logging the pre-run, serve and graceful stop phases of each unit and
+// reporting them as spans, so startup and shutdown ordering problems become
+// observable during topology bring-up.
+package lifecycle
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/tetratelabs/run"
+)
+
+// lifecycle phases
+const (
+	PhasePreRun = "pre-run"
+	PhaseServe  = "serve"
+	PhaseStop   = "stop"
+)
+
+// Event holds a completed lifecycle phase of a unit.
+type Event struct {
+	Unit     string
+	Phase    string
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// tracerProvider is implemented by the unit providing the Zipkin tracer, e.g.
+// pkg/zipkin.Service.
+type tracerProvider interface {
+	GetTracer() *zipkin.Tracer
+}
+
+// Recorder records the lifecycle events of the units wrapped by it. Events are
+// logged as they happen and reported as spans once the tracer is available:
+// the pre-run phases and serve starts as children of a startup span, and the
+// graceful stops and serve returns as children of a shutdown span. Units
+// stopping after the unit providing the tracer are only logged.
+type Recorder struct {
+	mtx      sync.Mutex
+	tracer   *zipkin.Tracer
+	events   []Event
+	services int
+	serving  int
+	startup  zipkin.Span
+	shutdown zipkin.Span
+	stopped  bool
+}
+
+// Events returns the events recorded so far.
+func (r *Recorder) Events() []Event {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+// Wrap returns the provided units wrapped to record their lifecycle events,
+// ready for registration with run.Group.
+func (r *Recorder) Wrap(units ...run.Unit) []run.Unit {
+	wrapped := make([]run.Unit, 0, len(units))
+	for _, u := range units {
+		w := &unit{Unit: u, r: r}
+		_, pre := u.(run.PreRunner)
+		_, svc := u.(run.Service)
+		switch {
+		case pre && svc:
+			r.services++
+			wrapped = append(wrapped, preRunService{w})
+		case pre:
+			wrapped = append(wrapped, preRunner{w})
+		case svc:
+			r.services++
+			wrapped = append(wrapped, service{w})
+		default:
+			wrapped = append(wrapped, u)
+		}
+	}
+	return wrapped
+}
+
+// record logs the event and reports it as a child span of parent once the
+// tracer is available. The caller must hold the mutex.
+func (r *Recorder) record(e Event, parent zipkin.Span) {
+	if e.Err != nil {
+		log.Printf("lifecycle unit=%s phase=%s duration=%s error=%q", e.Unit, e.Phase, e.Duration, e.Err)
+	} else {
+		log.Printf("lifecycle unit=%s phase=%s duration=%s", e.Unit, e.Phase, e.Duration)
+	}
+	r.events = append(r.events, e)
+	if parent != nil {
+		r.report(e, parent)
+	}
+}
+
+// report emits the event as a child span of parent. The caller must hold the
+// mutex.
+func (r *Recorder) report(e Event, parent zipkin.Span) {
+	span := r.tracer.StartSpan(e.Phase+" "+e.Unit,
+		zipkin.Parent(parent.Context()), zipkin.StartTime(e.Start))
+	span.Tag("lifecycle.unit", e.Unit)
+	span.Tag("lifecycle.phase", e.Phase)
+	if e.Err != nil && !errors.Is(e.Err, run.ErrRequestedShutdown) {
+		zipkin.TagError.Set(span, e.Err.Error())
+	}
+	span.FinishedWithDuration(e.Duration)
+}
+
+// attach starts reporting spans with the tracer, replaying the events recorded
+// before it became available. The caller must hold the mutex.
+func (r *Recorder) attach(tracer *zipkin.Tracer) {
+	if tracer == nil || r.tracer != nil {
+		return
+	}
+	r.tracer = tracer
+	start := time.Now()
+	if len(r.events) > 0 {
+		start = r.events[0].Start
+	}
+	r.startup = tracer.StartSpan("startup", zipkin.StartTime(start))
+	for _, e := range r.events {
+		r.report(e, r.startup)
+	}
+}
+
+// startShutdown returns the shutdown span, starting it with the first serve
+// return or graceful stop. The caller must hold the mutex.
+func (r *Recorder) startShutdown() zipkin.Span {
+	if r.tracer == nil || r.stopped {
+		return nil
+	}
+	if r.shutdown == nil {
+		r.shutdown = r.tracer.StartSpan("shutdown")
+	}
+	return r.shutdown
+}
+
+// unit records the lifecycle events of the wrapped unit. It forwards the
+// optional run.Config and run.Namer methods, while the types embedding it
+// implement run.PreRunner and run.Service only if the wrapped unit does.
+type unit struct {
+	run.Unit
+	r *Recorder
+}
+
+// FlagSet implements run.Config.
+func (u *unit) FlagSet() *run.FlagSet {
+	if c, ok := u.Unit.(run.Config); ok {
+		return c.FlagSet()
+	}
+	return nil
+}
+
+// Validate implements run.Config.
+func (u *unit) Validate() error {
+	if c, ok := u.Unit.(run.Config); ok {
+		return c.Validate()
+	}
+	return nil
+}
+
+// GroupName implements run.Namer.
+func (u *unit) GroupName(name string) {
+	if n, ok := u.Unit.(run.Namer); ok {
+		n.GroupName(name)
+	}
+}
+
+func (u *unit) preRun() error {
+	start := time.Now()
+	err := u.Unit.(run.PreRunner).PreRun()
+
+	u.r.mtx.Lock()
+	defer u.r.mtx.Unlock()
+	u.r.record(Event{Unit: u.Name(), Phase: PhasePreRun, Start: start, Duration: time.Since(start), Err: err}, u.r.startup)
+	if p, ok := u.Unit.(tracerProvider); ok && err == nil {
+		u.r.attach(p.GetTracer())
+	}
+	return err
+}
+
+func (u *unit) serve() error {
+	u.r.mtx.Lock()
+	log.Printf("lifecycle unit=%s phase=%s", u.Name(), PhaseServe)
+	if u.r.serving++; u.r.startup != nil {
+		u.r.startup.Annotate(time.Now(), u.Name()+" serving")
+		if u.r.serving == u.r.services {
+			u.r.startup.Finish()
+		}
+	}
+	u.r.mtx.Unlock()
+
+	start := time.Now()
+	err := u.Unit.(run.Service).Serve()
+
+	u.r.mtx.Lock()
+	defer u.r.mtx.Unlock()
+	u.r.record(Event{Unit: u.Name(), Phase: PhaseServe, Start: start, Duration: time.Since(start), Err: err}, nil)
+	if span := u.r.startShutdown(); span != nil {
+		span.Annotate(time.Now(), u.Name()+" served")
+	}
+	return err
+}
+
+func (u *unit) gracefulStop() {
+	u.r.mtx.Lock()
+	shutdown := u.r.startShutdown()
+	if _, ok := u.Unit.(tracerProvider); ok && shutdown != nil {
+		// the tracer stops reporting with this unit
+		shutdown.Finish()
+		u.r.stopped = true
+	}
+	u.r.mtx.Unlock()
+
+	start := time.Now()
+	u.Unit.(run.Service).GracefulStop()
+
+	u.r.mtx.Lock()
+	defer u.r.mtx.Unlock()
+	if u.r.stopped {
+		shutdown = nil
+	}
+	u.r.record(Event{Unit: u.Name(), Phase: PhaseStop, Start: start, Duration: time.Since(start)}, shutdown)
+}
+
+type preRunner struct{ *unit }
+
+// PreRun implements run.PreRunner.
+func (p preRunner) PreRun() error { return p.preRun() }
+
+type service struct{ *unit }
+
+// Serve implements run.Service.
+func (s service) Serve() error { return s.serve() }
+
+// GracefulStop implements run.Service.
+func (s service) GracefulStop() { s.gracefulStop() }
+
+type preRunService struct{ *unit }
+
+// PreRun implements run.PreRunner.
+func (p preRunService) PreRun() error { return p.preRun() }
+
+// Serve implements run.Service.
+func (p preRunService) Serve() error { return p.serve() }
+
+// GracefulStop implements run.Service.
+func (p preRunService) GracefulStop() { p.gracefulStop() }
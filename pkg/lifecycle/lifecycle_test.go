@@ -0,0 +1,116 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lifecycle
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/tetratelabs/run"
+)
+
+type fakeUnit struct {
+	name    string
+	stopped chan struct{}
+}
+
+func (f *fakeUnit) Name() string { return f.name }
+
+func (f *fakeUnit) PreRun() error {
+	f.stopped = make(chan struct{})
+	return nil
+}
+
+func (f *fakeUnit) Serve() error {
+	<-f.stopped
+	return nil
+}
+
+func (f *fakeUnit) GracefulStop() { close(f.stopped) }
+
+type fakeTracerUnit struct {
+	fakeUnit
+	tracer *zipkin.Tracer
+}
+
+func (f *fakeTracerUnit) GetTracer() *zipkin.Tracer { return f.tracer }
+
+type fakeConfig struct{ fakeUnit }
+
+func (f *fakeConfig) FlagSet() *run.FlagSet { return run.NewFlagSet("fake") }
+
+func (f *fakeConfig) Validate() error { return nil }
+
+func TestRecorder(t *testing.T) {
+	rec := recorder.NewReporter()
+	tracer, err := zipkin.NewTracer(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		r      Recorder
+		first  = &fakeUnit{name: "first"}
+		traced = &fakeTracerUnit{fakeUnit: fakeUnit{name: "tracer"}, tracer: tracer}
+		config = &fakeConfig{fakeUnit: fakeUnit{name: "config"}}
+	)
+	units := r.Wrap(first, traced, run.NewPreRunner("prerun", func() error { return errors.New("failed") }), config)
+	if _, ok := units[2].(run.Service); ok {
+		t.Error("expected wrapped pre runner not to implement run.Service")
+	}
+	if c, ok := units[3].(run.Config); !ok || c.FlagSet() == nil {
+		t.Error("expected wrapped unit to forward run.Config")
+	}
+
+	for _, u := range units {
+		if p, ok := u.(run.PreRunner); ok {
+			_ = p.PreRun()
+		}
+	}
+	services := []run.Service{units[0].(run.Service), units[1].(run.Service), units[3].(run.Service)}
+	done := make(chan struct{})
+	for _, s := range services {
+		go func(s run.Service) {
+			_ = s.Serve()
+			done <- struct{}{}
+		}(s)
+	}
+	for _, s := range services {
+		s.GracefulStop()
+		<-done
+	}
+
+	events := r.Events()
+	if len(events) != 10 {
+		t.Fatalf("expected 10 events, got %d", len(events))
+	}
+	if e := events[2]; e.Unit != "prerun" || e.Phase != PhasePreRun || e.Err == nil {
+		t.Errorf("expected failed pre-run event, got %+v", e)
+	}
+
+	names := map[string]bool{}
+	for _, s := range rec.Flush() {
+		names[s.Name] = true
+	}
+	for _, name := range []string{"startup", "pre-run first", "pre-run tracer", "pre-run prerun", "shutdown", "stop first"} {
+		if !names[name] {
+			t.Errorf("expected span %q, got %v", name, names)
+		}
+	}
+	if names["stop config"] {
+		t.Error("expected no spans after the tracer unit stopped")
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// AccessLog returns a middleware writing a line per request to the provided
+// logger, holding the client address, request line, status code, response
+// size, duration and trace id. A status code of 0 denotes a hijacked
+// connection.
+func AccessLog(l *log.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			defer func() {
+				traceID := "-"
+				if span := zipkin.SpanFromContext(r.Context()); span != nil {
+					traceID = span.Context().TraceID.String()
+				}
+				l.Printf("%s %q %s %q %d %d %s %s", r.RemoteAddr, r.Host,
+					r.Method, r.URL.RequestURI(), sw.code, sw.size,
+					time.Since(start).Round(time.Microsecond), traceID)
+			}()
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+)
+
+// DefaultLatencyBuckets holds the upper bounds in seconds of the buckets of a
+// LatencyHistogram, matching the defaults of the Prometheus client libraries.
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// exemplar links an observation to the trace of the request it was made for.
+type exemplar struct {
+	traceID string
+	value   float64
+	ts      time.Time
+}
+
+// LatencyHistogram is a request latency histogram keeping the trace id of the
+// most recent sampled request of each bucket as exemplar. It is served in the
+// OpenMetrics text format, allowing Prometheus to scrape the exemplars and
+// Grafana to navigate from a latency bucket to a trace.
+type LatencyHistogram struct {
+	name      string
+	help      string
+	bounds    []float64
+	mtx       sync.Mutex
+	counts    []uint64
+	exemplars []exemplar
+	sum       float64
+	count     uint64
+}
+
+// NewLatencyHistogram returns a histogram with the provided name, help text
+// and ascending bucket upper bounds in seconds. The +Inf bucket is implicit.
+func NewLatencyHistogram(name, help string, bounds []float64) *LatencyHistogram {
+	return &LatencyHistogram{
+		name:      name,
+		help:      help,
+		bounds:    bounds,
+		counts:    make([]uint64, len(bounds)+1),
+		exemplars: make([]exemplar, len(bounds)+1),
+	}
+}
+
+// Observe records a latency in seconds, with the trace id as exemplar unless
+// empty.
+func (h *LatencyHistogram) Observe(seconds float64, traceID string) {
+	i := 0
+	for i < len(h.bounds) && seconds > h.bounds[i] {
+		i++
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+	if traceID != "" {
+		h.exemplars[i] = exemplar{traceID: traceID, value: seconds, ts: time.Now()}
+	}
+}
+
+// ServeHTTP writes the histogram in the OpenMetrics text format.
+func (h *LatencyHistogram) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	defer func() { _ = bw.Flush() }()
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	_, _ = bw.WriteString("# TYPE " + h.name + " histogram\n")
+	_, _ = bw.WriteString("# UNIT " + h.name + " seconds\n")
+	_, _ = bw.WriteString("# HELP " + h.name + " " + h.help + "\n")
+	var cumulative uint64
+	for i, n := range h.counts {
+		cumulative += n
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = formatFloat(h.bounds[i])
+		}
+		_, _ = bw.WriteString(h.name + `_bucket{le="` + le + `"} ` + strconv.FormatUint(cumulative, 10))
+		if e := h.exemplars[i]; e.traceID != "" {
+			_, _ = bw.WriteString(` # {trace_id="` + e.traceID + `"} ` + formatFloat(e.value) + " " +
+				formatFloat(float64(e.ts.UnixNano())/1e9))
+		}
+		_, _ = bw.WriteString("\n")
+	}
+	_, _ = bw.WriteString(h.name + "_count " + strconv.FormatUint(h.count, 10) + "\n")
+	_, _ = bw.WriteString(h.name + "_sum " + formatFloat(h.sum) + "\n")
+	_, _ = bw.WriteString("# EOF\n")
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// RequestLatency returns a middleware observing the duration of requests in
+// the provided histogram, using the trace id of sampled requests as exemplar.
+// It must follow the tracing middlewares, which provide the span.
+func RequestLatency(h *LatencyHistogram) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			var traceID string
+			if span := zipkin.SpanFromContext(r.Context()); span != nil {
+				if sc := span.Context(); sc.Debug || (sc.Sampled != nil && *sc.Sampled) {
+					traceID = sc.TraceID.String()
+				}
+			}
+			defer func() {
+				h.Observe(time.Since(start).Seconds(), traceID)
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+)
+
+func TestRequestLatency(t *testing.T) {
+	tracer, err := zipkin.NewTracer(recorder.NewReporter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewLatencyHistogram("latency_seconds", "Test latency.", []float64{.1, 1})
+	handler := RequestLatency(h)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	span := tracer.StartSpan("request")
+	req := httptest.NewRequest("GET", "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(zipkin.NewContext(req.Context(), span)))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	h.Observe(5, "")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Errorf("expected OpenMetrics content type, got %q", ct)
+	}
+	lines := strings.Split(rec.Body.String(), "\n")
+	exemplar := `latency_seconds_bucket{le="0.1"} 2 # {trace_id="` + span.Context().TraceID.String() + `"} `
+	if !strings.HasPrefix(lines[3], exemplar) {
+		t.Errorf("expected bucket with exemplar %q, got %q", exemplar, lines[3])
+	}
+	for i, want := range []string{
+		`latency_seconds_bucket{le="1"} 2`,
+		`latency_seconds_bucket{le="+Inf"} 3`,
+		`latency_seconds_count 3`,
+	} {
+		if lines[4+i] != want {
+			t.Errorf("expected %q, got %q", want, lines[4+i])
+		}
+	}
+	if lines[len(lines)-2] != "# EOF" {
+		t.Errorf("expected # EOF terminator, got %q", lines[len(lines)-2])
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"expvar"
+	"net/http"
+	"strconv"
+)
+
+// RequestMetrics returns a middleware counting requests, requests in flight
+// and responses by status class (e.g. "responses_5xx") in the provided expvar
+// map. Hijacked connections are counted as "responses_hijacked".
+func RequestMetrics(m *expvar.Map) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m.Add("requests", 1)
+			m.Add("inflight", 1)
+			sw := &statusWriter{ResponseWriter: w}
+			defer func() {
+				m.Add("inflight", -1)
+				switch {
+				case sw.code == 0:
+					m.Add("responses_hijacked", 1)
+				default:
+					m.Add("responses_"+strconv.Itoa(sw.code/100)+"xx", 1)
+				}
+			}()
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package middleware composes the HTTP middlewares wrapping a service handler
+// in a defined order, independent of the order in which they are configured.
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+)
+
+// Middleware wraps a http.Handler.
+type Middleware func(http.Handler) http.Handler
+
+// Stage defines the position of a middleware in a Chain. Requests pass the
+// stages in ascending order, so tracing sees the requests rejected by the auth
+// and rate limiting stages.
+type Stage int
+
+// supported middleware stages
+const (
+	Tracing Stage = iota
+	Metrics
+	Logging
+	Auth
+	RateLimit
+)
+
+var stageNames = [...]string{"tracing", "metrics", "logging", "auth", "ratelimit"}
+
+// String implements fmt.Stringer.
+func (s Stage) String() string {
+	if s < 0 || int(s) >= len(stageNames) {
+		return "unknown"
+	}
+	return stageNames[s]
+}
+
+type entry struct {
+	stage Stage
+	m     Middleware
+}
+
+// Chain composes middlewares by stage. Middlewares of the same stage keep the
+// order in which they were added, the first one receiving requests first.
+type Chain struct {
+	entries []entry
+}
+
+// Use adds the provided middlewares to a stage of the chain. Nil middlewares
+// are ignored, so optional middlewares can be added unconditionally.
+func (c *Chain) Use(stage Stage, mw ...Middleware) *Chain {
+	for _, m := range mw {
+		if m != nil {
+			c.entries = append(c.entries, entry{stage: stage, m: m})
+		}
+	}
+	return c
+}
+
+// Then returns the provided handler wrapped by the middlewares of the chain.
+func (c *Chain) Then(h http.Handler) http.Handler {
+	entries := c.sorted()
+	for i := len(entries) - 1; i >= 0; i-- {
+		h = entries[i].m(h)
+	}
+	return h
+}
+
+func (c *Chain) sorted() []entry {
+	entries := make([]entry, len(c.entries))
+	copy(entries, c.entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].stage < entries[j].stage
+	})
+	return entries
+}
+
+var errHijack = errors.New("response writer does not support hijacking")
+
+// statusWriter records the status code and body size of a response, passing
+// flushes and connection hijacks on to the wrapped writer.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+	size int64
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusWriter) Write(b []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.size += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher.
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (s *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijack
+	}
+	return h.Hijack()
+}
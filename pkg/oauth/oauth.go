@@ -0,0 +1,278 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package oauth provides an OAuth2 client credentials token source which can
+// be used to authenticate outbound requests to downstream services.
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/multierror"
+	"github.com/tetratelabs/run"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+)
+
+const (
+	flagIssuer        = "oauth-issuer"
+	flagTokenEndpoint = "oauth-token-endpoint"
+	flagClientID      = "oauth-client-id"
+	flagClientSecret  = "oauth-client-secret"
+	flagScopes        = "oauth-scopes"
+	flagAudience      = "oauth-audience"
+
+	// refresh tokens this long before they actually expire
+	expiryDelta = 30 * time.Second
+
+	errNoAccessToken pkg.Error = "token endpoint returned no access token"
+	errNoEndpoint    pkg.Error = "issuer discovery returned no token endpoint"
+)
+
+var (
+	_ run.Config    = (*Service)(nil)
+	_ run.PreRunner = (*Service)(nil)
+)
+
+// Service implements a run.Group compatible OAuth2 client credentials token
+// source. Tokens are cached and refreshed when they are about to expire.
+type Service struct {
+	Issuer        string
+	TokenEndpoint string
+	ClientID      string
+	ClientSecret  string
+	Scopes        []string
+	Audience      string
+	Client        *http.Client
+
+	mtx      sync.Mutex
+	endpoint string
+	token    string
+	expiry   time.Time
+	refresh  *refresh
+}
+
+// refresh is a token request in flight, shared by all callers needing it.
+type refresh struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// Name implements run.Unit.
+func (s *Service) Name() string {
+	return "oauth"
+}
+
+// FlagSet implements run.Config.
+func (s *Service) FlagSet() *run.FlagSet {
+	flags := run.NewFlagSet("OAuth2 client credentials options")
+
+	flags.StringVar(&s.Issuer, flagIssuer, s.Issuer,
+		`OIDC issuer URL used to discover the token endpoint`)
+	flags.StringVar(&s.TokenEndpoint, flagTokenEndpoint, s.TokenEndpoint,
+		`OAuth2 token endpoint, takes precedence over issuer discovery`)
+	flags.StringVar(&s.ClientID, flagClientID, s.ClientID,
+		`OAuth2 client ID`)
+	flags.StringVar(&s.ClientSecret, flagClientSecret, s.ClientSecret,
+		`OAuth2 client secret`)
+	flags.StringSliceVar(&s.Scopes, flagScopes, s.Scopes,
+		`OAuth2 scopes to request`)
+	flags.StringVar(&s.Audience, flagAudience, s.Audience,
+		`Audience to request the token for`)
+
+	return flags
+}
+
+// Validate implements run.Config.
+func (s *Service) Validate() error {
+	if !s.Enabled() {
+		return nil
+	}
+
+	var mErr error
+
+	for flag, u := range map[string]string{
+		flagIssuer:        s.Issuer,
+		flagTokenEndpoint: s.TokenEndpoint,
+	} {
+		if u == "" {
+			continue
+		}
+		if _, err := url.ParseRequestURI(u); err != nil {
+			mErr = multierror.Append(mErr, fmt.Errorf(pkg.FlagErr, flag, err))
+		}
+	}
+	if s.ClientID == "" {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagClientID, pkg.ErrRequired))
+	}
+
+	return mErr
+}
+
+// PreRun implements run.PreRunner.
+func (s *Service) PreRun() error {
+	if s.Client == nil {
+		s.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	s.endpoint = s.TokenEndpoint
+	return nil
+}
+
+// Enabled returns true if a token issuer or endpoint has been configured.
+func (s *Service) Enabled() bool {
+	return s != nil && (s.Issuer != "" || s.TokenEndpoint != "")
+}
+
+// Token returns a valid access token. Tokens about to expire are refreshed by
+// a single request to the token endpoint, made without holding the lock, while
+// callers keep receiving the cached token as long as it is still valid. Only
+// callers without a valid token wait for the refresh.
+func (s *Service) Token(ctx context.Context) (string, error) {
+	now := time.Now()
+	s.mtx.Lock()
+	token := s.token
+	if token != "" && now.Add(expiryDelta).Before(s.expiry) {
+		s.mtx.Unlock()
+		return token, nil
+	}
+	valid := token != "" && now.Before(s.expiry)
+	r := s.refresh
+	if r == nil {
+		r = &refresh{done: make(chan struct{})}
+		s.refresh = r
+		// the refresh is shared, so it must not be cancelled with the caller
+		go s.fetch(r)
+	}
+	s.mtx.Unlock()
+
+	if valid {
+		return token, nil
+	}
+	select {
+	case <-r.done:
+		return r.token, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// fetch requests a new token and stores it for use by Token.
+func (s *Service) fetch(r *refresh) {
+	token, expiry, err := s.requestToken(context.Background())
+
+	s.mtx.Lock()
+	if err == nil {
+		s.token, s.expiry = token, expiry
+	}
+	s.refresh = nil
+	s.mtx.Unlock()
+
+	r.token, r.err = token, err
+	close(r.done)
+}
+
+// requestToken requests a new access token from the token endpoint,
+// discovering the endpoint first if needed.
+func (s *Service) requestToken(ctx context.Context) (string, time.Time, error) {
+	s.mtx.Lock()
+	endpoint := s.endpoint
+	s.mtx.Unlock()
+
+	if endpoint == "" {
+		var err error
+		if endpoint, err = s.discover(ctx); err != nil {
+			return "", time.Time{}, err
+		}
+		s.mtx.Lock()
+		s.endpoint = endpoint
+		s.mtx.Unlock()
+	}
+
+	data := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.Scopes) > 0 {
+		data.Set("scope", strings.Join(s.Scopes, " "))
+	}
+	if s.Audience != "" {
+		data.Set("audience", s.Audience)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		endpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(s.ClientID), url.QueryEscape(s.ClientSecret))
+
+	var res struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err = s.do(req, &res); err != nil {
+		return "", time.Time{}, err
+	}
+	if res.AccessToken == "" {
+		return "", time.Time{}, errNoAccessToken
+	}
+
+	expiry := time.Now().Add(time.Duration(res.ExpiresIn) * time.Second)
+	if res.ExpiresIn <= 0 {
+		// no expiry provided, refresh on next use after the expiry delta
+		expiry = time.Now().Add(2 * expiryDelta)
+	}
+	return res.AccessToken, expiry, nil
+}
+
+// discover retrieves the token endpoint from the issuer's OIDC discovery
+// document.
+func (s *Service) discover(ctx context.Context) (string, error) {
+	u := strings.TrimSuffix(s.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var res struct {
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+	if err = s.do(req, &res); err != nil {
+		return "", err
+	}
+	if res.TokenEndpoint == "" {
+		return "", errNoEndpoint
+	}
+	return res.TokenEndpoint, nil
+}
+
+func (s *Service) do(req *http.Request, v interface{}) error {
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: unexpected status code %d",
+			req.Method, req.URL, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
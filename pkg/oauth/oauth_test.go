@@ -0,0 +1,180 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeIssuer serves an OIDC discovery document and a token endpoint handing
+// out numbered tokens.
+type fakeIssuer struct {
+	*httptest.Server
+	discoveries int32
+	issued      int32
+	// gate, if set, holds token requests until closed
+	gate chan struct{}
+}
+
+func newFakeIssuer() *fakeIssuer {
+	f := &fakeIssuer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&f.discoveries, 1)
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"token_endpoint": f.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, _, ok := r.BasicAuth(); !ok || id != "client" ||
+			r.PostFormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if f.gate != nil {
+			<-f.gate
+		}
+		n := atomic.AddInt32(&f.issued, 1)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token-" + strconv.Itoa(int(n)),
+			"expires_in":   3600,
+		})
+	})
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func newService(t *testing.T, f *fakeIssuer) *Service {
+	s := &Service{Issuer: f.URL, ClientID: "client", ClientSecret: "secret"}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PreRun(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (s *Service) setExpiry(expiry time.Time) {
+	s.mtx.Lock()
+	s.expiry = expiry
+	s.mtx.Unlock()
+}
+
+func (s *Service) waitRefresh() {
+	s.mtx.Lock()
+	r := s.refresh
+	s.mtx.Unlock()
+	if r != nil {
+		<-r.done
+	}
+}
+
+func mustToken(t *testing.T, s *Service, want string) {
+	t.Helper()
+	token, err := s.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != want {
+		t.Fatalf("expected %q, got %q", want, token)
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	f := newFakeIssuer()
+	defer f.Close()
+	s := newService(t, f)
+
+	mustToken(t, s, "token-1")
+	mustToken(t, s, "token-1")
+	if atomic.LoadInt32(&f.discoveries) != 1 || atomic.LoadInt32(&f.issued) != 1 {
+		t.Fatalf("expected 1 discovery and 1 token, got %d and %d",
+			atomic.LoadInt32(&f.discoveries), atomic.LoadInt32(&f.issued))
+	}
+
+	// about to expire: the cached token is served while refreshing
+	s.setExpiry(time.Now().Add(expiryDelta / 2))
+	mustToken(t, s, "token-1")
+	s.waitRefresh()
+	mustToken(t, s, "token-2")
+
+	// expired: callers wait for the refresh
+	s.setExpiry(time.Now().Add(-time.Second))
+	mustToken(t, s, "token-3")
+
+	if atomic.LoadInt32(&f.discoveries) != 1 {
+		t.Errorf("expected discovery to be cached, got %d", atomic.LoadInt32(&f.discoveries))
+	}
+}
+
+func TestTokenSingleRefresh(t *testing.T) {
+	f := newFakeIssuer()
+	defer f.Close()
+	f.gate = make(chan struct{})
+	s := newService(t, f)
+
+	var (
+		wg     sync.WaitGroup
+		tokens = make([]string, 10)
+		errs   = make([]error, 10)
+	)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], errs[i] = s.Token(context.Background())
+		}(i)
+	}
+
+	// a caller giving up does not cancel the shared refresh
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.Token(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	close(f.gate)
+	wg.Wait()
+	for i := range tokens {
+		if errs[i] != nil || tokens[i] != "token-1" {
+			t.Errorf("caller %d: expected token-1, got %q (%v)", i, tokens[i], errs[i])
+		}
+	}
+	if atomic.LoadInt32(&f.issued) != 1 {
+		t.Errorf("expected a single token request, got %d", atomic.LoadInt32(&f.issued))
+	}
+}
+
+func TestTokenError(t *testing.T) {
+	f := newFakeIssuer()
+	defer f.Close()
+	s := newService(t, f)
+	s.ClientID = "unknown"
+
+	if _, err := s.Token(context.Background()); err == nil {
+		t.Fatal("expected token request to fail")
+	}
+	s.ClientID = "client"
+	mustToken(t, s, "token-1")
+}
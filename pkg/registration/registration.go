@@ -0,0 +1,175 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package registration provides a registration client which periodically
+// announces this instance to a topology controller, allowing the controller
+// to push configuration to a fleet of testers.
+package registration
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/tetratelabs/multierror"
+	"github.com/tetratelabs/run"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+)
+
+const (
+	flagController = "register-controller"
+	flagAddress    = "register-address"
+	flagInterval   = "register-interval"
+
+	defaultInterval = 10 * time.Second
+	defaultPort     = "8000"
+
+	errInterval pkg.Error = "expected a positive interval"
+)
+
+var (
+	_ run.Config    = (*Service)(nil)
+	_ run.PreRunner = (*Service)(nil)
+	_ run.Service   = (*Service)(nil)
+)
+
+// Service implements a run.Group compatible registration client. If no
+// controller address is configured registration is disabled.
+type Service struct {
+	ServiceName string
+	Controller  string
+	Address     string
+	Interval    time.Duration
+
+	instance string
+	client   *http.Client
+	closer   chan struct{}
+}
+
+// Name implements run.Unit.
+func (s *Service) Name() string {
+	return "registration"
+}
+
+// FlagSet implements run.Config.
+func (s *Service) FlagSet() *run.FlagSet {
+	if s.Interval == 0 {
+		s.Interval = defaultInterval
+	}
+	flags := run.NewFlagSet("Controller registration options")
+
+	flags.StringVar(&s.Controller, flagController, s.Controller,
+		`Controller address to register with, e.g. "controller:9100"`)
+	flags.StringVar(&s.Address, flagAddress, s.Address,
+		`Address the controller can reach this instance at, defaults to hostname:8000`)
+	flags.DurationVar(&s.Interval, flagInterval, s.Interval,
+		`Interval between registration pings`)
+
+	return flags
+}
+
+// Validate implements run.Config.
+func (s *Service) Validate() error {
+	if s.Controller == "" {
+		return nil
+	}
+
+	var mErr error
+
+	for flag, addr := range map[string]string{
+		flagController: s.Controller,
+		flagAddress:    s.Address,
+	} {
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			mErr = multierror.Append(mErr, fmt.Errorf(pkg.FlagErr, flag, err))
+		}
+	}
+	if s.Interval <= 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, flagInterval, errInterval))
+	}
+
+	return mErr
+}
+
+// PreRun implements run.PreRunner.
+func (s *Service) PreRun() (err error) {
+	s.closer = make(chan struct{})
+	s.client = &http.Client{Timeout: 5 * time.Second}
+	if s.instance, err = os.Hostname(); err != nil {
+		return err
+	}
+	if s.Address == "" {
+		s.Address = net.JoinHostPort(s.instance, defaultPort)
+	}
+	return nil
+}
+
+// Serve implements run.Service.
+func (s *Service) Serve() error {
+	if s.Controller == "" {
+		// registration is disabled
+		<-s.closer
+		return nil
+	}
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		if err := s.register(); err != nil {
+			log.Printf("error while registering with controller: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-s.closer:
+			return nil
+		}
+	}
+}
+
+// GracefulStop implements run.Service.
+func (s *Service) GracefulStop() {
+	close(s.closer)
+}
+
+// register sends a registration ping to the controller.
+func (s *Service) register() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Interval)
+	defer cancel()
+
+	u := fmt.Sprintf("http://%s/register/%s/%s?instance=%s", s.Controller,
+		url.PathEscape(s.ServiceName), url.PathEscape(s.Address),
+		url.QueryEscape(s.instance))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package sockopt
+
+import "github.com/basvanbeek/topology-tester/pkg"
+
+// ErrReusePort is returned when SO_REUSEPORT is not supported on the platform.
+const ErrReusePort pkg.Error = "SO_REUSEPORT is not supported on this platform"
+
+func setReusePort(uintptr) error {
+	return ErrReusePort
+}
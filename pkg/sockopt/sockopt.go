@@ -0,0 +1,99 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sockopt provides socket option tuning shared by the listeners and
+// dialers, for toggling socket level behavior affecting latency distributions.
+package sockopt
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// Options holds the socket options to apply.
+type Options struct {
+	// ReusePort sets SO_REUSEPORT, allowing multiple sockets to bind the same
+	// address and port.
+	ReusePort bool
+	// NoDelay sets TCP_NODELAY, disabling Nagle's algorithm.
+	NoDelay bool
+	// KeepAlive sets the TCP keepalive period, 0 uses the Go default and a
+	// negative value disables keepalive.
+	KeepAlive time.Duration
+}
+
+// control returns the function to set options prior to binding or connecting
+// a socket.
+func (o Options) control() func(network, address string, c syscall.RawConn) error {
+	if !o.ReusePort {
+		return nil
+	}
+	return func(_, _ string, c syscall.RawConn) error {
+		var sErr error
+		if err := c.Control(func(fd uintptr) {
+			sErr = setReusePort(fd)
+		}); err != nil {
+			return err
+		}
+		return sErr
+	}
+}
+
+// ListenConfig returns a net.ListenConfig applying the options.
+func (o Options) ListenConfig() *net.ListenConfig {
+	return &net.ListenConfig{
+		Control:   o.control(),
+		KeepAlive: o.KeepAlive,
+	}
+}
+
+// Dialer returns a net.Dialer with the provided connect timeout applying the
+// options. Connections created by the dialer still need to be passed through
+// Apply for TCP_NODELAY to take effect.
+func (o Options) Dialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{
+		Timeout:   timeout,
+		Control:   o.control(),
+		KeepAlive: o.KeepAlive,
+	}
+}
+
+// Apply sets the per connection options on an established connection.
+func (o Options) Apply(conn net.Conn) {
+	if tc, ok := conn.(*net.TCPConn); ok {
+		_ = tc.SetNoDelay(o.NoDelay)
+	}
+}
+
+// Listener wraps l so the per connection options are applied to accepted
+// connections.
+func (o Options) Listener(l net.Listener) net.Listener {
+	return &listener{Listener: l, o: o}
+}
+
+type listener struct {
+	net.Listener
+	o Options
+}
+
+// Accept implements net.Listener.
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.o.Apply(conn)
+	return conn, nil
+}
@@ -0,0 +1,188 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// getJSON decodes the JSON response of a query API request into v, returning
+// ErrNotFound on a 404.
+func getJSON(client *http.Client, req *http.Request, v interface{}) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		_, _ = io.Copy(ioutil.Discard, res.Body)
+		return ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("unexpected status code %d: %s", res.StatusCode, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// zipkinTrace retrieves a trace using the Zipkin v2 API.
+func zipkinTrace(ctx context.Context, client *http.Client, address, traceID string) (*Trace, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		address+"/api/v2/trace/"+traceID, nil)
+	if err != nil {
+		return nil, err
+	}
+	var res []struct {
+		ID            string `json:"id"`
+		ParentID      string `json:"parentId"`
+		Shared        bool   `json:"shared"`
+		LocalEndpoint struct {
+			ServiceName string `json:"serviceName"`
+		} `json:"localEndpoint"`
+	}
+	if err = getJSON(client, req, &res); err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, ErrNotFound
+	}
+	spans := make([]span, 0, len(res))
+	for _, s := range res {
+		spans = append(spans, span{
+			id:      s.ID,
+			parent:  s.ParentID,
+			service: s.LocalEndpoint.ServiceName,
+			shared:  s.Shared,
+		})
+	}
+	return newTrace(traceID, spans), nil
+}
+
+// jaegerTrace retrieves a trace using the Jaeger query HTTP API.
+func jaegerTrace(ctx context.Context, client *http.Client, address, traceID string) (*Trace, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		address+"/api/traces/"+traceID, nil)
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		Data []struct {
+			Spans []struct {
+				SpanID     string `json:"spanID"`
+				ProcessID  string `json:"processID"`
+				References []struct {
+					RefType string `json:"refType"`
+					SpanID  string `json:"spanID"`
+				} `json:"references"`
+			} `json:"spans"`
+			Processes map[string]struct {
+				ServiceName string `json:"serviceName"`
+			} `json:"processes"`
+		} `json:"data"`
+	}
+	if err = getJSON(client, req, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Data) == 0 || len(res.Data[0].Spans) == 0 {
+		return nil, ErrNotFound
+	}
+	trace := res.Data[0]
+	spans := make([]span, 0, len(trace.Spans))
+	for _, s := range trace.Spans {
+		sp := span{id: s.SpanID, service: trace.Processes[s.ProcessID].ServiceName}
+		for _, ref := range s.References {
+			if ref.RefType == "CHILD_OF" {
+				sp.parent = ref.SpanID
+				break
+			}
+		}
+		spans = append(spans, sp)
+	}
+	return newTrace(traceID, spans), nil
+}
+
+// skyWalkingQuery holds the GraphQL query retrieving the spans of a trace
+// from SkyWalking.
+const skyWalkingQuery = `query queryTrace($traceId: ID!) {
+  trace: queryTrace(traceId: $traceId) {
+    spans { segmentId spanId parentSpanId serviceCode refs { parentSegmentId parentSpanId } }
+  }
+}`
+
+// skyWalkingTrace retrieves a trace using the SkyWalking GraphQL query API.
+func skyWalkingTrace(ctx context.Context, client *http.Client, address, traceID string) (*Trace, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query":     skyWalkingQuery,
+		"variables": map[string]string{"traceId": traceID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		address+"/graphql", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var res struct {
+		Data struct {
+			Trace struct {
+				Spans []struct {
+					SegmentID    string `json:"segmentId"`
+					SpanID       int    `json:"spanId"`
+					ParentSpanID int    `json:"parentSpanId"`
+					ServiceCode  string `json:"serviceCode"`
+					Refs         []struct {
+						ParentSegmentID string `json:"parentSegmentId"`
+						ParentSpanID    int    `json:"parentSpanId"`
+					} `json:"refs"`
+				} `json:"spans"`
+			} `json:"trace"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err = getJSON(client, req, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Errors) > 0 {
+		return nil, fmt.Errorf("query failed: %s", res.Errors[0].Message)
+	}
+	if len(res.Data.Trace.Spans) == 0 {
+		return nil, ErrNotFound
+	}
+	// SkyWalking span ids are unique within their segment only
+	spans := make([]span, 0, len(res.Data.Trace.Spans))
+	for _, s := range res.Data.Trace.Spans {
+		sp := span{
+			id:      fmt.Sprintf("%s/%d", s.SegmentID, s.SpanID),
+			service: s.ServiceCode,
+		}
+		if s.ParentSpanID >= 0 {
+			sp.parent = fmt.Sprintf("%s/%d", s.SegmentID, s.ParentSpanID)
+		} else if len(s.Refs) > 0 {
+			sp.parent = fmt.Sprintf("%s/%d", s.Refs[0].ParentSegmentID, s.Refs[0].ParentSpanID)
+		}
+		spans = append(spans, sp)
+	}
+	return newTrace(traceID, spans), nil
+}
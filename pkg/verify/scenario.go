@@ -0,0 +1,295 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+)
+
+const (
+	errScenario  pkg.Error = "invalid scenario"
+	errRequest   pkg.Error = "expected an absolute http(s) request url"
+	errCount     pkg.Error = "expected a zero or positive request count"
+	errErrorRate pkg.Error = "expected an error rate range between 0 and 100"
+)
+
+// Scenario holds a set of tests to execute against a deployed topology. The
+// backend and address are optional and default to the verify flags.
+//
+// Example scenario file:
+//
+//	{
+//	  "name": "checkout",
+//	  "tests": [{
+//	    "name": "happy path",
+//	    "request": {"url": "http://svca:8000/proxy/svcb/proxy/svcc"},
+//	    "count": 10,
+//	    "expectStatus": 200,
+//	    "errorRate": {"max": 0},
+//	    "trace": {"minSpans": 5, "edges": ["svca->svcb", "svcb->svcc"]}
+//	  }]
+//	}
+type Scenario struct {
+	Name    string     `json:"name"`
+	Backend string     `json:"backend,omitempty"`
+	Address string     `json:"address,omitempty"`
+	Tests   []TestCase `json:"tests"`
+}
+
+// TestCase holds the request to send to the topology and the expectations
+// of its outcome. The trace of the first request is verified in the tracing
+// backend.
+type TestCase struct {
+	Name         string            `json:"name"`
+	Request      Request           `json:"request"`
+	Count        int               `json:"count"`
+	ExpectStatus int               `json:"expectStatus"`
+	ErrorRate    *ErrorRate        `json:"errorRate"`
+	Trace        *TraceExpectation `json:"trace"`
+}
+
+// Request holds the HTTP request to send to the topology.
+type Request struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// ErrorRate holds the accepted range of the error rate in percentages. A
+// request counts as an error if it fails or returns a status code of 400 or
+// higher.
+type ErrorRate struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// TraceExpectation holds the expected span graph of a trace, with edges being
+// calls between services in the form of "svca->svcb".
+type TraceExpectation struct {
+	MinSpans int      `json:"minSpans"`
+	Services []string `json:"services"`
+	Edges    []string `json:"edges"`
+}
+
+// Result holds the outcome of a scenario test.
+type Result struct {
+	Name      string        `json:"name"`
+	Duration  time.Duration `json:"-"`
+	Requests  int           `json:"requests"`
+	Errors    int           `json:"errors"`
+	ErrorRate float64       `json:"errorRate"`
+	Trace     *Trace        `json:"trace,omitempty"`
+	Failures  []string      `json:"failures,omitempty"`
+}
+
+// LoadScenario reads and validates a JSON scenario file.
+func LoadScenario(path string) (*Scenario, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseScenario(b)
+}
+
+func parseScenario(b []byte) (*Scenario, error) {
+	var sc Scenario
+	if err := json.Unmarshal(b, &sc); err != nil {
+		return nil, fmt.Errorf("%w: %v", errScenario, err)
+	}
+	if len(sc.Tests) == 0 {
+		return nil, fmt.Errorf("%w: no tests found", errScenario)
+	}
+	if sc.Backend != "" {
+		if _, ok := defaultAddresses[sc.Backend]; !ok {
+			return nil, fmt.Errorf("%w: %v", errScenario, errBackend)
+		}
+	}
+	for i := range sc.Tests {
+		tc := &sc.Tests[i]
+		if tc.Name == "" {
+			tc.Name = fmt.Sprintf("test-%d", i+1)
+		}
+		if tc.Request.Method == "" {
+			tc.Request.Method = http.MethodGet
+		}
+		if tc.Count == 0 {
+			tc.Count = 1
+		}
+		u, err := url.Parse(tc.Request.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%w: %s: %v", errScenario, tc.Name, errRequest)
+		}
+		if tc.Count < 0 {
+			return nil, fmt.Errorf("%w: %s: %v", errScenario, tc.Name, errCount)
+		}
+		if r := tc.ErrorRate; r != nil && (r.Min < 0 || r.Max > 100 || r.Min > r.Max) {
+			return nil, fmt.Errorf("%w: %s: %v", errScenario, tc.Name, errErrorRate)
+		}
+	}
+	return &sc, nil
+}
+
+// Runner executes scenarios, waiting up to Timeout for each trace to show up
+// complete in the tracing backend.
+type Runner struct {
+	Client   *Client
+	HTTP     *http.Client
+	Timeout  time.Duration
+	Interval time.Duration
+}
+
+// Run executes the tests of the scenario in order.
+func (r *Runner) Run(ctx context.Context, sc *Scenario) []Result {
+	results := make([]Result, 0, len(sc.Tests))
+	for _, tc := range sc.Tests {
+		results = append(results, r.runTest(ctx, tc))
+	}
+	return results
+}
+
+func (r *Runner) runTest(ctx context.Context, tc TestCase) Result {
+	start := time.Now()
+	res := Result{Name: tc.Name, Requests: tc.Count}
+
+	var (
+		traceID    string
+		badStatus  int
+		lastStatus int
+		lastErr    error
+	)
+	for i := 0; i < tc.Count; i++ {
+		id, status, err := r.send(ctx, tc.Request)
+		if i == 0 {
+			traceID = id
+		}
+		if err != nil {
+			lastErr = err
+		}
+		if err != nil || status >= http.StatusBadRequest {
+			res.Errors++
+		}
+		if tc.ExpectStatus != 0 && status != tc.ExpectStatus {
+			badStatus++
+			lastStatus = status
+		}
+	}
+	if badStatus > 0 {
+		res.Failures = append(res.Failures, fmt.Sprintf(
+			"%d of %d requests returned an unexpected status, expected %d, got %d",
+			badStatus, tc.Count, tc.ExpectStatus, lastStatus))
+	}
+	if lastErr != nil && tc.ErrorRate == nil {
+		// failing requests are accepted if covered by the expected error rate
+		res.Failures = append(res.Failures, fmt.Sprintf("request failed: %v", lastErr))
+	}
+	if tc.Count > 0 {
+		res.ErrorRate = float64(res.Errors) * 100 / float64(tc.Count)
+	}
+	if er := tc.ErrorRate; er != nil && (res.ErrorRate < er.Min || res.ErrorRate > er.Max) {
+		res.Failures = append(res.Failures, fmt.Sprintf(
+			"error rate %.1f%% outside of expected range %.1f%% - %.1f%%",
+			res.ErrorRate, er.Min, er.Max))
+	}
+	if te := tc.Trace; te != nil && traceID != "" {
+		t, err := r.waitForTrace(ctx, traceID, te)
+		res.Trace = t
+		if err != nil {
+			res.Failures = append(res.Failures, fmt.Sprintf("trace %s: %v", traceID, err))
+		}
+	}
+	res.Duration = time.Since(start)
+	return res
+}
+
+// send executes the request with freshly generated B3 headers, so the trace
+// can be looked up in the tracing backend afterwards.
+func (r *Runner) send(ctx context.Context, tr Request) (traceID string, status int, err error) {
+	var body io.Reader
+	if tr.Body != "" {
+		body = strings.NewReader(tr.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, tr.Method, tr.URL, body)
+	if err != nil {
+		return "", 0, err
+	}
+	for k, v := range tr.Headers {
+		req.Header.Set(k, v)
+	}
+	traceID, spanID := randomHex(16), randomHex(8)
+	req.Header.Set("X-B3-TraceId", traceID)
+	req.Header.Set("X-B3-SpanId", spanID)
+	req.Header.Set("X-B3-Sampled", "1")
+
+	client := r.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return traceID, 0, err
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	_ = res.Body.Close()
+	return traceID, res.StatusCode, nil
+}
+
+// waitForTrace polls the tracing backend until the trace meets the
+// expectations or the timeout expires.
+func (r *Runner) waitForTrace(ctx context.Context, traceID string, te *TraceExpectation) (*Trace, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	var (
+		found *Trace
+		err   error
+	)
+	for {
+		t, qErr := r.Client.Trace(ctx, traceID)
+		switch {
+		case qErr == nil:
+			found = t
+			if err = t.CheckGraph(te.MinSpans, te.Services, te.Edges); err == nil {
+				return t, nil
+			}
+		case ctx.Err() == nil || err == nil:
+			// keep the outcome of the previous query if interrupted by the timeout
+			err = qErr
+		}
+		select {
+		case <-ctx.Done():
+			return found, err
+		case <-time.After(r.Interval):
+		}
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,122 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScenario(t *testing.T) {
+	tests := []struct {
+		input string
+		err   bool
+	}{
+		{`{"tests":[{"request":{"url":"http://svca:8000/"}}]}`, false},
+		{`{"tests":[]}`, true},
+		{`{"tests":[{"request":{"url":"/proxy/svcb"}}]}`, true},
+		{`{"tests":[{"request":{"url":"http://svca:8000/"},"count":-1}]}`, true},
+		{`{"tests":[{"request":{"url":"http://svca:8000/"},"errorRate":{"min":20,"max":10}}]}`, true},
+		{`{"backend":"datadog","tests":[{"request":{"url":"http://svca:8000/"}}]}`, true},
+		{`{"tests":`, true},
+	}
+	for _, tt := range tests {
+		sc, err := parseScenario([]byte(tt.input))
+		if tt.err {
+			if !errors.Is(err, errScenario) {
+				t.Errorf("%s: expected errScenario, got %v", tt.input, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.input, err)
+		}
+		tc := sc.Tests[0]
+		if tc.Name != "test-1" || tc.Count != 1 || tc.Request.Method != http.MethodGet {
+			t.Errorf("%s: defaults not applied: %+v", tt.input, tc)
+		}
+	}
+}
+
+func TestRunnerRun(t *testing.T) {
+	topo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-B3-TraceId") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer topo.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/v2/trace/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`[{"id":"1","localEndpoint":{"serviceName":"svca"}},
+			{"id":"2","parentId":"1","localEndpoint":{"serviceName":"svcb"}}]`))
+	}))
+	defer backend.Close()
+
+	sc, err := parseScenario([]byte(`{"name":"e2e","tests":[
+		{"name":"ok","request":{"url":"` + topo.URL + `/ok"},"count":2,"expectStatus":200,
+			"trace":{"minSpans":2,"edges":["svca->svcb"]}},
+		{"name":"errors","request":{"url":"` + topo.URL + `/fail"},"count":2,
+			"errorRate":{"min":100,"max":100}},
+		{"name":"graph","request":{"url":"` + topo.URL + `/ok"},
+			"trace":{"edges":["svcb->svca"]}}
+	]}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := &Runner{
+		Client:   &Client{Backend: BackendZipkin, Address: backend.URL},
+		Timeout:  50 * time.Millisecond,
+		Interval: 10 * time.Millisecond,
+	}
+	results := r.Run(context.Background(), sc)
+
+	expected := []int{0, 0, 1}
+	for i, res := range results {
+		if len(res.Failures) != expected[i] {
+			t.Errorf("%s: expected %d failures, got %v", res.Name, expected[i], res.Failures)
+		}
+	}
+	if results[1].ErrorRate != 100 {
+		t.Errorf("expected error rate 100, got %v", results[1].ErrorRate)
+	}
+
+	var buf bytes.Buffer
+	if err = WriteJUnit(&buf, sc.Name, results); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var report junitSuites
+	if err = xml.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("invalid JUnit XML: %v", err)
+	}
+	suite := report.Suites[0]
+	if suite.Name != "e2e" || suite.Tests != 3 || suite.Failures != 1 {
+		t.Errorf("unexpected suite: %+v", suite)
+	}
+	if f := suite.Cases[2].Failure; f == nil || !strings.Contains(f.Message, "svcb->svca") {
+		t.Errorf("expected failure on missing call, got %+v", f)
+	}
+}
@@ -0,0 +1,429 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package verify implements a client for the query APIs of tracing backends,
+// confirming that a trace reported by the topology testers made it to the
+// backend with the expected spans and services. This closes the loop for
+// automated end-to-end tracing tests.
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/multierror"
+	"github.com/tetratelabs/run"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+)
+
+// flags
+const (
+	Backend      = "verify-backend"
+	Address      = "verify-address"
+	TraceID      = "verify-trace-id"
+	MinSpans     = "verify-min-spans"
+	Services     = "verify-services"
+	Timeout      = "verify-timeout"
+	Interval     = "verify-interval"
+	ScenarioFile = "verify-scenario"
+	JUnit        = "verify-junit"
+)
+
+// supported tracing backends
+const (
+	BackendZipkin     = "zipkin"
+	BackendJaeger     = "jaeger"
+	BackendSkyWalking = "skywalking"
+)
+
+const (
+	errBackend  pkg.Error = "expected one of: zipkin, jaeger, skywalking"
+	errTraceID  pkg.Error = "expected a hex encoded trace id"
+	errMinSpans pkg.Error = "expected a zero or positive amount of spans"
+	errDuration pkg.Error = "expected a positive duration"
+
+	// ErrNotFound is returned if the backend does not hold the trace.
+	ErrNotFound pkg.Error = "trace not found"
+	// ErrMismatch is returned if the trace does not meet the expectations.
+	ErrMismatch pkg.Error = "trace does not match expectations"
+	// ErrFailed is returned if any of the tests of a scenario failed.
+	ErrFailed pkg.Error = "scenario failed"
+)
+
+const (
+	defaultTimeout  = 30 * time.Second
+	defaultInterval = time.Second
+)
+
+// defaultAddresses holds the default query API address of each backend.
+var defaultAddresses = map[string]string{
+	BackendZipkin:     "http://zipkin:9411",
+	BackendJaeger:     "http://jaeger-query:16686",
+	BackendSkyWalking: "http://skywalking-oap:12800",
+}
+
+var (
+	_ run.Config    = (*Service)(nil)
+	_ run.PreRunner = (*Service)(nil)
+)
+
+// Trace holds the details of a trace as found in the tracing backend.
+type Trace struct {
+	TraceID  string   `json:"traceID"`
+	Spans    int      `json:"spans"`
+	Services []string `json:"services"`
+	Edges    []string `json:"edges"`
+}
+
+// Check returns ErrMismatch if the trace holds less than minSpans spans or
+// does not include all of the provided services.
+func (t *Trace) Check(minSpans int, services []string) error {
+	return t.CheckGraph(minSpans, services, nil)
+}
+
+// CheckGraph returns ErrMismatch if the trace holds less than minSpans spans,
+// does not include all of the provided services or misses any of the provided
+// edges, being calls between services in the form of "svca->svcb".
+func (t *Trace) CheckGraph(minSpans int, services, edges []string) error {
+	var problems []string
+	if t.Spans < minSpans {
+		problems = append(problems,
+			fmt.Sprintf("expected at least %d spans, got %d", minSpans, t.Spans))
+	}
+	for _, svc := range services {
+		found := false
+		for _, s := range t.Services {
+			if strings.EqualFold(s, svc) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("service %s not found", svc))
+		}
+	}
+	for _, edge := range edges {
+		found := false
+		for _, e := range t.Edges {
+			if strings.EqualFold(strings.Replace(edge, " ", "", -1), e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("call %s not found", edge))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrMismatch, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// Client queries the trace API of a tracing backend.
+type Client struct {
+	Backend string
+	Address string
+	HTTP    *http.Client
+}
+
+// Trace retrieves a trace from the backend, returning ErrNotFound if the
+// backend does not hold it (yet).
+func (c *Client) Trace(ctx context.Context, traceID string) (*Trace, error) {
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	address := strings.TrimSuffix(c.Address, "/")
+	if address == "" {
+		address = defaultAddresses[c.Backend]
+	}
+	switch c.Backend {
+	case BackendZipkin:
+		return zipkinTrace(ctx, client, address, traceID)
+	case BackendJaeger:
+		return jaegerTrace(ctx, client, address, traceID)
+	case BackendSkyWalking:
+		return skyWalkingTrace(ctx, client, address, traceID)
+	default:
+		return nil, errBackend
+	}
+}
+
+// span holds the details of a span needed to build the span graph. Shared
+// spans are the server side of a client span using the same span id, as
+// found with Zipkin.
+type span struct {
+	id      string
+	parent  string
+	service string
+	shared  bool
+}
+
+// newTrace returns a trace holding the span count, the sorted unique service
+// names and the calls between services found in the provided spans.
+func newTrace(traceID string, spans []span) *Trace {
+	t := &Trace{TraceID: traceID, Spans: len(spans), Services: []string{}, Edges: []string{}}
+	byID := make(map[string][]span, len(spans))
+	services := make(map[string]bool)
+	for _, s := range spans {
+		byID[s.id] = append(byID[s.id], s)
+		if s.service != "" && !services[s.service] {
+			services[s.service] = true
+			t.Services = append(t.Services, s.service)
+		}
+	}
+	edges := make(map[string]bool)
+	for _, s := range spans {
+		var parent *span
+		if s.shared {
+			// the caller holds the client side of a shared span
+			parent = pickSpan(byID[s.id], false)
+		} else if s.parent != "" {
+			// prefer the server side of a shared parent span
+			parent = pickSpan(byID[s.parent], true)
+		}
+		if parent == nil || parent.service == s.service || parent.service == "" || s.service == "" {
+			continue
+		}
+		edge := parent.service + "->" + s.service
+		if !edges[edge] {
+			edges[edge] = true
+			t.Edges = append(t.Edges, edge)
+		}
+	}
+	sort.Strings(t.Services)
+	sort.Strings(t.Edges)
+	return t
+}
+
+// pickSpan returns the span matching the shared setting or any span if none
+// match.
+func pickSpan(spans []span, shared bool) *span {
+	for i := range spans {
+		if spans[i].shared == shared {
+			return &spans[i]
+		}
+	}
+	if len(spans) > 0 {
+		return &spans[0]
+	}
+	return nil
+}
+
+// Service implements a run.Group compatible unit verifying a trace or
+// executing a scenario as part of its PreRun phase, so it can be used by a
+// CLI without Service units.
+type Service struct {
+	Backend  string
+	Address  string
+	TraceID  string
+	MinSpans int
+	Services []string
+	Timeout  time.Duration
+	Interval time.Duration
+	Scenario string
+	JUnit    string
+
+	scenario *Scenario
+}
+
+// Name implements run.Unit.
+func (s *Service) Name() string {
+	return "verify"
+}
+
+// FlagSet implements run.Config.
+func (s *Service) FlagSet() *run.FlagSet {
+	if s.Backend == "" {
+		s.Backend = BackendZipkin
+	}
+	if s.Timeout == 0 {
+		s.Timeout = defaultTimeout
+	}
+	if s.Interval == 0 {
+		s.Interval = defaultInterval
+	}
+
+	flags := run.NewFlagSet("Trace verification options")
+
+	flags.StringVar(
+		&s.Backend,
+		Backend,
+		s.Backend,
+		`Tracing backend to query, one of: zipkin, jaeger, skywalking`)
+	flags.StringVar(
+		&s.Address,
+		Address,
+		s.Address,
+		`Query API address of the tracing backend, defaults to `+
+			`http://zipkin:9411, http://jaeger-query:16686 or http://skywalking-oap:12800`)
+	flags.StringVar(
+		&s.TraceID,
+		TraceID,
+		s.TraceID,
+		`Trace id to verify, as returned by the topology tester`)
+	flags.IntVar(
+		&s.MinSpans,
+		MinSpans,
+		s.MinSpans,
+		`Minimum amount of spans the trace must hold`)
+	flags.StringSliceVar(
+		&s.Services,
+		Services,
+		s.Services,
+		`Services the trace must include, e.g. "svca,svcb,svcc"`)
+	flags.DurationVar(
+		&s.Timeout,
+		Timeout,
+		s.Timeout,
+		`Time to wait for the trace to be complete in the backend`)
+	flags.DurationVar(
+		&s.Interval,
+		Interval,
+		s.Interval,
+		`Interval between queries while waiting for the trace`)
+
+	flags.StringVar(
+		&s.Scenario,
+		ScenarioFile,
+		s.Scenario,
+		`Scenario file holding the requests to run and the expected span graph `+
+			`and error rates, replaces the trace id based verification`)
+
+	flags.StringVar(
+		&s.JUnit,
+		JUnit,
+		s.JUnit,
+		`Path to write the scenario results to as JUnit XML report`)
+
+	return flags
+}
+
+// Validate implements run.Config.
+func (s *Service) Validate() error {
+	var mErr error
+
+	if _, ok := defaultAddresses[s.Backend]; !ok {
+		mErr = multierror.Append(mErr, fmt.Errorf(pkg.FlagErr, Backend, errBackend))
+	}
+	if s.Scenario != "" {
+		var err error
+		if s.scenario, err = LoadScenario(s.Scenario); err != nil {
+			mErr = multierror.Append(mErr, fmt.Errorf(pkg.FlagErr, ScenarioFile, err))
+		}
+	} else if s.TraceID == "" {
+		mErr = multierror.Append(mErr, fmt.Errorf(pkg.FlagErr, TraceID, pkg.ErrRequired))
+	} else if s.Backend != BackendSkyWalking && !isHex(s.TraceID) {
+		mErr = multierror.Append(mErr, fmt.Errorf(pkg.FlagErr, TraceID, errTraceID))
+	}
+	if s.MinSpans < 0 {
+		mErr = multierror.Append(mErr, fmt.Errorf(pkg.FlagErr, MinSpans, errMinSpans))
+	}
+	if s.Timeout <= 0 {
+		mErr = multierror.Append(mErr, fmt.Errorf(pkg.FlagErr, Timeout, errDuration))
+	}
+	if s.Interval <= 0 {
+		mErr = multierror.Append(mErr, fmt.Errorf(pkg.FlagErr, Interval, errDuration))
+	}
+
+	return mErr
+}
+
+// PreRun implements run.PreRunner. It polls the backend until the trace meets
+// the expectations or the timeout expires, printing the trace found to
+// stdout. If a scenario is provided it is executed instead, printing the
+// results to stdout and returning ErrFailed if any of the tests failed.
+func (s *Service) PreRun() error {
+	if s.scenario != nil {
+		return s.runScenario()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	c := &Client{Backend: s.Backend, Address: s.Address}
+	for {
+		t, err := c.Trace(ctx, s.TraceID)
+		if err == nil {
+			if err = t.Check(s.MinSpans, s.Services); err == nil {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(t)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(s.Interval):
+		}
+	}
+}
+
+func (s *Service) runScenario() error {
+	c := &Client{Backend: s.Backend, Address: s.Address}
+	if s.scenario.Backend != "" {
+		c.Backend, c.Address = s.scenario.Backend, s.scenario.Address
+	}
+	if s.scenario.Address != "" {
+		c.Address = s.scenario.Address
+	}
+	r := &Runner{Client: c, Timeout: s.Timeout, Interval: s.Interval}
+	results := r.Run(context.Background(), s.scenario)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(results); err != nil {
+		return err
+	}
+	if s.JUnit != "" {
+		f, err := os.Create(s.JUnit)
+		if err != nil {
+			return err
+		}
+		err = WriteJUnit(f, s.scenario.Name, results)
+		if cErr := f.Close(); err == nil {
+			err = cErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	var failed int
+	for _, res := range results {
+		if len(res.Failures) > 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d tests failed", ErrFailed, failed, len(results))
+	}
+	return nil
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,86 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestClientTrace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/trace/abc":
+			_, _ = w.Write([]byte(`[{"id":"2","parentId":"1","localEndpoint":{"serviceName":"svca"}},
+				{"id":"2","parentId":"1","shared":true,"localEndpoint":{"serviceName":"svcb"}},
+				{"id":"3","parentId":"2","localEndpoint":{"serviceName":"svcb"}},
+				{"id":"1","localEndpoint":{"serviceName":"svca"}}]`))
+		case "/api/traces/abc":
+			_, _ = w.Write([]byte(`{"data":[{"spans":[{"spanID":"1","processID":"p1"},
+				{"spanID":"2","processID":"p2","references":[{"refType":"CHILD_OF","spanID":"1"}]}],
+				"processes":{"p1":{"serviceName":"svca"},"p2":{"serviceName":"svcc"}}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		backend  string
+		expected *Trace
+	}{
+		{BackendZipkin, &Trace{TraceID: "abc", Spans: 4, Services: []string{"svca", "svcb"},
+			Edges: []string{"svca->svcb"}}},
+		{BackendJaeger, &Trace{TraceID: "abc", Spans: 2, Services: []string{"svca", "svcc"},
+			Edges: []string{"svca->svcc"}}},
+	}
+	for _, tt := range tests {
+		c := &Client{Backend: tt.backend, Address: srv.URL}
+		trace, err := c.Trace(context.Background(), "abc")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.backend, err)
+		}
+		if !reflect.DeepEqual(trace, tt.expected) {
+			t.Errorf("%s: expected %+v, got %+v", tt.backend, tt.expected, trace)
+		}
+		if _, err = c.Trace(context.Background(), "def"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, got %v", tt.backend, err)
+		}
+	}
+}
+
+func TestTraceCheck(t *testing.T) {
+	trace := &Trace{TraceID: "abc", Spans: 3, Services: []string{"svca", "svcb"}}
+	if err := trace.Check(3, []string{"svcb"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := trace.Check(4, nil); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected ErrMismatch, got %v", err)
+	}
+	if err := trace.Check(0, []string{"svcc"}); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected ErrMismatch, got %v", err)
+	}
+	trace.Edges = []string{"svca->svcb"}
+	if err := trace.CheckGraph(0, nil, []string{"svca -> svcb"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := trace.CheckGraph(0, nil, []string{"svcb->svca"}); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected ErrMismatch, got %v", err)
+	}
+}
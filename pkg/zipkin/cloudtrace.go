@@ -0,0 +1,110 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/openzipkin/zipkin-go/propagation/b3"
+)
+
+// CloudTraceContextHeader is the trace context header used by Google Cloud
+// load balancers and Anthos Service Mesh, formatted as
+// TRACE_ID/SPAN_ID;o=OPTIONS with a decimal span id.
+const CloudTraceContextHeader = "X-Cloud-Trace-Context"
+
+// ExtractCloudTraceContext returns a middleware which translates an incoming
+// X-Cloud-Trace-Context header into B3 headers, unless the request already
+// holds B3 headers. It must wrap the Zipkin server middleware.
+func ExtractCloudTraceContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(b3.Context) == "" && r.Header.Get(b3.TraceID) == "" {
+			if traceID, spanID, sampled, ok := parseCloudTraceContext(r.Header.Get(CloudTraceContextHeader)); ok {
+				r.Header.Set(b3.TraceID, traceID)
+				r.Header.Set(b3.SpanID, fmt.Sprintf("%016x", spanID))
+				if sampled != "" {
+					r.Header.Set(b3.Sampled, sampled)
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// InjectCloudTraceContext returns a RoundTripper which adds the
+// X-Cloud-Trace-Context header matching the B3 headers of outgoing requests.
+// It must be wrapped by the Zipkin transport, so the B3 headers are set.
+func InjectCloudTraceContext(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		traceID := r.Header.Get(b3.TraceID)
+		spanID, err := strconv.ParseUint(r.Header.Get(b3.SpanID), 16, 64)
+		if traceID != "" && err == nil {
+			if len(traceID) < 32 {
+				traceID = strings.Repeat("0", 32-len(traceID)) + traceID
+			}
+			value := traceID + "/" + strconv.FormatUint(spanID, 10)
+			switch r.Header.Get(b3.Sampled) {
+			case "1", "true":
+				value += ";o=1"
+			case "0", "false":
+				value += ";o=0"
+			}
+			r.Header.Set(CloudTraceContextHeader, value)
+		}
+		return rt.RoundTrip(r)
+	})
+}
+
+// parseCloudTraceContext parses an X-Cloud-Trace-Context header value,
+// returning the trace id, span id and B3 sampled value.
+func parseCloudTraceContext(v string) (traceID string, spanID uint64, sampled string, ok bool) {
+	parts := strings.SplitN(v, "/", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[0]) > 32 {
+		return "", 0, "", false
+	}
+	if strings.Trim(parts[0], "0") == "" {
+		return "", 0, "", false
+	}
+	for _, c := range parts[0] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return "", 0, "", false
+		}
+	}
+	fields := strings.Split(parts[1], ";")
+	spanID, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil || spanID == 0 {
+		return "", 0, "", false
+	}
+	for _, f := range fields[1:] {
+		switch strings.TrimSpace(f) {
+		case "o=1":
+			sampled = "1"
+		case "o=0":
+			sampled = "0"
+		}
+	}
+	return strings.ToLower(parts[0]), spanID, sampled, true
+}
+
+// roundTripperFunc allows a function to be used as http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper.
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,339 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/propagation/b3"
+	"github.com/openzipkin/zipkin-go/reporter"
+	"github.com/tetratelabs/run/pkg/version"
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/transport"
+)
+
+// ElasticTraceparentHeader is the trace context header used by the Elastic APM
+// agents, formatted as a W3C traceparent: 00-{trace id}-{span id}-{flags}.
+const ElasticTraceparentHeader = "Elastic-Apm-Traceparent"
+
+// defaultElasticServerURL is the APM Server address used by the Elastic APM
+// agent if ELASTIC_APM_SERVER_URL is not set.
+const defaultElasticServerURL = "http://localhost:8200"
+
+// errElasticFlush is returned if a batch is not flushed within the timeout.
+var errElasticFlush = errors.New("timed out flushing spans to the APM Server")
+
+// elasticSender replays the spans created by the Zipkin tracer through the
+// Elastic APM Go agent, which streams them to the APM Server. Server and root
+// spans become transactions, other spans become spans of their parent. Each
+// batch is flushed synchronously, so the batching, fault injection and status
+// are shared with the other transports.
+type elasticSender struct {
+	tracer  *apm.Tracer
+	logger  *elasticLogger
+	timeout time.Duration
+}
+
+// elasticLogger keeps the last request error logged by the APM agent, so send
+// can report why a batch failed.
+type elasticLogger struct {
+	mtx sync.Mutex
+	err string
+}
+
+func (l *elasticLogger) Debugf(format string, args ...interface{}) {
+	if strings.HasPrefix(format, "request failed") {
+		l.Errorf(format, args...)
+	}
+}
+
+func (l *elasticLogger) Errorf(format string, args ...interface{}) {
+	l.mtx.Lock()
+	l.err = fmt.Sprintf(format, args...)
+	l.mtx.Unlock()
+}
+
+func (l *elasticLogger) lastError() string {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.err
+}
+
+// newElasticReporter returns a reporter sending spans of the provided service
+// to the APM Server at the provided address. The server address and secret
+// token fall back to the environment variables of the Elastic APM agent, such
+// as ELASTIC_APM_SERVER_URL and ELASTIC_APM_SECRET_TOKEN, if empty.
+func newElasticReporter(serverURL, secretToken, service string, cfg batchConfig) (reporter.Reporter, error) {
+	tr, err := transport.NewHTTPTransport()
+	if err != nil {
+		return nil, err
+	}
+	if serverURL != "" {
+		u, err := url.Parse(serverURL)
+		if err != nil {
+			return nil, err
+		}
+		tr.SetServerURL(u)
+	}
+	if secretToken != "" {
+		tr.SetSecretToken(secretToken)
+	}
+	rt := tr.Client.Transport
+	tr.Client.Transport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		// don't let service mesh sidecars trace our span reporting
+		r.Header.Set("b3", "0")
+		return rt.RoundTrip(r)
+	})
+
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{
+		ServiceName:    elasticServiceName(service),
+		ServiceVersion: version.Parse(),
+		Transport:      tr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// only report the spans handed to us: no agent metrics, remote
+	// configuration or stack traces of the reporter itself
+	tracer.SetMetricsInterval(0)
+	tracer.SetConfigWatcher(nil)
+	tracer.SetStackTraceLimit(0)
+	s := &elasticSender{
+		tracer:  tracer,
+		logger:  &elasticLogger{},
+		timeout: cfg.timeout,
+	}
+	tracer.SetLogger(s.logger)
+	return newBatchReporter(s.send, s.close, cfg), nil
+}
+
+func (s *elasticSender) send(spans []*model.SpanModel) error {
+	before := s.tracer.Stats()
+	for _, span := range spans {
+		s.record(span)
+	}
+	abort := make(chan struct{})
+	timer := time.AfterFunc(s.timeout, func() { close(abort) })
+	s.tracer.Flush(abort)
+	if !timer.Stop() {
+		return errElasticFlush
+	}
+
+	after := s.tracer.Stats()
+	if after.Errors.SendStream > before.Errors.SendStream {
+		return errors.New(s.logger.lastError())
+	}
+	dropped := after.TransactionsDropped + after.SpansDropped -
+		before.TransactionsDropped - before.SpansDropped
+	if dropped > 0 {
+		return fmt.Errorf("APM agent dropped %d spans", dropped)
+	}
+	return nil
+}
+
+func (s *elasticSender) close() error {
+	s.tracer.Close()
+	return nil
+}
+
+// record hands a Zipkin span to the APM agent as a transaction if it is a
+// server or root span, or else as a span of its parent.
+func (s *elasticSender) record(span *model.SpanModel) {
+	var traceCtx apm.TraceContext
+	binary.BigEndian.PutUint64(traceCtx.Trace[:8], span.TraceID.High)
+	binary.BigEndian.PutUint64(traceCtx.Trace[8:], span.TraceID.Low)
+	traceCtx.Options = traceCtx.Options.WithRecorded(true)
+	if span.ParentID != nil {
+		binary.BigEndian.PutUint64(traceCtx.Span[:], uint64(*span.ParentID))
+	}
+	var id apm.SpanID
+	binary.BigEndian.PutUint64(id[:], uint64(span.ID))
+
+	code, _ := strconv.Atoi(span.Tags["http.status_code"])
+	outcome := "success"
+	if _, ok := span.Tags["error"]; ok || code >= 500 {
+		outcome = "failure"
+	}
+	req := elasticRequest(span)
+
+	if span.ParentID == nil || span.Kind == model.Server {
+		tx := s.tracer.StartTransactionOptions(span.Name, "request", apm.TransactionOptions{
+			TraceContext:  traceCtx,
+			TransactionID: id,
+			Start:         span.Timestamp,
+		})
+		for k, v := range span.Tags {
+			tx.Context.SetLabel(k, v)
+		}
+		if req != nil {
+			tx.Context.SetHTTPRequest(req)
+			if code == 0 {
+				// the Zipkin middleware only tags unsuccessful status codes
+				code = http.StatusOK
+			}
+			tx.Context.SetHTTPStatusCode(code)
+			tx.Result = fmt.Sprintf("HTTP %dxx", code/100)
+		}
+		tx.Outcome = outcome
+		tx.Duration = span.Duration
+		tx.End()
+		return
+	}
+
+	spanType := "app"
+	switch span.Kind {
+	case model.Client:
+		spanType = "external"
+	case model.Producer, model.Consumer:
+		spanType = "messaging"
+	}
+	// Zipkin doesn't know the local root of a span, so its parent stands in
+	// for the transaction
+	sp := s.tracer.StartSpan(span.Name, spanType, traceCtx.Span, apm.SpanOptions{
+		Parent: traceCtx,
+		SpanID: id,
+		Start:  span.Timestamp,
+	})
+	for k, v := range span.Tags {
+		sp.Context.SetLabel(k, v)
+	}
+	if span.Kind == model.Client {
+		if req != nil {
+			sp.Subtype = "http"
+			sp.Context.SetHTTPRequest(req)
+			if code > 0 {
+				sp.Context.SetHTTPStatusCode(code)
+			}
+		}
+		if span.RemoteEndpoint != nil && span.RemoteEndpoint.ServiceName != "" {
+			sp.Context.SetDestinationService(apm.DestinationServiceSpanContext{
+				Name:     span.RemoteEndpoint.ServiceName,
+				Resource: span.RemoteEndpoint.ServiceName,
+			})
+		}
+	}
+	sp.Outcome = outcome
+	sp.Duration = span.Duration
+	sp.End()
+}
+
+// elasticServiceName replaces the characters not allowed in Elastic APM service
+// names with underscores.
+func elasticServiceName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == ' ', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// elasticRequest returns the HTTP request described by the span tags, if any.
+func elasticRequest(span *model.SpanModel) *http.Request {
+	method, ok := span.Tags["http.method"]
+	if !ok {
+		return nil
+	}
+	raw := span.Tags["http.url"]
+	if raw == "" {
+		raw = span.Tags["http.path"]
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		u = &url.URL{}
+	}
+	return &http.Request{Method: method, URL: u, Host: u.Host, Header: http.Header{}}
+}
+
+// ExtractElasticTraceparent returns a middleware which translates an incoming
+// Elastic-Apm-Traceparent header into B3 headers, unless the request already
+// holds B3 headers. It must wrap the Zipkin server middleware.
+func ExtractElasticTraceparent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(b3.Context) == "" && r.Header.Get(b3.TraceID) == "" {
+			if traceID, spanID, sampled, ok := parseTraceparent(r.Header.Get(ElasticTraceparentHeader)); ok {
+				r.Header.Set(b3.TraceID, traceID)
+				r.Header.Set(b3.SpanID, spanID)
+				r.Header.Set(b3.Sampled, sampled)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// InjectElasticTraceparent returns a RoundTripper which adds the
+// Elastic-Apm-Traceparent header matching the B3 headers of outgoing requests.
+// It must be wrapped by the Zipkin transport, so the B3 headers are set.
+func InjectElasticTraceparent(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if value, ok := formatTraceparent(r.Header); ok {
+			r.Header.Set(ElasticTraceparentHeader, value)
+		}
+		return rt.RoundTrip(r)
+	})
+}
+
+// formatTraceparent returns the traceparent value matching the provided B3
+// headers.
+func formatTraceparent(h http.Header) (string, bool) {
+	traceID := h.Get(b3.TraceID)
+	spanID := h.Get(b3.SpanID)
+	if traceID == "" || len(traceID) > 32 || len(spanID) != 16 {
+		return "", false
+	}
+	if len(traceID) < 32 {
+		traceID = strings.Repeat("0", 32-len(traceID)) + traceID
+	}
+	flags := "00"
+	switch h.Get(b3.Sampled) {
+	case "1", "true":
+		flags = "01"
+	}
+	if h.Get(b3.Flags) == "1" {
+		flags = "01"
+	}
+	return "00-" + traceID + "-" + spanID + "-" + flags, true
+}
+
+// parseTraceparent parses a traceparent header value, returning the trace id,
+// parent span id and B3 sampled value.
+func parseTraceparent(v string) (traceID, spanID, sampled string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return "", "", "", false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 ||
+		!isHex(parts[0]+parts[1]+parts[2]+parts[3]) {
+		return "", "", "", false
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", "", false
+	}
+	flags, _ := strconv.ParseUint(parts[3], 16, 8)
+	sampled = strconv.FormatUint(flags&1, 10)
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2]), sampled, true
+}
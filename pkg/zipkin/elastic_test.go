@@ -0,0 +1,229 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+// fakeAPMServer records the intake events posted to it, answering with the
+// provided status code.
+type fakeAPMServer struct {
+	*httptest.Server
+	t      *testing.T
+	code   int
+	mtx    sync.Mutex
+	header http.Header
+	events []map[string]map[string]interface{}
+}
+
+func newFakeAPMServer(t *testing.T, code int) *fakeAPMServer {
+	s := &fakeAPMServer{t: t, code: code}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.intake))
+	return s
+}
+
+func (s *fakeAPMServer) intake(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/intake/v2/events" {
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var body io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "deflate":
+		zr, err := zlib.NewReader(r.Body)
+		if err != nil {
+			s.t.Errorf("invalid deflate body: %v", err)
+			return
+		}
+		body = zr
+	case "gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			s.t.Errorf("invalid gzip body: %v", err)
+			return
+		}
+		body = zr
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.header = r.Header
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var event map[string]map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			s.t.Errorf("invalid event %q: %v", scanner.Text(), err)
+		}
+		s.events = append(s.events, event)
+	}
+	w.WriteHeader(s.code)
+}
+
+// find returns the events of the provided kind.
+func (s *fakeAPMServer) find(kind string) []map[string]interface{} {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	var events []map[string]interface{}
+	for _, e := range s.events {
+		if v, ok := e[kind]; ok {
+			events = append(events, v)
+		}
+	}
+	return events
+}
+
+func TestElasticIntake(t *testing.T) {
+	srv := newFakeAPMServer(t, http.StatusAccepted)
+	defer srv.Close()
+
+	rep, err := newElasticReporter(srv.URL+"/", "secret", "svca.ns", batchConfig{
+		size: 10, queue: 10, interval: time.Hour, timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	traceID := model.TraceID{High: 1, Low: 2}
+	parentID := model.ID(3)
+	start := time.Unix(1, 0)
+	rep.Send(model.SpanModel{
+		SpanContext: model.SpanContext{TraceID: traceID, ID: parentID},
+		Name:        "get /echo",
+		Kind:        model.Server,
+		Timestamp:   start,
+		Duration:    2 * time.Millisecond,
+		Tags: map[string]string{
+			"http.method":      "GET",
+			"http.path":        "/echo",
+			"http.status_code": "503",
+		},
+	})
+	rep.Send(model.SpanModel{
+		SpanContext:    model.SpanContext{TraceID: traceID, ID: 4, ParentID: &parentID},
+		Name:           "get",
+		Kind:           model.Client,
+		Timestamp:      start,
+		Duration:       time.Millisecond,
+		RemoteEndpoint: &model.Endpoint{ServiceName: "svcb"},
+		Tags:           map[string]string{"http.method": "GET", "http.url": "http://svcb/"},
+	})
+	if err := rep.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ct := srv.header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected ndjson, got %s", ct)
+	}
+	if auth := srv.header.Get("Authorization"); auth != "Bearer secret" {
+		t.Errorf("expected bearer token, got %q", auth)
+	}
+	if b3 := srv.header.Get("b3"); b3 != "0" {
+		t.Errorf("expected span reporting not to be traced, got b3 %q", b3)
+	}
+	metadata := srv.find("metadata")
+	if len(metadata) != 1 {
+		t.Fatalf("expected a metadata line, got %d", len(metadata))
+	}
+	if name := metadata[0]["service"].(map[string]interface{})["name"]; name != "svca_ns" {
+		t.Errorf("expected sanitized service svca_ns, got %v", name)
+	}
+
+	txs, spans := srv.find("transaction"), srv.find("span")
+	if len(txs) != 1 || len(spans) != 1 {
+		t.Fatalf("expected a transaction and a span, got %d and %d", len(txs), len(spans))
+	}
+	tx := txs[0]
+	for field, expected := range map[string]interface{}{
+		"id":        "0000000000000003",
+		"trace_id":  "00000000000000010000000000000002",
+		"name":      "get /echo",
+		"type":      "request",
+		"timestamp": float64(1e6),
+		"duration":  float64(2),
+		"outcome":   "failure",
+		"result":    "HTTP 5xx",
+	} {
+		if tx[field] != expected {
+			t.Errorf("transaction %s: expected %v, got %v", field, expected, tx[field])
+		}
+	}
+	if _, ok := tx["parent_id"]; ok {
+		t.Errorf("expected root transaction, got parent %v", tx["parent_id"])
+	}
+	txCtx := tx["context"].(map[string]interface{})
+	if labels := txCtx["tags"].(map[string]interface{}); labels["http_method"] != "GET" {
+		t.Errorf("expected sanitized label keys, got %v", labels)
+	}
+	if code := txCtx["response"].(map[string]interface{})["status_code"]; code != float64(503) {
+		t.Errorf("expected status code 503, got %v", code)
+	}
+
+	span := spans[0]
+	for field, expected := range map[string]interface{}{
+		"id":             "0000000000000004",
+		"trace_id":       "00000000000000010000000000000002",
+		"parent_id":      "0000000000000003",
+		"transaction_id": "0000000000000003",
+		"type":           "external",
+		"subtype":        "http",
+		"outcome":        "success",
+		"duration":       float64(1),
+	} {
+		if span[field] != expected {
+			t.Errorf("span %s: expected %v, got %v", field, expected, span[field])
+		}
+	}
+	if _, ok := span["stacktrace"]; ok {
+		t.Error("expected no stack trace of the reporter")
+	}
+	destination := span["context"].(map[string]interface{})["destination"].(map[string]interface{})
+	if name := destination["service"].(map[string]interface{})["name"]; name != "svcb" {
+		t.Errorf("expected destination svcb, got %v", name)
+	}
+}
+
+func TestElasticIntakeError(t *testing.T) {
+	srv := newFakeAPMServer(t, http.StatusServiceUnavailable)
+	defer srv.Close()
+
+	rep, err := newElasticReporter(srv.URL, "", "svca", batchConfig{
+		size: 10, queue: 10, interval: time.Hour, timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rep.Close()
+	err = rep.(*batchReporter).send([]*model.SpanModel{{
+		SpanContext: model.SpanContext{TraceID: model.TraceID{Low: 1}, ID: 1},
+		Name:        "get /",
+		Timestamp:   time.Unix(1, 0),
+	}})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected the APM Server error to be returned, got %v", err)
+	}
+}
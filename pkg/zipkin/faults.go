@@ -0,0 +1,198 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+)
+
+// reporter fault errors
+const (
+	ErrFaultDrop  pkg.Error = "expected a drop percentage between 0 and 100"
+	ErrFaultValue pkg.Error = "expected a zero or positive value"
+)
+
+// ReporterFaults injects faults on the tracing path itself, so "missing spans"
+// symptoms can be diagnosed against a known ground truth. A percentage of the
+// spans is dropped before reporting, tag values are truncated and spans are
+// handed to the reporter after a delay, emulating late flushes. The affected
+// spans are counted in the zipkin expvar map, and spans kept in memory with
+// --zipkin-memory-spans are not affected. The faults can be adjusted at
+// runtime.
+type ReporterFaults struct {
+	reporter.Reporter
+	dropped int64
+
+	mtx      sync.Mutex
+	drop     float64
+	truncate int
+	delay    time.Duration
+	pending  map[*time.Timer]model.SpanModel
+	closed   bool
+}
+
+// NewReporterFaults returns the reporter wrapped to inject the provided faults.
+func NewReporterFaults(rep reporter.Reporter, drop float64, truncate int, delay time.Duration) (*ReporterFaults, error) {
+	f := &ReporterFaults{
+		Reporter: rep,
+		pending:  make(map[*time.Timer]model.SpanModel),
+	}
+	if err := f.SetDrop(drop); err != nil {
+		return nil, err
+	}
+	if err := f.SetTruncate(truncate); err != nil {
+		return nil, err
+	}
+	if err := f.SetDelay(delay); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Send implements reporter.Reporter.
+func (f *ReporterFaults) Send(s model.SpanModel) {
+	f.mtx.Lock()
+	drop, truncate, delay := f.drop, f.truncate, f.delay
+	if f.closed {
+		delay = 0
+	}
+	f.mtx.Unlock()
+
+	if drop > 0 && rand.Float64()*100 < drop {
+		atomic.AddInt64(&f.dropped, 1)
+		reporterMetrics.Add("faultDroppedSpans", 1)
+		return
+	}
+	if truncate > 0 {
+		s.Tags = truncateTags(s.Tags, truncate)
+	}
+	if delay <= 0 {
+		f.Reporter.Send(s)
+		return
+	}
+	reporterMetrics.Add("faultDelayedSpans", 1)
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	var t *time.Timer
+	t = time.AfterFunc(delay, func() {
+		f.mtx.Lock()
+		s, ok := f.pending[t]
+		delete(f.pending, t)
+		f.mtx.Unlock()
+		if ok {
+			f.Reporter.Send(s)
+		}
+	})
+	f.pending[t] = s
+}
+
+// truncateTags returns the tags with their values truncated to length bytes.
+// The tags are copied if truncated, as the span model is shared with the
+// memory reporter.
+func truncateTags(tags map[string]string, length int) map[string]string {
+	var truncated map[string]string
+	for k, v := range tags {
+		if len(v) <= length {
+			continue
+		}
+		if truncated == nil {
+			truncated = make(map[string]string, len(tags))
+			for k, v := range tags {
+				truncated[k] = v
+			}
+		}
+		truncated[k] = v[:length]
+		reporterMetrics.Add("faultTruncatedTags", 1)
+	}
+	if truncated == nil {
+		return tags
+	}
+	return truncated
+}
+
+// Close implements reporter.Reporter. Delayed spans are handed to the reporter
+// right away before closing it.
+func (f *ReporterFaults) Close() error {
+	f.mtx.Lock()
+	f.closed = true
+	pending := make([]model.SpanModel, 0, len(f.pending))
+	for t, s := range f.pending {
+		if t.Stop() {
+			pending = append(pending, s)
+		}
+		delete(f.pending, t)
+	}
+	f.mtx.Unlock()
+
+	for _, s := range pending {
+		f.Reporter.Send(s)
+	}
+	return f.Reporter.Close()
+}
+
+// SetDrop sets the percentage of spans dropped before reporting.
+func (f *ReporterFaults) SetDrop(percentage float64) error {
+	if percentage < 0 || percentage > 100 || math.IsNaN(percentage) {
+		return ErrFaultDrop
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.drop = percentage
+	return nil
+}
+
+// SetTruncate sets the maximum length of tag values, 0 disables truncation.
+func (f *ReporterFaults) SetTruncate(length int) error {
+	if length < 0 {
+		return ErrFaultValue
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.truncate = length
+	return nil
+}
+
+// SetDelay sets the delay with which spans are handed to the reporter.
+func (f *ReporterFaults) SetDelay(delay time.Duration) error {
+	if delay < 0 {
+		return ErrFaultValue
+	}
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.delay = delay
+	return nil
+}
+
+// Settings returns the drop percentage, the maximum tag value length, the
+// reporting delay and the amount of delayed spans waiting to be reported.
+func (f *ReporterFaults) Settings() (drop float64, truncate int, delay time.Duration, pending int) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.drop, f.truncate, f.delay, len(f.pending)
+}
+
+// Dropped returns the amount of spans dropped so far.
+func (f *ReporterFaults) Dropped() int64 {
+	return atomic.LoadInt64(&f.dropped)
+}
@@ -0,0 +1,47 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"testing"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+)
+
+func TestReporterFaultsDropped(t *testing.T) {
+	rec := recorder.NewReporter()
+	f, err := NewReporterFaults(rec, 100, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		f.Send(model.SpanModel{})
+	}
+	if n := f.Dropped(); n != 3 {
+		t.Errorf("expected 3 dropped spans, got %d", n)
+	}
+	if spans := rec.Flush(); len(spans) != 0 {
+		t.Errorf("expected no spans to be reported, got %d", len(spans))
+	}
+
+	if err = f.SetDrop(0); err != nil {
+		t.Fatal(err)
+	}
+	f.Send(model.SpanModel{})
+	if n, spans := f.Dropped(), rec.Flush(); n != 3 || len(spans) != 1 {
+		t.Errorf("expected span to be reported, got %d dropped and %d reported", n, len(spans))
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+)
+
+const (
+	// cloudTraceEndpoint is the Cloud Trace v2 API endpoint.
+	cloudTraceEndpoint = "https://cloudtrace.googleapis.com/v2"
+	// defaultMetadataHost is the GCE metadata server, which can be overridden
+	// with the GCE_METADATA_HOST environment variable like the Google client
+	// libraries allow.
+	defaultMetadataHost = "metadata.google.internal"
+)
+
+var errMetadata = errors.New("unexpected metadata server response")
+
+// cloudTraceKinds maps Zipkin span kinds to Cloud Trace span kinds.
+var cloudTraceKinds = map[model.Kind]string{
+	model.Server:   "SERVER",
+	model.Client:   "CLIENT",
+	model.Producer: "PRODUCER",
+	model.Consumer: "CONSUMER",
+}
+
+// gcpSender sends batches of spans to Google Cloud Trace, authenticating with
+// the service account of the GKE workload or GCE instance as provided by the
+// metadata server.
+type gcpSender struct {
+	project  string
+	client   *http.Client
+	timeout  time.Duration
+	endpoint string
+	metadata string
+
+	mtx     sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// newGCPReporter returns a reporter sending spans to Cloud Trace for the
+// provided project, or the project of the metadata server if empty.
+func newGCPReporter(project string, cfg batchConfig) reporter.Reporter {
+	s := &gcpSender{
+		project:  project,
+		client:   &http.Client{},
+		timeout:  cfg.timeout,
+		endpoint: cloudTraceEndpoint,
+		metadata: defaultMetadataHost,
+	}
+	if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+		s.metadata = host
+	}
+	return newBatchReporter(s.send, nil, cfg)
+}
+
+func (s *gcpSender) send(spans []*model.SpanModel) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	if s.project == "" {
+		if s.project, err = s.metadataValue(ctx, "project/project-id"); err != nil {
+			return err
+		}
+	}
+
+	body := map[string]interface{}{"spans": cloudTraceSpans(s.project, spans)}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	url := s.endpoint + "/projects/" + s.project + "/traces:batchWrite"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("unexpected status code %d: %s", res.StatusCode, bytes.TrimSpace(msg))
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	return nil
+}
+
+// accessToken returns a cached access token of the default service account,
+// refreshing it from the metadata server shortly before it expires.
+func (s *gcpSender) accessToken(ctx context.Context) (string, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.token != "" && time.Now().Before(s.expires) {
+		return s.token, nil
+	}
+	raw, err := s.metadataValue(ctx, "instance/service-accounts/default/token")
+	if err != nil {
+		return "", err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err = json.Unmarshal([]byte(raw), &token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", errMetadata
+	}
+	s.token = token.AccessToken
+	s.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return s.token, nil
+}
+
+// metadataValue returns a value from the metadata server.
+func (s *gcpSender) metadataValue(ctx context.Context, path string) (string, error) {
+	url := "http://" + s.metadata + "/computeMetadata/v1/" + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %s %d", errMetadata, path, res.StatusCode)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// cloudTraceSpans converts Zipkin spans to the Cloud Trace v2 span format.
+func cloudTraceSpans(project string, spans []*model.SpanModel) []map[string]interface{} {
+	res := make([]map[string]interface{}, 0, len(spans))
+	for _, s := range spans {
+		traceID := fmt.Sprintf("%016x%016x", s.TraceID.High, s.TraceID.Low)
+		attrs := make(map[string]interface{}, len(s.Tags)+1)
+		for k, v := range s.Tags {
+			attrs[k] = truncatable(v, 256)
+		}
+		if s.LocalEndpoint != nil && s.LocalEndpoint.ServiceName != "" {
+			attrs["service.name"] = truncatable(s.LocalEndpoint.ServiceName, 256)
+		}
+		events := make([]map[string]interface{}, 0, len(s.Annotations))
+		for _, a := range s.Annotations {
+			events = append(events, map[string]interface{}{
+				"time": a.Timestamp.UTC().Format(time.RFC3339Nano),
+				"annotation": map[string]interface{}{
+					"description": truncatableString(a.Value, 256),
+				},
+			})
+		}
+		span := map[string]interface{}{
+			"name":        "projects/" + project + "/traces/" + traceID + "/spans/" + s.ID.String(),
+			"spanId":      s.ID.String(),
+			"displayName": truncatableString(s.Name, 128),
+			"startTime":   s.Timestamp.UTC().Format(time.RFC3339Nano),
+			"endTime":     s.Timestamp.Add(s.Duration).UTC().Format(time.RFC3339Nano),
+			"attributes":  map[string]interface{}{"attributeMap": attrs},
+			"timeEvents":  map[string]interface{}{"timeEvent": events},
+		}
+		if s.ParentID != nil {
+			span["parentSpanId"] = s.ParentID.String()
+		}
+		if kind, ok := cloudTraceKinds[s.Kind]; ok {
+			span["spanKind"] = kind
+		}
+		res = append(res, span)
+	}
+	return res
+}
+
+// truncatableString returns a Cloud Trace TruncatableString, truncating the
+// value to the provided maximum amount of bytes without splitting a UTF-8
+// encoded character.
+func truncatableString(v string, max int) map[string]interface{} {
+	ts := map[string]interface{}{"value": v}
+	if len(v) > max {
+		cut := max
+		for cut > 0 && !utf8.RuneStart(v[cut]) {
+			cut--
+		}
+		ts["value"] = v[:cut]
+		ts["truncatedByteCount"] = len(v) - cut
+	}
+	return ts
+}
+
+// truncatable returns a Cloud Trace string attribute value.
+func truncatable(v string, max int) map[string]interface{} {
+	return map[string]interface{}{"stringValue": truncatableString(v, max)}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+func TestTruncatableString(t *testing.T) {
+	for _, tt := range []struct {
+		value     string
+		max       int
+		expected  string
+		truncated int
+	}{
+		{"abc", 3, "abc", 0},
+		{"abcd", 3, "abc", 1},
+		// "é" is encoded in 2 bytes, "€" in 3 bytes
+		{"aé", 2, "a", 2},
+		{"a€b", 3, "a", 4},
+		{"a€b", 4, "a€", 1},
+		{"€", 2, "", 3},
+	} {
+		ts := truncatableString(tt.value, tt.max)
+		value := ts["value"].(string)
+		if value != tt.expected || !utf8.ValidString(value) {
+			t.Errorf("truncatableString(%q, %d): expected %q, got %q",
+				tt.value, tt.max, tt.expected, value)
+		}
+		if n, _ := ts["truncatedByteCount"].(int); n != tt.truncated {
+			t.Errorf("truncatableString(%q, %d): expected %d truncated bytes, got %d",
+				tt.value, tt.max, tt.truncated, n)
+		}
+	}
+}
+
+func TestCloudTraceBatchWrite(t *testing.T) {
+	var batch struct {
+		Spans []struct {
+			Name         string
+			SpanID       string
+			ParentSpanID string
+			DisplayName  struct{ Value string }
+			SpanKind     string
+			StartTime    string
+			EndTime      string
+			Attributes   struct {
+				AttributeMap map[string]struct {
+					StringValue struct {
+						Value              string
+						TruncatedByteCount int
+					}
+				}
+			}
+		}
+	}
+	tokens := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			tokens++
+			_, _ = w.Write([]byte(`{"access_token":"token","expires_in":3600}`))
+		case "/computeMetadata/v1/project/project-id":
+			_, _ = w.Write([]byte("proj\n"))
+		case "/projects/proj/traces:batchWrite":
+			if auth := r.Header.Get("Authorization"); auth != "Bearer token" {
+				t.Errorf("expected bearer token, got %q", auth)
+			}
+			if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+				t.Error(err)
+			}
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	s := &gcpSender{
+		client:   srv.Client(),
+		timeout:  time.Second,
+		endpoint: srv.URL,
+		metadata: strings.TrimPrefix(srv.URL, "http://"),
+	}
+	parentID := model.ID(1)
+	span := &model.SpanModel{
+		SpanContext:   model.SpanContext{TraceID: model.TraceID{High: 2, Low: 3}, ID: 4, ParentID: &parentID},
+		Name:          "get /echo",
+		Kind:          model.Client,
+		Timestamp:     time.Unix(1, 0),
+		Duration:      time.Second,
+		LocalEndpoint: &model.Endpoint{ServiceName: "svca"},
+		Tags:          map[string]string{"long": strings.Repeat("€", 100)},
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.send([]*model.SpanModel{span}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tokens != 1 {
+		t.Errorf("expected access token to be cached, got %d token requests", tokens)
+	}
+
+	if len(batch.Spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(batch.Spans))
+	}
+	got := batch.Spans[0]
+	for field, values := range map[string][2]string{
+		"name":         {got.Name, "projects/proj/traces/00000000000000020000000000000003/spans/0000000000000004"},
+		"spanId":       {got.SpanID, "0000000000000004"},
+		"parentSpanId": {got.ParentSpanID, "0000000000000001"},
+		"displayName":  {got.DisplayName.Value, "get /echo"},
+		"spanKind":     {got.SpanKind, "CLIENT"},
+		"startTime":    {got.StartTime, "1970-01-01T00:00:01Z"},
+		"endTime":      {got.EndTime, "1970-01-01T00:00:02Z"},
+		"service.name": {got.Attributes.AttributeMap["service.name"].StringValue.Value, "svca"},
+	} {
+		if values[0] != values[1] {
+			t.Errorf("%s: expected %q, got %q", field, values[1], values[0])
+		}
+	}
+	long := got.Attributes.AttributeMap["long"].StringValue
+	if len(long.Value) != 255 || long.TruncatedByteCount != 45 || !utf8.ValidString(long.Value) {
+		t.Errorf("expected long attribute cut at a character boundary, got %d bytes and %d truncated",
+			len(long.Value), long.TruncatedByteCount)
+	}
+}
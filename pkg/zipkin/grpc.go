@@ -0,0 +1,104 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/proto/zipkin_proto3"
+	"github.com/openzipkin/zipkin-go/reporter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// grpcReportMethod is the Zipkin collector gRPC method accepting spans.
+const grpcReportMethod = "/zipkin.proto3.SpanService/Report"
+
+var errRawCodec = errors.New("expected raw message bytes")
+
+// rawCodec passes already serialized messages as is, so spans can be sent
+// using the Zipkin proto3 serializer without generated gRPC client code.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, errRawCodec
+	}
+	return *b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return errRawCodec
+	}
+	*b = data
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// grpcSender sends batches of spans to the gRPC endpoint of a Zipkin
+// collector using proto3 encoding.
+type grpcSender struct {
+	conn       *grpc.ClientConn
+	timeout    time.Duration
+	serializer reporter.SpanSerializer
+}
+
+// newGRPCReporter returns a reporter sending spans to the gRPC endpoint of the
+// Zipkin collector found at the host of the provided address. TLS is used if
+// the address has the https scheme.
+func newGRPCReporter(address string, cfg batchConfig) (reporter.Reporter, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	creds := insecure.NewCredentials()
+	if u.Scheme == "https" {
+		creds = credentials.NewTLS(&tls.Config{ServerName: u.Hostname()})
+	}
+	conn, err := grpc.Dial(u.Host, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	s := &grpcSender{
+		conn:       conn,
+		timeout:    cfg.timeout,
+		serializer: zipkin_proto3.SpanSerializer{},
+	}
+	return newBatchReporter(s.send, conn.Close, cfg), nil
+}
+
+func (s *grpcSender) send(spans []*model.SpanModel) error {
+	req, err := s.serializer.Serialize(spans)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	var res []byte
+	return s.conn.Invoke(ctx, grpcReportMethod, &req, &res, grpc.ForceCodec(rawCodec{}))
+}
@@ -0,0 +1,77 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+)
+
+// httpSender sends batches of spans to a Zipkin HTTP collector using the
+// Zipkin V2 API.
+type httpSender struct {
+	url        string
+	client     *http.Client
+	timeout    time.Duration
+	serializer reporter.SpanSerializer
+}
+
+// newHTTPReporter returns a reporter sending spans to the Zipkin HTTP
+// collector at the provided address.
+func newHTTPReporter(address string, cfg batchConfig) reporter.Reporter {
+	s := &httpSender{
+		url:        address,
+		client:     &http.Client{},
+		timeout:    cfg.timeout,
+		serializer: reporter.JSONSerializer{},
+	}
+	return newBatchReporter(s.send, nil, cfg)
+}
+
+func (s *httpSender) send(spans []*model.SpanModel) error {
+	body, err := s.serializer.Serialize(spans)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	// don't let service mesh sidecars trace our span reporting
+	req.Header.Set("b3", "0")
+	req.Header.Set("Content-Type", s.serializer.ContentType())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	_ = res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	return nil
+}
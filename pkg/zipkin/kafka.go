@@ -0,0 +1,90 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+)
+
+// kafkaClientID identifies our producer to the Kafka brokers.
+const kafkaClientID = "topology-tester"
+
+// kafkaProducer sends each batch of spans as a single JSON encoded message to
+// the partitions of the topic in round robin fashion, as expected by Zipkin's
+// Kafka span ingestion. The sarama producer takes care of partition leader
+// discovery, metadata refreshes and retries. It is created on the first batch,
+// so the service starts while the brokers are unreachable.
+type kafkaProducer struct {
+	brokers     []string
+	topic       string
+	config      *sarama.Config
+	serializer  reporter.SpanSerializer
+	newProducer func(brokers []string, config *sarama.Config) (sarama.SyncProducer, error)
+	producer    sarama.SyncProducer
+}
+
+// newKafkaReporter returns a reporter sending spans to the provided topic of
+// the Kafka cluster reachable through the provided bootstrap brokers.
+func newKafkaReporter(brokers []string, topic string, cfg batchConfig) reporter.Reporter {
+	p := newKafkaProducer(brokers, topic, cfg)
+	return newBatchReporter(p.send, p.close, cfg)
+}
+
+func newKafkaProducer(brokers []string, topic string, cfg batchConfig) *kafkaProducer {
+	config := sarama.NewConfig()
+	config.ClientID = kafkaClientID
+	// record batches, as supported by all current brokers
+	config.Version = sarama.V0_11_0_0
+	config.Net.DialTimeout = cfg.timeout
+	config.Net.ReadTimeout = cfg.timeout
+	config.Net.WriteTimeout = cfg.timeout
+	config.Producer.Timeout = cfg.timeout
+	config.Producer.RequiredAcks = sarama.WaitForLocal
+	config.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+	config.Producer.Return.Successes = true
+	return &kafkaProducer{
+		brokers:     brokers,
+		topic:       topic,
+		config:      config,
+		serializer:  reporter.JSONSerializer{},
+		newProducer: sarama.NewSyncProducer,
+	}
+}
+
+func (p *kafkaProducer) send(spans []*model.SpanModel) error {
+	value, err := p.serializer.Serialize(spans)
+	if err != nil {
+		return err
+	}
+	if p.producer == nil {
+		if p.producer, err = p.newProducer(p.brokers, p.config); err != nil {
+			return err
+		}
+	}
+	_, _, err = p.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: p.topic,
+		Value: sarama.ByteEncoder(value),
+	})
+	return err
+}
+
+func (p *kafkaProducer) close() error {
+	if p.producer == nil {
+		return nil
+	}
+	return p.producer.Close()
+}
@@ -0,0 +1,178 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+var testKafkaBatching = batchConfig{size: 10, queue: 10, interval: time.Hour, timeout: time.Second}
+
+func testKafkaSpans() []*model.SpanModel {
+	return []*model.SpanModel{
+		{SpanContext: model.SpanContext{TraceID: model.TraceID{Low: 1}, ID: 1}, Name: "a"},
+		{SpanContext: model.SpanContext{TraceID: model.TraceID{Low: 1}, ID: 2}, Name: "b"},
+	}
+}
+
+func TestKafkaProducerMessage(t *testing.T) {
+	p := newKafkaProducer([]string{"kafka:9092"}, "zipkin", testKafkaBatching)
+	mock := mocks.NewSyncProducer(t, p.config)
+	dialErr := errors.New("no brokers")
+	p.newProducer = func(brokers []string, config *sarama.Config) (sarama.SyncProducer, error) {
+		if dialErr != nil {
+			return nil, dialErr
+		}
+		return mock, nil
+	}
+
+	// the producer is created on the first batch, and retried if that fails
+	if err := p.send(testKafkaSpans()); err != dialErr {
+		t.Errorf("expected %v, got %v", dialErr, err)
+	}
+	dialErr = nil
+	mock.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != "zipkin" {
+			t.Errorf("expected topic zipkin, got %s", msg.Topic)
+		}
+		value, _ := msg.Value.Encode()
+		var spans []model.SpanModel
+		if err := json.Unmarshal(value, &spans); err != nil {
+			return err
+		}
+		if len(spans) != 2 || spans[0].Name != "a" || spans[1].ID != 2 {
+			t.Errorf("expected the batch as a single message, got %+v", spans)
+		}
+		return nil
+	})
+	if err := p.send(testKafkaSpans()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := p.close(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// countRequests returns the amount of requests of the provided type received
+// by the mock broker.
+func countRequests(b *sarama.MockBroker, match func(interface{}) bool) int {
+	n := 0
+	for _, rr := range b.History() {
+		if match(rr.Request) {
+			n++
+		}
+	}
+	return n
+}
+
+func isProduce(r interface{}) bool {
+	_, ok := r.(*sarama.ProduceRequest)
+	return ok
+}
+
+func isMetadata(r interface{}) bool {
+	_, ok := r.(*sarama.MetadataRequest)
+	return ok
+}
+
+func TestKafkaProducerLeaderChange(t *testing.T) {
+	seed := sarama.NewMockBroker(t, 1)
+	defer seed.Close()
+	leader := sarama.NewMockBroker(t, 2)
+	defer leader.Close()
+
+	metadata := func(leaderID int32) *sarama.MockMetadataResponse {
+		return sarama.NewMockMetadataResponse(t).
+			SetBroker(seed.Addr(), seed.BrokerID()).
+			SetBroker(leader.Addr(), leader.BrokerID()).
+			SetLeader("zipkin", 0, leaderID)
+	}
+	// the partition moves to the other broker after the first produce
+	seed.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockSequence(metadata(seed.BrokerID()), metadata(leader.BrokerID())),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3).
+			SetError("zipkin", 0, sarama.ErrNotLeaderForPartition),
+	})
+	leader.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": metadata(leader.BrokerID()),
+		"ProduceRequest":  sarama.NewMockProduceResponse(t).SetVersion(3),
+	})
+
+	p := newKafkaProducer([]string{seed.Addr()}, "zipkin", testKafkaBatching)
+	p.config.Producer.Retry.Backoff = time.Millisecond
+	p.config.Metadata.Retry.Backoff = time.Millisecond
+	defer p.close()
+	if err := p.send(testKafkaSpans()); err != nil {
+		t.Fatalf("expected the batch to be retried at the new leader, got %v", err)
+	}
+	if n := countRequests(seed, isMetadata); n < 2 {
+		t.Errorf("expected metadata to be refreshed, got %d metadata requests", n)
+	}
+	if n := countRequests(leader, isProduce); n != 1 {
+		t.Errorf("expected the new leader to receive the batch, got %d produce requests", n)
+	}
+}
+
+func TestKafkaProducerError(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("zipkin", 0, broker.BrokerID()).
+			SetLeader("zipkin", 1, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockSequence(
+			sarama.NewMockProduceResponse(t).SetVersion(3).
+				SetError("zipkin", 0, sarama.ErrMessageSizeTooLarge).
+				SetError("zipkin", 1, sarama.ErrMessageSizeTooLarge),
+			sarama.NewMockProduceResponse(t).SetVersion(3),
+		),
+	})
+
+	p := newKafkaProducer([]string{broker.Addr()}, "zipkin", testKafkaBatching)
+	defer p.close()
+	if err := p.send(testKafkaSpans()); err != sarama.ErrMessageSizeTooLarge {
+		t.Errorf("expected the broker error, got %v", err)
+	}
+	// the producer keeps working after a rejected batch
+	if err := p.send(testKafkaSpans()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n := countRequests(broker, isProduce); n != 2 {
+		t.Errorf("expected 2 produce requests, got %d", n)
+	}
+}
+
+func TestKafkaProducerUnreachable(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	addr := broker.Addr()
+	broker.Close()
+
+	p := newKafkaProducer([]string{addr}, "zipkin", testKafkaBatching)
+	p.config.Metadata.Retry.Max = 0
+	if err := p.send(testKafkaSpans()); err == nil {
+		t.Error("expected an error without reachable brokers")
+	}
+	if p.producer != nil {
+		t.Error("expected the producer to be created on a later batch")
+	}
+}
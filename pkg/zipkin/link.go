@@ -0,0 +1,56 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+// Span and SpanContext are convenience type aliases so our packages only need
+// to import this package for Tracing support.
+type (
+	Span        = zipkin.Span
+	SpanContext = model.SpanContext
+)
+
+// Links returns a span option which links the span being created to the
+// provided span contexts. Zipkin has no native notion of span links, so they
+// are emulated through "link.<n>.traceid" and "link.<n>.spanid" tags.
+func Links(links ...SpanContext) zipkin.SpanOption {
+	tags := make(map[string]string, 2*len(links))
+	for idx, sc := range links {
+		prefix := "link." + strconv.Itoa(idx) + "."
+		tags[prefix+"traceid"] = sc.TraceID.String()
+		tags[prefix+"spanid"] = sc.ID.String()
+	}
+	return zipkin.Tags(tags)
+}
+
+// StartLinkedSpan starts a new root span which is linked to the span found in
+// the provided context instead of being its child. This is useful for async and
+// batch patterns where work is triggered by, but not part of, a request trace.
+func StartLinkedSpan(
+	ctx context.Context, t *Tracer, name string, options ...zipkin.SpanOption,
+) (Span, context.Context) {
+	if sp := zipkin.SpanFromContext(ctx); sp != nil {
+		options = append(options, Links(sp.Context()))
+	}
+	span := t.StartSpan(name, options...)
+	return span, zipkin.NewContext(ctx, span)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+)
+
+func TestStartLinkedSpan(t *testing.T) {
+	rec := recorder.NewReporter()
+	tracer, err := zipkin.NewTracer(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trigger := tracer.StartSpan("trigger")
+	ctx := zipkin.NewContext(context.Background(), trigger)
+
+	span, ctx := StartLinkedSpan(ctx, tracer, "batch")
+	if zipkin.SpanFromContext(ctx) != span {
+		t.Error("expected the linked span in the returned context")
+	}
+	span.Finish()
+	trigger.Finish()
+
+	spans := rec.Flush()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	linked, sc := spans[0], trigger.Context()
+	if linked.Name != "batch" {
+		t.Fatalf("expected the linked span first, got %q", linked.Name)
+	}
+	if linked.TraceID == sc.TraceID || linked.ParentID != nil {
+		t.Error("expected the linked span to start a new trace")
+	}
+	if linked.Tags["link.0.traceid"] != sc.TraceID.String() || linked.Tags["link.0.spanid"] != sc.ID.String() {
+		t.Errorf("expected link to the trigger span, got %v", linked.Tags)
+	}
+
+	root, _ := StartLinkedSpan(context.Background(), tracer, "root")
+	root.Finish()
+	if tags := rec.Flush()[0].Tags; len(tags) != 0 {
+		t.Errorf("expected no links without a span in the context, got %v", tags)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"sync"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+)
+
+// defaultMemorySpans is the amount of spans kept by the memory transport if
+// no size is configured.
+const defaultMemorySpans = 1000
+
+// memoryReporter keeps the last finished spans in a ring buffer, so they can
+// be queried without a tracing backend.
+type memoryReporter struct {
+	mtx   sync.RWMutex
+	spans []model.SpanModel
+	next  int
+	full  bool
+}
+
+var _ reporter.Reporter = (*memoryReporter)(nil)
+
+func newMemoryReporter(size int) *memoryReporter {
+	return &memoryReporter{spans: make([]model.SpanModel, size)}
+}
+
+// Send implements reporter.Reporter.
+func (m *memoryReporter) Send(s model.SpanModel) {
+	m.mtx.Lock()
+	m.spans[m.next] = s
+	if m.next++; m.next == len(m.spans) {
+		m.next, m.full = 0, true
+	}
+	m.mtx.Unlock()
+}
+
+// Close implements reporter.Reporter.
+func (m *memoryReporter) Close() error {
+	return nil
+}
+
+// find returns the recorded spans, oldest first, optionally limited to the
+// spans of the provided trace.
+func (m *memoryReporter) find(traceID *model.TraceID) []model.SpanModel {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	spans := m.spans[:m.next]
+	if m.full {
+		spans = append(append([]model.SpanModel(nil), m.spans[m.next:]...), spans...)
+	}
+	res := make([]model.SpanModel, 0, len(spans))
+	for _, s := range spans {
+		if traceID == nil || s.TraceID == *traceID {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// teeReporter sends spans to multiple reporters.
+type teeReporter []reporter.Reporter
+
+// Send implements reporter.Reporter.
+func (t teeReporter) Send(s model.SpanModel) {
+	for _, r := range t {
+		r.Send(s)
+	}
+}
+
+// Close implements reporter.Reporter.
+func (t teeReporter) Close() error {
+	var err error
+	for _, r := range t {
+		if cErr := r.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}
+	return err
+}
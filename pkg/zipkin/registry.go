@@ -0,0 +1,229 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/idgenerator"
+	"github.com/openzipkin/zipkin-go/reporter"
+	zipkinlog "github.com/openzipkin/zipkin-go/reporter/log"
+	"github.com/tetratelabs/multierror"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+)
+
+// SpanTransport provides the span reporter of a span transport, registered by
+// name with RegisterTransport.
+type SpanTransport interface {
+	// Validate validates the settings of the Zipkin service the transport
+	// depends on.
+	Validate(s *Service) error
+	// NewReporter is called from PreRun with the validated configuration of
+	// the Zipkin service and may adjust the settings the transport depends on,
+	// e.g. SingleHostSpans. The Zipkin service closes the returned reporter on
+	// GracefulStop.
+	NewReporter(s *Service) (reporter.Reporter, error)
+	// TracerOptions returns the tracer options the backend of the transport
+	// requires, e.g. the format of trace ids.
+	TracerOptions(s *Service) []zipkin.TracerOption
+}
+
+// TransportFactory adapts a function creating a span reporter to a SpanTransport
+// without settings to validate or tracer options.
+type TransportFactory func(s *Service) (reporter.Reporter, error)
+
+// Validate implements SpanTransport.
+func (TransportFactory) Validate(*Service) error { return nil }
+
+// NewReporter implements SpanTransport.
+func (f TransportFactory) NewReporter(s *Service) (reporter.Reporter, error) { return f(s) }
+
+// TracerOptions implements SpanTransport.
+func (TransportFactory) TracerOptions(*Service) []zipkin.TracerOption { return nil }
+
+// endpointTransport reports spans to the collector at the reporter endpoint.
+type endpointTransport struct {
+	TransportFactory
+}
+
+// Validate implements SpanTransport.
+func (endpointTransport) Validate(s *Service) error {
+	if _, err := url.Parse(s.Address); err != nil {
+		return fmt.Errorf(pkg.FlagErr, ReporterEndpoint, err)
+	}
+	return nil
+}
+
+// kafkaTransport reports spans to a Kafka topic.
+type kafkaTransport struct {
+	TransportFactory
+}
+
+// Validate implements SpanTransport.
+func (kafkaTransport) Validate(s *Service) error {
+	var mErr error
+	if len(s.KafkaBrokers) == 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, KafkaBrokers, pkg.ErrRequired))
+	}
+	for _, broker := range s.KafkaBrokers {
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, KafkaBrokers, err))
+		}
+	}
+	if s.KafkaTopic == "" {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, KafkaTopic, pkg.ErrRequired))
+	}
+	return mErr
+}
+
+// xrayTransport reports segments to the X-Ray daemon.
+type xrayTransport struct {
+	TransportFactory
+}
+
+// Validate implements SpanTransport.
+func (xrayTransport) Validate(s *Service) error {
+	if s.XRayDaemon != "" {
+		if _, _, err := net.SplitHostPort(s.XRayDaemon); err != nil {
+			return fmt.Errorf(pkg.FlagErr, XRayDaemon, err)
+		}
+	}
+	return nil
+}
+
+// TracerOptions implements SpanTransport.
+func (xrayTransport) TracerOptions(*Service) []zipkin.TracerOption {
+	// X-Ray trace ids start with the epoch time in seconds
+	return []zipkin.TracerOption{zipkin.WithIDGenerator(idgenerator.NewRandomTimestamped())}
+}
+
+// elasticTransport reports events to the Elastic APM Server intake API.
+type elasticTransport struct {
+	TransportFactory
+}
+
+// Validate implements SpanTransport.
+func (elasticTransport) Validate(s *Service) error {
+	if s.ElasticServerURL != "" {
+		if _, err := url.Parse(s.ElasticServerURL); err != nil {
+			return fmt.Errorf(pkg.FlagErr, ElasticServerURL, err)
+		}
+	}
+	return nil
+}
+
+// TracerOptions implements SpanTransport.
+func (elasticTransport) TracerOptions(*Service) []zipkin.TracerOption {
+	// Elastic APM expects 128 bit trace ids
+	return []zipkin.TracerOption{zipkin.WithTraceID128Bit(true)}
+}
+
+var (
+	transportsMtx sync.RWMutex
+	transports    = make(map[string]SpanTransport)
+)
+
+func init() {
+	RegisterTransport(TransportHTTP, endpointTransport{func(s *Service) (reporter.Reporter, error) {
+		return newHTTPReporter(s.Address, s.batching()), nil
+	}})
+	RegisterTransport(TransportKafka, kafkaTransport{func(s *Service) (reporter.Reporter, error) {
+		return newKafkaReporter(s.KafkaBrokers, s.KafkaTopic, s.batching()), nil
+	}})
+	RegisterTransport(TransportGRPC, endpointTransport{func(s *Service) (reporter.Reporter, error) {
+		return newGRPCReporter(s.Address, s.batching())
+	}})
+	RegisterTransport(TransportGCP, TransportFactory(func(s *Service) (reporter.Reporter, error) {
+		s.CloudTraceContext = true
+		// Cloud Trace requires unique span ids
+		s.SingleHostSpans = true
+		return newGCPReporter(s.GCPProject, s.batching()), nil
+	}))
+	RegisterTransport(TransportXRay, xrayTransport{func(s *Service) (reporter.Reporter, error) {
+		s.XRayTraceHeader = true
+		// X-Ray requires unique segment ids
+		s.SingleHostSpans = true
+		return newXRayReporter(s.XRayDaemon, s.batching())
+	}})
+	RegisterTransport(TransportElastic, elasticTransport{func(s *Service) (reporter.Reporter, error) {
+		s.ElasticTraceparent = true
+		// Elastic APM requires unique span ids
+		s.SingleHostSpans = true
+		return newElasticReporter(s.ElasticServerURL, s.ElasticToken, s.Servicename, s.batching())
+	}})
+	RegisterTransport(TransportLog, TransportFactory(func(*Service) (reporter.Reporter, error) {
+		return zipkinlog.NewReporter(log.New(os.Stdout, "", 0)), nil
+	}))
+	RegisterTransport(TransportNone, TransportFactory(func(*Service) (reporter.Reporter, error) {
+		return reporter.NewNoopReporter(), nil
+	}))
+	RegisterTransport(TransportMemory, TransportFactory(func(s *Service) (reporter.Reporter, error) {
+		if s.MemorySpans == 0 {
+			s.MemorySpans = defaultMemorySpans
+		}
+		s.memory = newMemoryReporter(s.MemorySpans)
+		return s.memory, nil
+	}))
+}
+
+// RegisterTransport makes a span reporter transport available by the provided
+// name to the zipkin-reporter-transport flag, so forks can add transports
+// without changing this package. It is meant to be called from the init
+// function of the package providing the transport and panics if the name is
+// already registered or the transport is nil.
+func RegisterTransport(name string, transport SpanTransport) {
+	transportsMtx.Lock()
+	defer transportsMtx.Unlock()
+
+	if transport == nil {
+		panic("zipkin: RegisterTransport transport is nil")
+	}
+	if _, dup := transports[name]; dup {
+		panic("zipkin: RegisterTransport called twice for transport " + name)
+	}
+	transports[name] = transport
+}
+
+// Transports returns the sorted names of the registered transports.
+func Transports() []string {
+	transportsMtx.RLock()
+	defer transportsMtx.RUnlock()
+
+	names := make([]string, 0, len(transports))
+	for name := range transports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupTransport(name string) (SpanTransport, bool) {
+	transportsMtx.RLock()
+	defer transportsMtx.RUnlock()
+
+	transport, ok := transports[name]
+	return transport, ok
+}
@@ -0,0 +1,83 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestService returns a Zipkin service with the flag defaults and the
+// provided transport.
+func newTestService(transport string) *Service {
+	s := &Service{}
+	s.FlagSet()
+	s.Transport = transport
+	return s
+}
+
+func TestTransportValidate(t *testing.T) {
+	s := newTestService(TransportKafka)
+	if err := s.Validate(); err == nil {
+		t.Error("expected kafka transport without brokers to fail validation")
+	}
+	s.KafkaBrokers, s.KafkaTopic = []string{"127.0.0.1:9092"}, "zipkin"
+	if err := s.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	s = newTestService(TransportXRay)
+	s.XRayDaemon = "no-port"
+	if err := s.Validate(); err == nil {
+		t.Error("expected invalid X-Ray daemon address to fail validation")
+	}
+
+	if err := newTestService("unknown").Validate(); err == nil {
+		t.Error("expected unknown transport to fail validation")
+	}
+}
+
+func TestTransportTracerOptions(t *testing.T) {
+	for _, tt := range []struct {
+		transport string
+		check     func(t *testing.T, s *Service)
+	}{
+		{TransportNone, func(t *testing.T, s *Service) {}},
+		{TransportXRay, func(t *testing.T, s *Service) {
+			span := s.Tracer.StartSpan("test")
+			// X-Ray trace ids start with the epoch time in seconds
+			if ts := int64(span.Context().TraceID.High >> 32); time.Since(time.Unix(ts, 0)) > time.Minute {
+				t.Errorf("expected timestamped trace id, got %s", span.Context().TraceID)
+			}
+		}},
+		{TransportElastic, func(t *testing.T, s *Service) {
+			if span := s.Tracer.StartSpan("test"); span.Context().TraceID.High == 0 {
+				t.Errorf("expected 128 bit trace id, got %s", span.Context().TraceID)
+			}
+		}},
+	} {
+		t.Run(tt.transport, func(t *testing.T) {
+			s := newTestService(tt.transport)
+			if err := s.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.PreRun(); err != nil {
+				t.Fatal(err)
+			}
+			defer s.GracefulStop()
+			tt.check(t, s)
+		})
+	}
+}
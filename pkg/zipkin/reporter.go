@@ -0,0 +1,178 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"expvar"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+)
+
+// supported reporter transports
+const (
+	TransportHTTP    = "http"
+	TransportKafka   = "kafka"
+	TransportGRPC    = "grpc"
+	TransportLog     = "log"
+	TransportNone    = "none"
+	TransportMemory  = "memory"
+	TransportGCP     = "gcp"
+	TransportXRay    = "xray"
+	TransportElastic = "elastic"
+)
+
+const (
+	// default batching configuration values, equal to those of the Zipkin
+	// HTTP reporter
+	defaultBatchSize     = 100
+	defaultBatchInterval = time.Second
+	defaultQueueSize     = 1000
+	defaultTimeout       = 5 * time.Second
+)
+
+// reporterMetrics holds the span reporting counters, exposed through expvar so
+// span loss under load can be studied.
+var reporterMetrics = expvar.NewMap("zipkin")
+
+// batchConfig holds the batching and backpressure settings shared by the
+// reporter transports.
+type batchConfig struct {
+	size     int
+	queue    int
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// batchReporter implements reporter.Reporter by queueing spans and handing
+// them in batches to the send function of a reporter transport. Spans are
+// dropped if the queue is full. Dropped spans and spans which failed to be
+// sent are counted and logged.
+type batchReporter struct {
+	send      func([]*model.SpanModel) error
+	close     func() error
+	logger    *log.Logger
+	cfg       batchConfig
+	spans     chan *model.SpanModel
+	pending   int64
+	dropped   int64
+	status    reporterStatus
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ reporter.Reporter = (*batchReporter)(nil)
+
+func newBatchReporter(
+	send func([]*model.SpanModel) error, closeFn func() error, cfg batchConfig,
+) *batchReporter {
+	r := &batchReporter{
+		send:   send,
+		close:  closeFn,
+		logger: log.New(os.Stderr, "", log.LstdFlags),
+		cfg:    cfg,
+		spans:  make(chan *model.SpanModel, cfg.queue),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go r.loop()
+	return r
+}
+
+// Send implements reporter.Reporter. Spans are dropped if the amount of spans
+// waiting to be sent has reached the queue size.
+func (r *batchReporter) Send(s model.SpanModel) {
+	if atomic.AddInt64(&r.pending, 1) > int64(r.cfg.queue) {
+		atomic.AddInt64(&r.pending, -1)
+		atomic.AddInt64(&r.dropped, 1)
+		reporterMetrics.Add("droppedSpans", 1)
+		return
+	}
+	reporterMetrics.Add("queuedSpans", 1)
+	r.spans <- &s
+}
+
+// Close implements reporter.Reporter. Queued spans are sent before closing.
+func (r *batchReporter) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.quit)
+		<-r.done
+		if r.close != nil {
+			err = r.close()
+		}
+	})
+	return err
+}
+
+func (r *batchReporter) loop() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.cfg.interval)
+	defer ticker.Stop()
+
+	batch := make([]*model.SpanModel, 0, r.cfg.size)
+	flush := func() {
+		if dropped := atomic.SwapInt64(&r.dropped, 0); dropped > 0 {
+			r.status.drop(dropped)
+			r.logger.Printf("span queue full, dropped %d spans", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		atomic.AddInt64(&r.pending, -int64(len(batch)))
+		reporterMetrics.Add("queuedSpans", -int64(len(batch)))
+		err := r.send(batch)
+		if recovered := r.status.record(len(batch), err); recovered > 0 {
+			r.logger.Printf("span reporting recovered after %d failed batches", recovered)
+		}
+		if err != nil {
+			reporterMetrics.Add("failedSpans", int64(len(batch)))
+			r.logger.Printf("failed to send %d spans: %v", len(batch), err)
+		} else {
+			reporterMetrics.Add("reportedSpans", int64(len(batch)))
+		}
+		batch = make([]*model.SpanModel, 0, r.cfg.size)
+	}
+
+	for {
+		select {
+		case s := <-r.spans:
+			if batch = append(batch, s); len(batch) >= r.cfg.size {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-r.quit:
+			for {
+				select {
+				case s := <-r.spans:
+					if batch = append(batch, s); len(batch) >= r.cfg.size {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
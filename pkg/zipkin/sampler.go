@@ -0,0 +1,152 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+)
+
+// sampler errors
+const (
+	ErrSampleRate   pkg.Error = "expected sample rate between 0.0 and 1.0"
+	ErrSampleTarget pkg.Error = "expected a zero or positive sample target"
+)
+
+const (
+	// sampleScale is the inverse of the smallest sample rate increment.
+	sampleScale = 10000
+	// sampleWindow is the interval at which the adaptive sample rate is
+	// recalculated from the observed amount of traces.
+	sampleWindow = time.Second
+)
+
+// Sampler decides on sampling new traces, either with a fixed probability or
+// adaptively by targeting an amount of sampled traces per second. In adaptive
+// mode the sample rate is recalculated each second from the observed amount of
+// traces, so long-running high-RPS topologies don't overwhelm the tracing
+// backend. The rate is kept at the smallest increment of 0.0001 or above, as
+// the boundary based decision can't express smaller rates. Both the rate and
+// the target can be adjusted at runtime.
+type Sampler struct {
+	mtx      sync.Mutex
+	salt     int64
+	rate     float64
+	target   float64
+	boundary int64
+	start    time.Time
+	seen     int64
+}
+
+// NewSampler returns a Sampler using the provided fixed sample rate, or
+// targeting the provided amount of sampled traces per second if target is
+// larger than zero.
+func NewSampler(rate, target float64, salt int64) (*Sampler, error) {
+	s := &Sampler{salt: salt}
+	if err := s.SetRate(rate); err != nil {
+		return nil, err
+	}
+	if err := s.SetTarget(target); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Sample implements the zipkin.Sampler signature.
+func (s *Sampler) Sample(id uint64) bool {
+	s.mtx.Lock()
+	if s.target > 0 {
+		now := time.Now()
+		if elapsed := now.Sub(s.start); elapsed >= sampleWindow {
+			s.boundary = adaptiveBoundary(s.target, float64(s.seen)/elapsed.Seconds())
+			s.start, s.seen = now, 0
+		}
+		s.seen++
+	}
+	boundary := s.boundary
+	s.mtx.Unlock()
+
+	if boundary >= sampleScale {
+		return true
+	}
+	return abs(int64(id)^s.salt)%sampleScale < boundary
+}
+
+// SetRate sets the fixed sample rate, used if no target is set.
+func (s *Sampler) SetRate(rate float64) error {
+	if rate < 0 || rate > 1 || math.IsNaN(rate) {
+		return ErrSampleRate
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.rate = rate
+	if s.target == 0 {
+		s.boundary = int64(rate * sampleScale)
+	}
+	return nil
+}
+
+// SetTarget sets the target amount of sampled traces per second. A target of
+// zero returns to the fixed sample rate.
+func (s *Sampler) SetTarget(target float64) error {
+	if target < 0 || math.IsNaN(target) || math.IsInf(target, 0) {
+		return ErrSampleTarget
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.target = target
+	if target == 0 {
+		s.boundary = int64(s.rate * sampleScale)
+		return nil
+	}
+	// sample everything until the first window provides an observed rate
+	s.boundary, s.start, s.seen = sampleScale, time.Now(), 0
+	return nil
+}
+
+// Settings returns the fixed sample rate, the target amount of sampled traces
+// per second and the sample rate currently in effect.
+func (s *Sampler) Settings() (rate, target, effective float64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.rate, s.target, float64(s.boundary) / sampleScale
+}
+
+// adaptiveBoundary returns the sample boundary for reaching the target amount
+// of sampled traces per second at the observed amount of traces per second.
+func adaptiveBoundary(target, observed float64) int64 {
+	if observed <= target {
+		return sampleScale
+	}
+	boundary := int64(math.Ceil(target / observed * sampleScale))
+	if boundary < 1 {
+		// smallest increment
+		return 1
+	}
+	return boundary
+}
+
+func abs(n int64) int64 {
+	if n == math.MinInt64 {
+		return math.MaxInt64
+	}
+	if n < 0 {
+		return -n
+	}
+	return n
+}
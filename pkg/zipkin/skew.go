@@ -0,0 +1,45 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+)
+
+// skewReporter shifts the timestamps of spans and their annotations by a
+// fixed offset, emulating an instance with a drifting clock.
+type skewReporter struct {
+	reporter.Reporter
+	skew time.Duration
+}
+
+// Send implements reporter.Reporter.
+func (r skewReporter) Send(s model.SpanModel) {
+	if !s.Timestamp.IsZero() {
+		s.Timestamp = s.Timestamp.Add(r.skew)
+	}
+	if len(s.Annotations) > 0 {
+		annotations := make([]model.Annotation, len(s.Annotations))
+		for i, a := range s.Annotations {
+			a.Timestamp = a.Timestamp.Add(r.skew)
+			annotations[i] = a
+		}
+		s.Annotations = annotations
+	}
+	r.Reporter.Send(s)
+}
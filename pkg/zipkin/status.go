@@ -0,0 +1,96 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"sync"
+	"time"
+)
+
+// ReporterStatus holds the connectivity status of the span reporter, so
+// reporting failures can be surfaced instead of spans silently disappearing.
+type ReporterStatus struct {
+	Transport           string     `json:"transport"`
+	Healthy             bool       `json:"healthy"`
+	LastSuccess         *time.Time `json:"lastSuccess,omitempty"`
+	LastFailure         *time.Time `json:"lastFailure,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	ReportedSpans       int64      `json:"reportedSpans"`
+	FailedSpans         int64      `json:"failedSpans"`
+	DroppedSpans        int64      `json:"droppedSpans"`
+}
+
+// reporterStatus tracks the outcome of sending batches of spans.
+type reporterStatus struct {
+	mtx         sync.Mutex
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastErr     error
+	failures    int
+	reported    int64
+	failed      int64
+	dropped     int64
+}
+
+// record registers the outcome of sending a batch of n spans and returns the
+// amount of consecutive failures preceding a success.
+func (s *reporterStatus) record(n int, err error) (recovered int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if err != nil {
+		s.lastFailure, s.lastErr = time.Now(), err
+		s.failures++
+		s.failed += int64(n)
+		return 0
+	}
+	recovered = s.failures
+	s.lastSuccess, s.failures = time.Now(), 0
+	s.reported += int64(n)
+	return recovered
+}
+
+func (s *reporterStatus) drop(n int64) {
+	s.mtx.Lock()
+	s.dropped += n
+	s.mtx.Unlock()
+}
+
+func (s *reporterStatus) status(transport string) ReporterStatus {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	rs := ReporterStatus{
+		Transport:           transport,
+		Healthy:             s.failures == 0,
+		ConsecutiveFailures: s.failures,
+		ReportedSpans:       s.reported,
+		FailedSpans:         s.failed,
+		DroppedSpans:        s.dropped,
+	}
+	if !s.lastSuccess.IsZero() {
+		t := s.lastSuccess
+		rs.LastSuccess = &t
+	}
+	if !s.lastFailure.IsZero() {
+		t := s.lastFailure
+		rs.LastFailure = &t
+	}
+	if s.lastErr != nil {
+		rs.LastError = s.lastErr.Error()
+	}
+	return rs
+}
@@ -0,0 +1,65 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"os"
+	"strings"
+
+	"github.com/basvanbeek/topology-tester/pkg"
+	"github.com/basvanbeek/topology-tester/pkg/buildinfo"
+)
+
+// ObservabilityTagsEnv holds the environment variable providing tags added to
+// all spans, using the same key=value,... format as the observability-tags
+// flag. Tags set by flag take precedence.
+const ObservabilityTagsEnv = "OBSERVABILITY_TAGS"
+
+const errTags pkg.Error = "expected tags as key=value,..."
+
+// parseTags parses a comma separated list of key=value pairs.
+func parseTags(v string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, errTags
+		}
+		tags[key] = strings.TrimSpace(kv[1])
+	}
+	return tags, nil
+}
+
+// globalTags returns the tags to add to all spans: the version and build tags,
+// the tags found in the environment and the tags set by flag, in order of
+// precedence.
+func (s *Service) globalTags(versionTag string) (map[string]string, error) {
+	tags, err := parseTags(os.Getenv(ObservabilityTagsEnv))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.Tags {
+		tags[k] = v
+	}
+	for k, v := range buildinfo.Tags() {
+		tags[k] = v
+	}
+	tags["tetrate"] = versionTag
+	return tags, nil
+}
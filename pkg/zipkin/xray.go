@@ -0,0 +1,226 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/propagation/b3"
+	"github.com/openzipkin/zipkin-go/reporter"
+)
+
+// XRayTraceHeader is the AWS X-Ray trace context header, formatted as
+// Root=1-{time}-{id};Parent={span id};Sampled={0|1}.
+const XRayTraceHeader = "X-Amzn-Trace-Id"
+
+const (
+	// defaultXRayDaemon is the default address of the X-Ray daemon, which can
+	// be overridden with the AWS_XRAY_DAEMON_ADDRESS environment variable like
+	// the AWS SDKs allow.
+	defaultXRayDaemon = "127.0.0.1:2000"
+	// xrayHeader precedes each segment document sent to the X-Ray daemon.
+	xrayHeader = `{"format": "json", "version": 1}` + "\n"
+)
+
+// xraySender sends spans as segment documents to the X-Ray daemon over UDP.
+type xraySender struct {
+	conn net.Conn
+}
+
+// newXRayReporter returns a reporter sending spans to the X-Ray daemon at the
+// provided address, or the address found in the environment if empty.
+func newXRayReporter(daemon string, cfg batchConfig) (reporter.Reporter, error) {
+	if daemon == "" {
+		daemon = os.Getenv("AWS_XRAY_DAEMON_ADDRESS")
+	}
+	if daemon == "" {
+		daemon = defaultXRayDaemon
+	}
+	conn, err := net.Dial("udp", daemon)
+	if err != nil {
+		return nil, err
+	}
+	s := &xraySender{conn: conn}
+	return newBatchReporter(s.send, conn.Close, cfg), nil
+}
+
+func (s *xraySender) send(spans []*model.SpanModel) error {
+	var lastErr error
+	for _, span := range spans {
+		b, err := json.Marshal(xraySegment(span))
+		if err == nil {
+			_, err = s.conn.Write(append([]byte(xrayHeader), b...))
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// xraySegment converts a Zipkin span to an X-Ray segment document. Server and
+// root spans become segments, other spans become subsegments of their parent.
+func xraySegment(s *model.SpanModel) map[string]interface{} {
+	name := "unknown"
+	if s.LocalEndpoint != nil && s.LocalEndpoint.ServiceName != "" {
+		name = s.LocalEndpoint.ServiceName
+	}
+	seg := map[string]interface{}{
+		"name":       name,
+		"id":         s.ID.String(),
+		"trace_id":   xrayTraceID(s.TraceID),
+		"start_time": float64(s.Timestamp.UnixNano()) / 1e9,
+		"end_time":   float64(s.Timestamp.Add(s.Duration).UnixNano()) / 1e9,
+		"metadata": map[string]interface{}{
+			"zipkin": map[string]interface{}{"name": s.Name, "kind": s.Kind, "tags": s.Tags},
+		},
+	}
+	if s.ParentID != nil {
+		seg["parent_id"] = s.ParentID.String()
+		if s.Kind != model.Server {
+			seg["type"] = "subsegment"
+			seg["name"] = s.Name
+			if s.Kind == model.Client || s.Kind == model.Producer {
+				seg["namespace"] = "remote"
+				if s.RemoteEndpoint != nil && s.RemoteEndpoint.ServiceName != "" {
+					seg["name"] = s.RemoteEndpoint.ServiceName
+				}
+			}
+		}
+	}
+
+	request := map[string]interface{}{}
+	if method, ok := s.Tags["http.method"]; ok {
+		request["method"] = method
+	}
+	if url, ok := s.Tags["http.url"]; ok {
+		request["url"] = url
+	} else if path, ok := s.Tags["http.path"]; ok {
+		request["url"] = path
+	}
+	if len(request) > 0 {
+		info := map[string]interface{}{"request": request}
+		if code, err := strconv.Atoi(s.Tags["http.status_code"]); err == nil {
+			info["response"] = map[string]interface{}{"status": code}
+			switch {
+			case code == http.StatusTooManyRequests:
+				seg["throttle"] = true
+				seg["error"] = true
+			case code >= 500:
+				seg["fault"] = true
+			case code >= 400:
+				seg["error"] = true
+			}
+		}
+		seg["http"] = info
+	}
+	if _, ok := s.Tags["error"]; ok {
+		seg["fault"] = true
+	}
+	return seg
+}
+
+// xrayTraceID returns the X-Ray representation of a 128 bit trace id, where
+// the first 32 bits hold the epoch time in seconds.
+func xrayTraceID(id model.TraceID) string {
+	hex := fmt.Sprintf("%016x%016x", id.High, id.Low)
+	return "1-" + hex[:8] + "-" + hex[8:]
+}
+
+// ExtractXRayTraceHeader returns a middleware which translates an incoming
+// X-Amzn-Trace-Id header into B3 headers, unless the request already holds B3
+// headers. A header without parent still conveys the sampling decision. It
+// must wrap the Zipkin server middleware.
+func ExtractXRayTraceHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(b3.Context) == "" && r.Header.Get(b3.TraceID) == "" {
+			traceID, parent, sampled := parseXRayTraceHeader(r.Header.Get(XRayTraceHeader))
+			if traceID != "" && parent != "" {
+				r.Header.Set(b3.TraceID, traceID)
+				r.Header.Set(b3.SpanID, parent)
+			}
+			if sampled != "" {
+				r.Header.Set(b3.Sampled, sampled)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// InjectXRayTraceHeader returns a RoundTripper which adds the X-Amzn-Trace-Id
+// header matching the B3 headers of outgoing requests. It must be wrapped by
+// the Zipkin transport, so the B3 headers are set.
+func InjectXRayTraceHeader(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		traceID := r.Header.Get(b3.TraceID)
+		spanID := r.Header.Get(b3.SpanID)
+		if len(traceID) == 32 && spanID != "" {
+			value := "Root=1-" + traceID[:8] + "-" + traceID[8:] + ";Parent=" + spanID
+			switch r.Header.Get(b3.Sampled) {
+			case "1", "true":
+				value += ";Sampled=1"
+			case "0", "false":
+				value += ";Sampled=0"
+			}
+			r.Header.Set(XRayTraceHeader, value)
+		}
+		return rt.RoundTrip(r)
+	})
+}
+
+// parseXRayTraceHeader parses an X-Amzn-Trace-Id header value, returning the
+// B3 trace id, parent span id and sampled value.
+func parseXRayTraceHeader(v string) (traceID, parent, sampled string) {
+	for _, field := range strings.Split(v, ";") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "Root":
+			parts := strings.Split(kv[1], "-")
+			if len(parts) == 3 && parts[0] == "1" && len(parts[1]) == 8 && len(parts[2]) == 24 &&
+				isHex(parts[1]+parts[2]) {
+				traceID = strings.ToLower(parts[1] + parts[2])
+			}
+		case "Parent":
+			if len(kv[1]) == 16 && isHex(kv[1]) {
+				parent = strings.ToLower(kv[1])
+			}
+		case "Sampled":
+			switch kv[1] {
+			case "0", "1":
+				sampled = kv[1]
+			}
+		}
+	}
+	return traceID, parent, sampled
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
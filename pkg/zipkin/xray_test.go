@@ -0,0 +1,131 @@
+// Copyright (c) Bas van Beek 2022.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+func TestXRayDaemon(t *testing.T) {
+	daemon, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = daemon.Close() }()
+
+	rep, err := newXRayReporter(daemon.LocalAddr().String(), batchConfig{
+		size: 10, queue: 10, interval: time.Hour, timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	traceID := model.TraceID{High: 0x5f84c7a1abcdef01, Low: 0x23456789abcdef01}
+	parentID := model.ID(1)
+	start := time.Unix(1602537377, 500000000)
+	rep.Send(model.SpanModel{
+		SpanContext:   model.SpanContext{TraceID: traceID, ID: parentID},
+		Name:          "get /echo",
+		Kind:          model.Server,
+		Timestamp:     start,
+		Duration:      250 * time.Millisecond,
+		LocalEndpoint: &model.Endpoint{ServiceName: "svca"},
+		Tags:          map[string]string{"http.method": "GET", "http.path": "/echo", "http.status_code": "503"},
+	})
+	rep.Send(model.SpanModel{
+		SpanContext:    model.SpanContext{TraceID: traceID, ID: 2, ParentID: &parentID},
+		Name:           "get",
+		Kind:           model.Client,
+		Timestamp:      start,
+		Duration:       100 * time.Millisecond,
+		LocalEndpoint:  &model.Endpoint{ServiceName: "svca"},
+		RemoteEndpoint: &model.Endpoint{ServiceName: "svcb"},
+		Tags:           map[string]string{"http.method": "GET", "http.url": "http://svcb/", "http.status_code": "429"},
+	})
+	if err = rep.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var segments []map[string]interface{}
+	_ = daemon.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64<<10)
+	for len(segments) < 2 {
+		n, _, err := daemon.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc := buf[:n]
+		if !bytes.HasPrefix(doc, []byte(xrayHeader)) {
+			t.Fatalf("expected segment header, got %q", doc)
+		}
+		var seg map[string]interface{}
+		if err = json.Unmarshal(doc[len(xrayHeader):], &seg); err != nil {
+			t.Fatal(err)
+		}
+		segments = append(segments, seg)
+	}
+
+	for i, expected := range []map[string]interface{}{
+		{
+			"name":       "svca",
+			"id":         "0000000000000001",
+			"trace_id":   "1-5f84c7a1-abcdef0123456789abcdef01",
+			"start_time": 1602537377.5,
+			"fault":      true,
+		},
+		{
+			"name":      "svcb",
+			"id":        "0000000000000002",
+			"parent_id": "0000000000000001",
+			"type":      "subsegment",
+			"namespace": "remote",
+			"throttle":  true,
+			"error":     true,
+		},
+	} {
+		for field, value := range expected {
+			if segments[i][field] != value {
+				t.Errorf("segment %d %s: expected %v, got %v", i, field, value, segments[i][field])
+			}
+		}
+	}
+	if end, _ := segments[0]["end_time"].(float64); math.Abs(end-1602537377.75) > 1e-6 {
+		t.Errorf("expected end time 1602537377.75, got %f", end)
+	}
+	request := segments[0]["http"].(map[string]interface{})["request"].(map[string]interface{})
+	if request["method"] != "GET" || request["url"] != "/echo" {
+		t.Errorf("unexpected http request %v", request)
+	}
+	if _, ok := segments[0]["parent_id"]; ok {
+		t.Error("expected root segment without parent")
+	}
+}
+
+func TestXRayTraceHeader(t *testing.T) {
+	traceID, parent, sampled := parseXRayTraceHeader(
+		"Root=1-5f84c7a1-abcdef0123456789ABCDEF01;Parent=53995c3f42cd8ad8;Sampled=1")
+	if traceID != "5f84c7a1abcdef0123456789abcdef01" || parent != "53995c3f42cd8ad8" || sampled != "1" {
+		t.Errorf("unexpected trace context %s %s %s", traceID, parent, sampled)
+	}
+	if traceID, _, _ = parseXRayTraceHeader("Root=1-5f84c7a1-xyz"); traceID != "" {
+		t.Errorf("expected invalid root to be ignored, got %s", traceID)
+	}
+}
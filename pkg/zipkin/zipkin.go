@@ -20,14 +20,15 @@ package zipkin
 import (
 	"fmt"
 	"net"
-	"net/url"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/model"
 	"github.com/openzipkin/zipkin-go/reporter"
-	"github.com/openzipkin/zipkin-go/reporter/http"
 	"github.com/tetratelabs/multierror"
 	"github.com/tetratelabs/run"
 	"github.com/tetratelabs/run/pkg/version"
@@ -41,29 +42,85 @@ type Tracer = zipkin.Tracer
 
 // flags
 const (
-	ReporterEndpoint = "zipkin-reporter-endpoint"
-	LocalServicename = "zipkin-local-servicename"
-	LocalHostport    = "zipkin-local-hostport"
-	SinglehostSpans  = "zipkin-singlehost-spans"
-	SampleRate       = "zipkin-sample-rate"
+	ReporterEndpoint   = "zipkin-reporter-endpoint"
+	LocalServicename   = "zipkin-local-servicename"
+	LocalHostport      = "zipkin-local-hostport"
+	SinglehostSpans    = "zipkin-singlehost-spans"
+	SampleRate         = "zipkin-sample-rate"
+	SampleTarget       = "zipkin-sample-target"
+	Transport          = "zipkin-reporter-transport"
+	BatchSize          = "zipkin-reporter-batch-size"
+	BatchInterval      = "zipkin-reporter-batch-interval"
+	QueueSize          = "zipkin-reporter-queue-size"
+	Timeout            = "zipkin-reporter-timeout"
+	KafkaBrokers       = "zipkin-kafka-brokers"
+	KafkaTopic         = "zipkin-kafka-topic"
+	MemorySpans        = "zipkin-memory-spans"
+	GCPProject         = "zipkin-gcp-project"
+	CloudTraceCtx      = "zipkin-cloud-trace-context"
+	XRayDaemon         = "zipkin-xray-daemon"
+	XRayTraceHdr       = "zipkin-xray-trace-header"
+	ElasticServerURL   = "zipkin-elastic-server-url"
+	ElasticSecretToken = "zipkin-elastic-secret-token"
+	ElasticTraceparent = "zipkin-elastic-traceparent"
+	ClockSkew          = "zipkin-clock-skew"
+	FaultDrop          = "zipkin-fault-drop"
+	FaultTruncateTags  = "zipkin-fault-truncate-tags"
+	FaultDelay         = "zipkin-fault-delay"
+	ObservabilityTags  = "observability-tags"
+	HeaderTags         = "observability-header-tags"
+)
+
+// validation errors
+const (
+	errTransport   pkg.Error = "unknown transport"
+	errBatchConfig pkg.Error = "expected a positive value"
+	errMemorySpans pkg.Error = "expected a zero or positive amount of spans"
 )
 
 const (
 	// default configuration values
 	defaultReporterAddr = "http://zipkin:9411/api/v2/spans"
 	defaultSampleRate   = 1.0
+	defaultKafkaTopic   = "zipkin"
 )
 
 // Service implements run.GroupService
 type Service struct {
-	Servicename     string
-	LocalHostport   string
-	Address         string
-	SampleRate      float64
-	Tracer          *zipkin.Tracer
-	Reporter        reporter.Reporter
-	SingleHostSpans bool
+	Servicename        string
+	LocalHostport      string
+	Address            string
+	SampleRate         float64
+	SampleTarget       float64
+	Tracer             *zipkin.Tracer
+	Reporter           reporter.Reporter
+	SingleHostSpans    bool
+	Transport          string
+	BatchSize          int
+	BatchInterval      time.Duration
+	QueueSize          int
+	Timeout            time.Duration
+	KafkaBrokers       []string
+	KafkaTopic         string
+	MemorySpans        int
+	GCPProject         string
+	CloudTraceContext  bool
+	XRayDaemon         string
+	XRayTraceHeader    bool
+	ElasticServerURL   string
+	ElasticToken       string
+	ElasticTraceparent bool
+	ClockSkew          time.Duration
+	FaultDrop          float64
+	FaultTruncateTags  int
+	FaultDelay         time.Duration
+	Tags               map[string]string
+	HeaderTags         map[string]string
 
+	sampler      *Sampler
+	batch        *batchReporter
+	memory       *memoryReporter
+	faults       *ReporterFaults
 	ownsReporter bool
 	closer       chan error
 }
@@ -94,6 +151,85 @@ func (s Service) GetTracer() *zipkin.Tracer {
 	return s.Tracer
 }
 
+// GetSampler returns the Sampler of the Zipkin Tracer, allowing the sample
+// rate and target to be adjusted at runtime.
+func (s Service) GetSampler() *Sampler {
+	return s.sampler
+}
+
+// GetReporterFaults returns the faults injected on the span reporting path,
+// allowing them to be adjusted at runtime.
+func (s Service) GetReporterFaults() *ReporterFaults {
+	return s.faults
+}
+
+// GetReporterStatus returns the connectivity status of the span reporter. The
+// status of the log and none transports and of a reporter provided by the
+// caller is not tracked and reported as healthy.
+func (s Service) GetReporterStatus() ReporterStatus {
+	if s.batch != nil {
+		return s.batch.status.status(s.Transport)
+	}
+	if s.ownsReporter {
+		return ReporterStatus{Transport: s.Transport, Healthy: true}
+	}
+	return ReporterStatus{Transport: "custom", Healthy: true}
+}
+
+// GetReporterCounts returns the amount of spans handled by the batching
+// reporter transports so far, either reported, failed to be sent or dropped,
+// including the spans dropped by reporter faults. For transports not batching
+// spans ok is false.
+func (s Service) GetReporterCounts() (handled int64, ok bool) {
+	if s.batch == nil {
+		return 0, false
+	}
+	rs := s.batch.status.status(s.Transport)
+	handled = rs.ReportedSpans + rs.FailedSpans + rs.DroppedSpans
+	if s.faults != nil {
+		handled += s.faults.Dropped()
+	}
+	return handled, true
+}
+
+// Instrumentation describes the active tracing setup.
+type Instrumentation struct {
+	Tracer      string   `json:"tracer"`
+	Transport   string   `json:"transport"`
+	Propagation []string `json:"propagation"`
+	SharedSpans bool     `json:"sharedSpans"`
+}
+
+// GetInstrumentation returns the active tracing setup.
+func (s Service) GetInstrumentation() Instrumentation {
+	i := Instrumentation{
+		Tracer:      "zipkin-go",
+		Transport:   s.GetReporterStatus().Transport,
+		Propagation: []string{"b3"},
+		SharedSpans: !s.SingleHostSpans,
+	}
+	if s.CloudTraceContext {
+		i.Propagation = append(i.Propagation, "cloud-trace-context")
+	}
+	if s.XRayTraceHeader {
+		i.Propagation = append(i.Propagation, "xray")
+	}
+	if s.ElasticTraceparent {
+		i.Propagation = append(i.Propagation, "elastic-traceparent")
+	}
+	return i
+}
+
+// GetSpans returns the finished spans kept in memory, oldest first, optionally
+// limited to the spans of the provided trace. It returns false if spans are
+// not kept in memory.
+func (s Service) GetSpans(traceID *model.TraceID) ([]model.SpanModel, bool) {
+	if s.memory == nil {
+		return nil, false
+	}
+	return s.memory.find(traceID), true
+}
+
 // FlagSet implements run.Config
 func (s *Service) FlagSet() *run.FlagSet {
 	// set defaults if needed
@@ -108,6 +244,24 @@ func (s *Service) FlagSet() *run.FlagSet {
 	} else if s.SampleRate == 0.0 {
 		s.SampleRate = defaultSampleRate
 	}
+	if s.Transport == "" {
+		s.Transport = TransportHTTP
+	}
+	if s.BatchSize == 0 {
+		s.BatchSize = defaultBatchSize
+	}
+	if s.BatchInterval == 0 {
+		s.BatchInterval = defaultBatchInterval
+	}
+	if s.QueueSize == 0 {
+		s.QueueSize = defaultQueueSize
+	}
+	if s.Timeout == 0 {
+		s.Timeout = defaultTimeout
+	}
+	if s.KafkaTopic == "" {
+		s.KafkaTopic = defaultKafkaTopic
+	}
 
 	// create our configuration flags
 	flags := run.NewFlagSet("Zipkin Tracer Config")
@@ -116,7 +270,96 @@ func (s *Service) FlagSet() *run.FlagSet {
 		&s.Address,
 		ReporterEndpoint,
 		s.Address,
-		`Full address, including URI, of the Zipkin HTTP collector`)
+		`Full address, including URI, of the Zipkin HTTP collector. The gRPC `+
+			`transport connects to its host, using TLS for https addresses`)
+	flags.StringVar(
+		&s.Transport,
+		Transport,
+		s.Transport,
+		`Transport used for reporting spans, one of: http, kafka, grpc, gcp `+
+			`(Google Cloud Trace), xray (AWS X-Ray daemon), elastic (Elastic APM `+
+			`Server), log `+
+			`(print spans to stdout), memory (only keep spans in memory) or none `+
+			`(disable tracing)`)
+	flags.IntVar(
+		&s.BatchSize,
+		BatchSize,
+		s.BatchSize,
+		`Maximum amount of spans reported in a single batch`)
+	flags.DurationVar(
+		&s.BatchInterval,
+		BatchInterval,
+		s.BatchInterval,
+		`Maximum interval between reporting batches of spans`)
+	flags.IntVar(
+		&s.QueueSize,
+		QueueSize,
+		s.QueueSize,
+		`Maximum amount of spans waiting to be reported, new spans are dropped when full`)
+	flags.DurationVar(
+		&s.Timeout,
+		Timeout,
+		s.Timeout,
+		`Timeout for reporting a batch of spans`)
+	flags.StringSliceVar(
+		&s.KafkaBrokers,
+		KafkaBrokers,
+		s.KafkaBrokers,
+		`Kafka bootstrap brokers (host:port) for the kafka transport`)
+	flags.StringVar(
+		&s.KafkaTopic,
+		KafkaTopic,
+		s.KafkaTopic,
+		`Kafka topic to report spans to for the kafka transport`)
+	flags.StringVar(
+		&s.GCPProject,
+		GCPProject,
+		s.GCPProject,
+		`Google Cloud project to report spans to for the gcp transport, `+
+			`defaults to the project of the metadata server`)
+	flags.BoolVar(
+		&s.CloudTraceContext,
+		CloudTraceCtx,
+		s.CloudTraceContext,
+		`Propagate the X-Cloud-Trace-Context header next to B3, `+
+			`always enabled for the gcp transport`)
+	flags.StringVar(
+		&s.XRayDaemon,
+		XRayDaemon,
+		s.XRayDaemon,
+		`X-Ray daemon UDP address for the xray transport, defaults to `+
+			`AWS_XRAY_DAEMON_ADDRESS or `+defaultXRayDaemon)
+	flags.BoolVar(
+		&s.XRayTraceHeader,
+		XRayTraceHdr,
+		s.XRayTraceHeader,
+		`Propagate the X-Amzn-Trace-Id header next to B3, `+
+			`always enabled for the xray transport`)
+	flags.StringVar(
+		&s.ElasticServerURL,
+		ElasticServerURL,
+		s.ElasticServerURL,
+		`Elastic APM Server URL for the elastic transport, defaults to `+
+			`ELASTIC_APM_SERVER_URL or `+defaultElasticServerURL)
+	flags.StringVar(
+		&s.ElasticToken,
+		ElasticSecretToken,
+		s.ElasticToken,
+		`Elastic APM Server secret token for the elastic transport, defaults to `+
+			`ELASTIC_APM_SECRET_TOKEN`)
+	flags.BoolVar(
+		&s.ElasticTraceparent,
+		ElasticTraceparent,
+		s.ElasticTraceparent,
+		`Propagate the Elastic-Apm-Traceparent header next to B3, `+
+			`always enabled for the elastic transport`)
+	flags.IntVar(
+		&s.MemorySpans,
+		MemorySpans,
+		s.MemorySpans,
+		`Keep the last amount of finished spans in memory next to reporting them, `+
+			`queryable at /admin/spans. The memory transport keeps `+
+			strconv.Itoa(defaultMemorySpans)+` spans if not set`)
 	flags.StringVar(
 		&s.Servicename,
 		LocalServicename,
@@ -138,6 +381,46 @@ func (s *Service) FlagSet() *run.FlagSet {
 		s.SampleRate,
 		`Set the Zipkin sample rate, between never (0.0) and always (1.0), `+
 			`smallest increment: 0.0001`)
+	flags.Float64Var(
+		&s.SampleTarget,
+		SampleTarget,
+		s.SampleTarget,
+		`Adaptively sample targeting this amount of traces per second instead of `+
+			`using a fixed sample rate, 0 disables`)
+	flags.DurationVar(
+		&s.ClockSkew,
+		ClockSkew,
+		s.ClockSkew,
+		`Artificial clock skew applied to the timestamps of the spans of this `+
+			`instance, e.g. "-250ms", to demo how backends render and correct skew`)
+	flags.Float64Var(
+		&s.FaultDrop,
+		FaultDrop,
+		s.FaultDrop,
+		`Percentage of spans dropped before reporting, e.g. "10", to practice `+
+			`diagnosing missing spans`)
+	flags.IntVar(
+		&s.FaultTruncateTags,
+		FaultTruncateTags,
+		s.FaultTruncateTags,
+		`Truncate tag values to this amount of bytes before reporting, 0 disables`)
+	flags.DurationVar(
+		&s.FaultDelay,
+		FaultDelay,
+		s.FaultDelay,
+		`Delay with which spans are handed to the reporter, emulating late flushes`)
+	flags.StringToStringVar(
+		&s.Tags,
+		ObservabilityTags,
+		s.Tags,
+		`Tags added to all spans, e.g. "team=payments,env=staging,cluster=eu1", `+
+			`also read from the `+ObservabilityTagsEnv+` environment variable`)
+	flags.StringToStringVar(
+		&s.HeaderTags,
+		HeaderTags,
+		s.HeaderTags,
+		`Request headers to tag on server spans, mapping header to tag name, `+
+			`e.g. "x-team=team,x-tenant=tenant"`)
 
 	return flags
 }
@@ -147,11 +430,46 @@ func (s Service) Validate() error {
 	var mErr error
 
 	if s.Reporter == nil {
-		if _, err := url.Parse(s.Address); err != nil {
+		if transport, ok := lookupTransport(s.Transport); !ok {
 			mErr = multierror.Append(mErr,
-				fmt.Errorf(pkg.FlagErr, ReporterEndpoint, err))
+				fmt.Errorf(pkg.FlagErr, Transport, fmt.Errorf("%w, expected one of: %s",
+					errTransport, strings.Join(Transports(), ", "))))
+		} else if err := transport.Validate(&s); err != nil {
+			mErr = multierror.Append(mErr, err)
 		}
 	}
+	if s.BatchSize <= 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, BatchSize, errBatchConfig))
+	}
+	if s.BatchInterval <= 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, BatchInterval, errBatchConfig))
+	}
+	if s.QueueSize <= 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, QueueSize, errBatchConfig))
+	}
+	if s.Timeout <= 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, Timeout, errBatchConfig))
+	}
+	if s.FaultDrop < 0 || s.FaultDrop > 100 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, FaultDrop, ErrFaultDrop))
+	}
+	if s.FaultTruncateTags < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, FaultTruncateTags, ErrFaultValue))
+	}
+	if s.FaultDelay < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, FaultDelay, ErrFaultValue))
+	}
+	if s.MemorySpans < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, MemorySpans, errMemorySpans))
+	}
 	if s.Servicename == "" {
 		mErr = multierror.Append(mErr,
 			fmt.Errorf(pkg.FlagErr, LocalServicename, pkg.ErrRequired))
@@ -166,10 +484,41 @@ func (s Service) Validate() error {
 		mErr = multierror.Append(mErr,
 			fmt.Errorf(pkg.FlagErr, SampleRate, err))
 	}
+	if s.SampleTarget < 0 {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, SampleTarget, ErrSampleTarget))
+	}
+	if _, err := parseTags(os.Getenv(ObservabilityTagsEnv)); err != nil {
+		mErr = multierror.Append(mErr,
+			fmt.Errorf(pkg.FlagErr, ObservabilityTags, fmt.Errorf("%s: %w", ObservabilityTagsEnv, err)))
+	}
+	for k := range s.Tags {
+		if k == "" {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, ObservabilityTags, errTags))
+		}
+	}
+	for header, tag := range s.HeaderTags {
+		if header == "" || tag == "" {
+			mErr = multierror.Append(mErr,
+				fmt.Errorf(pkg.FlagErr, HeaderTags, errTags))
+		}
+	}
 
 	return mErr
 }
 
+// batching returns the batching and backpressure settings of the reporter
+// transports.
+func (s *Service) batching() batchConfig {
+	return batchConfig{
+		size:     s.BatchSize,
+		queue:    s.QueueSize,
+		interval: s.BatchInterval,
+		timeout:  s.Timeout,
+	}
+}
+
 // PreRun implements run.PreRunner
 func (s *Service) PreRun() error {
 	var err error
@@ -182,26 +531,58 @@ func (s *Service) PreRun() error {
 
 	// configure our sampler
 	salt := time.Now().UnixNano()
-	sampler, err := zipkin.NewBoundarySampler(s.SampleRate, salt)
+	s.sampler, err = NewSampler(s.SampleRate, s.SampleTarget, salt)
 	if err != nil {
 		return err
 	}
 
+	var transport SpanTransport
 	rep := s.Reporter
 	if rep == nil {
 		// we create our own reporter
+		var ok bool
+		if transport, ok = lookupTransport(s.Transport); !ok {
+			return fmt.Errorf(pkg.FlagErr, Transport, errTransport)
+		}
+		if rep, err = transport.NewReporter(s); err != nil {
+			return err
+		}
+		s.batch, _ = rep.(*batchReporter)
 		s.ownsReporter = true
-		rep = http.NewReporter(s.Address)
+	}
+	// faults only affect the reporter, spans kept in memory are the ground
+	// truth
+	if s.faults, err = NewReporterFaults(rep, s.FaultDrop, s.FaultTruncateTags, s.FaultDelay); err != nil {
+		return err
+	}
+	rep = s.faults
+	if s.MemorySpans > 0 && s.memory == nil {
+		// keep spans in memory next to reporting them
+		s.memory = newMemoryReporter(s.MemorySpans)
+		rep = teeReporter{rep, s.memory}
+	}
+	if s.ClockSkew != 0 {
+		rep = skewReporter{Reporter: rep, skew: s.ClockSkew}
 	}
 
-	// create our tracer
-	s.Tracer, err = zipkin.NewTracer(
-		rep,
+	tags, err := s.globalTags(version.Parse())
+	if err != nil {
+		return fmt.Errorf(pkg.FlagErr, ObservabilityTags, err)
+	}
+
+	opts := []zipkin.TracerOption{
 		zipkin.WithLocalEndpoint(ep),
 		zipkin.WithSharedSpans(!s.SingleHostSpans),
-		zipkin.WithSampler(sampler),
-		zipkin.WithTags(map[string]string{"tetrate": version.Parse()}),
-	)
+		zipkin.WithSampler(s.sampler.Sample),
+		zipkin.WithNoopTracer(s.ownsReporter && s.Transport == TransportNone),
+		zipkin.WithTags(tags),
+	}
+	if transport != nil {
+		opts = append(opts, transport.TracerOptions(s)...)
+	}
+
+	// create our tracer
+	s.Tracer, err = zipkin.NewTracer(rep, opts...)
 	if err != nil {
 		if s.ownsReporter {
 			// we handle the lifecycle of the reporter internally